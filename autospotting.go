@@ -37,17 +37,17 @@ func main() {
 		}
 		Handler(context.TODO(), parseEvent)
 	} else {
-		eventHandler(nil)
+		eventHandler(context.Background(), nil)
 	}
 }
 
-func eventHandler(event *json.RawMessage) {
+func eventHandler(ctx context.Context, event *json.RawMessage) {
 
 	log.Println("Starting autospotting agent, build ", Version, "charging", SavingsCut, "percent of savings via AWS Marketplace")
 
 	log.Printf("Configuration flags: %#v", conf)
 
-	as.EventHandler(event)
+	as.EventHandler(ctx, event)
 	log.Println("Execution completed, nothing left to do")
 }
 
@@ -72,5 +72,5 @@ func init() {
 
 // Handler implements the AWS Lambda handler interface
 func Handler(ctx context.Context, rawEvent json.RawMessage) {
-	eventHandler(&rawEvent)
+	eventHandler(ctx, &rawEvent)
 }
@@ -0,0 +1,399 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// api_retry.go contains the retry, backoff and throttling logic shared by all
+// the AWS API calls made through the service connections.
+
+import (
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const (
+	// DefaultAPIMaxRetries is the default number of times a throttled or
+	// otherwise transiently failing AWS API call is retried.
+	DefaultAPIMaxRetries = 8
+
+	// DefaultAPIRetryBudget is the default number of AWS API call retries
+	// allowed during a single run, across all regions and groups.
+	DefaultAPIRetryBudget = 500
+
+	// DefaultAPIRateLimit is the default maximum number of AWS API calls per
+	// second issued against each region.
+	DefaultAPIRateLimit = 20.0
+
+	apiMinRetryDelay    = 100 * time.Millisecond
+	apiMaxRetryDelay    = 20 * time.Second
+	apiMinThrottleDelay = 500 * time.Millisecond
+	apiMaxThrottleDelay = 60 * time.Second
+)
+
+// apiErrorClass groups the AWS API errors by the way we should react to them.
+type apiErrorClass int
+
+const (
+	apiErrorNone apiErrorClass = iota
+	apiErrorThrottling
+	apiErrorCapacity
+	apiErrorValidation
+	apiErrorAuth
+	apiErrorTransient
+	apiErrorOther
+)
+
+func (c apiErrorClass) String() string {
+	switch c {
+	case apiErrorNone:
+		return "none"
+	case apiErrorThrottling:
+		return "throttling"
+	case apiErrorCapacity:
+		return "capacity"
+	case apiErrorValidation:
+		return "validation"
+	case apiErrorAuth:
+		return "auth"
+	case apiErrorTransient:
+		return "transient"
+	}
+	return "other"
+}
+
+var capacityErrorCodes = []string{
+	"InsufficientCapacity",
+	"InsufficientHostCapacity",
+	"InsufficientInstanceCapacity",
+	"InsufficientReservedInstanceCapacity",
+	"InstanceLimitExceeded",
+	"MaxSpotInstanceCountExceeded",
+	"SpotMaxPriceTooLow",
+	"UnfulfillableCapacity",
+}
+
+var validationErrorCodes = []string{
+	"MissingParameter",
+	"ValidationError",
+	"ValidationException",
+}
+
+var authErrorCodes = []string{
+	"AccessDenied",
+	"AccessDeniedException",
+	"AuthFailure",
+	"ExpiredToken",
+	"ExpiredTokenException",
+	"InvalidClientTokenId",
+	"OptInRequired",
+	"SignatureDoesNotMatch",
+	"UnauthorizedOperation",
+	"UnrecognizedClientException",
+}
+
+// classifyAPIErrorCode maps an AWS error code to its error class.
+func classifyAPIErrorCode(code string) apiErrorClass {
+	switch {
+	case code == "":
+		return apiErrorNone
+	case request.IsErrorThrottle(awserr.New(code, "", nil)):
+		return apiErrorThrottling
+	case itemInSlice(code, capacityErrorCodes):
+		return apiErrorCapacity
+	case itemInSlice(code, authErrorCodes):
+		return apiErrorAuth
+	case itemInSlice(code, validationErrorCodes),
+		strings.HasPrefix(code, "Invalid"):
+		return apiErrorValidation
+	}
+	return apiErrorOther
+}
+
+// classifyAPIError determines the class of an error returned by an AWS API call.
+func classifyAPIError(err error) apiErrorClass {
+	if err == nil {
+		return apiErrorNone
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		if aerr.Code() == request.CanceledErrorCode {
+			return apiErrorOther
+		}
+		if class := classifyAPIErrorCode(aerr.Code()); class != apiErrorOther {
+			return class
+		}
+		// also covers the connection errors wrapped by the SDK
+		if request.IsErrorRetryable(err) {
+			return apiErrorTransient
+		}
+	}
+	return apiErrorOther
+}
+
+// retryBudget limits the total number of retries performed during a run, so
+// that a heavily throttled account doesn't keep a run busy until it times out.
+type retryBudget struct {
+	sync.Mutex
+	size      int
+	remaining int
+}
+
+func newRetryBudget(size int) *retryBudget {
+	return &retryBudget{size: size, remaining: size}
+}
+
+// take consumes a retry from the budget, returning false once it's exhausted.
+func (b *retryBudget) take() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+func (b *retryBudget) reset() {
+	b.Lock()
+	defer b.Unlock()
+	b.remaining = b.size
+}
+
+// rateLimiter is a token bucket that spaces out the API calls made against a
+// given region.
+type rateLimiter struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := math.Max(rate, 1)
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes a token from the bucket and returns how long the caller needs
+// to wait before it's allowed to use it.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *rateLimiter) wait(ctx aws.Context) error {
+	delay := l.reserve(time.Now())
+	if delay == 0 {
+		return nil
+	}
+	return aws.SleepWithContext(ctx, delay)
+}
+
+// apiThrottling holds the state shared by all the sessions created during the
+// lifetime of the process. A single process always runs with the credentials of
+// a single account, so keeping a rate limiter for each region limits the calls
+// for each account and region pair.
+type apiThrottling struct {
+	sync.Mutex
+	maxRetries int
+	rateLimit  float64
+	budget     *retryBudget
+	limiters   map[string]*rateLimiter
+}
+
+var apiCalls = &apiThrottling{
+	maxRetries: DefaultAPIMaxRetries,
+	rateLimit:  DefaultAPIRateLimit,
+	budget:     newRetryBudget(DefaultAPIRetryBudget),
+	limiters:   make(map[string]*rateLimiter),
+}
+
+// configure applies the throttling settings of the configuration, falling
+// back to the defaults for the values left unset, such as when the Config is
+// built directly instead of being parsed from the flags.
+func (t *apiThrottling) configure(cfg *Config) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.maxRetries = DefaultAPIMaxRetries
+	if cfg.APIMaxRetries > 0 {
+		t.maxRetries = cfg.APIMaxRetries
+	}
+
+	t.rateLimit = DefaultAPIRateLimit
+	if cfg.APIRateLimit > 0 {
+		t.rateLimit = cfg.APIRateLimit
+	}
+
+	budget := DefaultAPIRetryBudget
+	if cfg.APIRetryBudget > 0 {
+		budget = cfg.APIRetryBudget
+	}
+	t.budget = newRetryBudget(budget)
+	t.limiters = make(map[string]*rateLimiter)
+}
+
+func (t *apiThrottling) limiter(region string) *rateLimiter {
+	t.Lock()
+	defer t.Unlock()
+
+	if l, ok := t.limiters[region]; ok {
+		return l
+	}
+	l := newRateLimiter(t.rateLimit)
+	t.limiters[region] = l
+	return l
+}
+
+// resetBudget is called at the beginning of each run, since the retry budget
+// is meant to be consumed by a single run.
+func (t *apiThrottling) resetBudget() {
+	t.Lock()
+	defer t.Unlock()
+	t.budget.reset()
+}
+
+// apiRetryer decides which failed API calls are retried and how long to wait
+// before each retry. It's used by all the service clients instead of the SDK's
+// default retryer.
+type apiRetryer struct {
+	client.DefaultRetryer
+	budget *retryBudget
+}
+
+// ShouldRetry only retries throttling and transient errors, as long as the
+// retry budget of the current run allows it. Capacity, validation and
+// authorization errors are returned immediately since retrying wouldn't help.
+func (r apiRetryer) ShouldRetry(req *request.Request) bool {
+	if req.RetryCount >= r.MaxRetries() {
+		return false
+	}
+
+	class := classifyAPIError(req.Error)
+
+	// 5xx responses are usually transient, except for the not implemented ones
+	if class == apiErrorOther && req.HTTPResponse != nil &&
+		req.HTTPResponse.StatusCode >= 500 && req.HTTPResponse.StatusCode != 501 {
+		class = apiErrorTransient
+	}
+
+	// expired credentials are refreshed before retrying, so try once more
+	if req.IsErrorExpired() && req.RetryCount == 0 {
+		class = apiErrorTransient
+	}
+
+	if class != apiErrorThrottling && class != apiErrorTransient {
+		debug.Printf("Not retrying %s call failing with %s error: %v",
+			apiCallName(req), class, req.Error)
+		return false
+	}
+
+	if !r.budget.take() {
+		log.Printf("Retry budget exhausted, not retrying %s call failing with %s error: %v",
+			apiCallName(req), class, req.Error)
+		return false
+	}
+	return true
+}
+
+// RetryRules computes an exponential backoff with full jitter, using larger
+// delays for throttling errors.
+func (r apiRetryer) RetryRules(req *request.Request) time.Duration {
+	minDelay, maxDelay := r.MinRetryDelay, r.MaxRetryDelay
+	if classifyAPIError(req.Error) == apiErrorThrottling {
+		minDelay, maxDelay = r.MinThrottleDelay, r.MaxThrottleDelay
+	}
+
+	delay := jitteredBackoff(req.RetryCount, minDelay, maxDelay)
+	log.Printf("Retrying %s call in %v after attempt %d failed with: %v",
+		apiCallName(req), delay, req.RetryCount+1, req.Error)
+	return delay
+}
+
+func apiCallName(req *request.Request) string {
+	if req.Operation == nil {
+		return req.ClientInfo.ServiceName
+	}
+	return req.ClientInfo.ServiceName + "/" + req.Operation.Name
+}
+
+// jitteredBackoff returns a random delay between minDelay and the exponential
+// backoff value for the given retry, capped at maxDelay.
+func jitteredBackoff(retryCount int, minDelay, maxDelay time.Duration) time.Duration {
+	backoff := float64(minDelay) * math.Pow(2, float64(retryCount))
+	if backoff > float64(maxDelay) {
+		backoff = float64(maxDelay)
+	}
+	if backoff <= float64(minDelay) {
+		return minDelay
+	}
+	return minDelay + time.Duration(rand.Int63n(int64(backoff)-int64(minDelay)))
+}
+
+// newAPISession creates a session whose clients retry and rate limit their
+// API calls as configured for the current run.
+func newAPISession(region string) (*session.Session, error) {
+	apiCalls.Lock()
+	retryer := apiRetryer{
+		DefaultRetryer: client.DefaultRetryer{
+			NumMaxRetries:    apiCalls.maxRetries,
+			MinRetryDelay:    apiMinRetryDelay,
+			MaxRetryDelay:    apiMaxRetryDelay,
+			MinThrottleDelay: apiMinThrottleDelay,
+			MaxThrottleDelay: apiMaxThrottleDelay,
+		},
+		budget: apiCalls.budget,
+	}
+	apiCalls.Unlock()
+
+	cfg := aws.NewConfig()
+	cfg.EnforceShouldRetryCheck = aws.Bool(true)
+	if region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+
+	sess, err := session.NewSession(request.WithRetryer(cfg, retryer))
+	if err != nil {
+		return nil, err
+	}
+
+	// The Sign handlers are executed before each attempt, including retries
+	sess.Handlers.Sign.PushFrontNamed(request.NamedHandler{
+		Name: "autospotting.RateLimiter",
+		Fn: func(r *request.Request) {
+			l := apiCalls.limiter(aws.StringValue(r.Config.Region))
+			if err := l.wait(r.Context()); err != nil {
+				r.Error = awserr.New(request.CanceledErrorCode,
+					"request context canceled while rate limited", err)
+			}
+		},
+	})
+
+	return sess, nil
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func Test_classifyAPIError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want apiErrorClass
+	}{
+		{
+			name: "no error",
+			err:  nil,
+			want: apiErrorNone,
+		},
+		{
+			name: "EC2 request limit exceeded",
+			err:  awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil),
+			want: apiErrorThrottling,
+		},
+		{
+			name: "AutoScaling throttling",
+			err:  awserr.New("Throttling", "Rate exceeded", nil),
+			want: apiErrorThrottling,
+		},
+		{
+			name: "insufficient capacity",
+			err:  awserr.New("InsufficientInstanceCapacity", "", nil),
+			want: apiErrorCapacity,
+		},
+		{
+			name: "validation error",
+			err:  awserr.New("ValidationError", "", nil),
+			want: apiErrorValidation,
+		},
+		{
+			name: "invalid parameter",
+			err:  awserr.New("InvalidParameterValue", "", nil),
+			want: apiErrorValidation,
+		},
+		{
+			name: "unauthorized operation",
+			err:  awserr.New("UnauthorizedOperation", "", nil),
+			want: apiErrorAuth,
+		},
+		{
+			name: "invalid client token is an auth error",
+			err:  awserr.New("InvalidClientTokenId", "", nil),
+			want: apiErrorAuth,
+		},
+		{
+			name: "request timeout",
+			err:  awserr.New("RequestTimeout", "", nil),
+			want: apiErrorTransient,
+		},
+		{
+			name: "canceled request",
+			err:  awserr.New(request.CanceledErrorCode, "", nil),
+			want: apiErrorOther,
+		},
+		{
+			name: "unknown error",
+			err:  errors.New("foo"),
+			want: apiErrorOther,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyAPIError(tt.err); got != tt.want {
+				t.Errorf("classifyAPIError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_retryBudget(t *testing.T) {
+	b := newRetryBudget(2)
+
+	for i, want := range []bool{true, true, false} {
+		if got := b.take(); got != want {
+			t.Errorf("take() call %d = %v, want %v", i, got, want)
+		}
+	}
+
+	b.reset()
+	if !b.take() {
+		t.Errorf("take() after reset() = false, want true")
+	}
+}
+
+func Test_apiThrottling_configure(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            Config
+		wantMaxRetries int
+		wantRateLimit  float64
+		wantBudget     int
+	}{
+		{
+			name:           "zero values",
+			cfg:            Config{},
+			wantMaxRetries: DefaultAPIMaxRetries,
+			wantRateLimit:  DefaultAPIRateLimit,
+			wantBudget:     DefaultAPIRetryBudget,
+		},
+		{
+			name:           "negative values",
+			cfg:            Config{APIMaxRetries: -1, APIRateLimit: -1, APIRetryBudget: -1},
+			wantMaxRetries: DefaultAPIMaxRetries,
+			wantRateLimit:  DefaultAPIRateLimit,
+			wantBudget:     DefaultAPIRetryBudget,
+		},
+		{
+			name:           "configured values",
+			cfg:            Config{APIMaxRetries: 3, APIRateLimit: 5, APIRetryBudget: 10},
+			wantMaxRetries: 3,
+			wantRateLimit:  5,
+			wantBudget:     10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := &apiThrottling{}
+			th.configure(&tt.cfg)
+
+			if th.maxRetries != tt.wantMaxRetries || th.rateLimit != tt.wantRateLimit ||
+				th.budget.size != tt.wantBudget {
+				t.Errorf("configure() = %d retries, %v calls/s, budget %d, want %d, %v, %d",
+					th.maxRetries, th.rateLimit, th.budget.size,
+					tt.wantMaxRetries, tt.wantRateLimit, tt.wantBudget)
+			}
+		})
+	}
+}
+
+func Test_rateLimiter_reserve(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		rate  float64
+		calls int
+		want  time.Duration
+	}{
+		{
+			name:  "within burst",
+			rate:  2,
+			calls: 2,
+			want:  0,
+		},
+		{
+			name:  "exceeding burst",
+			rate:  2,
+			calls: 3,
+			want:  500 * time.Millisecond,
+		},
+		{
+			name:  "disabled",
+			rate:  0,
+			calls: 10,
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.rate)
+			l.last = now
+
+			var got time.Duration
+			for i := 0; i < tt.calls; i++ {
+				got = l.reserve(now)
+			}
+			if got != tt.want {
+				t.Errorf("reserve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_jitteredBackoff(t *testing.T) {
+	minDelay, maxDelay := 100*time.Millisecond, time.Second
+
+	for retry := 0; retry < 10; retry++ {
+		got := jitteredBackoff(retry, minDelay, maxDelay)
+		if got < minDelay || got > maxDelay {
+			t.Errorf("jitteredBackoff(%d) = %v, outside of [%v, %v]",
+				retry, got, minDelay, maxDelay)
+		}
+	}
+}
+
+func Test_apiRetryer_ShouldRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		statusCode int
+		retryCount int
+		budget     int
+		want       bool
+	}{
+		{
+			name:   "throttling error",
+			err:    awserr.New("RequestLimitExceeded", "", nil),
+			budget: 1,
+			want:   true,
+		},
+		{
+			name:   "throttling error with exhausted budget",
+			err:    awserr.New("RequestLimitExceeded", "", nil),
+			budget: 0,
+			want:   false,
+		},
+		{
+			name:       "throttling error after too many retries",
+			err:        awserr.New("RequestLimitExceeded", "", nil),
+			retryCount: 3,
+			budget:     1,
+			want:       false,
+		},
+		{
+			name:       "server error",
+			err:        awserr.New("InternalError", "", nil),
+			statusCode: 500,
+			budget:     1,
+			want:       true,
+		},
+		{
+			name:   "capacity error",
+			err:    awserr.New("InsufficientInstanceCapacity", "", nil),
+			budget: 1,
+			want:   false,
+		},
+		{
+			name:   "validation error",
+			err:    awserr.New("ValidationError", "", nil),
+			budget: 1,
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := apiRetryer{
+				DefaultRetryer: client.DefaultRetryer{NumMaxRetries: 3},
+				budget:         newRetryBudget(tt.budget),
+			}
+			req := &request.Request{
+				Error:      tt.err,
+				RetryCount: tt.retryCount,
+			}
+			if tt.statusCode != 0 {
+				req.HTTPResponse = &http.Response{StatusCode: tt.statusCode}
+			}
+
+			if got := r.ShouldRetry(req); got != tt.want {
+				t.Errorf("ShouldRetry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -26,6 +26,9 @@ type autoScalingGroup struct {
 	autospotting        *AutoSpotting
 	instances           instances
 	config              AutoScalingConfig
+
+	// the availability zones of the subnets of the group, loaded on demand
+	subnets map[string]string
 }
 
 func (a *autoScalingGroup) loadLaunchConfiguration() (*launchConfiguration, error) {
@@ -45,7 +48,7 @@ func (a *autoScalingGroup) loadLaunchConfiguration() (*launchConfiguration, erro
 	params := &autoscaling.DescribeLaunchConfigurationsInput{
 		LaunchConfigurationNames: []*string{lcName},
 	}
-	resp, err := svc.DescribeLaunchConfigurations(params)
+	resp, err := svc.DescribeLaunchConfigurationsWithContext(a.region.runContext(), params)
 
 	if err != nil {
 		log.Println(err.Error())
@@ -84,7 +87,7 @@ func (a *autoScalingGroup) loadLaunchTemplate() (*launchTemplate, error) {
 		Versions:         []*string{ltVer},
 	}
 
-	resp, err := svc.DescribeLaunchTemplateVersions(params)
+	resp, err := svc.DescribeLaunchTemplateVersionsWithContext(a.region.runContext(), params)
 
 	if err != nil {
 		log.Println(err.Error())
@@ -101,7 +104,7 @@ func (a *autoScalingGroup) loadLaunchTemplate() (*launchTemplate, error) {
 		ImageIds: []*string{ltv.LaunchTemplateData.ImageId},
 	}
 
-	resp2, err2 := svc.DescribeImages(params2)
+	resp2, err2 := svc.DescribeImagesWithContext(a.region.runContext(), params2)
 
 	if err2 != nil {
 		log.Println(err2.Error())
@@ -149,6 +152,8 @@ func (a *autoScalingGroup) cronEventAction() runer {
 	a.loadDefaultConfig()
 	a.loadConfigFromTags()
 
+	a.resumeReplacements()
+
 	shouldRun := cronRunAction(time.Now(), a.config.CronSchedule, a.config.CronTimezone, a.config.CronScheduleState)
 	debug.Println(a.region.name, a.name, "Should take replacement actions:", shouldRun)
 
@@ -173,7 +178,7 @@ func (a *autoScalingGroup) cronEventAction() runer {
 	}
 
 	recapText := fmt.Sprintf("%s Triggered replacement for on-demand instance %s", a.name, *onDemandInstance.Instance.InstanceId)
-	a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+	a.region.addToFinalRecap(recapText)
 
 	return replaceAndTerminateInstance{target{
 		autospotting:     a.autospotting,
@@ -254,6 +259,19 @@ func (a *autoScalingGroup) getInstance(
 				continue
 			}
 
+			if considerInstanceProtection && onDemand && i.isCreditConstrained() {
+				debug.Println(a.name, "skipping credit constrained instance", *i.InstanceId)
+				continue
+			}
+
+			if onDemand && i.hasSpotUnsupportedPlacement() {
+				continue
+			}
+
+			if considerInstanceProtection && onDemand && i.isCoveredByReservation() {
+				continue
+			}
+
 			if (availabilityZone != nil) && (*availabilityZone != *i.Placement.AvailabilityZone) {
 				debug.Println(a.name, "skipping instance", *i.InstanceId,
 					"placed in a different AZ than what we're looking for")
@@ -279,47 +297,42 @@ func (a *autoScalingGroup) hasMemberInstance(inst *instance) bool {
 }
 
 func (a *autoScalingGroup) waitForInstanceStatus(instanceID *string, status string, maxRetry int) error {
-	isInstanceInStatus := false
-	for retry := 0; !isInstanceInStatus; retry++ {
-		if retry > maxRetry {
-			log.Printf("Failed waiting instance %s in status %s",
-				*instanceID, status)
-			break
-		} else {
-			result, err := a.region.services.autoScaling.DescribeAutoScalingInstances(
-				&autoscaling.DescribeAutoScalingInstancesInput{
-					InstanceIds: []*string{instanceID},
-				})
-
-			if err != nil {
-				log.Println(err.Error())
-				continue
-			}
-
-			autoScalingInstances := result.AutoScalingInstances
+	for retry := 0; retry <= maxRetry; retry++ {
+		result, err := a.region.services.autoScaling.DescribeAutoScalingInstancesWithContext(
+			a.region.runContext(),
+			&autoscaling.DescribeAutoScalingInstancesInput{
+				InstanceIds: []*string{instanceID},
+			})
 
-			if len(autoScalingInstances) > 0 {
-				if instanceStatus := *autoScalingInstances[0].LifecycleState; instanceStatus != status {
-					log.Printf("Waiting for instance %s to be in status %s [%s]",
-						*instanceID, status, instanceStatus)
-				} else {
-					isInstanceInStatus = true
-					return nil
-				}
+		if err != nil {
+			log.Println(err.Error())
+		} else if autoScalingInstances := result.AutoScalingInstances; len(autoScalingInstances) > 0 {
+			if instanceStatus := *autoScalingInstances[0].LifecycleState; instanceStatus != status {
+				log.Printf("Waiting for instance %s to be in status %s [%s]",
+					*instanceID, status, instanceStatus)
 			} else {
-				log.Printf("Waiting for instance %s to be in AutoScalingGroup with status %s",
-					*instanceID, status)
+				return nil
 			}
+		} else {
+			log.Printf("Waiting for instance %s to be in AutoScalingGroup with status %s",
+				*instanceID, status)
+		}
 
-			sleepTime := 10 - (2 * retry)
-			if sleepTime <= 0 {
-				sleepTime = 1
-			}
-			time.Sleep(time.Duration(sleepTime) * time.Second)
+		sleepTime := 10 - (2 * retry)
+		if sleepTime <= 0 {
+			sleepTime = 1
+		}
+		if err := aws.SleepWithContext(a.region.runContext(),
+			time.Duration(sleepTime)*time.Second*a.region.conf.SleepMultiplier); err != nil {
+			log.Printf("Stopped waiting for instance %s to be in status %s: %s",
+				*instanceID, status, err.Error())
+			return err
 		}
 	}
 
-	return errors.New("")
+	log.Printf("Failed waiting instance %s in status %s",
+		*instanceID, status)
+	return fmt.Errorf("instance %s didn't reach status %s", *instanceID, status)
 }
 
 func (a *autoScalingGroup) findUnattachedInstanceLaunchedForThisASG() *instance {
@@ -385,10 +398,16 @@ func (a *autoScalingGroup) getDisallowedInstanceTypes(baseInstance *instance) []
 	})
 }
 
+// setAutoScalingMaxSize is also used for restoring the original MaxSize, so it
+// isn't cancelled together with the run.
 func (a *autoScalingGroup) setAutoScalingMaxSize(maxSize int64) error {
 	svc := a.region.services.autoScaling
 
-	_, err := svc.UpdateAutoScalingGroup(
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	_, err := svc.UpdateAutoScalingGroupWithContext(
+		ctx,
 		&autoscaling.UpdateAutoScalingGroupInput{
 			AutoScalingGroupName: aws.String(a.name),
 			MaxSize:              aws.Int64(maxSize),
@@ -406,7 +425,8 @@ func (a *autoScalingGroup) setAutoScalingMaxSize(maxSize int64) error {
 func (a *autoScalingGroup) attachSpotInstance(spotInstanceID string, wait bool) error {
 	if wait {
 		log.Printf("Waiting for instance %s to start", spotInstanceID)
-		err := a.region.services.ec2.WaitUntilInstanceRunning(
+		err := a.region.services.ec2.WaitUntilInstanceRunningWithContext(
+			a.region.runContext(),
 			&ec2.DescribeInstancesInput{
 				InstanceIds: []*string{aws.String(spotInstanceID)},
 			})
@@ -418,7 +438,8 @@ func (a *autoScalingGroup) attachSpotInstance(spotInstanceID string, wait bool)
 
 	}
 	log.Printf("Attaching instance %s to ASG %v", spotInstanceID, a.name)
-	resp, err := a.region.services.autoScaling.AttachInstances(
+	resp, err := a.region.services.autoScaling.AttachInstancesWithContext(
+		a.region.runContext(),
 		&autoscaling.AttachInstancesInput{
 			AutoScalingGroupName: aws.String(a.name),
 			InstanceIds: []*string{
@@ -453,7 +474,8 @@ func (a *autoScalingGroup) terminateInstanceInAutoScalingGroup(
 	instanceID *string, wait bool, decreaseCapacity bool) error {
 
 	if wait {
-		err := a.region.services.ec2.WaitUntilInstanceRunning(
+		err := a.region.services.ec2.WaitUntilInstanceRunningWithContext(
+			a.region.runContext(),
 			&ec2.DescribeInstancesInput{
 				InstanceIds: []*string{instanceID},
 			})
@@ -476,7 +498,8 @@ func (a *autoScalingGroup) terminateInstanceInAutoScalingGroup(
 
 	asSvc := a.region.services.autoScaling
 
-	resDLH, err := asSvc.DescribeLifecycleHooks(
+	resDLH, err := asSvc.DescribeLifecycleHooksWithContext(
+		a.region.runContext(),
 		&autoscaling.DescribeLifecycleHooksInput{
 			AutoScalingGroupName: a.AutoScalingGroupName,
 		})
@@ -487,7 +510,8 @@ func (a *autoScalingGroup) terminateInstanceInAutoScalingGroup(
 	}
 
 	for _, hook := range resDLH.LifecycleHooks {
-		asSvc.CompleteLifecycleAction(
+		asSvc.CompleteLifecycleActionWithContext(
+			a.region.runContext(),
 			&autoscaling.CompleteLifecycleActionInput{
 				AutoScalingGroupName:  a.AutoScalingGroupName,
 				InstanceId:            instanceID,
@@ -496,7 +520,8 @@ func (a *autoScalingGroup) terminateInstanceInAutoScalingGroup(
 			})
 	}
 
-	resTIIASG, err := asSvc.TerminateInstanceInAutoScalingGroup(
+	resTIIASG, err := asSvc.TerminateInstanceInAutoScalingGroupWithContext(
+		a.region.runContext(),
 		&autoscaling.TerminateInstanceInAutoScalingGroupInput{
 			InstanceId:                     instanceID,
 			ShouldDecrementDesiredCapacity: aws.Bool(decreaseCapacity),
@@ -545,34 +570,119 @@ func (a *autoScalingGroup) suspendProcesses() {
 	AutoScalingProcessesToSuspend := []*string{aws.String("Terminate"), aws.String("AZRebalance")}
 	log.Printf("Suspending processes on ASG %s", a.name)
 
-	_, err := a.region.services.autoScaling.SuspendProcesses(
+	_, err := a.region.services.autoScaling.SuspendProcessesWithContext(
+		a.region.runContext(),
 		&autoscaling.ScalingProcessQuery{
 			AutoScalingGroupName: a.AutoScalingGroupName,
 			ScalingProcesses:     AutoScalingProcessesToSuspend,
 		})
 	if err != nil {
 		log.Printf("couldn't suspend processes on ASG %s ", a.name)
+	} else {
+		a.setMarkerTag(suspendedProcessesTag, "Terminate,AZRebalance")
 	}
-	time.Sleep(30 * time.Second * a.region.conf.SleepMultiplier)
+	aws.SleepWithContext(a.region.runContext(), 30*time.Second*a.region.conf.SleepMultiplier)
 }
 
-func (a *autoScalingGroup) resumeProcesses() {
+func (a *autoScalingGroup) resumeProcesses() error {
 	AutoScalingProcessesToResume := []*string{aws.String("Terminate"), aws.String("AZRebalance")}
 	log.Printf("Resuming processes on ASG %s", a.name)
 
-	_, err := a.region.services.autoScaling.ResumeProcesses(
+	// resuming the processes should also happen after the run was cancelled
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	_, err := a.region.services.autoScaling.ResumeProcessesWithContext(
+		ctx,
 		&autoscaling.ScalingProcessQuery{
 			AutoScalingGroupName: a.AutoScalingGroupName,
 			ScalingProcesses:     AutoScalingProcessesToResume,
 		})
 	if err != nil {
 		log.Printf("couldn't resume processes on ASG %s ", a.name)
+		return err
+	}
+	return a.deleteMarkerTag(suspendedProcessesTag)
+}
+
+// setMarkerTag records on the group a temporary change that needs to be
+// reverted later, so that the reconciliation pass can revert it in case the
+// run that made it fails.
+func (a *autoScalingGroup) setMarkerTag(key, value string) error {
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	_, err := a.region.services.autoScaling.CreateOrUpdateTagsWithContext(
+		ctx,
+		&autoscaling.CreateOrUpdateTagsInput{
+			Tags: []*autoscaling.Tag{
+				{
+					ResourceId:        a.AutoScalingGroupName,
+					ResourceType:      aws.String("auto-scaling-group"),
+					Key:               aws.String(key),
+					Value:             aws.String(value),
+					PropagateAtLaunch: aws.Bool(false),
+				},
+			},
+		})
+
+	if err != nil {
+		log.Printf("couldn't set tag %s on ASG %s: %s", key, a.name, err.Error())
+		return err
+	}
+
+	if tagValue := a.getTagValue(key); tagValue != nil {
+		*tagValue = value
+	} else {
+		a.Tags = append(a.Tags, &autoscaling.TagDescription{
+			Key:   aws.String(key),
+			Value: aws.String(value),
+		})
+	}
+	return nil
+}
+
+// deleteMarkerTag removes a tag set by setMarkerTag, once the change it
+// recorded was reverted.
+func (a *autoScalingGroup) deleteMarkerTag(key string) error {
+	if a.getTagValue(key) == nil {
+		return nil
+	}
+
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	_, err := a.region.services.autoScaling.DeleteTagsWithContext(
+		ctx,
+		&autoscaling.DeleteTagsInput{
+			Tags: []*autoscaling.Tag{
+				{
+					ResourceId:   a.AutoScalingGroupName,
+					ResourceType: aws.String("auto-scaling-group"),
+					Key:          aws.String(key),
+				},
+			},
+		})
+
+	if err != nil {
+		log.Printf("couldn't delete tag %s from ASG %s: %s", key, a.name, err.Error())
+		return err
 	}
+
+	var tags []*autoscaling.TagDescription
+	for _, tag := range a.Tags {
+		if *tag.Key != key {
+			tags = append(tags, tag)
+		}
+	}
+	a.Tags = tags
+	return nil
 }
 
 func (a *autoScalingGroup) hasLifecycleHook(hookType string) (bool, *autoscaling.LifecycleHook) {
 	result, err := a.region.services.autoScaling.
-		DescribeLifecycleHooks(
+		DescribeLifecycleHooksWithContext(
+			a.region.runContext(),
 			&autoscaling.DescribeLifecycleHooksInput{
 				AutoScalingGroupName: a.AutoScalingGroupName,
 			})
@@ -592,12 +702,12 @@ func (a *autoScalingGroup) hasLifecycleHook(hookType string) (bool, *autoscaling
 }
 
 func (a *autoScalingGroup) hasCodeDeployLifecycleHook() (bool, *autoscaling.LifecycleHook) {
-	hasLH, lHook := a.hasLifecycleHook("autoscaling:EC2_INSTANCE_LAUNCHING")
+	hasLH, lHook := a.hasLifecycleHook(lifecycleTransitionLaunching)
 
 	if !hasLH {
 		return false, nil
 	}
-	if strings.HasPrefix(*lHook.LifecycleHookName, "CodeDeploy-managed-automatic-launch-deployment-hook") {
+	if strings.HasPrefix(*lHook.LifecycleHookName, codeDeployLifecycleHookPrefix) {
 		return true, lHook
 	}
 	return false, nil
@@ -619,7 +729,7 @@ func (a *autoScalingGroup) triggerCodeDeployDeployment(hook *autoscaling.Lifecyc
 
 func (a *autoScalingGroup) findDeployment(hookName string) (*string, *string, error) {
 
-	apps, err := a.region.services.codedeploy.ListApplications(&codedeploy.ListApplicationsInput{})
+	apps, err := a.region.services.codedeploy.ListApplicationsWithContext(a.region.runContext(), &codedeploy.ListApplicationsInput{})
 
 	if err != nil {
 		log.Println(err.Error())
@@ -629,7 +739,7 @@ func (a *autoScalingGroup) findDeployment(hookName string) (*string, *string, er
 	for _, app := range apps.Applications {
 		log.Println("Processing CodeDeploy application:", *app)
 
-		groups, err := a.region.services.codedeploy.ListDeploymentGroups(&codedeploy.ListDeploymentGroupsInput{
+		groups, err := a.region.services.codedeploy.ListDeploymentGroupsWithContext(a.region.runContext(), &codedeploy.ListDeploymentGroupsInput{
 			ApplicationName: app,
 		})
 
@@ -641,7 +751,7 @@ func (a *autoScalingGroup) findDeployment(hookName string) (*string, *string, er
 		for _, group := range groups.DeploymentGroups {
 
 			log.Printf("Processing CodeDeploy deployment group %s for application %s", *group, *app)
-			gd, err := a.region.services.codedeploy.GetDeploymentGroup(&codedeploy.GetDeploymentGroupInput{
+			gd, err := a.region.services.codedeploy.GetDeploymentGroupWithContext(a.region.runContext(), &codedeploy.GetDeploymentGroupInput{
 				ApplicationName:     app,
 				DeploymentGroupName: group,
 			})
@@ -674,7 +784,8 @@ func (a *autoScalingGroup) findDeployment(hookName string) (*string, *string, er
 
 func (a *autoScalingGroup) triggerDeployment(appName, deploymentGroupName *string) error {
 
-	_, err := a.region.services.codedeploy.CreateDeployment(
+	_, err := a.region.services.codedeploy.CreateDeploymentWithContext(
+		a.region.runContext(),
 		&codedeploy.CreateDeploymentInput{
 			ApplicationName:             appName,
 			DeploymentGroupName:         deploymentGroupName,
@@ -107,6 +107,10 @@ const (
 	// can override the global value of the GP2ConversionThreshold parameter
 	GP2ConversionThresholdTag = "autospotting_gp2_conversion_threshold"
 
+	// EBSVolumePolicyTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the EBSVolumePolicy parameter
+	EBSVolumePolicyTag = "autospotting_ebs_volume_policy"
+
 	// SpotAllocationStrategyTag is the name of the tag set on the AutoScaling Group that
 	// can override the global value of the SpotAllocationStrategy parameter
 	SpotAllocationStrategyTag = "autospotting_spot_allocation_strategy"
@@ -114,6 +118,99 @@ const (
 	// PrioritizedInstanceTypesBiasTag is the name of the tag set on the AutoScaling Group that
 	// can override the global value of the PrioritizedInstanceTypesBias parameter
 	PrioritizedInstanceTypesBiasTag = "autospotting_prioritized_instance_types_bias"
+
+	// CompatibilityScoreWeightsTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the CompatibilityScoreWeights parameter
+	CompatibilityScoreWeightsTag = "autospotting_compatibility_score_weights"
+
+	// RelaxNetworkCompatibilityTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the RelaxNetworkCompatibility parameter
+	RelaxNetworkCompatibilityTag = "autospotting_relax_network_compatibility"
+
+	// EquivalentAcceleratorModelsTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the EquivalentAcceleratorModels parameter
+	EquivalentAcceleratorModelsTag = "autospotting_equivalent_accelerator_models"
+
+	// MaxVCPUTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the MaxVCPU parameter
+	MaxVCPUTag = "autospotting_max_vcpu"
+
+	// MaxMemoryTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the MaxMemory parameter
+	MaxMemoryTag = "autospotting_max_memory"
+
+	// MaxPriceRatioTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the MaxPriceRatio parameter
+	MaxPriceRatioTag = "autospotting_max_price_ratio"
+
+	// InstanceRequirementsTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the InstanceRequirements
+	// parameter
+	InstanceRequirementsTag = "autospotting_instance_requirements"
+
+	// ArchitectureImagesTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the ArchitectureImages
+	// parameter
+	ArchitectureImagesTag = "autospotting_architecture_images"
+
+	// UserDataTransformersTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the UserDataTransformers
+	// parameter
+	UserDataTransformersTag = "autospotting_userdata_transformers"
+
+	// UserDataEnvironmentTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the UserDataEnvironment
+	// parameter
+	UserDataEnvironmentTag = "autospotting_userdata_environment"
+
+	// UserDataAppendScriptTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the UserDataAppendScript
+	// parameter
+	UserDataAppendScriptTag = "autospotting_userdata_append_script"
+
+	// TagPropagationIncludeTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the TagPropagationInclude
+	// parameter
+	TagPropagationIncludeTag = "autospotting_tag_propagation_include"
+
+	// TagPropagationExcludeTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the TagPropagationExclude
+	// parameter
+	TagPropagationExcludeTag = "autospotting_tag_propagation_exclude"
+
+	// TagPropagationResourcesTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the TagPropagationResources
+	// parameter
+	TagPropagationResourcesTag = "autospotting_tag_propagation_resources"
+
+	// ExtraTagsTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the ExtraTags parameter
+	ExtraTagsTag = "autospotting_extra_tags"
+
+	// ReplaceReservedInstancesTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the ReplaceReservedInstances
+	// parameter
+	ReplaceReservedInstancesTag = "autospotting_replace_reserved_instances"
+
+	// EmulateLaunchLifecycleHooksTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// EmulateLaunchLifecycleHooks parameter
+	EmulateLaunchLifecycleHooksTag = "autospotting_emulate_launch_lifecycle_hooks"
+
+	// AllowBurstableMixingTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the AllowBurstableMixing
+	// parameter
+	AllowBurstableMixingTag = "autospotting_allow_burstable_mixing"
+
+	// BurstableCPUUtilizationTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the BurstableCPUUtilization
+	// parameter
+	BurstableCPUUtilizationTag = "autospotting_burstable_cpu_utilization"
+
+	// SkipCreditConstrainedInstancesTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// SkipCreditConstrainedInstances parameter
+	SkipCreditConstrainedInstancesTag = "autospotting_skip_credit_constrained_instances"
 )
 
 // AutoScalingConfig stores some group-specific configurations that can override
@@ -151,6 +248,10 @@ type AutoScalingConfig struct {
 	// size GP2 may be more performant than GP3.
 	GP2ConversionThreshold int64
 
+	// EBSVolumePolicy lists the EBS volume type conversions applied to the
+	// volumes of the spot instances, such as "gp2:gp3,io1:io2".
+	EBSVolumePolicy string
+
 	// Controls the instance type selection when launching new Spot instances.
 	// Further information about this is available at
 	// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ec2-fleet-allocation-strategy.html
@@ -159,6 +260,86 @@ type AutoScalingConfig struct {
 	// PrioritizedInstanceTypesBias can be used to tweak the ordering of the instance types when using the
 	//"capacity-optimized-prioritized" allocation strategy, biasing towards newer instance types.
 	PrioritizedInstanceTypesBias string
+
+	// CompatibilityScoreWeights are the weights of the dimensions of the
+	// compatibility score used by the "best_fit" PrioritizedInstanceTypesBias.
+	CompatibilityScoreWeights string
+
+	// RelaxNetworkCompatibility allows replacing instances with spot instances
+	// having lower network performance or fewer network interfaces.
+	RelaxNetworkCompatibility bool
+
+	// EquivalentAcceleratorModels lists the groups of GPU, inference
+	// accelerator or FPGA models that can replace each other.
+	EquivalentAcceleratorModels string
+
+	// MaxVCPU and MaxMemory limit the size of the spot instance types, either
+	// as absolute values or as ratios to the original instance type, such as
+	// "16" or "2x". Unlimited when empty.
+	MaxVCPU   string
+	MaxMemory string
+
+	// MaxPriceRatio limits the spot price of the spot instance types relative
+	// to the price of the original instance type. Unlimited when 0.
+	MaxPriceRatio float64
+
+	// InstanceRequirements selects the spot instance types by their
+	// attributes, in addition to the allowed instance types.
+	InstanceRequirements string
+
+	// ArchitectureImages maps CPU architectures to the images used for
+	// launching spot instances of that architecture, allowing the replacement
+	// of instances with spot instances of another architecture.
+	ArchitectureImages string
+
+	// UserDataTransformers is the comma-separated list of transformers
+	// applied in order to the user data of the spot instances.
+	UserDataTransformers string
+
+	// UserDataEnvironment lists the environment variables injected by the
+	// environment user data transformer.
+	UserDataEnvironment string
+
+	// UserDataAppendScript is the script snippet appended by the
+	// append_script user data transformer.
+	UserDataAppendScript string
+
+	// TagPropagationInclude and TagPropagationExclude are comma-separated
+	// lists of patterns matching the keys of the tags propagated from the
+	// group and the instances to their spot replacements. All the tags are
+	// propagated when no include patterns are set.
+	TagPropagationInclude string
+	TagPropagationExclude string
+
+	// TagPropagationResources lists the resource types tagged with the
+	// propagated tags in addition to the spot instances.
+	TagPropagationResources string
+
+	// ExtraTags lists static tags set on the spot instances and their
+	// resources, in addition to the propagated tags.
+	ExtraTags string
+
+	// ReplaceReservedInstances allows replacing the on-demand instances covered
+	// by capacity reservations or Reserved Instances, which are kept by default.
+	ReplaceReservedInstances bool
+
+	// EmulateLaunchLifecycleHooks triggers the launch lifecycle hooks of the
+	// group for the spot instances, and waits for their completion before
+	// attaching them to the group.
+	EmulateLaunchLifecycleHooks bool
+
+	// AllowBurstableMixing allows replacing burstable instances with
+	// non-burstable spot instances and the other way round.
+	AllowBurstableMixing bool
+
+	// BurstableCPUUtilization is the expected CPU utilization percentage,
+	// used for estimating the cost of the surplus credits of the burstable
+	// spot instances running in unlimited mode.
+	BurstableCPUUtilization float64
+
+	// SkipCreditConstrainedInstances skips the replacement of the burstable
+	// instances running low on CPU credits or spending surplus credits.
+	SkipCreditConstrainedInstances bool
 }
 
 func (a *autoScalingGroup) loadPercentageOnDemand(tagValue *string) (int64, bool) {
@@ -279,6 +460,292 @@ func (a *autoScalingGroup) loadPatchBeanstalkUserdata() bool {
 	return false
 }
 
+func (a *autoScalingGroup) loadRelaxNetworkCompatibility() bool {
+	tagValue := a.getTagValue(RelaxNetworkCompatibilityTag)
+
+	if tagValue != nil {
+		log.Printf("Loaded RelaxNetworkCompatibility value %v from tag %v\n", *tagValue, RelaxNetworkCompatibilityTag)
+		val, err := strconv.ParseBool(*tagValue)
+
+		if err != nil {
+			log.Printf("Failed to parse RelaxNetworkCompatibility value %v as a boolean", *tagValue)
+			a.config.RelaxNetworkCompatibility = a.region.conf.RelaxNetworkCompatibility
+			return false
+		}
+		a.config.RelaxNetworkCompatibility = val
+		return true
+	}
+	debug.Println("Couldn't find tag", RelaxNetworkCompatibilityTag, "on the group", a.name, "using the default configuration")
+	a.config.RelaxNetworkCompatibility = a.region.conf.RelaxNetworkCompatibility
+	return false
+}
+
+func (a *autoScalingGroup) loadReplaceReservedInstances() bool {
+	tagValue := a.getTagValue(ReplaceReservedInstancesTag)
+
+	if tagValue != nil {
+		log.Printf("Loaded ReplaceReservedInstances value %v from tag %v\n", *tagValue, ReplaceReservedInstancesTag)
+		val, err := strconv.ParseBool(*tagValue)
+
+		if err != nil {
+			log.Printf("Failed to parse ReplaceReservedInstances value %v as a boolean", *tagValue)
+			a.config.ReplaceReservedInstances = a.region.conf.ReplaceReservedInstances
+			return false
+		}
+		a.config.ReplaceReservedInstances = val
+		return true
+	}
+	debug.Println("Couldn't find tag", ReplaceReservedInstancesTag, "on the group", a.name, "using the default configuration")
+	a.config.ReplaceReservedInstances = a.region.conf.ReplaceReservedInstances
+	return false
+}
+
+func (a *autoScalingGroup) loadEmulateLaunchLifecycleHooks() bool {
+	tagValue := a.getTagValue(EmulateLaunchLifecycleHooksTag)
+
+	if tagValue != nil {
+		log.Printf("Loaded EmulateLaunchLifecycleHooks value %v from tag %v\n", *tagValue, EmulateLaunchLifecycleHooksTag)
+		val, err := strconv.ParseBool(*tagValue)
+
+		if err != nil {
+			log.Printf("Failed to parse EmulateLaunchLifecycleHooks value %v as a boolean", *tagValue)
+			a.config.EmulateLaunchLifecycleHooks = a.region.conf.EmulateLaunchLifecycleHooks
+			return false
+		}
+		a.config.EmulateLaunchLifecycleHooks = val
+		return true
+	}
+	debug.Println("Couldn't find tag", EmulateLaunchLifecycleHooksTag, "on the group", a.name, "using the default configuration")
+	a.config.EmulateLaunchLifecycleHooks = a.region.conf.EmulateLaunchLifecycleHooks
+	return false
+}
+
+func (a *autoScalingGroup) loadAllowBurstableMixing() bool {
+	tagValue := a.getTagValue(AllowBurstableMixingTag)
+
+	if tagValue != nil {
+		log.Printf("Loaded AllowBurstableMixing value %v from tag %v\n", *tagValue, AllowBurstableMixingTag)
+		val, err := strconv.ParseBool(*tagValue)
+
+		if err != nil {
+			log.Printf("Failed to parse AllowBurstableMixing value %v as a boolean", *tagValue)
+			a.config.AllowBurstableMixing = a.region.conf.AllowBurstableMixing
+			return false
+		}
+		a.config.AllowBurstableMixing = val
+		return true
+	}
+	debug.Println("Couldn't find tag", AllowBurstableMixingTag, "on the group", a.name, "using the default configuration")
+	a.config.AllowBurstableMixing = a.region.conf.AllowBurstableMixing
+	return false
+}
+
+func (a *autoScalingGroup) loadBurstableCPUUtilization() bool {
+	a.config.BurstableCPUUtilization = a.region.conf.BurstableCPUUtilization
+
+	tagValue := a.getTagValue(BurstableCPUUtilizationTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", BurstableCPUUtilizationTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	utilization, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || utilization < 0 || utilization > 100 {
+		log.Printf("Ignoring invalid value %v of tag %v\n", *tagValue, BurstableCPUUtilizationTag)
+		return false
+	}
+
+	log.Printf("Loaded BurstableCPUUtilization value %v from tag %v\n", utilization, BurstableCPUUtilizationTag)
+	a.config.BurstableCPUUtilization = utilization
+	return true
+}
+
+func (a *autoScalingGroup) loadSkipCreditConstrainedInstances() bool {
+	tagValue := a.getTagValue(SkipCreditConstrainedInstancesTag)
+
+	if tagValue != nil {
+		log.Printf("Loaded SkipCreditConstrainedInstances value %v from tag %v\n", *tagValue, SkipCreditConstrainedInstancesTag)
+		val, err := strconv.ParseBool(*tagValue)
+
+		if err != nil {
+			log.Printf("Failed to parse SkipCreditConstrainedInstances value %v as a boolean", *tagValue)
+			a.config.SkipCreditConstrainedInstances = a.region.conf.SkipCreditConstrainedInstances
+			return false
+		}
+		a.config.SkipCreditConstrainedInstances = val
+		return true
+	}
+	debug.Println("Couldn't find tag", SkipCreditConstrainedInstancesTag, "on the group", a.name, "using the default configuration")
+	a.config.SkipCreditConstrainedInstances = a.region.conf.SkipCreditConstrainedInstances
+	return false
+}
+
+func (a *autoScalingGroup) loadEquivalentAcceleratorModels() bool {
+	a.config.EquivalentAcceleratorModels = a.region.conf.EquivalentAcceleratorModels
+
+	tagValue := a.getTagValue(EquivalentAcceleratorModelsTag)
+
+	if tagValue != nil {
+		log.Printf("Loaded EquivalentAcceleratorModels value %v from tag %v\n", *tagValue, EquivalentAcceleratorModelsTag)
+		a.config.EquivalentAcceleratorModels = *tagValue
+		return true
+	}
+
+	debug.Println("Couldn't find tag", EquivalentAcceleratorModelsTag, "on the group", a.name, "using the default configuration")
+	return false
+}
+
+// loadUpperBound loads an upper bound from the given tag, ignoring the invalid
+// values.
+func (a *autoScalingGroup) loadUpperBound(tag string, globalValue string, value *string) bool {
+	*value = globalValue
+
+	tagValue := a.getTagValue(tag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", tag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	if _, err := parseUpperBound(*tagValue); err != nil {
+		log.Printf("Ignoring invalid value %v of tag %v: %v\n", *tagValue, tag, err.Error())
+		return false
+	}
+
+	log.Printf("Loaded value %v from tag %v\n", *tagValue, tag)
+	*value = *tagValue
+	return true
+}
+
+func (a *autoScalingGroup) loadMaxPriceRatio() bool {
+	a.config.MaxPriceRatio = a.region.conf.MaxPriceRatio
+
+	tagValue := a.getTagValue(MaxPriceRatioTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MaxPriceRatioTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	ratio, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || ratio < 0 {
+		log.Printf("Ignoring invalid value %v of tag %v\n", *tagValue, MaxPriceRatioTag)
+		return false
+	}
+
+	log.Printf("Loaded MaxPriceRatio value %v from tag %v\n", ratio, MaxPriceRatioTag)
+	a.config.MaxPriceRatio = ratio
+	return true
+}
+
+func (a *autoScalingGroup) loadInstanceRequirements() bool {
+	a.config.InstanceRequirements = a.region.conf.InstanceRequirements
+
+	tagValue := a.getTagValue(InstanceRequirementsTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", InstanceRequirementsTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	if _, err := parseInstanceRequirements(*tagValue); err != nil {
+		log.Printf("Ignoring invalid value %v of tag %v: %v\n", *tagValue, InstanceRequirementsTag, err.Error())
+		return false
+	}
+
+	log.Printf("Loaded InstanceRequirements value %v from tag %v\n", *tagValue, InstanceRequirementsTag)
+	a.config.InstanceRequirements = *tagValue
+	return true
+}
+
+func (a *autoScalingGroup) loadArchitectureImages() bool {
+	a.config.ArchitectureImages = a.region.conf.ArchitectureImages
+
+	tagValue := a.getTagValue(ArchitectureImagesTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", ArchitectureImagesTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	if _, err := parseArchitectureImages(*tagValue); err != nil {
+		log.Printf("Ignoring invalid value %v of tag %v: %v\n", *tagValue, ArchitectureImagesTag, err.Error())
+		return false
+	}
+
+	log.Printf("Loaded ArchitectureImages value %v from tag %v\n", *tagValue, ArchitectureImagesTag)
+	a.config.ArchitectureImages = *tagValue
+	return true
+}
+
+func (a *autoScalingGroup) loadUserDataTransformers() bool {
+	a.config.UserDataTransformers = a.region.conf.UserDataTransformers
+
+	tagValue := a.getTagValue(UserDataTransformersTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", UserDataTransformersTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	if _, err := parseUserDataTransformers(*tagValue); err != nil {
+		log.Printf("Ignoring invalid value %v of tag %v: %v\n", *tagValue, UserDataTransformersTag, err.Error())
+		return false
+	}
+
+	log.Printf("Loaded UserDataTransformers value %v from tag %v\n", *tagValue, UserDataTransformersTag)
+	a.config.UserDataTransformers = *tagValue
+	return true
+}
+
+func (a *autoScalingGroup) loadUserDataEnvironment() bool {
+	a.config.UserDataEnvironment = a.region.conf.UserDataEnvironment
+
+	tagValue := a.getTagValue(UserDataEnvironmentTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", UserDataEnvironmentTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	if _, err := parseUserDataEnvironment(*tagValue); err != nil {
+		log.Printf("Ignoring invalid value %v of tag %v: %v\n", *tagValue, UserDataEnvironmentTag, err.Error())
+		return false
+	}
+
+	log.Printf("Loaded UserDataEnvironment value %v from tag %v\n", *tagValue, UserDataEnvironmentTag)
+	a.config.UserDataEnvironment = *tagValue
+	return true
+}
+
+func (a *autoScalingGroup) loadUserDataAppendScript() bool {
+	a.config.UserDataAppendScript = a.region.conf.UserDataAppendScript
+
+	tagValue := a.getTagValue(UserDataAppendScriptTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", UserDataAppendScriptTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	log.Printf("Loaded UserDataAppendScript value %v from tag %v\n", *tagValue, UserDataAppendScriptTag)
+	a.config.UserDataAppendScript = *tagValue
+	return true
+}
+
+func (a *autoScalingGroup) loadTagPropagationSetting(tag string, globalValue string, value *string,
+	validate func(string) error) bool {
+	*value = globalValue
+
+	tagValue := a.getTagValue(tag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", tag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	if err := validate(*tagValue); err != nil {
+		log.Printf("Ignoring invalid value %v of tag %v: %v\n", *tagValue, tag, err.Error())
+		return false
+	}
+
+	log.Printf("Loaded value %v from tag %v\n", *tagValue, tag)
+	*value = *tagValue
+	return true
+}
+
 func (a *autoScalingGroup) loadSpotAllocationStrategy() bool {
 	a.config.SpotAllocationStrategy = a.region.conf.SpotAllocationStrategy
 
@@ -309,6 +776,25 @@ func (a *autoScalingGroup) loadPrioritizedInstanceTypesBiasTag() bool {
 	return false
 }
 
+func (a *autoScalingGroup) loadCompatibilityScoreWeights() bool {
+	a.config.CompatibilityScoreWeights = a.region.conf.CompatibilityScoreWeights
+
+	tagValue := a.getTagValue(CompatibilityScoreWeightsTag)
+
+	if tagValue != nil {
+		if _, err := parseScoreWeights(*tagValue); err != nil {
+			log.Printf("Ignoring invalid value %v of tag %v: %v\n", *tagValue, CompatibilityScoreWeightsTag, err.Error())
+			return false
+		}
+		log.Printf("Loaded CompatibilityScoreWeights value %v from tag %v\n", *tagValue, CompatibilityScoreWeightsTag)
+		a.config.CompatibilityScoreWeights = *tagValue
+		return true
+	}
+
+	debug.Println("Couldn't find tag", CompatibilityScoreWeightsTag, "on the group", a.name, "using the default configuration")
+	return false
+}
+
 func (a *autoScalingGroup) loadGP2ConversionThreshold() bool {
 	// setting the default value
 	a.config.GP2ConversionThreshold = a.region.conf.GP2ConversionThreshold
@@ -332,6 +818,26 @@ func (a *autoScalingGroup) loadGP2ConversionThreshold() bool {
 	return true
 }
 
+func (a *autoScalingGroup) loadEBSVolumePolicy() bool {
+	// setting the default value
+	a.config.EBSVolumePolicy = a.region.conf.EBSVolumePolicy
+
+	tagValue := a.getTagValue(EBSVolumePolicyTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", EBSVolumePolicyTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	if _, err := parseEBSVolumePolicy(*tagValue); err != nil {
+		log.Printf("Ignoring invalid value %v of tag %v: %v\n", *tagValue, EBSVolumePolicyTag, err.Error())
+		return false
+	}
+
+	log.Printf("Loaded EBSVolumePolicy value %v from tag %v\n", *tagValue, EBSVolumePolicyTag)
+	a.config.EBSVolumePolicy = *tagValue
+	return true
+}
+
 func (a *autoScalingGroup) loadBiddingPolicy(tagValue *string) (string, bool) {
 	biddingPolicy := *tagValue
 	if biddingPolicy != "aggressive" {
@@ -480,6 +986,11 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 		ret = true
 	}
 
+	if a.loadEBSVolumePolicy() {
+		log.Println("Found and applied configuration for EBS Volume Policy")
+		ret = true
+	}
+
 	if a.loadSpotAllocationStrategy() {
 		log.Println("Found and applied configuration for Spot Allocation Strategy")
 		ret = true
@@ -490,6 +1001,122 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 		ret = true
 	}
 
+	if a.loadCompatibilityScoreWeights() {
+		log.Println("Found and applied configuration for Compatibility Score Weights")
+		ret = true
+	}
+
+	if a.loadRelaxNetworkCompatibility() {
+		log.Println("Found and applied configuration for Relax Network Compatibility")
+		ret = true
+	}
+
+	if a.loadEquivalentAcceleratorModels() {
+		log.Println("Found and applied configuration for Equivalent Accelerator Models")
+		ret = true
+	}
+
+	if a.loadUpperBound(MaxVCPUTag, a.region.conf.MaxVCPU, &a.config.MaxVCPU) {
+		log.Println("Found and applied configuration for Max vCPU")
+		ret = true
+	}
+
+	if a.loadUpperBound(MaxMemoryTag, a.region.conf.MaxMemory, &a.config.MaxMemory) {
+		log.Println("Found and applied configuration for Max Memory")
+		ret = true
+	}
+
+	if a.loadMaxPriceRatio() {
+		log.Println("Found and applied configuration for Max Price Ratio")
+		ret = true
+	}
+
+	if a.loadInstanceRequirements() {
+		log.Println("Found and applied configuration for Instance Requirements")
+		ret = true
+	}
+
+	if a.loadArchitectureImages() {
+		log.Println("Found and applied configuration for Architecture Images")
+		ret = true
+	}
+
+	if a.loadUserDataTransformers() {
+		log.Println("Found and applied configuration for User Data Transformers")
+		ret = true
+	}
+
+	if a.loadUserDataEnvironment() {
+		log.Println("Found and applied configuration for User Data Environment")
+		ret = true
+	}
+
+	if a.loadUserDataAppendScript() {
+		log.Println("Found and applied configuration for User Data Append Script")
+		ret = true
+	}
+
+	if a.loadTagPropagationSetting(TagPropagationIncludeTag, a.region.conf.TagPropagationInclude,
+		&a.config.TagPropagationInclude, func(s string) error {
+			_, err := parseTagPatterns(s)
+			return err
+		}) {
+		log.Println("Found and applied configuration for Tag Propagation Include")
+		ret = true
+	}
+
+	if a.loadTagPropagationSetting(TagPropagationExcludeTag, a.region.conf.TagPropagationExclude,
+		&a.config.TagPropagationExclude, func(s string) error {
+			_, err := parseTagPatterns(s)
+			return err
+		}) {
+		log.Println("Found and applied configuration for Tag Propagation Exclude")
+		ret = true
+	}
+
+	if a.loadTagPropagationSetting(TagPropagationResourcesTag, a.region.conf.TagPropagationResources,
+		&a.config.TagPropagationResources, func(s string) error {
+			_, err := parseTagPropagationResources(s)
+			return err
+		}) {
+		log.Println("Found and applied configuration for Tag Propagation Resources")
+		ret = true
+	}
+
+	if a.loadTagPropagationSetting(ExtraTagsTag, a.region.conf.ExtraTags,
+		&a.config.ExtraTags, func(s string) error {
+			_, err := parseExtraTags(s)
+			return err
+		}) {
+		log.Println("Found and applied configuration for Extra Tags")
+		ret = true
+	}
+
+	if a.loadReplaceReservedInstances() {
+		log.Println("Found and applied configuration for Replace Reserved Instances")
+		ret = true
+	}
+
+	if a.loadEmulateLaunchLifecycleHooks() {
+		log.Println("Found and applied configuration for Emulate Launch Lifecycle Hooks")
+		ret = true
+	}
+
+	if a.loadAllowBurstableMixing() {
+		log.Println("Found and applied configuration for Allow Burstable Mixing")
+		ret = true
+	}
+
+	if a.loadBurstableCPUUtilization() {
+		log.Println("Found and applied configuration for Burstable CPU Utilization")
+		ret = true
+	}
+
+	if a.loadSkipCreditConstrainedInstances() {
+		log.Println("Found and applied configuration for Skip Credit Constrained Instances")
+		ret = true
+	}
+
 	return ret
 }
 
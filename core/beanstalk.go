@@ -69,15 +69,22 @@ func getPatchedUserDataForBeanstalk(userData *string) *string {
 	decodedUserData := decodeUserData(userData)
 
 	// Patch the UserData if possible
-	if strings.Contains(*decodedUserData, "ebbootstrap") {
+	patchedUserData := patchBeanstalkUserData(*decodedUserData)
+	if patchedUserData != *decodedUserData {
+		return encodeUserData(&patchedUserData)
+	}
+
+	return userData
+}
+
+func patchBeanstalkUserData(userData string) string {
+	if strings.Contains(userData, "ebbootstrap") {
 		// Force set the role for calling CloudFormation helpers to be the instance role
 		// The UserData created by Beanstalk is encoded as a Mime Multi Part Archive
 		// with Cloud Init User-Data format (https://cloudinit.readthedocs.io/en/latest/topics/format.html)
 		// We can't simply append our extra code to it, we need to add it to the correct mime part
 		// Hence, we replace the first `#!/bin/bash` with our wrapper
-		patchedUserData := strings.Replace(*decodedUserData, "#!/bin/bash\n", "#!/bin/bash\n"+beanstalkUserDataCFNWrappers, 1)
-		return encodeUserData(&patchedUserData)
+		return strings.Replace(userData, "#!/bin/bash\n", "#!/bin/bash\n"+beanstalkUserDataCFNWrappers, 1)
 	}
-
 	return userData
 }
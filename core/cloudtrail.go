@@ -18,5 +18,6 @@ type RequestParameters struct {
 	LifecycleHookName     string `json:"lifecycleHookName"`
 	InstanceID            string `json:"instanceId"`
 	LifecycleActionResult string `json:"lifecycleActionResult"`
+	LifecycleActionToken  string `json:"lifecycleActionToken"`
 	AutoScalingGroupName  string `json:"autoScalingGroupName"`
 }
@@ -123,6 +123,46 @@ type Config struct {
 
 	// BillingOnly - only billing related actions will be taken, no instance replacement will be performed.
 	BillingOnly bool
+
+	// Maximum number of retries for each AWS API call failing with throttling
+	// or other transient errors
+	APIMaxRetries int
+
+	// Total number of AWS API call retries allowed during a single run
+	APIRetryBudget int
+
+	// Maximum number of AWS API calls per second issued against each region
+	APIRateLimit float64
+
+	// Storage used for persisting the state of the instance replacements
+	// between runs. Available options: 'tags' and 'memory', default: 'tags'
+	ReplacementStateStore string
+
+	// Controls the reconciliation of the resources left behind by failed runs.
+	// Available options: 'repair', 'report' and 'off', default: 'repair'
+	ReconciliationMode string
+
+	// Name of the DynamoDB table storing the locks of the AutoScaling groups,
+	// if empty the locks are only kept in memory
+	GroupLockTable string
+
+	// Duration of the group locks, after which the locks held by crashed runs
+	// expire
+	GroupLockLease time.Duration
+
+	// Duration for which the instance type catalog loaded from the EC2 API is
+	// cached, if zero it's loaded on every run
+	InstanceTypeCatalogTTL time.Duration
+
+	// File containing the Savings Plans rates exported using the AWS CLI,
+	// used for determining the effective on-demand prices
+	SavingsPlansRatesFile string
+
+	// Loads the Savings Plans rates from the Savings Plans API
+	SavingsPlansFromAPI bool
+
+	// Percentage of the on-demand usage covered by Savings Plans
+	SavingsPlansCoverage float64
 }
 
 // ParseConfig loads configuration from command line flags, environments variables, and config files.
@@ -255,6 +295,15 @@ func ParseConfig(conf *Config) {
 			"1TB GP2 also has better IOPS than a baseline GP3 volume.\n"+
 			"\tExample: ./AutoSpotting --ebs_gp2_conversion_threshold 170\n")
 
+	flagSet.StringVar(&conf.EBSVolumePolicy, "ebs_volume_policy", DefaultEBSVolumePolicy,
+		"\n\tComma-separated list of from:to EBS volume type conversions applied to the volumes of the\n"+
+			"\tspot instances. The supported conversions are gp2:gp3, io1:io2, st1:gp3 and sc1:gp3, and\n"+
+			"\tthe value none disables them. The IOPS and throughput of the gp3 volumes are provisioned to\n"+
+			"\tmatch the baseline performance of the original volumes, and the estimated savings are reported.\n"+
+			"\tThe gp2:gp3 conversion is only done below the ebs_gp2_conversion_threshold volume size.\n"+
+			"\tThe tag "+EBSVolumePolicyTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --ebs_volume_policy gp2:gp3,io1:io2,st1:gp3\n")
+
 	flagSet.BoolVar(&conf.DisableEventBasedInstanceReplacement, "disable_event_based_instance_replacement", false,
 		"\n\tDisables the event based instance replacement, forcing the legacy cron mode.\n"+
 			"\tExample: ./AutoSpotting --disable_event_based_instance_replacement=true\n")
@@ -279,8 +328,217 @@ func ParseConfig(conf *Config) {
 		"\n\tControls the ordering of instance types when using the capacity-optimized-prioritized\n"+
 			"\tSpot allocation strategy. By default, using the 'lower_cost' bias it sorts instances by Spot price\n"+
 			"\tAlternatively, you can bias towards newer instance types by using the 'prefer_newer_generations' bias\n"+
+			"\tor towards the instance types closest to the replaced instance by using the 'best_fit' bias, which\n"+
+			"\tranks them by a weighted compatibility score.\n"+
 			"\tExample: ./AutoSpotting --prioritized_instance_types_bias lower_cost\n")
 
+	flagSet.StringVar(&conf.CompatibilityScoreWeights, "compatibility_score_weights", DefaultCompatibilityScoreWeights,
+		"\n\tWeights of the dimensions of the compatibility score used by the 'best_fit' bias, as a comma-separated\n"+
+			"\tlist of dimension=weight pairs. The dimensions missing from the list keep their default weight:\n"+
+			"\t\t'vcpu' - favours the instance types with the vCPU count closest to the replaced instance\n"+
+			"\t\t'memory' - favours the instance types with the memory size closest to the replaced instance\n"+
+			"\t\t'network' - penalizes the instance types with lower network performance\n"+
+			"\t\t'ebs' - penalizes the instance types with lower EBS throughput\n"+
+			"\t\t'generation' - favours the latest generation in each instance family\n"+
+			"\t\t'price' - favours the instance types with the biggest savings\n"+
+			"\tThe tag "+CompatibilityScoreWeightsTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --compatibility_score_weights vcpu=2,memory=2,price=1\n")
+
+	flagSet.BoolVar(&conf.RelaxNetworkCompatibility, "relax_network_compatibility", false,
+		"\n\tAllows replacing instances with spot instance types having lower network performance, fewer\n"+
+			"\tnetwork interfaces or IPv4 addresses, or lacking the ENA or EFA support of the original instance type.\n"+
+			"\tThe tag "+RelaxNetworkCompatibilityTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --relax_network_compatibility true\n")
+
+	flagSet.StringVar(&conf.EquivalentAcceleratorModels, "equivalent_accelerator_models", "",
+		"\n\tBy default the instances with GPUs, inference accelerators or FPGAs are only replaced with spot\n"+
+			"\tinstance types having the same accelerator manufacturer and model, with at least as many devices\n"+
+			"\tand as much memory on each of them. This lists the groups of accelerator models that can also\n"+
+			"\treplace each other, as semicolon-separated groups of comma-separated model names.\n"+
+			"\tThe tag "+EquivalentAcceleratorModelsTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --equivalent_accelerator_models 'T4,A10G;V100,A100'\n")
+
+	flagSet.StringVar(&conf.MaxVCPU, "max_vcpu", "",
+		"\n\tMaximum number of vCPUs of the spot instance types, either as an absolute value or as a ratio\n"+
+			"\tto the vCPU count of the replaced instance type when suffixed with 'x'. Unlimited by default.\n"+
+			"\tThe tag "+MaxVCPUTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --max_vcpu 2x\n")
+
+	flagSet.StringVar(&conf.MaxMemory, "max_memory", "",
+		"\n\tMaximum memory size in GiB of the spot instance types, either as an absolute value or as a ratio\n"+
+			"\tto the memory size of the replaced instance type when suffixed with 'x'. Unlimited by default.\n"+
+			"\tThe tag "+MaxMemoryTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --max_memory 64\n")
+
+	flagSet.Float64Var(&conf.MaxPriceRatio, "max_price_ratio", 0,
+		"\n\tMaximum spot price of the spot instance types, as a ratio to the spot price of the replaced\n"+
+			"\tinstance type in the same availability zone, or to its on-demand price when that's unavailable.\n"+
+			"\tAvoids replacing small instances with much larger ones that happen to be cheaper than the\n"+
+			"\ton-demand price. Unlimited when set to 0, the default.\n"+
+			"\tThe tag "+MaxPriceRatioTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --max_price_ratio 1.5\n")
+
+	flagSet.StringVar(&conf.InstanceRequirements, "instance_requirements", "",
+		"\n\tSemicolon-separated list of attributes required for the spot instance types, as an alternative\n"+
+			"\tto listing the allowed instance types. The supported attributes are vcpu, memory (GiB) and\n"+
+			"\tmemory_per_vcpu as ranges like 2-8, 2- or -8, cpu_manufacturer as a list of intel, amd and aws,\n"+
+			"\tburstable, bare_metal and local_storage set to included, excluded or required, and generations\n"+
+			"\tas a list of current and previous. The missing attributes don't restrict the instance types.\n"+
+			"\tThe tag "+InstanceRequirementsTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --instance_requirements 'vcpu=2-8;cpu_manufacturer=intel,amd;burstable=excluded'\n")
+
+	flagSet.StringVar(&conf.ArchitectureImages, "architecture_images", "",
+		"\n\tComma-separated list of architecture=image pairs, allowing the replacement of instances with spot\n"+
+			"\tinstances of other CPU architectures, launched from the image configured for their architecture.\n"+
+			"\tThe supported architectures are x86_64 and arm64, and the images can be AMI IDs or the paths of SSM\n"+
+			"\tparameters storing the AMI IDs. The instances keep using their own image for their architecture.\n"+
+			"\tThe tag "+ArchitectureImagesTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --architecture_images 'arm64=/my-service/ami/arm64'\n")
+
+	flagSet.StringVar(&conf.UserDataTransformers, "userdata_transformers", "",
+		"\n\tComma-separated list of transformers applied in order to the user data of the spot instances.\n"+
+			"\tThe supported transformers are beanstalk, patching the Elastic Beanstalk user data like the\n"+
+			"\tpatch_beanstalk_userdata option, environment, exporting the variables configured using the\n"+
+			"\tuserdata_environment option to the shell scripts, and append_script, appending the script snippet\n"+
+			"\tconfigured using the userdata_append_script option. MIME multipart user data is also supported.\n"+
+			"\tThe tag "+UserDataTransformersTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --userdata_transformers environment,append_script\n")
+
+	flagSet.StringVar(&conf.UserDataEnvironment, "userdata_environment", DefaultUserDataEnvironment,
+		"\n\tComma-separated list of environment variables injected by the environment user data transformer.\n"+
+			"\tThe tag "+UserDataEnvironmentTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --userdata_environment AUTOSPOTTING_LIFECYCLE=spot,STAGE=prod\n")
+
+	flagSet.StringVar(&conf.UserDataAppendScript, "userdata_append_script", "",
+		"\n\tShell script snippet appended to the user data by the append_script user data transformer.\n"+
+			"\tThe tag "+UserDataAppendScriptTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --userdata_append_script 'systemctl start spot-termination-handler'\n")
+
+	flagSet.StringVar(&conf.TagPropagationInclude, "tag_propagation_include", "",
+		"\n\tComma-separated list of patterns matching the keys of the tags propagated from the groups\n"+
+			"\tand the replaced instances to the spot instances and their resources. The tags of the groups\n"+
+			"\tare propagated if they are set to be propagated at launch. All the tags are propagated by default.\n"+
+			"\tThe tag "+TagPropagationIncludeTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --tag_propagation_include 'Name,team,cost-*'\n")
+
+	flagSet.StringVar(&conf.TagPropagationExclude, "tag_propagation_exclude", "",
+		"\n\tComma-separated list of patterns matching the keys of the tags which shouldn't be propagated.\n"+
+			"\tThe tag "+TagPropagationExcludeTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --tag_propagation_exclude 'kubernetes.io/*'\n")
+
+	flagSet.StringVar(&conf.TagPropagationResources, "tag_propagation_resources", DefaultTagPropagationResources,
+		"\n\tComma-separated list of resource types tagged with the propagated tags, in addition to the spot\n"+
+			"\tinstances. The supported resource types are volume, network-interface and spot-instances-request.\n"+
+			"\tThe tag "+TagPropagationResourcesTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --tag_propagation_resources volume,network-interface,spot-instances-request\n")
+
+	flagSet.StringVar(&conf.ExtraTags, "extra_tags", "",
+		"\n\tComma-separated list of key=value tags set on the spot instances and their resources, in addition\n"+
+			"\tto the propagated tags.\n"+
+			"\tThe tag "+ExtraTagsTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --extra_tags lifecycle=spot,team=data\n")
+
+	flagSet.BoolVar(&conf.ReplaceReservedInstances, "replace_reserved_instances", false,
+		"\n\tAllows replacing the on-demand instances consuming On-Demand Capacity Reservations or covered\n"+
+			"\tby Reserved Instances, which are already paid for, so by default they're not replaced.\n"+
+			"\tThe tag "+ReplaceReservedInstancesTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --replace_reserved_instances true\n")
+
+	flagSet.BoolVar(&conf.EmulateLaunchLifecycleHooks, "emulate_launch_lifecycle_hooks", false,
+		"\n\tTriggers the launch lifecycle hooks of the groups for the attached spot instances, which otherwise\n"+
+			"\tdon't run them, by publishing their notifications to the SNS topics or SQS queues of the hooks, or\n"+
+			"\tto EventBridge using the '"+lifecycleHookEventSource+"' event source, so the EventBridge rules of the\n"+
+			"\thooks need to also match this source. The spot instances are only attached once the hooks were\n"+
+			"\tcompleted using CompleteLifecycleAction or timed out, and terminated if the hooks were abandoned.\n"+
+			"\tRecordLifecycleActionHeartbeat extends the hooks like for new instances. Disabled by default, the tag\n"+
+			"\t"+EmulateLaunchLifecycleHooksTag+" can be used to enable it on a group level.\n"+
+			"\tExample: ./AutoSpotting --emulate_launch_lifecycle_hooks true\n")
+
+	flagSet.BoolVar(&conf.AllowBurstableMixing, "allow_burstable_mixing", false,
+		"\n\tAllows replacing burstable instances, such as the t3 family, with non-burstable spot instance\n"+
+			"\ttypes and the other way round. By default burstable instances are only replaced with burstable ones.\n"+
+			"\tThe tag "+AllowBurstableMixingTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --allow_burstable_mixing true\n")
+
+	flagSet.Float64Var(&conf.BurstableCPUUtilization, "burstable_cpu_utilization", 0,
+		"\n\tExpected average CPU utilization percentage of the instances, used for adding the cost of the\n"+
+			"\tsurplus credits to the price of the burstable spot instance types running in unlimited mode.\n"+
+			"\tWhen set to 0, the default, burstable instances are assumed to run at their baseline and the\n"+
+			"\tother instances aren't expected to spend surplus credits.\n"+
+			"\tThe tag "+BurstableCPUUtilizationTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --burstable_cpu_utilization 35\n")
+
+	flagSet.BoolVar(&conf.SkipCreditConstrainedInstances, "skip_credit_constrained_instances", false,
+		"\n\tSkips the replacement of burstable instances running low on CPU credits or spending surplus\n"+
+			"\tcredits, based on their CloudWatch metrics.\n"+
+			"\tThe tag "+SkipCreditConstrainedInstancesTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --skip_credit_constrained_instances true\n")
+
+	flagSet.IntVar(&conf.APIMaxRetries, "aws_api_max_retries", DefaultAPIMaxRetries,
+		"\n\tMaximum number of retries for each AWS API call failing with throttling or other transient\n"+
+			"\terrors, using a jittered exponential backoff between attempts.\n"+
+			"\tExample: ./AutoSpotting --aws_api_max_retries 8\n")
+
+	flagSet.IntVar(&conf.APIRetryBudget, "aws_api_retry_budget", DefaultAPIRetryBudget,
+		"\n\tTotal number of AWS API call retries allowed during a single run, across all regions and groups.\n"+
+			"\tOnce exhausted, failing API calls are no longer retried until the next run.\n"+
+			"\tExample: ./AutoSpotting --aws_api_retry_budget 500\n")
+
+	flagSet.Float64Var(&conf.APIRateLimit, "aws_api_rate_limit", DefaultAPIRateLimit,
+		"\n\tMaximum number of AWS API calls per second issued against each region, useful for avoiding\n"+
+			"\tRequestLimitExceeded errors when managing many groups.\n"+
+			"\tExample: ./AutoSpotting --aws_api_rate_limit 20\n")
+
+	flagSet.StringVar(&conf.ReplacementStateStore, "replacement_state_store", DefaultReplacementStateStore,
+		"\n\tStorage used for persisting the state of the instance replacements, allowing later runs to resume\n"+
+			"\tthe replacements interrupted by the end of a previous run. Available options:\n"+
+			"\t\t'tags' - the state is kept in tags set on the spot instances\n"+
+			"\t\t'memory' - the state is kept in memory, only useful when running as a long-lived process\n"+
+			"\tExample: ./AutoSpotting --replacement_state_store tags\n")
+
+	flagSet.StringVar(&conf.ReconciliationMode, "reconciliation_mode", DefaultReconciliationMode,
+		"\n\tControls the handling of the resources left behind by failed runs, such as orphan spot instances,\n"+
+			"\ttemporary launch templates, suspended AutoScaling processes and raised MaxSize values.\n"+
+			"\tThey are checked during the cron runs and added to the final recap. Available options:\n"+
+			"\t\t'repair' - the resources are also cleaned up and the group changes recorded in the marker tags reverted\n"+
+			"\t\t'report' - the resources are only reported\n"+
+			"\t\t'off' - no reconciliation is performed\n"+
+			"\tExample: ./AutoSpotting --reconciliation_mode report\n")
+
+	flagSet.StringVar(&conf.GroupLockTable, "group_lock_table", "",
+		"\n\tName of the DynamoDB table storing the locks that prevent concurrent runs from changing the same\n"+
+			"\tAutoScaling group at once. The table needs a string hash key named 'LockKey', and should be in the\n"+
+			"\tmain region. If not set, the locks are only kept in memory, which only protects the runs executed\n"+
+			"\tby the same process.\n"+
+			"\tExample: ./AutoSpotting --group_lock_table AutoSpotting-Locks\n")
+
+	flagSet.DurationVar(&conf.GroupLockLease, "group_lock_lease", DefaultGroupLockLease,
+		"\n\tDuration of the group locks, renewed while the group is being changed. The locks held by the\n"+
+			"\truns that crashed or timed out are released after this duration.\n"+
+			"\tExample: ./AutoSpotting --group_lock_lease 15m\n")
+
+	flagSet.DurationVar(&conf.InstanceTypeCatalogTTL, "instance_type_catalog_ttl", DefaultInstanceTypeCatalogTTL,
+		"\n\tDuration for which the instance type catalog loaded from the EC2 API is cached between runs. The\n"+
+			"\tcatalog adds the instance types launched after this build and the availability zones in which each\n"+
+			"\tinstance type is offered to the embedded instance type data. Set to 0 to load it on every run.\n"+
+			"\tExample: ./AutoSpotting --instance_type_catalog_ttl 1h\n")
+
+	flagSet.StringVar(&conf.SavingsPlansRatesFile, "savings_plans_rates_file", "",
+		"\n\tJSON file containing the rates of the Savings Plans covering the on-demand instances, as exported\n"+
+			"\tby 'aws savingsplans describe-savings-plan-rates', either a single output or a list of outputs.\n"+
+			"\tThe on-demand prices of the covered instance types are discounted accordingly, and the instances\n"+
+			"\tare only replaced with spot instances cheaper than the discounted price.\n"+
+			"\tExample: ./AutoSpotting --savings_plans_rates_file savings_plans_rates.json\n")
+
+	flagSet.BoolVar(&conf.SavingsPlansFromAPI, "savings_plans_from_api", false,
+		"\n\tLoads the rates of the active Savings Plans from the Savings Plans API instead of a file.\n"+
+			"\tExample: ./AutoSpotting --savings_plans_from_api true\n")
+
+	flagSet.Float64Var(&conf.SavingsPlansCoverage, "savings_plans_coverage", DefaultSavingsPlansCoverage,
+		"\n\tPercentage of the on-demand usage covered by Savings Plans, used for blending their rates with the\n"+
+			"\ton-demand list prices into the effective on-demand prices.\n"+
+			"\tExample: ./AutoSpotting --savings_plans_coverage 80\n")
+
 	printVersion := flagSet.Bool("version", false, "Print version number and exit.\n")
 
 	if err := flagSet.Parse(os.Args[1:]); err != nil {
@@ -292,6 +550,10 @@ func ParseConfig(conf *Config) {
 		os.Exit(0)
 	}
 
+	if err := validateUpperBounds(conf); err != nil {
+		log.Fatal(err.Error())
+	}
+
 	data, err := ec2instancesinfo.Data()
 	if err != nil {
 		log.Fatal(err.Error())
@@ -300,3 +562,15 @@ func ParseConfig(conf *Config) {
 
 	conf.FinalRecap = make(map[string][]string)
 }
+
+// validateUpperBounds checks the vCPU and memory upper bounds given as flags,
+// so that invalid values aren't silently ignored as unlimited.
+func validateUpperBounds(conf *Config) error {
+	if _, err := parseUpperBound(conf.MaxVCPU); err != nil {
+		return fmt.Errorf("invalid max_vcpu: %s", err.Error())
+	}
+	if _, err := parseUpperBound(conf.MaxMemory); err != nil {
+		return fmt.Errorf("invalid max_memory: %s", err.Error())
+	}
+	return nil
+}
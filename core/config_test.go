@@ -67,6 +67,7 @@ func TestParseConfig(t *testing.T) {
 			assert.Equal(t, config.LogFile, os.Stdout)
 			assert.Equal(t, config.SleepMultiplier, time.Duration(1))
 			assert.Assert(t, config.InstanceData != nil, "expected InstanceData to be initialized")
+			assert.Assert(t, !config.EmulateLaunchLifecycleHooks, "expected the lifecycle hook emulation to be opt-in")
 		})
 
 		// reset environment variables
@@ -77,3 +78,23 @@ func TestParseConfig(t *testing.T) {
 		}
 	}
 }
+
+func Test_validateUpperBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    Config
+		wantErr bool
+	}{
+		{name: "unlimited", conf: Config{}},
+		{name: "valid", conf: Config{AutoScalingConfig: AutoScalingConfig{MaxVCPU: "2x", MaxMemory: "64"}}},
+		{name: "invalid vCPU", conf: Config{AutoScalingConfig: AutoScalingConfig{MaxVCPU: "2X"}}, wantErr: true},
+		{name: "invalid memory", conf: Config{AutoScalingConfig: AutoScalingConfig{MaxMemory: "64GiB"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateUpperBounds(&tt.conf); (err != nil) != tt.wantErr {
+				t.Errorf("validateUpperBounds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -13,12 +13,22 @@ import (
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
 	"github.com/aws/aws-sdk-go/service/codedeploy"
 	"github.com/aws/aws-sdk-go/service/codedeploy/codedeployiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/aws/aws-sdk-go/service/savingsplans/savingsplansiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
@@ -31,12 +41,17 @@ type connections struct {
 	lambda         lambdaiface.LambdaAPI
 	sqs            sqsiface.SQSAPI
 	codedeploy     codedeployiface.CodeDeployAPI
+	dynamoDB       dynamodbiface.DynamoDBAPI
+	cloudWatch     cloudwatchiface.CloudWatchAPI
+	savingsPlans   savingsplansiface.SavingsPlansAPI
+	sns            snsiface.SNSAPI
+	eventBridge    eventbridgeiface.EventBridgeAPI
+	regionalSQS    sqsiface.SQSAPI // sqs is connected to the main region
 	region         string
 }
 
 func (c *connections) setSession(region string) {
-	c.session = session.Must(
-		session.NewSession(&aws.Config{Region: aws.String(region)}))
+	c.session = session.Must(newAPISession(region))
 }
 
 func (c *connections) connect(region, mainRegion string) {
@@ -53,6 +68,12 @@ func (c *connections) connect(region, mainRegion string) {
 	lambdaConn := make(chan *lambda.Lambda)
 	sqsConn := make(chan *sqs.SQS)
 	codedeployConn := make(chan *codedeploy.CodeDeploy)
+	dynamoDBConn := make(chan *dynamodb.DynamoDB)
+	cloudWatchConn := make(chan *cloudwatch.CloudWatch)
+	savingsPlansConn := make(chan *savingsplans.SavingsPlans)
+	snsConn := make(chan *sns.SNS)
+	eventBridgeConn := make(chan *eventbridge.EventBridge)
+	regionalSQSConn := make(chan *sqs.SQS)
 
 	go func() { asConn <- autoscaling.New(c.session) }()
 	go func() { ec2Conn <- ec2.New(c.session) }()
@@ -60,8 +81,16 @@ func (c *connections) connect(region, mainRegion string) {
 	go func() { cloudformationConn <- cloudformation.New(c.session) }()
 	go func() { codedeployConn <- codedeploy.New(c.session) }()
 	go func() { sqsConn <- sqs.New(c.session, aws.NewConfig().WithRegion(mainRegion)) }()
+	go func() { dynamoDBConn <- dynamodb.New(c.session, aws.NewConfig().WithRegion(mainRegion)) }()
+	go func() { cloudWatchConn <- cloudwatch.New(c.session) }()
+	// the Savings Plans API is global, served from us-east-1
+	go func() { savingsPlansConn <- savingsplans.New(c.session, aws.NewConfig().WithRegion("us-east-1")) }()
+	go func() { snsConn <- sns.New(c.session) }()
+	go func() { eventBridgeConn <- eventbridge.New(c.session) }()
+	go func() { regionalSQSConn <- sqs.New(c.session) }()
 
-	c.autoScaling, c.ec2, c.cloudFormation, c.lambda, c.sqs, c.codedeploy, c.region = <-asConn, <-ec2Conn, <-cloudformationConn, <-lambdaConn, <-sqsConn, <-codedeployConn, region
+	c.autoScaling, c.ec2, c.cloudFormation, c.lambda, c.sqs, c.codedeploy, c.dynamoDB, c.cloudWatch, c.savingsPlans, c.region = <-asConn, <-ec2Conn, <-cloudformationConn, <-lambdaConn, <-sqsConn, <-codedeployConn, <-dynamoDBConn, <-cloudWatchConn, <-savingsPlansConn, region
+	c.sns, c.eventBridge, c.regionalSQS = <-snsConn, <-eventBridgeConn, <-regionalSQSConn
 
 	debug.Println("Created service connections in", region)
 }
@@ -0,0 +1,266 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// DefaultEBSVolumePolicy is the default list of EBS volume type conversions
+	// applied to the volumes of the spot instances.
+	DefaultEBSVolumePolicy = "gp2:gp3,io1:io2"
+
+	// disabledEBSVolumePolicy disables the EBS volume type conversions.
+	disabledEBSVolumePolicy = "none"
+
+	// the baseline performance included in the price of gp3 volumes
+	gp3BaselineIOPS       = 3000
+	gp3BaselineThroughput = 125
+
+	// the maximum performance of gp2 and gp3 volumes
+	gp2MaxIOPS       = 16000
+	gp3MaxThroughput = 1000
+
+	// the maximum throughput of gp2 volumes of up to 170GiB, and above
+	gp2SmallVolumeThroughput = 128
+	gp2SmallVolumeMaxSize    = 170
+	gp2LargeVolumeThroughput = 250
+
+	// the burst throughput per TiB and the maximum throughput of the HDD
+	// volumes, in MiB/s
+	st1ThroughputPerTiB = 250
+	st1MaxThroughput    = 500
+	sc1ThroughputPerTiB = 80
+	sc1MaxThroughput    = 250
+)
+
+// supportedEBSVolumeConversions lists the supported volume type conversions,
+// for each source volume type.
+var supportedEBSVolumeConversions = map[string]string{
+	"gp2": "gp3",
+	"io1": "io2",
+	"st1": "gp3",
+	"sc1": "gp3",
+}
+
+// ebsMonthlyPrices are the us-east-1 prices per GiB-month of the EBS volume
+// types, only used for estimating the savings of the volume conversions.
+var ebsMonthlyPrices = map[string]float64{
+	"gp2": 0.10,
+	"gp3": 0.08,
+	"io1": 0.125,
+	"io2": 0.125,
+	"st1": 0.045,
+	"sc1": 0.015,
+}
+
+const (
+	gp3MonthlyIOPSPrice       = 0.005
+	gp3MonthlyThroughputPrice = 0.04
+	io1MonthlyIOPSPrice       = 0.065
+)
+
+// ebsVolumeConversion is a volume type conversion applied to a block device of
+// the spot instance.
+type ebsVolumeConversion struct {
+	from    string
+	to      string
+	size    int64
+	savings float64
+}
+
+// parseEBSVolumePolicy parses comma-separated lists of volume type conversions
+// such as "gp2:gp3,io1:io2". The empty policy is the default one.
+func parseEBSVolumePolicy(spec string) (map[string]string, error) {
+	spec = strings.TrimSpace(spec)
+
+	switch spec {
+	case "":
+		spec = DefaultEBSVolumePolicy
+	case disabledEBSVolumePolicy:
+		return map[string]string{}, nil
+	}
+
+	policy := make(map[string]string)
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		types := strings.Split(item, ":")
+		if len(types) != 2 {
+			return nil, fmt.Errorf("invalid volume conversion %q, expected from:to", item)
+		}
+
+		from, to := strings.TrimSpace(types[0]), strings.TrimSpace(types[1])
+		if supportedEBSVolumeConversions[from] != to {
+			return nil, fmt.Errorf("unsupported volume conversion %q", item)
+		}
+		policy[from] = to
+	}
+	return policy, nil
+}
+
+// ebsVolumePolicy returns the volume type conversions of the group, falling
+// back to the default ones if the configured policy is invalid.
+func (a *autoScalingGroup) ebsVolumePolicy() map[string]string {
+	policy, err := parseEBSVolumePolicy(a.config.EBSVolumePolicy)
+	if err != nil {
+		log.Printf("%s Ignoring the EBS volume policy %q: %s",
+			a.name, a.config.EBSVolumePolicy, err.Error())
+		policy, _ = parseEBSVolumePolicy(DefaultEBSVolumePolicy)
+	}
+	return policy
+}
+
+// gp3Performance returns the IOPS and throughput of a gp3 volume matching or
+// exceeding the performance of a volume of the given type and size.
+func gp3Performance(volumeType string, size int64) (int64, int64) {
+	iops := int64(gp3BaselineIOPS)
+	var throughput int64
+
+	switch volumeType {
+	case "gp2":
+		iops = max64(iops, min64(3*size, gp2MaxIOPS))
+		throughput = gp2LargeVolumeThroughput
+		if size <= gp2SmallVolumeMaxSize {
+			throughput = gp2SmallVolumeThroughput
+		}
+	case "st1":
+		throughput = min64(int64(math.Ceil(float64(size)*st1ThroughputPerTiB/1024)), st1MaxThroughput)
+	case "sc1":
+		throughput = min64(int64(math.Ceil(float64(size)*sc1ThroughputPerTiB/1024)), sc1MaxThroughput)
+	}
+
+	throughput = min64(max64(throughput, gp3BaselineThroughput), gp3MaxThroughput)
+	return iops, throughput
+}
+
+// ebsMonthlyCost estimates the monthly cost of a volume.
+func ebsMonthlyCost(volumeType string, size, iops, throughput int64) float64 {
+	cost := ebsMonthlyPrices[volumeType] * float64(size)
+
+	switch volumeType {
+	case "gp3":
+		cost += gp3MonthlyIOPSPrice*float64(max64(iops-gp3BaselineIOPS, 0)) +
+			gp3MonthlyThroughputPrice*float64(max64(throughput-gp3BaselineThroughput, 0))
+	case "io1", "io2":
+		cost += io1MonthlyIOPSPrice * float64(iops)
+	}
+	return cost
+}
+
+// applyEBSVolumePolicy converts the volume type of the block device according
+// to the policy of the group, provisioning the IOPS and throughput needed for
+// matching the performance of the original volume.
+func (i *instance) applyEBSVolumePolicy(deviceName *string, ebs *ec2.LaunchTemplateEbsBlockDeviceRequest) {
+	r := i.asg.region.name
+	asg := i.asg.name
+
+	if ebs.VolumeType == nil {
+		log.Println(r, ": Empty EBS VolumeType while converting volume for ASG", asg)
+		return
+	}
+
+	from := *ebs.VolumeType
+	to, ok := i.asg.ebsVolumePolicy()[from]
+	if !ok {
+		log.Println(r, ": No EBS volume conversion could be done for", asg)
+		return
+	}
+
+	size := aws.Int64Value(ebs.VolumeSize)
+	iops := aws.Int64Value(ebs.Iops)
+	throughput := aws.Int64Value(ebs.Throughput)
+	cost := ebsMonthlyCost(from, size, iops, throughput)
+
+	switch {
+	case to == "io2":
+		if !supportedIO2region(r) {
+			return
+		}
+
+	case size == 0:
+		log.Println(r, ": Not converting", from, "EBS volume of unknown size for", asg)
+		return
+
+	// convert GP2 to GP3 below the configurable threshold
+	case from == "gp2" && size > i.asg.config.GP2ConversionThreshold:
+		log.Println(r, ": Not converting GP2 EBS volume larger than the configured threshold for", asg)
+		return
+
+	case to == "gp3":
+		iops, throughput = gp3Performance(from, size)
+		ebs.Iops, ebs.Throughput = nil, nil
+		if iops > gp3BaselineIOPS {
+			ebs.Iops = aws.Int64(iops)
+		}
+		if throughput > gp3BaselineThroughput {
+			ebs.Throughput = aws.Int64(throughput)
+		}
+	}
+
+	log.Println(r, ": Converting", from, "EBS volume to", to, "for new instance launched for", asg)
+	ebs.VolumeType = aws.String(to)
+
+	// converting the throughput optimized and cold HDD volumes to SSD volumes
+	// improves their performance at a higher cost
+	savings := cost - ebsMonthlyCost(to, size, iops, throughput)
+	if savings < 0 {
+		log.Printf("%s : Converting %s EBS volume to %s increases its estimated cost by $%.2f per month for %s",
+			r, from, to, -savings, asg)
+	}
+
+	if i.ebsConversions == nil {
+		i.ebsConversions = make(map[string]ebsVolumeConversion)
+	}
+	i.ebsConversions[aws.StringValue(deviceName)] = ebsVolumeConversion{
+		from:    from,
+		to:      to,
+		size:    size,
+		savings: savings,
+	}
+}
+
+// reportEBSVolumeConversions reports the volume conversions applied to the
+// block devices of the launched spot instance, and their estimated savings.
+func (i *instance) reportEBSVolumeConversions(bdms []*ec2.LaunchTemplateBlockDeviceMappingRequest) {
+	var conversions []string
+	var savings float64
+
+	for _, bdm := range bdms {
+		c, ok := i.ebsConversions[aws.StringValue(bdm.DeviceName)]
+		if !ok || bdm.Ebs == nil || aws.StringValue(bdm.Ebs.VolumeType) != c.to {
+			continue
+		}
+		conversions = append(conversions, fmt.Sprintf("%s %s to %s (%d GiB)",
+			aws.StringValue(bdm.DeviceName), c.from, c.to, c.size))
+		savings += c.savings
+	}
+
+	if len(conversions) == 0 {
+		return
+	}
+	sort.Strings(conversions)
+
+	estimate := fmt.Sprintf("estimated savings of $%.2f per month", savings)
+	if savings < 0 {
+		estimate = fmt.Sprintf("estimated cost increase of $%.2f per month", -savings)
+	}
+
+	text := fmt.Sprintf("%s converted the EBS volumes of the spot replacement of %s: %s, %s",
+		i.asg.name, aws.StringValue(i.InstanceId), strings.Join(conversions, ", "), estimate)
+	log.Println(i.region.name, text)
+	i.region.addToFinalRecap(text)
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/davecgh/go-spew/spew"
+)
+
+func Test_parseEBSVolumePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "default", spec: "", want: map[string]string{"gp2": "gp3", "io1": "io2"}},
+		{name: "disabled", spec: "none", want: map[string]string{}},
+		{
+			name: "HDD volumes",
+			spec: "st1:gp3, sc1 : gp3",
+			want: map[string]string{"st1": "gp3", "sc1": "gp3"},
+		},
+		{name: "missing target", spec: "gp2", wantErr: true},
+		{name: "unsupported conversion", spec: "gp2:io2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEBSVolumePolicy(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEBSVolumePolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEBSVolumePolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gp3Performance(t *testing.T) {
+	tests := []struct {
+		name           string
+		volumeType     string
+		size           int64
+		wantIOPS       int64
+		wantThroughput int64
+	}{
+		{name: "small gp2", volumeType: "gp2", size: 100, wantIOPS: 3000, wantThroughput: 128},
+		{name: "medium gp2", volumeType: "gp2", size: 500, wantIOPS: 3000, wantThroughput: 250},
+		{name: "large gp2", volumeType: "gp2", size: 2000, wantIOPS: 6000, wantThroughput: 250},
+		{name: "huge gp2", volumeType: "gp2", size: 10000, wantIOPS: 16000, wantThroughput: 250},
+		{name: "st1", volumeType: "st1", size: 2048, wantIOPS: 3000, wantThroughput: 500},
+		{name: "small sc1", volumeType: "sc1", size: 500, wantIOPS: 3000, wantThroughput: 125},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iops, throughput := gp3Performance(tt.volumeType, tt.size)
+			if iops != tt.wantIOPS || throughput != tt.wantThroughput {
+				t.Errorf("gp3Performance() = %v, %v, want %v, %v",
+					iops, throughput, tt.wantIOPS, tt.wantThroughput)
+			}
+		})
+	}
+}
+
+func Test_instance_applyEBSVolumePolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		region        string
+		config        AutoScalingConfig
+		ebs           *ec2.LaunchTemplateEbsBlockDeviceRequest
+		want          *ec2.LaunchTemplateEbsBlockDeviceRequest
+		wantConverted bool
+		wantSaving    bool
+		wantCostRise  bool
+	}{
+		{
+			name:   "nil volume type",
+			region: "us-east-1",
+			ebs:    &ec2.LaunchTemplateEbsBlockDeviceRequest{},
+			want:   &ec2.LaunchTemplateEbsBlockDeviceRequest{},
+		},
+		{
+			name:   "IO1 in region supported by IO2",
+			region: "us-east-1",
+			ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("io1"),
+				Iops:       aws.Int64(1000),
+			},
+			want: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("io2"),
+				Iops:       aws.Int64(1000),
+			},
+			wantConverted: true,
+		},
+		{
+			name:   "IO1 in region not supported by IO2",
+			region: "cn-northwest-1",
+			ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("io1"),
+			},
+			want: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("io1"),
+			},
+		},
+		{
+			name:   "large GP2 matched by provisioned GP3 performance",
+			region: "us-east-1",
+			config: AutoScalingConfig{GP2ConversionThreshold: 2000},
+			ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp2"),
+				VolumeSize: aws.Int64(2000),
+			},
+			want: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp3"),
+				VolumeSize: aws.Int64(2000),
+				Iops:       aws.Int64(6000),
+				Throughput: aws.Int64(250),
+			},
+			wantConverted: true,
+			wantSaving:    true,
+		},
+		{
+			name:   "GP2 above the threshold",
+			region: "us-east-1",
+			config: AutoScalingConfig{GP2ConversionThreshold: 170},
+			ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp2"),
+				VolumeSize: aws.Int64(200),
+			},
+			want: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp2"),
+				VolumeSize: aws.Int64(200),
+			},
+		},
+		{
+			name:   "GP2 of unknown size",
+			region: "us-east-1",
+			config: AutoScalingConfig{GP2ConversionThreshold: 170},
+			ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp2"),
+			},
+			want: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp2"),
+			},
+		},
+		{
+			name:   "ST1 not converted by default",
+			region: "us-east-1",
+			ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("st1"),
+				VolumeSize: aws.Int64(500),
+			},
+			want: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("st1"),
+				VolumeSize: aws.Int64(500),
+			},
+		},
+		{
+			name:   "ST1 converted when enabled",
+			region: "us-east-1",
+			config: AutoScalingConfig{EBSVolumePolicy: "st1:gp3"},
+			ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("st1"),
+				VolumeSize: aws.Int64(2048),
+			},
+			want: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp3"),
+				VolumeSize: aws.Int64(2048),
+				Throughput: aws.Int64(500),
+			},
+			wantConverted: true,
+			// gp3 at $0.08 per GiB costs more than st1 at $0.045 per GiB
+			wantCostRise: true,
+		},
+		{
+			name:   "conversions disabled",
+			region: "us-east-1",
+			config: AutoScalingConfig{EBSVolumePolicy: "none", GP2ConversionThreshold: 170},
+			ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp2"),
+				VolumeSize: aws.Int64(10),
+			},
+			want: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp2"),
+				VolumeSize: aws.Int64(10),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				asg: &autoScalingGroup{
+					name:   "asg",
+					region: &region{name: tt.region},
+					config: tt.config,
+				},
+			}
+
+			i.applyEBSVolumePolicy(aws.String("/dev/xvda"), tt.ebs)
+
+			if !reflect.DeepEqual(tt.ebs, tt.want) {
+				t.Errorf("applyEBSVolumePolicy() = %v, want %v", spew.Sdump(tt.ebs), spew.Sdump(tt.want))
+			}
+
+			c, converted := i.ebsConversions["/dev/xvda"]
+			if converted != tt.wantConverted {
+				t.Errorf("applyEBSVolumePolicy() recorded conversion %+v, want %v", c, tt.wantConverted)
+			}
+			if tt.wantSaving && c.savings <= 0 {
+				t.Errorf("applyEBSVolumePolicy() savings = %v, want positive savings", c.savings)
+			}
+			if tt.wantCostRise && c.savings >= 0 {
+				t.Errorf("applyEBSVolumePolicy() savings = %v, want a cost increase", c.savings)
+			}
+		})
+	}
+}
+
+func Test_instance_reportEBSVolumeConversions(t *testing.T) {
+	r := &region{name: "us-east-1", conf: &Config{}}
+	i := &instance{
+		Instance: &ec2.Instance{InstanceId: aws.String("i-foo")},
+		region:   r,
+		asg: &autoScalingGroup{
+			name:   "asg",
+			region: r,
+			config: AutoScalingConfig{GP2ConversionThreshold: 170},
+		},
+	}
+
+	bdms := []*ec2.LaunchTemplateBlockDeviceMappingRequest{
+		{
+			DeviceName: aws.String("/dev/xvda"),
+			Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				VolumeType: aws.String("gp2"),
+				VolumeSize: aws.Int64(100),
+			},
+		},
+		{
+			DeviceName:  aws.String("/dev/sdb"),
+			VirtualName: aws.String("ephemeral0"),
+		},
+	}
+
+	i.reportEBSVolumeConversions(bdms)
+	if len(r.conf.FinalRecap) != 0 {
+		t.Fatalf("reportEBSVolumeConversions() reported %v without conversions", r.conf.FinalRecap)
+	}
+
+	i.applyEBSVolumePolicy(bdms[0].DeviceName, bdms[0].Ebs)
+	i.reportEBSVolumeConversions(bdms)
+
+	recap := r.conf.FinalRecap["us-east-1"]
+	if len(recap) != 1 {
+		t.Fatalf("reportEBSVolumeConversions() reported %v, want a single entry", recap)
+	}
+	// 100GiB gp2 at $0.10 vs gp3 at $0.08 with 3MiB/s of extra throughput
+	if want := "/dev/xvda gp2 to gp3 (100 GiB), estimated savings of $1.88 per month"; !strings.Contains(recap[0], want) {
+		t.Errorf("reportEBSVolumeConversions() reported %q, want it to contain %q", recap[0], want)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// fleet_subnets.go contains the selection of the subnets in which the spot
+// instances can be launched, allowing the fleet to fall back to other
+// availability zones of the group without unbalancing it.
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// fleetSubnet is a subnet of the group together with its availability zone.
+type fleetSubnet struct {
+	id               string
+	availabilityZone string
+}
+
+// loadSubnets loads the availability zones of the subnets of the group.
+func (a *autoScalingGroup) loadSubnets() (map[string]string, error) {
+	//already done
+	if a.subnets != nil {
+		return a.subnets, nil
+	}
+
+	var ids []*string
+	if a.Group != nil && a.VPCZoneIdentifier != nil {
+		for _, id := range strings.Split(*a.VPCZoneIdentifier, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, aws.String(id))
+			}
+		}
+	}
+
+	subnets := make(map[string]string)
+
+	// there's nothing to choose from
+	if len(ids) <= 1 {
+		a.subnets = subnets
+		return subnets, nil
+	}
+
+	resp, err := a.region.services.ec2.DescribeSubnetsWithContext(
+		a.region.runContext(),
+		&ec2.DescribeSubnetsInput{SubnetIds: ids})
+	if err != nil {
+		log.Println(a.name, "Couldn't describe the subnets of the group:", err.Error())
+		return nil, err
+	}
+
+	for _, s := range resp.Subnets {
+		subnets[aws.StringValue(s.SubnetId)] = aws.StringValue(s.AvailabilityZone)
+	}
+	a.subnets = subnets
+	return subnets, nil
+}
+
+// runningInstancesPerAZ counts the running instances of the group in each
+// availability zone.
+func (a *autoScalingGroup) runningInstancesPerAZ() map[string]int {
+	counts := make(map[string]int)
+	for inst := range a.instances.instances() {
+		if *inst.State.Name == ec2.InstanceStateNameRunning {
+			counts[*inst.Placement.AvailabilityZone]++
+		}
+	}
+	return counts
+}
+
+func (i *instance) placementAZ() string {
+	if i.Placement == nil {
+		return ""
+	}
+	return aws.StringValue(i.Placement.AvailabilityZone)
+}
+
+// fleetSubnets returns the subnets in which the replacement of the instance
+// can be launched, starting with the ones in the availability zone of the
+// instance. The other availability zones are only used if they have fewer
+// running instances, so moving the capacity there doesn't unbalance the group
+// and isn't undone by AZRebalance.
+func (i *instance) fleetSubnets() []fleetSubnet {
+	az := i.placementAZ()
+	own := []fleetSubnet{{id: aws.StringValue(i.SubnetId), availabilityZone: az}}
+
+	if i.asg == nil {
+		return own
+	}
+
+	subnets, err := i.asg.loadSubnets()
+	if err != nil || len(subnets) == 0 {
+		return own
+	}
+
+	// cluster placement groups can't span multiple availability zones, and
+	// neither can the secondary network interfaces placed in given subnets
+	cluster := i.isInClusterPlacementGroup()
+	pinned := i.hasPinnedSecondaryInterfaces()
+
+	counts := i.asg.runningInstancesPerAZ()
+
+	var others []fleetSubnet
+	for id, subnetAZ := range subnets {
+		switch {
+		case id == aws.StringValue(i.SubnetId):
+		case subnetAZ == az:
+			own = append(own, fleetSubnet{id: id, availabilityZone: subnetAZ})
+		case cluster:
+			debug.Println(i.asg.name, "Skipping subnet", id, "outside the cluster placement group")
+		case pinned:
+			debug.Println(i.asg.name, "Skipping subnet", id, "outside the availability zone of the secondary network interfaces")
+		case counts[subnetAZ] < counts[az]:
+			others = append(others, fleetSubnet{id: id, availabilityZone: subnetAZ})
+		default:
+			debug.Println(i.asg.name, "Skipping subnet", id, "which would unbalance the group")
+		}
+	}
+
+	sort.Slice(own[1:], func(x, y int) bool { return own[1+x].id < own[1+y].id })
+	sort.Slice(others, func(x, y int) bool {
+		if counts[others[x].availabilityZone] != counts[others[y].availabilityZone] {
+			return counts[others[x].availabilityZone] < counts[others[y].availabilityZone]
+		}
+		return others[x].id < others[y].id
+	})
+	return append(own, others...)
+}
+
+// hasPinnedSecondaryInterfaces tells if the launch template of the group places
+// secondary network interfaces in given subnets, which tie the spot instances
+// to the availability zone of those subnets.
+func (i *instance) hasPinnedSecondaryInterfaces() bool {
+	if i.asg == nil || i.asg.Group == nil || i.asg.LaunchTemplate == nil {
+		return false
+	}
+
+	lt, err := i.asg.loadLaunchTemplate()
+	if err != nil || lt.LaunchTemplateData == nil {
+		return false
+	}
+
+	for _, ni := range lt.LaunchTemplateData.NetworkInterfaces {
+		primary := aws.Int64Value(ni.DeviceIndex) == 0 && aws.Int64Value(ni.NetworkCardIndex) == 0
+		if !primary && ni.SubnetId != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isAvailableInAZ checks if the instance type can replace the instance in
+// another availability zone, where its spot price may be different.
+func (i *instance) isAvailableInAZ(instanceType string, az string) bool {
+	if az == i.placementAZ() {
+		return true
+	}
+
+	info, ok := i.region.instanceTypeInformation[instanceType]
+	if !ok {
+		return false
+	}
+
+	if info.availabilityZones != nil && !itemInSlice(az, info.availabilityZones) {
+		return false
+	}
+
+	price := info.pricing.spot[az]
+	if i.EbsOptimized != nil && *i.EbsOptimized {
+		price += info.pricing.ebsSurcharge
+	}
+	return price > 0 && price <= i.price
+}
+
+// spansMultipleAZs tells if the replacement of the instance may be launched in
+// another availability zone.
+func (i *instance) spansMultipleAZs() bool {
+	for _, s := range i.fleetSubnets() {
+		if s.availabilityZone != i.placementAZ() {
+			return true
+		}
+	}
+	return false
+}
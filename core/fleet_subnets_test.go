@@ -0,0 +1,231 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func runningInstanceIn(id, az string) *instance {
+	return &instance{
+		Instance: &ec2.Instance{
+			InstanceId: aws.String(id),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String(az)},
+		},
+	}
+}
+
+func Test_instance_fleetSubnets(t *testing.T) {
+	subnets := &ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{SubnetId: aws.String("subnet-a1"), AvailabilityZone: aws.String("us-east-1a")},
+			{SubnetId: aws.String("subnet-a2"), AvailabilityZone: aws.String("us-east-1a")},
+			{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1b")},
+			{SubnetId: aws.String("subnet-c"), AvailabilityZone: aws.String("us-east-1c")},
+		},
+	}
+
+	tests := []struct {
+		name              string
+		vpcZoneIdentifier *string
+		ec2               mockEC2
+		instances         instanceMap
+		want              []fleetSubnet
+	}{
+		{
+			name:              "single subnet",
+			vpcZoneIdentifier: aws.String("subnet-a1"),
+			want:              []fleetSubnet{{id: "subnet-a1", availabilityZone: "us-east-1a"}},
+		},
+		{
+			name:              "DescribeSubnets failure",
+			vpcZoneIdentifier: aws.String("subnet-a1,subnet-b"),
+			ec2:               mockEC2{dserr: errors.New("error")},
+			want:              []fleetSubnet{{id: "subnet-a1", availabilityZone: "us-east-1a"}},
+		},
+		{
+			name:              "balanced group keeps the replacement in its AZ",
+			vpcZoneIdentifier: aws.String("subnet-a1,subnet-a2,subnet-b,subnet-c"),
+			ec2:               mockEC2{dso: subnets},
+			instances: instanceMap{
+				"i-a": runningInstanceIn("i-a", "us-east-1a"),
+				"i-b": runningInstanceIn("i-b", "us-east-1b"),
+				"i-c": runningInstanceIn("i-c", "us-east-1c"),
+			},
+			want: []fleetSubnet{
+				{id: "subnet-a1", availabilityZone: "us-east-1a"},
+				{id: "subnet-a2", availabilityZone: "us-east-1a"},
+			},
+		},
+		{
+			name:              "AZs with fewer instances are used as fallback",
+			vpcZoneIdentifier: aws.String("subnet-a1, subnet-a2, subnet-b, subnet-c"),
+			ec2:               mockEC2{dso: subnets},
+			instances: instanceMap{
+				"i-a1": runningInstanceIn("i-a1", "us-east-1a"),
+				"i-a2": runningInstanceIn("i-a2", "us-east-1a"),
+				"i-a3": runningInstanceIn("i-a3", "us-east-1a"),
+				"i-b1": runningInstanceIn("i-b1", "us-east-1b"),
+				"i-b2": runningInstanceIn("i-b2", "us-east-1b"),
+				"i-c":  runningInstanceIn("i-c", "us-east-1c"),
+			},
+			want: []fleetSubnet{
+				{id: "subnet-a1", availabilityZone: "us-east-1a"},
+				{id: "subnet-a2", availabilityZone: "us-east-1a"},
+				{id: "subnet-c", availabilityZone: "us-east-1c"},
+				{id: "subnet-b", availabilityZone: "us-east-1b"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					SubnetId:  aws.String("subnet-a1"),
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				asg: &autoScalingGroup{
+					name:      "test-asg",
+					Group:     &autoscaling.Group{VPCZoneIdentifier: tt.vpcZoneIdentifier},
+					region:    &region{services: connections{ec2: tt.ec2}},
+					instances: makeInstancesWithCatalog(tt.instances),
+				},
+			}
+			if got := i.fleetSubnets(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fleetSubnets() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_createFleetInputMultipleAZs(t *testing.T) {
+	i := &instance{
+		Instance: &ec2.Instance{
+			SubnetId:  aws.String("subnet-a"),
+			Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		},
+		price: 0.1,
+		region: &region{
+			instanceTypeInformation: map[string]instanceTypeInformation{
+				"m5.large": {pricing: prices{spot: spotPriceMap{"us-east-1a": 0.05, "us-east-1b": 0.06}}},
+				"c5.large": {pricing: prices{spot: spotPriceMap{"us-east-1a": 0.05, "us-east-1b": 0.2}}},
+			},
+		},
+		asg: &autoScalingGroup{
+			name:   "test-asg",
+			config: AutoScalingConfig{SpotAllocationStrategy: "capacity-optimized-prioritized"},
+			subnets: map[string]string{
+				"subnet-a": "us-east-1a",
+				"subnet-b": "us-east-1b",
+			},
+			instances: makeInstancesWithCatalog(instanceMap{
+				"i-a1": runningInstanceIn("i-a1", "us-east-1a"),
+				"i-a2": runningInstanceIn("i-a2", "us-east-1a"),
+				"i-b":  runningInstanceIn("i-b", "us-east-1b"),
+			}),
+		},
+	}
+
+	got := i.createFleetInput(aws.String("testLT"),
+		[]*string{aws.String("m5.large"), aws.String("c5.large")}, nil)
+
+	want := []*ec2.FleetLaunchTemplateOverridesRequest{
+		{InstanceType: aws.String("m5.large"), SubnetId: aws.String("subnet-a"), Priority: aws.Float64(0)},
+		{InstanceType: aws.String("c5.large"), SubnetId: aws.String("subnet-a"), Priority: aws.Float64(1)},
+		// c5.large is too expensive in us-east-1b
+		{InstanceType: aws.String("m5.large"), SubnetId: aws.String("subnet-b"), Priority: aws.Float64(2)},
+	}
+
+	if len(got.LaunchTemplateConfigs) != 1 {
+		t.Fatalf("createFleetInput() returned %d launch template configs, want 1", len(got.LaunchTemplateConfigs))
+	}
+	if overrides := got.LaunchTemplateConfigs[0].Overrides; !reflect.DeepEqual(overrides, want) {
+		t.Errorf("createFleetInput() overrides = %v, want %v", overrides, want)
+	}
+
+	if !i.spansMultipleAZs() {
+		t.Errorf("spansMultipleAZs() = false, want true")
+	}
+}
+
+func Test_instance_fleetSubnetsPinnedSecondaryInterfaces(t *testing.T) {
+	tests := []struct {
+		name string
+		nis  []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification
+		want []fleetSubnet
+	}{
+		{
+			name: "only the primary interface in a given subnet",
+			nis: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{
+				{DeviceIndex: aws.Int64(0), SubnetId: aws.String("subnet-a")},
+				{DeviceIndex: aws.Int64(1)},
+			},
+			want: []fleetSubnet{
+				{id: "subnet-a", availabilityZone: "us-east-1a"},
+				{id: "subnet-b", availabilityZone: "us-east-1b"},
+			},
+		},
+		{
+			name: "EFA interface on another network card in a given subnet",
+			nis: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{
+				{DeviceIndex: aws.Int64(0), InterfaceType: aws.String("efa")},
+				{
+					DeviceIndex:      aws.Int64(1),
+					InterfaceType:    aws.String("efa"),
+					NetworkCardIndex: aws.Int64(1),
+					SubnetId:         aws.String("subnet-a"),
+				},
+			},
+			want: []fleetSubnet{
+				{id: "subnet-a", availabilityZone: "us-east-1a"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					SubnetId:  aws.String("subnet-a"),
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				asg: &autoScalingGroup{
+					name: "test-asg",
+					Group: &autoscaling.Group{
+						LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+							LaunchTemplateId: aws.String("lt-123"),
+							Version:          aws.String("1"),
+						},
+					},
+					launchTemplate: &launchTemplate{
+						LaunchTemplateVersion: &ec2.LaunchTemplateVersion{
+							LaunchTemplateData: &ec2.ResponseLaunchTemplateData{NetworkInterfaces: tt.nis},
+						},
+					},
+					subnets: map[string]string{
+						"subnet-a": "us-east-1a",
+						"subnet-b": "us-east-1b",
+					},
+					instances: makeInstancesWithCatalog(instanceMap{
+						"i-a1": runningInstanceIn("i-a1", "us-east-1a"),
+						"i-a2": runningInstanceIn("i-a2", "us-east-1a"),
+						"i-b":  runningInstanceIn("i-b", "us-east-1b"),
+					}),
+				},
+			}
+			if got := i.fleetSubnets(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fleetSubnets() = %+v, want %+v", got, tt.want)
+			}
+			if got, want := i.spansMultipleAZs(), len(tt.want) > 1; got != want {
+				t.Errorf("spansMultipleAZs() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// group_lock.go contains the lease-based locks that serialize the changes made
+// to the same AutoScaling group by concurrent runs, such as the cron runs, the
+// event based instance replacements and the spot interruption handlers.
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+const (
+	// DefaultGroupLockLease is the default duration of the group locks, after
+	// which the locks held by crashed runs are released. It covers the longest
+	// step holding the lock, waiting for up to 10 minutes for the spot instances
+	// to be running, so that the locks don't expire even if their renewals fail.
+	DefaultGroupLockLease = 15 * time.Minute
+
+	// groupLockRenewalsPerLease is the number of times the locks held during
+	// the long steps are renewed within the lease duration.
+	groupLockRenewalsPerLease = 3
+
+	// groupLockRetryInterval is the time between the attempts to take a lock
+	// held by another run.
+	groupLockRetryInterval = 10 * time.Second
+
+	// groupLockMaxWait limits the time spent waiting for a lock held by
+	// another run.
+	groupLockMaxWait = 2 * time.Minute
+
+	// groupLockInterruptionWait limits the time the spot interruption handlers
+	// wait for a lock held by another run, since the interrupted instances are
+	// reclaimed two minutes after the notice.
+	groupLockInterruptionWait = 30 * time.Second
+
+	// the attributes of the items stored in the DynamoDB lock table, which
+	// is expected to have the lockKeyAttribute as hash key
+	lockKeyAttribute     = "LockKey"
+	lockHolderAttribute  = "Holder"
+	lockExpiresAttribute = "ExpiresAt"
+)
+
+// groupLocker stores the group leases.
+type groupLocker interface {
+	// lock takes or renews the lease on the given key for the holder, until
+	// the expiration time. It returns the current holder of the lease, which
+	// is a different one if the lease couldn't be taken.
+	lock(key, holder string, expires time.Time) (string, error)
+
+	// unlock releases the lease if it's still owned by the holder.
+	unlock(key, holder string) error
+}
+
+// groupLockedError is returned when the lock of a group is held by another run.
+type groupLockedError struct {
+	asgName string
+	holder  string
+}
+
+func (e groupLockedError) Error() string {
+	return fmt.Sprintf("group %s is locked by %s", e.asgName, e.holder)
+}
+
+func (r *region) groupLocker() groupLocker {
+	if r.conf != nil && r.conf.GroupLockTable != "" {
+		return dynamoDBGroupLocker{
+			svc:   r.services.dynamoDB,
+			table: r.conf.GroupLockTable,
+		}
+	}
+	return memoryGroupLocks
+}
+
+func (r *region) groupLockLease() time.Duration {
+	if r.conf == nil || r.conf.GroupLockLease <= 0 {
+		return DefaultGroupLockLease
+	}
+	return r.conf.GroupLockLease
+}
+
+func (a *autoScalingGroup) lockKey() string {
+	return a.region.name + "/" + a.name
+}
+
+// tryLock takes the lock of the group for the current run, or renews it if
+// the current run already holds it.
+func (a *autoScalingGroup) tryLock() error {
+	holder := runID(a.region.ctx)
+	expires := time.Now().Add(a.region.groupLockLease())
+
+	owner, err := a.region.groupLocker().lock(a.lockKey(), holder, expires)
+	if err != nil {
+		log.Printf("%s %s Couldn't take the group lock: %s",
+			a.region.name, a.name, err.Error())
+		return err
+	}
+
+	if owner != holder {
+		log.Printf("%s %s Group is locked by %s", a.region.name, a.name, owner)
+		return groupLockedError{asgName: a.name, holder: owner}
+	}
+
+	debug.Printf("%s %s Group locked by %s until %s",
+		a.region.name, a.name, holder, expires.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// waitForLock takes the lock of the group, waiting for a while for the other
+// runs to release it.
+func (a *autoScalingGroup) waitForLock() error {
+	return a.waitForLockDuring(groupLockMaxWait)
+}
+
+// waitForLockDuring takes the lock of the group, waiting at most maxWait for
+// the other runs to release it.
+func (a *autoScalingGroup) waitForLockDuring(maxWait time.Duration) error {
+	sleepMultiplier := time.Duration(1)
+	if a.region.conf != nil {
+		sleepMultiplier = a.region.conf.SleepMultiplier
+	}
+	deadline := time.Now().Add(maxWait * sleepMultiplier)
+
+	for {
+		err := a.tryLock()
+		if _, locked := err.(groupLockedError); !locked || time.Now().After(deadline) {
+			return err
+		}
+
+		if err := aws.SleepWithContext(a.region.runContext(),
+			groupLockRetryInterval*sleepMultiplier); err != nil {
+			return err
+		}
+	}
+}
+
+// keepLock renews the lock of the group held by the current run in the
+// background until the returned function is called, so that it doesn't expire
+// during the steps taking longer than the lease.
+func (a *autoScalingGroup) keepLock() (stop func()) {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	ticker := time.NewTicker(a.region.groupLockLease() / groupLockRenewalsPerLease)
+
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// the errors are logged, and the next steps stop if the
+				// lock was lost meanwhile
+				a.tryLock()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// unlock releases the lock of the group, also when the run was cancelled.
+func (a *autoScalingGroup) unlock() {
+	holder := runID(a.region.ctx)
+
+	if err := a.region.groupLocker().unlock(a.lockKey(), holder); err != nil {
+		log.Printf("%s %s Couldn't release the group lock held by %s, it will expire: %s",
+			a.region.name, a.name, holder, err.Error())
+		return
+	}
+	debug.Printf("%s %s Group unlocked by %s", a.region.name, a.name, holder)
+}
+
+// withLock runs the given group change while holding the group lock, skipping
+// it if the lock is held by another run.
+func (a *autoScalingGroup) withLock(change func() error) error {
+	if err := a.tryLock(); err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	return change()
+}
+
+// withInterruptionLock runs the given group change while holding the group
+// lock, waiting for a short while for the other runs to release it. Unlike
+// withLock, it still makes the change when the lock can't be taken in time,
+// since the interrupted instances can't wait for the other runs.
+func (a *autoScalingGroup) withInterruptionLock(change func() error) error {
+	if err := a.waitForLockDuring(groupLockInterruptionWait); err != nil {
+		log.Printf("%s %s Changing the group without holding its lock: %s",
+			a.region.name, a.name, err.Error())
+		return change()
+	}
+	defer a.unlock()
+
+	return change()
+}
+
+// groupLease is a lease kept by the memoryGroupLocker.
+type groupLease struct {
+	holder  string
+	expires time.Time
+}
+
+// memoryGroupLocker keeps the leases in memory, only serializing the runs
+// executed by the current process.
+type memoryGroupLocker struct {
+	sync.Mutex
+	leases map[string]groupLease
+}
+
+var memoryGroupLocks = newMemoryGroupLocker()
+
+func newMemoryGroupLocker() *memoryGroupLocker {
+	return &memoryGroupLocker{leases: make(map[string]groupLease)}
+}
+
+func (l *memoryGroupLocker) lock(key, holder string, expires time.Time) (string, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if lease, ok := l.leases[key]; ok && lease.holder != holder &&
+		time.Now().Before(lease.expires) {
+		return lease.holder, nil
+	}
+
+	l.leases[key] = groupLease{holder: holder, expires: expires}
+	return holder, nil
+}
+
+func (l *memoryGroupLocker) unlock(key, holder string) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if lease, ok := l.leases[key]; ok && lease.holder == holder {
+		delete(l.leases, key)
+	}
+	return nil
+}
+
+// dynamoDBGroupLocker keeps the leases in a DynamoDB table shared by all the
+// runs, using conditional writes for taking them.
+type dynamoDBGroupLocker struct {
+	svc   dynamodbiface.DynamoDBAPI
+	table string
+}
+
+func (l dynamoDBGroupLocker) lock(key, holder string, expires time.Time) (string, error) {
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	_, err := l.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			lockKeyAttribute:     {S: aws.String(key)},
+			lockHolderAttribute:  {S: aws.String(holder)},
+			lockExpiresAttribute: {N: aws.String(strconv.FormatInt(expires.Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR #holder = :holder OR #expires < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key":     aws.String(lockKeyAttribute),
+			"#holder":  aws.String(lockHolderAttribute),
+			"#expires": aws.String(lockExpiresAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(holder)},
+			":now":    {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		},
+	})
+
+	if err == nil {
+		return holder, nil
+	}
+
+	if aerr, ok := err.(awserr.Error); !ok ||
+		aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		return "", err
+	}
+
+	// the lease is held by another run, find out which one for the logs
+	out, err := l.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(l.table),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			lockKeyAttribute: {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if v, ok := out.Item[lockHolderAttribute]; ok && v.S != nil && *v.S != holder {
+		return *v.S, nil
+	}
+	// released in the meantime, the next attempt should succeed
+	return "unknown", nil
+}
+
+func (l dynamoDBGroupLocker) unlock(key, holder string) error {
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	_, err := l.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(l.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			lockKeyAttribute: {S: aws.String(key)},
+		},
+		ConditionExpression: aws.String("#holder = :holder"),
+		ExpressionAttributeNames: map[string]*string{
+			"#holder": aws.String(lockHolderAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(holder)},
+		},
+	})
+
+	// the lease expired and was taken by another run
+	if aerr, ok := err.(awserr.Error); ok &&
+		aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func Test_region_groupLocker(t *testing.T) {
+	tests := []struct {
+		name string
+		conf *Config
+		want groupLocker
+	}{
+		{
+			name: "default",
+			conf: &Config{},
+			want: memoryGroupLocks,
+		},
+		{
+			name: "dynamodb",
+			conf: &Config{GroupLockTable: "locks"},
+			want: dynamoDBGroupLocker{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{conf: tt.conf}
+			if got := r.groupLocker(); reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("groupLocker() = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_memoryGroupLocker(t *testing.T) {
+	l := newMemoryGroupLocker()
+	now := time.Now()
+
+	steps := []struct {
+		name    string
+		holder  string
+		expires time.Time
+		unlock  bool
+		want    string
+	}{
+		{
+			name:    "first lock",
+			holder:  "run-1",
+			expires: now.Add(time.Minute),
+			want:    "run-1",
+		},
+		{
+			name:    "locked by another run",
+			holder:  "run-2",
+			expires: now.Add(time.Minute),
+			want:    "run-1",
+		},
+		{
+			name:    "renewed by the holder",
+			holder:  "run-1",
+			expires: now.Add(-time.Second),
+			want:    "run-1",
+		},
+		{
+			name:    "expired lease taken by another run",
+			holder:  "run-2",
+			expires: now.Add(time.Minute),
+			want:    "run-2",
+		},
+		{
+			name:   "unlock by a previous holder is ignored",
+			holder: "run-1",
+			unlock: true,
+		},
+		{
+			name:    "still locked",
+			holder:  "run-3",
+			expires: now.Add(time.Minute),
+			want:    "run-2",
+		},
+		{
+			name:   "unlock by the holder",
+			holder: "run-2",
+			unlock: true,
+		},
+		{
+			name:    "lock after unlock",
+			holder:  "run-3",
+			expires: now.Add(time.Minute),
+			want:    "run-3",
+		},
+	}
+	for _, step := range steps {
+		if step.unlock {
+			if err := l.unlock("us-east-1/asg", step.holder); err != nil {
+				t.Errorf("%s: unlock() error = %v", step.name, err)
+			}
+			continue
+		}
+
+		got, err := l.lock("us-east-1/asg", step.holder, step.expires)
+		if err != nil {
+			t.Errorf("%s: lock() error = %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: lock() = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func Test_dynamoDBGroupLocker_lock(t *testing.T) {
+	conditionFailed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)
+
+	tests := []struct {
+		name    string
+		svc     mockDynamoDB
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "lock taken",
+			svc:  mockDynamoDB{pio: &dynamodb.PutItemOutput{}},
+			want: "run-1",
+		},
+		{
+			name: "locked by another run",
+			svc: mockDynamoDB{
+				pierr: conditionFailed,
+				gio: &dynamodb.GetItemOutput{
+					Item: map[string]*dynamodb.AttributeValue{
+						lockHolderAttribute: {S: aws.String("run-2")},
+					},
+				},
+			},
+			want: "run-2",
+		},
+		{
+			name: "released meanwhile",
+			svc: mockDynamoDB{
+				pierr: conditionFailed,
+				gio:   &dynamodb.GetItemOutput{},
+			},
+			want: "unknown",
+		},
+		{
+			name:    "API error",
+			svc:     mockDynamoDB{pierr: errors.New("error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := dynamoDBGroupLocker{svc: tt.svc, table: "locks"}
+			got, err := l.lock("us-east-1/asg", "run-1", time.Now().Add(time.Minute))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("lock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("lock() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dynamoDBGroupLocker_unlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		dierr   error
+		wantErr bool
+	}{
+		{
+			name: "unlocked",
+		},
+		{
+			name:  "lease taken by another run",
+			dierr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil),
+		},
+		{
+			name:    "API error",
+			dierr:   errors.New("error"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := dynamoDBGroupLocker{svc: mockDynamoDB{dierr: tt.dierr}, table: "locks"}
+			if err := l.unlock("us-east-1/asg", "run-1"); (err != nil) != tt.wantErr {
+				t.Errorf("unlock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_withLock(t *testing.T) {
+	a := &autoScalingGroup{
+		name:   "locked-asg",
+		region: &region{name: "us-east-1", conf: &Config{}},
+	}
+
+	memoryGroupLocks.lock(a.lockKey(), "another-run", time.Now().Add(time.Minute))
+
+	called := false
+	err := a.withLock(func() error {
+		called = true
+		return nil
+	})
+	if _, ok := err.(groupLockedError); !ok || called {
+		t.Errorf("withLock() on locked group error = %v, called = %v", err, called)
+	}
+
+	memoryGroupLocks.unlock(a.lockKey(), "another-run")
+
+	if err := a.withLock(func() error {
+		called = true
+		return nil
+	}); err != nil || !called {
+		t.Errorf("withLock() error = %v, called = %v", err, called)
+	}
+
+	if got, _ := memoryGroupLocks.lock(a.lockKey(), "another-run", time.Now().Add(time.Minute)); got != "another-run" {
+		t.Errorf("withLock() didn't release the lock, held by %v", got)
+	}
+	memoryGroupLocks.unlock(a.lockKey(), "another-run")
+}
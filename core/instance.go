@@ -12,4 +12,15 @@ type instance struct {
 	region    *region
 	protected bool
 	asg       *autoScalingGroup
+
+	// the EBS volume conversions applied to the block devices of the spot
+	// replacement, by device name
+	ebsConversions map[string]ebsVolumeConversion
+
+	// the strategy of the placement group of the instance, loaded on demand
+	placementStrategy *string
+
+	// if the burstable instance is constrained by its CPU credits, loaded on
+	// demand
+	creditConstrained *bool
 }
@@ -0,0 +1,139 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_accelerators.go contains the compatibility checks of the GPUs,
+// inference accelerators and FPGAs, preventing the replacement of instances
+// with spot instances using different accelerator models.
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	gpuAccelerator       = "gpu"
+	inferenceAccelerator = "inference"
+	fpgaAccelerator      = "fpga"
+)
+
+// acceleratorInfo describes the accelerator devices of an instance type.
+type acceleratorInfo struct {
+	kind         string
+	manufacturer string
+	model        string
+	count        int
+
+	// memory of each device, in MiB
+	memory int64
+}
+
+func (info *instanceTypeInformation) setAcceleratorInfo(it *ec2.InstanceTypeInfo) {
+	switch {
+	case it.GpuInfo != nil && len(it.GpuInfo.Gpus) > 0:
+		gpu := it.GpuInfo.Gpus[0]
+		info.accelerator = acceleratorInfo{
+			kind:         gpuAccelerator,
+			manufacturer: aws.StringValue(gpu.Manufacturer),
+			model:        aws.StringValue(gpu.Name),
+			count:        int(aws.Int64Value(gpu.Count)),
+		}
+		if gpu.MemoryInfo != nil {
+			info.accelerator.memory = aws.Int64Value(gpu.MemoryInfo.SizeInMiB)
+		}
+
+	case it.InferenceAcceleratorInfo != nil && len(it.InferenceAcceleratorInfo.Accelerators) > 0:
+		acc := it.InferenceAcceleratorInfo.Accelerators[0]
+		info.accelerator = acceleratorInfo{
+			kind:         inferenceAccelerator,
+			manufacturer: aws.StringValue(acc.Manufacturer),
+			model:        aws.StringValue(acc.Name),
+			count:        int(aws.Int64Value(acc.Count)),
+		}
+
+	case it.FpgaInfo != nil && len(it.FpgaInfo.Fpgas) > 0:
+		fpga := it.FpgaInfo.Fpgas[0]
+		info.accelerator = acceleratorInfo{
+			kind:         fpgaAccelerator,
+			manufacturer: aws.StringValue(fpga.Manufacturer),
+			model:        aws.StringValue(fpga.Name),
+			count:        int(aws.Int64Value(fpga.Count)),
+		}
+		if fpga.MemoryInfo != nil {
+			info.accelerator.memory = aws.Int64Value(fpga.MemoryInfo.SizeInMiB)
+		}
+	}
+}
+
+// parseEquivalentAcceleratorModels parses a semicolon-separated list of
+// groups of comma-separated accelerator models considered equivalent, such as
+// "T4,A10G;V100,A100", into a map of each model to its equivalent models.
+func parseEquivalentAcceleratorModels(value string) map[string][]string {
+	equivalents := make(map[string][]string)
+
+	for _, group := range strings.Split(value, ";") {
+		var models []string
+		for _, model := range strings.Split(group, ",") {
+			if model = strings.ToLower(strings.TrimSpace(model)); model != "" {
+				models = append(models, model)
+			}
+		}
+		for _, model := range models {
+			equivalents[model] = append(equivalents[model], models...)
+		}
+	}
+	return equivalents
+}
+
+// isAcceleratorModelCompatible tells if the candidate accelerator model is the
+// same as the current one, or configured as equivalent for the group.
+func (i *instance) isAcceleratorModelCompatible(current, candidate acceleratorInfo) bool {
+	if strings.EqualFold(current.manufacturer, candidate.manufacturer) &&
+		strings.EqualFold(current.model, candidate.model) {
+		return true
+	}
+
+	if i.asg == nil {
+		return false
+	}
+
+	equivalents := parseEquivalentAcceleratorModels(i.asg.config.EquivalentAcceleratorModels)
+	return itemInSlice(strings.ToLower(candidate.model), equivalents[strings.ToLower(current.model)])
+}
+
+// isAcceleratorCompatible checks that the candidate has the same kind and
+// model of accelerators as the current instance type, with at least as many
+// devices and as much memory on each of them. It's skipped for the instance
+// types without accelerators or when their details couldn't be loaded.
+func (i *instance) isAcceleratorCompatible(spotCandidate *instanceTypeInformation) bool {
+	current, candidate := i.typeInfo.accelerator, spotCandidate.accelerator
+
+	if current.kind == "" {
+		return true
+	}
+
+	debug.Println("Comparing accelerators spot/instance:")
+	debug.Println("\tSpot kind/manufacturer/model/count/memory: ", candidate.kind,
+		candidate.manufacturer, candidate.model, candidate.count, candidate.memory)
+	debug.Println("\tInstance kind/manufacturer/model/count/memory: ", current.kind,
+		current.manufacturer, current.model, current.count, current.memory)
+
+	if candidate.kind != current.kind {
+		debug.Println("\tNot accelerator compatible, different accelerator kind")
+		return false
+	}
+
+	if !i.isAcceleratorModelCompatible(current, candidate) {
+		debug.Println("\tNot accelerator compatible, different accelerator model")
+		return false
+	}
+
+	if candidate.count < current.count || candidate.memory < current.memory {
+		debug.Println("\tNot accelerator compatible, fewer accelerators or less accelerator memory")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseEquivalentAcceleratorModels(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string][]string
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  map[string][]string{},
+		},
+		{
+			name:  "multiple groups",
+			value: "T4, A10G; V100,A100",
+			want: map[string][]string{
+				"t4":   {"t4", "a10g"},
+				"a10g": {"t4", "a10g"},
+				"v100": {"v100", "a100"},
+				"a100": {"v100", "a100"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseEquivalentAcceleratorModels(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEquivalentAcceleratorModels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAcceleratorCompatible(t *testing.T) {
+	t4 := acceleratorInfo{
+		kind:         gpuAccelerator,
+		manufacturer: "NVIDIA",
+		model:        "T4",
+		count:        1,
+		memory:       16384,
+	}
+
+	tests := []struct {
+		name        string
+		current     acceleratorInfo
+		candidate   acceleratorInfo
+		equivalents string
+		want        bool
+	}{
+		{
+			name:      "without accelerators",
+			candidate: t4,
+			want:      true,
+		},
+		{
+			name:      "same GPU model",
+			current:   t4,
+			candidate: t4,
+			want:      true,
+		},
+		{
+			name:    "more GPUs of the same model",
+			current: t4,
+			candidate: acceleratorInfo{
+				kind:         gpuAccelerator,
+				manufacturer: "NVIDIA",
+				model:        "T4",
+				count:        4,
+				memory:       16384,
+			},
+			want: true,
+		},
+		{
+			name:    "different GPU model",
+			current: t4,
+			candidate: acceleratorInfo{
+				kind:         gpuAccelerator,
+				manufacturer: "NVIDIA",
+				model:        "V100",
+				count:        1,
+				memory:       16384,
+			},
+			want: false,
+		},
+		{
+			name:    "equivalent GPU model",
+			current: t4,
+			candidate: acceleratorInfo{
+				kind:         gpuAccelerator,
+				manufacturer: "NVIDIA",
+				model:        "A10G",
+				count:        1,
+				memory:       24576,
+			},
+			equivalents: "t4,a10g",
+			want:        true,
+		},
+		{
+			name:    "equivalent GPU model with less memory",
+			current: t4,
+			candidate: acceleratorInfo{
+				kind:         gpuAccelerator,
+				manufacturer: "NVIDIA",
+				model:        "M60",
+				count:        1,
+				memory:       8192,
+			},
+			equivalents: "T4,M60",
+			want:        false,
+		},
+		{
+			name: "inference accelerator replaced by GPU",
+			current: acceleratorInfo{
+				kind:         inferenceAccelerator,
+				manufacturer: "AWS",
+				model:        "Inferentia",
+				count:        1,
+			},
+			candidate: t4,
+			want:      false,
+		},
+		{
+			name:    "GPU replaced by instance without accelerators",
+			current: t4,
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: instanceTypeInformation{accelerator: tt.current},
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{EquivalentAcceleratorModels: tt.equivalents},
+				},
+			}
+			candidate := instanceTypeInformation{accelerator: tt.candidate}
+			if got := i.isAcceleratorCompatible(&candidate); got != tt.want {
+				t.Errorf("isAcceleratorCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
@@ -44,16 +45,20 @@ func (i *instance) launchSpotReplacement() (*string, error) {
 		return nil, err
 	}
 
-	lt, err := i.createFleetLaunchTemplate(ltData)
-
-	debug.Printf("Fleet Launch Template: %+#v", lt)
+	// the tags are specific to each instance, so they're set on the fleet in
+	// order to reuse the launch template versions across instances
+	tags := fleetTagSpecifications(ltData)
+	i.removeInstanceSpecificData(ltData)
 
+	version, err := i.asg.managedLaunchTemplateVersion(ltData)
 	if err != nil {
-		log.Println(i.region, i.asg.name, "createFleetLaunchTemplate() failure:", err.Error())
+		log.Println(i.region, i.asg.name, "managedLaunchTemplateVersion() failure:", err.Error())
 		return nil, err
 	}
 
-	defer i.deleteLaunchTemplate(lt)
+	lt := aws.String(i.asg.managedLaunchTemplateName())
+	debug.Printf("Fleet Launch Template: %s version %s", *lt, *version)
+
 	instanceTypes, err := i.getCompatibleSpotInstanceTypesList(
 		i.asg.config.PrioritizedInstanceTypesBias,
 		i.asg.getAllowedInstanceTypes(i),
@@ -64,65 +69,48 @@ func (i *instance) launchSpotReplacement() (*string, error) {
 		return nil, err
 	}
 
-	cfi := i.createFleetInput(lt, instanceTypes)
-
-	debug.Printf("Fleet Input: %+#v", cfi)
-
-	resp, err := i.region.services.ec2.CreateFleet(cfi)
-
+	versions, err := i.createArchitectureLaunchTemplateVersions(ltData, version, instanceTypes)
 	if err != nil {
-		log.Println(i.region, i.asg.name, "CreateFleet() failure:", err.Error())
 		return nil, err
 	}
-
-	if resp != nil && len(resp.Instances) > 0 && resp.Instances[0] != nil && len(resp.Instances[0].InstanceIds) > 0 {
-		return resp.Instances[0].InstanceIds[0], nil
+	if versions == nil {
+		versions = map[string]*string{i.typeInfo.cpuArchitecture(): version}
 	}
 
-	return nil, fmt.Errorf("couldn't launch spot instance replacement")
-}
+	cfi := i.createFleetInput(lt, instanceTypes, versions)
+	cfi.TagSpecifications = tags
+	setFleetMaxPrice(cfi, i.price)
 
-func (i *instance) swapWithGroupMember(asg *autoScalingGroup) (*instance, error) {
+	debug.Printf("Fleet Input: %+#v", cfi)
+
+	resp, err := i.region.services.ec2.CreateFleetWithContext(i.region.runContext(), cfi)
 
-	odInstance, err := i.getSwapCandidate()
 	if err != nil {
-		log.Printf("Couldn't find suitable OnDemand swap candidate: %s", err.Error())
+		log.Println(i.region, i.asg.name, "CreateFleet() failure:", err.Error())
 		return nil, err
 	}
 
-	asg.suspendProcesses()
-
-	desiredCapacity, maxSize := *asg.DesiredCapacity, *asg.MaxSize
-
-	// temporarily increase AutoScaling group in case the desired capacity reaches the max size,
-	// otherwise attachSpotInstance might fail
-	if desiredCapacity == maxSize {
-		log.Println(asg.name, "Temporarily increasing MaxSize")
-		asg.setAutoScalingMaxSize(maxSize + 1)
-		defer asg.setAutoScalingMaxSize(maxSize)
-	}
-
-	log.Printf("Attaching spot instance %s to the group %s",
-		*i.InstanceId, asg.name)
-	err = asg.attachSpotInstance(*i.InstanceId, true)
-
-	if err != nil {
-		log.Printf("Spot instance %s couldn't be attached to the group %s, terminating it...",
-			*i.InstanceId, asg.name)
-		i.terminate()
-		return nil, fmt.Errorf("couldn't attach spot instance %s ", *i.InstanceId)
+	if resp != nil && len(resp.Instances) > 0 && resp.Instances[0] != nil && len(resp.Instances[0].InstanceIds) > 0 {
+		i.reportEBSVolumeConversions(ltData.BlockDeviceMappings)
+		return resp.Instances[0].InstanceIds[0], nil
 	}
 
-	log.Printf("Terminating on-demand instance %s from the group %s",
-		*odInstance.InstanceId, asg.name)
-	if err := asg.terminateInstanceInAutoScalingGroup(odInstance.Instance.InstanceId, true, true); err != nil {
-		log.Printf("On-demand instance %s couldn't be terminated, re-trying...",
-			*odInstance.InstanceId)
-		return nil, fmt.Errorf("couldn't terminate on-demand instance %s",
-			*odInstance.InstanceId)
+	// instant fleets report launch failures such as lack of capacity in the
+	// response instead of failing the API call
+	if resp != nil {
+		for _, e := range resp.Errors {
+			var instanceType *string
+			if e.LaunchTemplateAndOverrides != nil && e.LaunchTemplateAndOverrides.Overrides != nil {
+				instanceType = e.LaunchTemplateAndOverrides.Overrides.InstanceType
+			}
+			code := aws.StringValue(e.ErrorCode)
+			log.Printf("%s %s CreateFleet() %s error for instance type %s: %s %s",
+				i.region.name, i.asg.name, classifyAPIErrorCode(code),
+				aws.StringValue(instanceType), code, aws.StringValue(e.ErrorMessage))
+		}
 	}
 
-	return odInstance, nil
+	return nil, fmt.Errorf("couldn't launch spot instance replacement")
 }
 
 func (i *instance) getSwapCandidate() (*instance, error) {
@@ -145,7 +133,6 @@ func (i *instance) getSwapCandidate() (*instance, error) {
 
 	if !odInstance.shouldBeReplacedWithSpot() {
 		log.Printf("Target on-demand instance %s shouldn't be replaced", *odInstanceID)
-		i.terminate()
 		return nil, fmt.Errorf("target instance %s should not be replaced with spot",
 			*odInstanceID)
 	}
@@ -165,7 +152,7 @@ func (i *instance) terminate() error {
 		return fmt.Errorf("can't terminate %s", *i.InstanceId)
 	}
 
-	_, err = svc.TerminateInstances(&ec2.TerminateInstancesInput{
+	_, err = svc.TerminateInstancesWithContext(i.region.runContext(), &ec2.TerminateInstancesInput{
 		InstanceIds: []*string{i.InstanceId},
 	})
 
@@ -175,13 +162,3 @@ func (i *instance) terminate() error {
 
 	return err
 }
-
-func (i *instance) deleteLaunchTemplate(ltName *string) {
-	_, err := i.region.services.ec2.DeleteLaunchTemplate(&ec2.DeleteLaunchTemplateInput{
-		LaunchTemplateName: ltName,
-	})
-
-	if err != nil {
-		log.Printf("Issue while deleting launch template %v, error: %v", *ltName, err.Error())
-	}
-}
@@ -186,6 +186,13 @@ func Test_instance_launchSpotReplacement(t *testing.T) {
 								AllowedInstanceTypes: "",
 							},
 						},
+						services: connections{
+							ec2: mockEC2{
+								cltvo: &ec2.CreateLaunchTemplateVersionOutput{
+									LaunchTemplateVersion: &ec2.LaunchTemplateVersion{VersionNumber: aws.Int64(2)},
+								},
+							},
+						},
 					},
 				},
 				region: &region{
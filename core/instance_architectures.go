@@ -0,0 +1,133 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_architectures.go contains the support for replacing instances with
+// spot instances of a different CPU architecture, launched from the image
+// configured for each architecture.
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	x86Architecture   = "x86_64"
+	arm64Architecture = "arm64"
+
+	// ssmImagePrefix makes EC2 resolve the image ID from an SSM parameter
+	// when launching the instances.
+	ssmImagePrefix = "resolve:ssm:"
+)
+
+// cpuArchitecture returns the CPU architecture of an instance type.
+func (info *instanceTypeInformation) cpuArchitecture() string {
+	if isARM(info.PhysicalProcessor) {
+		return arm64Architecture
+	}
+	return x86Architecture
+}
+
+// parseArchitectureImages parses a comma-separated list of architecture=image
+// pairs, such as "x86_64=ami-0123,arm64=/my-service/ami/arm64", where the
+// image is either an AMI ID or the path of an SSM parameter storing it.
+func parseArchitectureImages(value string) (map[string]string, error) {
+	images := make(map[string]string)
+
+	for _, pair := range strings.FieldsFunc(value, func(c rune) bool { return c == ',' }) {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid architecture image %q", pair)
+		}
+
+		arch, image := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if arch != x86Architecture && arch != arm64Architecture {
+			return nil, fmt.Errorf("unsupported architecture %q", arch)
+		}
+
+		switch {
+		case strings.HasPrefix(image, "ami-"), strings.HasPrefix(image, ssmImagePrefix):
+		case strings.HasPrefix(image, "/"):
+			image = ssmImagePrefix + image
+		default:
+			return nil, fmt.Errorf("invalid image %q, expected an AMI ID or an SSM parameter path", image)
+		}
+		images[arch] = image
+	}
+	return images, nil
+}
+
+// architectureImages returns the images configured for the group, ignoring
+// them if they are invalid.
+func (a *autoScalingGroup) architectureImages() map[string]string {
+	images, err := parseArchitectureImages(a.config.ArchitectureImages)
+	if err != nil {
+		log.Printf("%s Ignoring the architecture images %q: %s",
+			a.name, a.config.ArchitectureImages, err.Error())
+		return nil
+	}
+	return images
+}
+
+// isArchitectureCompatible allows candidates of other architectures if the
+// group has an image configured for their architecture.
+func (i *instance) isArchitectureCompatible(spotCandidate *instanceTypeInformation) bool {
+	if i.isSameArch(spotCandidate) {
+		return true
+	}
+
+	if i.asg == nil {
+		return false
+	}
+
+	if _, ok := i.asg.architectureImages()[spotCandidate.cpuArchitecture()]; ok {
+		debug.Println("\tUsing the image configured for the", spotCandidate.cpuArchitecture(), "architecture")
+		return true
+	}
+	return false
+}
+
+// createArchitectureLaunchTemplateVersions creates a version of the managed
+// launch template for each of the other architectures of the instance types,
+// using the image configured for that architecture. It returns the launch
+// template version to be used for each architecture, or nil if all the
+// instance types have the architecture of the instance.
+func (i *instance) createArchitectureLaunchTemplateVersions(ltData *ec2.RequestLaunchTemplateData, version *string, instanceTypes []*string) (map[string]*string, error) {
+	current := i.typeInfo.cpuArchitecture()
+	images := i.asg.architectureImages()
+
+	var versions map[string]*string
+
+	for _, it := range instanceTypes {
+		info := i.region.instanceTypeInformation[*it]
+		arch := info.cpuArchitecture()
+		if arch == current {
+			continue
+		}
+		if versions == nil {
+			versions = map[string]*string{current: version}
+		}
+		if _, ok := versions[arch]; ok {
+			continue
+		}
+
+		archData := *ltData
+		archData.ImageId = aws.String(images[arch])
+
+		archVersion, err := i.asg.managedLaunchTemplateVersion(&archData)
+		if err != nil {
+			log.Println("failed to create LaunchTemplate version for the", arch, "architecture,", err.Error())
+			return nil, err
+		}
+
+		versions[arch] = archVersion
+		log.Println(i.region.name, i.asg.name, "Using image", images[arch], "for the", arch,
+			"instance types, in version", *archVersion, "of LaunchTemplate", i.asg.managedLaunchTemplateName())
+	}
+	return versions, nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_parseArchitectureImages(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  map[string]string{},
+		},
+		{
+			name:  "AMI IDs and SSM parameters",
+			value: "x86_64=ami-0123, arm64=/my-service/ami/arm64",
+			want: map[string]string{
+				"x86_64": "ami-0123",
+				"arm64":  "resolve:ssm:/my-service/ami/arm64",
+			},
+		},
+		{
+			name:  "explicit SSM resolution",
+			value: "arm64=resolve:ssm:/my-service/ami/arm64:3",
+			want: map[string]string{
+				"arm64": "resolve:ssm:/my-service/ami/arm64:3",
+			},
+		},
+		{
+			name:    "unsupported architecture",
+			value:   "i386=ami-0123",
+			wantErr: true,
+		},
+		{
+			name:    "invalid image",
+			value:   "arm64=my-image",
+			wantErr: true,
+		},
+		{
+			name:    "missing image",
+			value:   "arm64",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArchitectureImages(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseArchitectureImages() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseArchitectureImages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isArchitectureCompatible(t *testing.T) {
+	graviton := &instanceTypeInformation{PhysicalProcessor: "AWS Graviton2 Processor"}
+	amd := &instanceTypeInformation{PhysicalProcessor: "AMD EPYC 7571"}
+
+	tests := []struct {
+		name      string
+		images    string
+		candidate *instanceTypeInformation
+		want      bool
+	}{
+		{name: "same architecture", candidate: amd, want: true},
+		{name: "other architecture without image", candidate: graviton, want: false},
+		{name: "other architecture with image", images: "arm64=ami-0123", candidate: graviton, want: true},
+		{name: "image for the wrong architecture", images: "x86_64=ami-0123", candidate: graviton, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: instanceTypeInformation{PhysicalProcessor: "Intel Xeon Platinum 8175"},
+				asg:      &autoScalingGroup{config: AutoScalingConfig{ArchitectureImages: tt.images}},
+			}
+			if got := i.isArchitectureCompatible(tt.candidate); got != tt.want {
+				t.Errorf("isArchitectureCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_createArchitectureLaunchTemplateVersions(t *testing.T) {
+	types := map[string]instanceTypeInformation{
+		"m5.large":  {PhysicalProcessor: "Intel Xeon Platinum 8175"},
+		"m5a.large": {PhysicalProcessor: "AMD EPYC 7571"},
+		"m6g.large": {PhysicalProcessor: "AWS Graviton2 Processor"},
+	}
+
+	ltData := &ec2.RequestLaunchTemplateData{ImageId: aws.String("ami-x86")}
+	archHash, _ := launchTemplateDataHash(&ec2.RequestLaunchTemplateData{ImageId: aws.String("ami-0123")})
+
+	tests := []struct {
+		name          string
+		instanceTypes []string
+		ec2           mockEC2
+		want          map[string]*string
+		wantErr       bool
+	}{
+		{
+			name:          "same architecture",
+			instanceTypes: []string{"m5.large", "m5a.large"},
+			want:          nil,
+		},
+		{
+			name:          "mixed architectures",
+			instanceTypes: []string{"m6g.large", "m5.large"},
+			ec2: mockEC2{
+				cltvo: &ec2.CreateLaunchTemplateVersionOutput{
+					LaunchTemplateVersion: &ec2.LaunchTemplateVersion{VersionNumber: aws.Int64(2)},
+				},
+			},
+			want: map[string]*string{
+				"x86_64": aws.String("1"),
+				"arm64":  aws.String("2"),
+			},
+		},
+		{
+			name:          "reused architecture version",
+			instanceTypes: []string{"m6g.large", "m5.large"},
+			ec2: mockEC2{
+				dltvpo: []*ec2.DescribeLaunchTemplateVersionsOutput{{
+					LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{
+						{
+							VersionNumber:      aws.Int64(3),
+							VersionDescription: aws.String(archHash),
+						},
+					},
+				}},
+				cltverr: errors.New("error"),
+			},
+			want: map[string]*string{
+				"x86_64": aws.String("1"),
+				"arm64":  aws.String("3"),
+			},
+		},
+		{
+			name:          "version creation failure",
+			instanceTypes: []string{"m6g.large"},
+			ec2:           mockEC2{cltverr: errors.New("error")},
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name:                    "us-east-1",
+				instanceTypeInformation: types,
+				services:                connections{ec2: tt.ec2},
+			}
+			i := &instance{
+				typeInfo: types["m5.large"],
+				region:   r,
+				asg: &autoScalingGroup{
+					name:   "test-asg",
+					config: AutoScalingConfig{ArchitectureImages: "arm64=ami-0123"},
+					region: r,
+				},
+			}
+
+			got, err := i.createArchitectureLaunchTemplateVersions(ltData, aws.String("1"),
+				aws.StringSlice(tt.instanceTypes))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("createArchitectureLaunchTemplateVersions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("createArchitectureLaunchTemplateVersions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_burstable.go contains the handling of the burstable instance types,
+// whose cost and performance depend on their CPU credits.
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+const (
+	// surplusCreditPrice is the price of the surplus credits spent by the
+	// Linux burstable instances running in unlimited mode, per vCPU-hour.
+	surplusCreditPrice = 0.05
+
+	cpuCreditsStandard  = "standard"
+	cpuCreditsUnlimited = "unlimited"
+)
+
+// burstableBaselines is the baseline CPU utilization of each vCPU of the
+// burstable instance types, by size. The t2 family has lower baselines for its
+// largest sizes.
+var burstableBaselines = map[string]float64{
+	"nano":    0.05,
+	"micro":   0.1,
+	"small":   0.2,
+	"medium":  0.2,
+	"large":   0.3,
+	"xlarge":  0.4,
+	"2xlarge": 0.4,
+}
+
+var t2Baselines = map[string]float64{
+	"xlarge":  0.225,
+	"2xlarge": 0.16875,
+}
+
+// burstableBaseline returns the baseline CPU utilization per vCPU of an
+// instance type, or 0 if it isn't burstable.
+func burstableBaseline(instanceType string) float64 {
+	if !isBurstableInstanceType(instanceType) {
+		return 0
+	}
+
+	parts := strings.SplitN(instanceType, ".", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+
+	if parts[0] == "t2" {
+		if baseline, ok := t2Baselines[parts[1]]; ok {
+			return baseline
+		}
+	}
+	return burstableBaselines[parts[1]]
+}
+
+// cpuCredits returns the credit specification the spot instance would be
+// launched with, either the one set in the launch template or the default
+// one of the instance family.
+func (i *instance) cpuCredits(spotCandidate *instanceTypeInformation) string {
+	if i.asg != nil && i.asg.launchTemplate != nil &&
+		i.asg.launchTemplate.LaunchTemplateVersion != nil &&
+		i.asg.launchTemplate.LaunchTemplateData != nil &&
+		i.asg.launchTemplate.LaunchTemplateData.CreditSpecification != nil {
+		return aws.StringValue(i.asg.launchTemplate.LaunchTemplateData.CreditSpecification.CpuCredits)
+	}
+
+	if strings.HasPrefix(spotCandidate.instanceType, "t2.") {
+		return cpuCreditsStandard
+	}
+	return cpuCreditsUnlimited
+}
+
+// cpuLoad estimates the number of vCPUs used by the instance, either from the
+// CPU utilization configured for the group, or assuming burstable instances
+// run at their baseline and the others at full utilization.
+func (i *instance) cpuLoad() float64 {
+	if i.asg != nil && i.asg.config.BurstableCPUUtilization > 0 {
+		return float64(i.typeInfo.vCPU) * i.asg.config.BurstableCPUUtilization / 100
+	}
+
+	baseline := burstableBaseline(i.typeInfo.instanceType)
+	if baseline == 0 {
+		return float64(i.typeInfo.vCPU)
+	}
+	return float64(i.typeInfo.vCPU) * baseline
+}
+
+// burstableSurcharge estimates the hourly cost of the surplus credits spent by
+// a burstable candidate running in unlimited mode, when the load of the
+// instance exceeds the baseline of the candidate.
+func (i *instance) burstableSurcharge(spotCandidate *instanceTypeInformation) float64 {
+	baseline := burstableBaseline(spotCandidate.instanceType)
+	if baseline == 0 || i.cpuCredits(spotCandidate) != cpuCreditsUnlimited {
+		return 0
+	}
+
+	surplus := i.cpuLoad() - float64(spotCandidate.vCPU)*baseline
+	return math.Max(0, surplus) * surplusCreditPrice
+}
+
+// isBurstableCompatible keeps the burstable instances on burstable instance
+// types and the others on non-burstable instance types, unless mixing them is
+// allowed for the group.
+func (i *instance) isBurstableCompatible(spotCandidate *instanceTypeInformation) bool {
+	if i.typeInfo.burstable == spotCandidate.burstable {
+		return true
+	}
+
+	if i.asg != nil && i.asg.config.AllowBurstableMixing {
+		return true
+	}
+
+	debug.Println("\tNot burstable compatible, mixing burstable and non-burstable instance types")
+	return false
+}
+
+// isCreditConstrained tells if a burstable instance is running above its
+// baseline, either low on CPU credits or spending surplus credits, in which
+// case its replacement can be skipped for the group.
+func (i *instance) isCreditConstrained() bool {
+	if i.asg == nil || !i.asg.config.SkipCreditConstrainedInstances ||
+		!i.typeInfo.burstable {
+		return false
+	}
+
+	if i.creditConstrained == nil {
+		i.creditConstrained = aws.Bool(i.checkCreditConstrained())
+	}
+	return *i.creditConstrained
+}
+
+// checkCreditConstrained checks the CPU credit metrics of the instance.
+func (i *instance) checkCreditConstrained() bool {
+	surplus, err := i.latestCPUCreditMetric("CPUSurplusCreditBalance")
+	if err != nil {
+		debug.Println("Couldn't get the surplus credit balance of", *i.InstanceId, err.Error())
+	} else if surplus != nil && *surplus > 0 {
+		debug.Println(*i.InstanceId, "is spending surplus CPU credits")
+		return true
+	}
+
+	// one hour worth of earned credits, which are measured in vCPU-minutes
+	earnedHourly := 60 * float64(i.typeInfo.vCPU) * burstableBaseline(i.typeInfo.instanceType)
+
+	balance, err := i.latestCPUCreditMetric("CPUCreditBalance")
+	if err != nil {
+		debug.Println("Couldn't get the credit balance of", *i.InstanceId, err.Error())
+		return false
+	}
+
+	if balance != nil && *balance < earnedHourly {
+		debug.Println(*i.InstanceId, "is low on CPU credits:", *balance)
+		return true
+	}
+	return false
+}
+
+// latestCPUCreditMetric returns the latest value of a credit metric of the
+// instance, or nil if there are no recent datapoints.
+func (i *instance) latestCPUCreditMetric(metric string) (*float64, error) {
+	now := time.Now()
+
+	resp, err := i.region.services.cloudWatch.GetMetricStatisticsWithContext(
+		i.region.runContext(),
+		&cloudwatch.GetMetricStatisticsInput{
+			Namespace:  aws.String("AWS/EC2"),
+			MetricName: aws.String(metric),
+			Dimensions: []*cloudwatch.Dimension{
+				{
+					Name:  aws.String("InstanceId"),
+					Value: i.InstanceId,
+				},
+			},
+			StartTime:  aws.Time(now.Add(-30 * time.Minute)),
+			EndTime:    aws.Time(now),
+			Period:     aws.Int64(300),
+			Statistics: []*string{aws.String(cloudwatch.StatisticAverage)},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *cloudwatch.Datapoint
+	for _, dp := range resp.Datapoints {
+		if latest == nil || dp.Timestamp.After(*latest.Timestamp) {
+			latest = dp
+		}
+	}
+
+	if latest == nil {
+		return nil, nil
+	}
+	return latest.Average, nil
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_burstableBaseline(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		want         float64
+	}{
+		{instanceType: "t3.large", want: 0.3},
+		{instanceType: "t4g.micro", want: 0.1},
+		{instanceType: "t2.2xlarge", want: 0.16875},
+		{instanceType: "t2.large", want: 0.3},
+		{instanceType: "m5.large", want: 0},
+		{instanceType: "t3.unknown", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instanceType, func(t *testing.T) {
+			if got := burstableBaseline(tt.instanceType); got != tt.want {
+				t.Errorf("burstableBaseline() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_burstableSurcharge(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     instanceTypeInformation
+		candidate   instanceTypeInformation
+		utilization float64
+		credits     *string
+		want        float64
+	}{
+		{
+			name:      "non-burstable candidate",
+			current:   instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+			candidate: instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+			want:      0,
+		},
+		{
+			name:      "same baseline",
+			current:   instanceTypeInformation{instanceType: "t3.large", vCPU: 2},
+			candidate: instanceTypeInformation{instanceType: "t3a.large", vCPU: 2},
+			want:      0,
+		},
+		{
+			name:      "lower baseline",
+			current:   instanceTypeInformation{instanceType: "t3.xlarge", vCPU: 4},
+			candidate: instanceTypeInformation{instanceType: "t2.xlarge", vCPU: 4},
+			credits:   aws.String(cpuCreditsUnlimited),
+			want:      (4*0.4 - 4*0.225) * surplusCreditPrice,
+		},
+		{
+			name:      "non-burstable instance at full utilization",
+			current:   instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+			candidate: instanceTypeInformation{instanceType: "t3.large", vCPU: 2},
+			want:      (2 - 2*0.3) * surplusCreditPrice,
+		},
+		{
+			name:        "configured utilization",
+			current:     instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+			candidate:   instanceTypeInformation{instanceType: "t3.large", vCPU: 2},
+			utilization: 80,
+			want:        (2*0.8 - 2*0.3) * surplusCreditPrice,
+		},
+		{
+			name:        "standard mode",
+			current:     instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+			candidate:   instanceTypeInformation{instanceType: "t3.large", vCPU: 2},
+			utilization: 80,
+			credits:     aws.String(cpuCreditsStandard),
+			want:        0,
+		},
+		{
+			name:        "t2 defaults to standard mode",
+			current:     instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+			candidate:   instanceTypeInformation{instanceType: "t2.large", vCPU: 2},
+			utilization: 80,
+			want:        0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				config: AutoScalingConfig{BurstableCPUUtilization: tt.utilization},
+			}
+			if tt.credits != nil {
+				a.launchTemplate = &launchTemplate{
+					LaunchTemplateVersion: &ec2.LaunchTemplateVersion{
+						LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+							CreditSpecification: &ec2.CreditSpecification{CpuCredits: tt.credits},
+						},
+					},
+				}
+			}
+			i := &instance{typeInfo: tt.current, asg: a}
+
+			if got := i.burstableSurcharge(&tt.candidate); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("burstableSurcharge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isBurstableCompatible(t *testing.T) {
+	burstable := instanceTypeInformation{instanceType: "t3.large", burstable: true}
+	regular := instanceTypeInformation{instanceType: "m5.large"}
+
+	tests := []struct {
+		name      string
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		allow     bool
+		want      bool
+	}{
+		{name: "burstable to burstable", current: burstable, candidate: burstable, want: true},
+		{name: "regular to regular", current: regular, candidate: regular, want: true},
+		{name: "burstable to regular", current: burstable, candidate: regular, want: false},
+		{name: "regular to burstable", current: regular, candidate: burstable, want: false},
+		{name: "mixing allowed", current: burstable, candidate: regular, allow: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: tt.current,
+				asg:      &autoScalingGroup{config: AutoScalingConfig{AllowBurstableMixing: tt.allow}},
+			}
+			if got := i.isBurstableCompatible(&tt.candidate); got != tt.want {
+				t.Errorf("isBurstableCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isCreditConstrained(t *testing.T) {
+	datapoints := func(values ...float64) *cloudwatch.GetMetricStatisticsOutput {
+		out := &cloudwatch.GetMetricStatisticsOutput{}
+		for n, v := range values {
+			out.Datapoints = append(out.Datapoints, &cloudwatch.Datapoint{
+				Timestamp: aws.Time(time.Now().Add(time.Duration(n) * time.Minute)),
+				Average:   aws.Float64(v),
+			})
+		}
+		return out
+	}
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		burstable  bool
+		cloudWatch mockCloudWatch
+		want       bool
+	}{
+		{
+			name:      "disabled",
+			burstable: true,
+			cloudWatch: mockCloudWatch{gmso: map[string]*cloudwatch.GetMetricStatisticsOutput{
+				"CPUCreditBalance": datapoints(0),
+			}},
+			want: false,
+		},
+		{
+			name:    "not burstable",
+			enabled: true,
+			want:    false,
+		},
+		{
+			name:      "spending surplus credits",
+			enabled:   true,
+			burstable: true,
+			cloudWatch: mockCloudWatch{gmso: map[string]*cloudwatch.GetMetricStatisticsOutput{
+				"CPUSurplusCreditBalance": datapoints(0, 12),
+				"CPUCreditBalance":        datapoints(500),
+			}},
+			want: true,
+		},
+		{
+			name:      "low on credits",
+			enabled:   true,
+			burstable: true,
+			cloudWatch: mockCloudWatch{gmso: map[string]*cloudwatch.GetMetricStatisticsOutput{
+				"CPUCreditBalance": datapoints(100, 20),
+			}},
+			want: true,
+		},
+		{
+			name:      "enough credits",
+			enabled:   true,
+			burstable: true,
+			cloudWatch: mockCloudWatch{gmso: map[string]*cloudwatch.GetMetricStatisticsOutput{
+				"CPUCreditBalance": datapoints(20, 100),
+			}},
+			want: false,
+		},
+		{
+			name:       "no metrics",
+			enabled:    true,
+			burstable:  true,
+			cloudWatch: mockCloudWatch{},
+			want:       false,
+		},
+		{
+			name:       "CloudWatch error",
+			enabled:    true,
+			burstable:  true,
+			cloudWatch: mockCloudWatch{gmserr: errors.New("error")},
+			want:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-burstable")},
+				typeInfo: instanceTypeInformation{
+					instanceType: "t3.large",
+					vCPU:         2,
+					burstable:    tt.burstable,
+				},
+				region: &region{services: connections{cloudWatch: tt.cloudWatch}},
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{SkipCreditConstrainedInstances: tt.enabled},
+				},
+			}
+			if got := i.isCreditConstrained(); got != tt.want {
+				t.Errorf("isCreditConstrained() = %v, want %v", got, tt.want)
+			}
+
+			// the result is memoized for the next checks of the instance
+			i.region.services.cloudWatch = mockCloudWatch{gmserr: errors.New("unexpected call")}
+			if got := i.isCreditConstrained(); got != tt.want {
+				t.Errorf("memoized isCreditConstrained() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
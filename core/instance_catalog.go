@@ -0,0 +1,249 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_catalog.go contains the instance type catalog loaded from the EC2
+// API, which complements the instance type data embedded in the binary with
+// the details missing from it and with the instance types launched since the
+// binary was built.
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// DefaultInstanceTypeCatalogTTL is the default duration for which the instance
+// type catalog of a region is cached between runs.
+const DefaultInstanceTypeCatalogTTL = 6 * time.Hour
+
+// instanceTypeCatalog is the list of instance types available in a region,
+// together with the availability zones in which each of them is offered.
+type instanceTypeCatalog struct {
+	instanceTypes []*ec2.InstanceTypeInfo
+	offerings     map[string][]string
+	loadedAt      time.Time
+}
+
+// instanceTypeCatalogCache keeps the catalogs of the regions across the runs
+// executed by the same process, such as the invocations of a warm Lambda
+// function.
+type instanceTypeCatalogCache struct {
+	sync.Mutex
+	catalogs map[string]*instanceTypeCatalog
+}
+
+var instanceTypeCatalogs = &instanceTypeCatalogCache{catalogs: make(map[string]*instanceTypeCatalog)}
+
+func (c *instanceTypeCatalogCache) get(region string, ttl time.Duration) *instanceTypeCatalog {
+	c.Lock()
+	defer c.Unlock()
+
+	catalog, ok := c.catalogs[region]
+	if !ok || time.Since(catalog.loadedAt) > ttl {
+		return nil
+	}
+	return catalog
+}
+
+func (c *instanceTypeCatalogCache) set(region string, catalog *instanceTypeCatalog) {
+	c.Lock()
+	defer c.Unlock()
+	c.catalogs[region] = catalog
+}
+
+func (r *region) instanceTypeCatalogTTL() time.Duration {
+	if r.conf == nil {
+		return DefaultInstanceTypeCatalogTTL
+	}
+	return r.conf.InstanceTypeCatalogTTL
+}
+
+// getInstanceTypeCatalog returns the cached catalog of the region, or loads it
+// from the EC2 API if it expired.
+func (r *region) getInstanceTypeCatalog() (*instanceTypeCatalog, error) {
+	ttl := r.instanceTypeCatalogTTL()
+	if catalog := instanceTypeCatalogs.get(r.name, ttl); catalog != nil {
+		debug.Println(r.name, "Using the instance type catalog loaded at", catalog.loadedAt)
+		return catalog, nil
+	}
+
+	catalog := &instanceTypeCatalog{
+		offerings: make(map[string][]string),
+		loadedAt:  time.Now(),
+	}
+
+	err := r.services.ec2.DescribeInstanceTypesPagesWithContext(
+		r.runContext(),
+		&ec2.DescribeInstanceTypesInput{},
+		func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
+			catalog.instanceTypes = append(catalog.instanceTypes, page.InstanceTypes...)
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.services.ec2.DescribeInstanceTypeOfferingsPagesWithContext(
+		r.runContext(),
+		&ec2.DescribeInstanceTypeOfferingsInput{
+			LocationType: aws.String(ec2.LocationTypeAvailabilityZone),
+		},
+		func(page *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
+			for _, o := range page.InstanceTypeOfferings {
+				it := aws.StringValue(o.InstanceType)
+				catalog.offerings[it] = append(catalog.offerings[it], aws.StringValue(o.Location))
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		instanceTypeCatalogs.set(r.name, catalog)
+	}
+	return catalog, nil
+}
+
+// loadInstanceTypeCatalog merges the catalog of the region into the instance
+// type information loaded from the embedded data. The instance types missing
+// from the embedded data are added without on-demand pricing, so they can be
+// launched as spot instances but their own instances aren't replaced.
+func (r *region) loadInstanceTypeCatalog() error {
+	catalog, err := r.getInstanceTypeCatalog()
+	if err != nil {
+		return err
+	}
+
+	for _, it := range catalog.instanceTypes {
+		name := aws.StringValue(it.InstanceType)
+
+		info, ok := r.instanceTypeInformation[name]
+		if !ok {
+			debug.Println(r.name, "Adding instance type", name, "missing from the embedded instance type data")
+			info = newInstanceTypeInformation(it)
+			info.pricing = prices{
+				spot:    make(spotPriceMap),
+				premium: r.conf.SpotProductPremium,
+			}
+		}
+
+		if it.NetworkInfo != nil {
+			info.setNetworkInfo(it.NetworkInfo)
+		}
+		info.setAcceleratorInfo(it)
+		info.setPlatformInfo(it)
+		info.setPlacementGroupInfo(it)
+		if it.BurstablePerformanceSupported != nil {
+			info.burstable = *it.BurstablePerformanceSupported
+		}
+		if it.BareMetal != nil {
+			info.bareMetal = *it.BareMetal
+		}
+		if it.CurrentGeneration != nil {
+			info.previousGeneration = !*it.CurrentGeneration
+		}
+		r.instanceTypeInformation[name] = info
+	}
+
+	if len(catalog.offerings) == 0 {
+		return nil
+	}
+
+	// the instance types without offerings aren't available in any of the
+	// availability zones
+	for name, info := range r.instanceTypeInformation {
+		info.availabilityZones = append([]string{}, catalog.offerings[name]...)
+		r.instanceTypeInformation[name] = info
+	}
+	return nil
+}
+
+// newInstanceTypeInformation converts the EC2 API data of an instance type
+// missing from the embedded instance type data.
+func newInstanceTypeInformation(it *ec2.InstanceTypeInfo) instanceTypeInformation {
+	info := instanceTypeInformation{
+		instanceType:       aws.StringValue(it.InstanceType),
+		PhysicalProcessor:  processorFromInstanceTypeInfo(it),
+		burstable:          isBurstableInstanceType(aws.StringValue(it.InstanceType)),
+		bareMetal:          aws.BoolValue(it.BareMetal),
+		previousGeneration: !aws.BoolValue(it.CurrentGeneration),
+	}
+
+	if it.VCpuInfo != nil {
+		info.vCPU = int(aws.Int64Value(it.VCpuInfo.DefaultVCpus))
+	}
+
+	if it.MemoryInfo != nil {
+		info.memory = float32(aws.Int64Value(it.MemoryInfo.SizeInMiB)) / 1024
+	}
+
+	if it.GpuInfo != nil {
+		for _, gpu := range it.GpuInfo.Gpus {
+			info.GPU += int(aws.Int64Value(gpu.Count))
+		}
+	}
+
+	for _, vt := range it.SupportedVirtualizationTypes {
+		switch aws.StringValue(vt) {
+		case ec2.VirtualizationTypeHvm:
+			info.virtualizationTypes = append(info.virtualizationTypes, "HVM")
+		case ec2.VirtualizationTypeParavirtual:
+			info.virtualizationTypes = append(info.virtualizationTypes, "PV")
+		}
+	}
+
+	if ebs := it.EbsInfo; ebs != nil {
+		info.hasEBSOptimization = aws.StringValue(ebs.EbsOptimizedSupport) != ec2.EbsOptimizedSupportUnsupported
+		if ebs.EbsOptimizedInfo != nil {
+			info.EBSThroughput = float32(aws.Float64Value(ebs.EbsOptimizedInfo.MaximumThroughputInMBps))
+		}
+	}
+
+	if storage := it.InstanceStorageInfo; storage != nil && len(storage.Disks) > 0 {
+		disk := storage.Disks[0]
+		info.hasInstanceStore = true
+		info.instanceStoreDeviceCount = int(aws.Int64Value(disk.Count))
+		info.instanceStoreDeviceSize = float32(aws.Int64Value(disk.SizeInGB))
+		info.instanceStoreIsSSD = aws.StringValue(disk.Type) == ec2.DiskTypeSsd
+	}
+	return info
+}
+
+// processorFromInstanceTypeInfo approximates the processor name, which is
+// missing from the EC2 API, from the architecture and the instance family.
+func processorFromInstanceTypeInfo(it *ec2.InstanceTypeInfo) string {
+	if it.ProcessorInfo != nil {
+		for _, arch := range it.ProcessorInfo.SupportedArchitectures {
+			if aws.StringValue(arch) == ec2.ArchitectureTypeArm64 {
+				return "AWS Graviton Processor"
+			}
+		}
+	}
+
+	family := strings.SplitN(aws.StringValue(it.InstanceType), ".", 2)[0]
+	if i := strings.IndexAny(family, "0123456789"); i >= 0 &&
+		strings.Contains(family[i+1:], "a") {
+		return "AMD EPYC Processor"
+	}
+	return "Intel Xeon Processor"
+}
+
+// isOfferedInAZ checks if the candidate is offered in the availability zone
+// of the instance, unless the offerings couldn't be loaded.
+func (i *instance) isOfferedInAZ(spotCandidate *instanceTypeInformation) bool {
+	if spotCandidate.availabilityZones == nil || i.Placement == nil {
+		return true
+	}
+
+	if !itemInSlice(aws.StringValue(i.Placement.AvailabilityZone), spotCandidate.availabilityZones) {
+		debug.Println("\tNot offered in the availability zone", aws.StringValue(i.Placement.AvailabilityZone))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_region_loadInstanceTypeCatalog(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{},
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"c5n.4xlarge": {instanceType: "c5n.4xlarge"},
+			"g4dn.xlarge": {instanceType: "g4dn.xlarge"},
+		},
+		services: connections{
+			ec2: mockEC2{
+				ditpo: []*ec2.DescribeInstanceTypesOutput{
+					{
+						InstanceTypes: []*ec2.InstanceTypeInfo{
+							{
+								InstanceType:       aws.String("c5n.4xlarge"),
+								Hypervisor:         aws.String(ec2.InstanceTypeHypervisorNitro),
+								SupportedBootModes: []*string{aws.String(ec2.BootModeValuesLegacyBios)},
+								EbsInfo:            &ec2.EbsInfo{NvmeSupport: aws.String(ec2.EbsNvmeSupportRequired)},
+								NetworkInfo: &ec2.NetworkInfo{
+									NetworkPerformance:        aws.String("Up to 25 Gigabit"),
+									MaximumNetworkInterfaces:  aws.Int64(8),
+									Ipv4AddressesPerInterface: aws.Int64(30),
+									EnaSupport:                aws.String(ec2.EnaSupportRequired),
+									EfaSupported:              aws.Bool(true),
+								},
+							},
+							{
+								InstanceType:                 aws.String("m7a.large"),
+								CurrentGeneration:            aws.Bool(true),
+								BareMetal:                    aws.Bool(false),
+								VCpuInfo:                     &ec2.VCpuInfo{DefaultVCpus: aws.Int64(2)},
+								MemoryInfo:                   &ec2.MemoryInfo{SizeInMiB: aws.Int64(8192)},
+								ProcessorInfo:                &ec2.ProcessorInfo{SupportedArchitectures: []*string{aws.String("x86_64")}},
+								SupportedVirtualizationTypes: []*string{aws.String("hvm")},
+								EbsInfo: &ec2.EbsInfo{
+									EbsOptimizedSupport: aws.String(ec2.EbsOptimizedSupportDefault),
+									EbsOptimizedInfo:    &ec2.EbsOptimizedInfo{MaximumThroughputInMBps: aws.Float64(1250)},
+								},
+							},
+						},
+					},
+					{
+						InstanceTypes: []*ec2.InstanceTypeInfo{
+							{
+								InstanceType: aws.String("g4dn.xlarge"),
+								GpuInfo: &ec2.GpuInfo{
+									Gpus: []*ec2.GpuDeviceInfo{
+										{
+											Count:        aws.Int64(1),
+											Manufacturer: aws.String("NVIDIA"),
+											Name:         aws.String("T4"),
+											MemoryInfo:   &ec2.GpuDeviceMemoryInfo{SizeInMiB: aws.Int64(16384)},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				ditopo: []*ec2.DescribeInstanceTypeOfferingsOutput{
+					{
+						InstanceTypeOfferings: []*ec2.InstanceTypeOffering{
+							{InstanceType: aws.String("c5n.4xlarge"), Location: aws.String("us-east-1a")},
+							{InstanceType: aws.String("c5n.4xlarge"), Location: aws.String("us-east-1b")},
+							{InstanceType: aws.String("m7a.large"), Location: aws.String("us-east-1a")},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := r.loadInstanceTypeCatalog(); err != nil {
+		t.Fatalf("loadInstanceTypeCatalog() error = %v", err)
+	}
+
+	want := map[string]instanceTypeInformation{
+		"c5n.4xlarge": {
+			instanceType:              "c5n.4xlarge",
+			networkPerformance:        12.5,
+			maxNetworkInterfaces:      8,
+			ipv4AddressesPerInterface: 30,
+			enaSupport:                ec2.EnaSupportRequired,
+			efaSupported:              true,
+			hypervisor:                ec2.InstanceTypeHypervisorNitro,
+			nvmeSupport:               ec2.EbsNvmeSupportRequired,
+			bootModes:                 []string{ec2.BootModeValuesLegacyBios},
+			availabilityZones:         []string{"us-east-1a", "us-east-1b"},
+		},
+		"g4dn.xlarge": {
+			instanceType: "g4dn.xlarge",
+			accelerator: acceleratorInfo{
+				kind:         gpuAccelerator,
+				manufacturer: "NVIDIA",
+				model:        "T4",
+				count:        1,
+				memory:       16384,
+			},
+			availabilityZones: []string{},
+		},
+		"m7a.large": {
+			instanceType:        "m7a.large",
+			vCPU:                2,
+			memory:              8,
+			PhysicalProcessor:   "AMD EPYC Processor",
+			virtualizationTypes: []string{"HVM"},
+			hasEBSOptimization:  true,
+			EBSThroughput:       1250,
+			pricing:             prices{spot: spotPriceMap{}},
+			availabilityZones:   []string{"us-east-1a"},
+		},
+	}
+	if !reflect.DeepEqual(r.instanceTypeInformation, want) {
+		t.Errorf("loadInstanceTypeCatalog() = %+v, want %+v", r.instanceTypeInformation, want)
+	}
+}
+
+func Test_region_getInstanceTypeCatalog(t *testing.T) {
+	page := []*ec2.DescribeInstanceTypesOutput{
+		{InstanceTypes: []*ec2.InstanceTypeInfo{{InstanceType: aws.String("m5.large")}}},
+	}
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		ec2       mockEC2
+		wantTypes int
+		wantErr   bool
+	}{
+		{
+			name:    "API error",
+			ttl:     time.Hour,
+			ec2:     mockEC2{ditperr: errors.New("error")},
+			wantErr: true,
+		},
+		{
+			name:      "loaded from the API",
+			ttl:       time.Hour,
+			ec2:       mockEC2{ditpo: page},
+			wantTypes: 1,
+		},
+		{
+			name:      "cached",
+			ttl:       time.Hour,
+			ec2:       mockEC2{ditperr: errors.New("error")},
+			wantTypes: 1,
+		},
+		{
+			name:    "cache disabled",
+			ttl:     0,
+			ec2:     mockEC2{ditperr: errors.New("error")},
+			wantErr: true,
+		},
+	}
+
+	defer func() {
+		instanceTypeCatalogs = &instanceTypeCatalogCache{catalogs: make(map[string]*instanceTypeCatalog)}
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name:     "catalog-test-region",
+				conf:     &Config{InstanceTypeCatalogTTL: tt.ttl},
+				services: connections{ec2: tt.ec2},
+			}
+
+			got, err := r.getInstanceTypeCatalog()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getInstanceTypeCatalog() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(got.instanceTypes) != tt.wantTypes {
+				t.Errorf("getInstanceTypeCatalog() returned %d instance types, want %d",
+					len(got.instanceTypes), tt.wantTypes)
+			}
+		})
+	}
+}
+
+func Test_processorFromInstanceTypeInfo(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		arch         string
+		want         string
+	}{
+		{instanceType: "m7g.large", arch: "arm64", want: "AWS Graviton Processor"},
+		{instanceType: "c7a.large", arch: "x86_64", want: "AMD EPYC Processor"},
+		{instanceType: "r7iz.large", arch: "x86_64", want: "Intel Xeon Processor"},
+		{instanceType: "a1.large", arch: "arm64", want: "AWS Graviton Processor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instanceType, func(t *testing.T) {
+			it := &ec2.InstanceTypeInfo{
+				InstanceType:  aws.String(tt.instanceType),
+				ProcessorInfo: &ec2.ProcessorInfo{SupportedArchitectures: []*string{aws.String(tt.arch)}},
+			}
+			if got := processorFromInstanceTypeInfo(it); got != tt.want {
+				t.Errorf("processorFromInstanceTypeInfo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isOfferedInAZ(t *testing.T) {
+	i := &instance{
+		Instance: &ec2.Instance{
+			Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		zones []string
+		want  bool
+	}{
+		{name: "unknown offerings", zones: nil, want: true},
+		{name: "offered", zones: []string{"us-east-1b", "us-east-1a"}, want: true},
+		{name: "not offered", zones: []string{"us-east-1b"}, want: false},
+		{name: "not offered anywhere", zones: []string{}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := i.isOfferedInAZ(&instanceTypeInformation{availabilityZones: tt.zones}); got != tt.want {
+				t.Errorf("isOfferedInAZ() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -66,8 +66,9 @@ func (i *instance) convertLaunchConfigurationBlockDeviceMappings(BDMs []*autosca
 				Iops:                BDM.Ebs.Iops,
 				SnapshotId:          BDM.Ebs.SnapshotId,
 				VolumeSize:          BDM.Ebs.VolumeSize,
-				VolumeType:          convertLaunchConfigurationEBSVolumeType(BDM.Ebs, i.asg),
+				VolumeType:          BDM.Ebs.VolumeType,
 			}
+			i.applyEBSVolumePolicy(BDM.DeviceName, ec2BDM.Ebs)
 		}
 
 		// handle the noDevice field directly by skipping the device if set to true
@@ -104,8 +105,10 @@ func (i *instance) convertLaunchTemplateBlockDeviceMappings(BDMs []*ec2.LaunchTe
 				Iops:                BDM.Ebs.Iops,
 				SnapshotId:          BDM.Ebs.SnapshotId,
 				VolumeSize:          BDM.Ebs.VolumeSize,
-				VolumeType:          convertLaunchTemplateEBSVolumeType(BDM.Ebs, i.asg),
+				VolumeType:          BDM.Ebs.VolumeType,
+				Throughput:          BDM.Ebs.Throughput,
 			}
+			i.applyEBSVolumePolicy(BDM.DeviceName, ec2BDM.Ebs)
 		}
 
 		// handle the noDevice field directly by skipping the device if set to true, apparently NoDevice is here a string instead of a bool.
@@ -142,8 +145,10 @@ func (i *instance) convertImageBlockDeviceMappings(BDMs []*ec2.BlockDeviceMappin
 				Iops:                BDM.Ebs.Iops,
 				SnapshotId:          BDM.Ebs.SnapshotId,
 				VolumeSize:          BDM.Ebs.VolumeSize,
-				VolumeType:          convertImageEBSVolumeType(BDM.Ebs, i.asg),
+				VolumeType:          BDM.Ebs.VolumeType,
+				Throughput:          BDM.Ebs.Throughput,
 			}
+			i.applyEBSVolumePolicy(BDM.DeviceName, ec2BDM.Ebs)
 		}
 
 		// handle the noDevice field directly by skipping the device if set to true, apparently NoDevice is here a string instead of a bool.
@@ -159,66 +164,6 @@ func (i *instance) convertImageBlockDeviceMappings(BDMs []*ec2.BlockDeviceMappin
 	return bds
 }
 
-func convertLaunchConfigurationEBSVolumeType(ebs *autoscaling.Ebs, a *autoScalingGroup) *string {
-	// convert IO1 to IO2 in supported regions
-	r := a.region.name
-	asg := a.name
-
-	if ebs.VolumeType == nil {
-		log.Println(r, ": Empty EBS VolumeType while converting LC volume for ASG", asg)
-		return nil
-	}
-
-	if *ebs.VolumeType == "io1" && supportedIO2region(r) {
-		log.Println(r, ": Converting IO1 volume to IO2 for new instance launched for", asg)
-		return aws.String("io2")
-	}
-
-	// convert GP2 to GP3 below the threshold where GP2 becomes more performant. The Threshold is configurable
-	if *ebs.VolumeType == "gp2" && *ebs.VolumeSize <= a.config.GP2ConversionThreshold {
-		log.Println(r, ": Converting GP2 EBS volume to GP3 for new instance launched for", asg)
-		return aws.String("gp3")
-	}
-	log.Println(r, ": No EBS volume conversion could be done for", asg)
-	return ebs.VolumeType
-}
-
-func convertLaunchTemplateEBSVolumeType(ebs *ec2.LaunchTemplateEbsBlockDevice, a *autoScalingGroup) *string {
-	// convert IO1 to IO2 in supported regions
-	r := a.region.name
-	asg := a.name
-	if *ebs.VolumeType == "io1" && supportedIO2region(r) {
-		log.Println(r, ": Converting IO1 volume to IO2 for new instance launched for", asg)
-		return aws.String("io2")
-	}
-
-	// convert GP2 to GP3 below the threshold where GP2 becomes more performant. The Threshold is configurable
-	if *ebs.VolumeType == "gp2" && *ebs.VolumeSize <= a.config.GP2ConversionThreshold {
-		log.Println(r, ": Converting GP2 EBS volume to GP3 for new instance launched for", asg)
-		return aws.String("gp3")
-	}
-	log.Println(r, ": No EBS volume conversion could be done for", asg)
-	return ebs.VolumeType
-}
-
-func convertImageEBSVolumeType(ebs *ec2.EbsBlockDevice, a *autoScalingGroup) *string {
-	// convert IO1 to IO2 in supported regions
-	r := a.region.name
-	asg := a.name
-	if *ebs.VolumeType == "io1" && supportedIO2region(r) {
-		log.Println(r, ": Converting IO1 volume to IO2 for new instance launched for", asg)
-		return aws.String("io2")
-	}
-
-	// convert GP2 to GP3 below the threshold where GP2 becomes more performant. The Threshold is configurable
-	if *ebs.VolumeType == "gp2" && *ebs.VolumeSize <= a.config.GP2ConversionThreshold {
-		log.Println(r, ": Converting GP2 EBS volume to GP3 for new instance launched for", asg)
-		return aws.String("gp3")
-	}
-	log.Println(r, ": No EBS volume conversion could be done for", asg)
-	return ebs.VolumeType
-}
-
 func supportedIO2region(region string) bool {
 	for _, r := range unsupportedIO2Regions {
 		if region == r {
@@ -238,7 +183,8 @@ func (i *instance) convertSecurityGroups() []*string {
 }
 
 func (i *instance) getlaunchTemplate(id, ver *string) (*ec2.ResponseLaunchTemplateData, error) {
-	res, err := i.region.services.ec2.DescribeLaunchTemplateVersions(
+	res, err := i.region.services.ec2.DescribeLaunchTemplateVersionsWithContext(
+		i.region.runContext(),
 		&ec2.DescribeLaunchTemplateVersionsInput{
 			Versions:         []*string{ver},
 			LaunchTemplateId: id,
@@ -265,20 +211,8 @@ func (i *instance) processLaunchTemplate(retval *ec2.RequestLaunchTemplateData)
 		return err
 	}
 
-	// see https://docs.aws.amazon.com/sdk-for-go/api/service/ec2/#RequestLaunchTemplateData for the attributes we need to copy over to the new LT
-
-	// currently omitted fields:
-	// ElasticGpuSpecifications - not sure about the use case for this, but I'm open to add it later
-	// ElasticInferenceAccelerators - not sure about the use case for this, but I'm open to add it later
-	// EnclaveOptions - not sure about the use case for this, but I'm open to add it later
-	// HibernationOptions - not sure about the use case for this, but I'm open to add it later
-	// InstanceMarketOptions - needs to be set to Spot anyway
-	// InstanceType - not needed because we pass more instance types
-	// KernelId - probably not needed, should be determined from the AMI
-	// LicenseSpecifications - probably not needed, should be determined from the AMI
-	// MetadataOptions - not sure what's the use case for changing this
-	// Placement - determined dynamically when launching each Spot instance
-	// RamDiskId probably not needed, should be determined from the AMI
+	// see launchTemplateDataAttributes for how each of the attributes is
+	// carried over to the new LT
 
 	retval.BlockDeviceMappings = i.convertLaunchTemplateBlockDeviceMappings(ltData.BlockDeviceMappings)
 
@@ -293,31 +227,58 @@ func (i *instance) processLaunchTemplate(retval *ec2.RequestLaunchTemplateData)
 
 	retval.CreditSpecification = (*ec2.CreditSpecificationRequest)(ltData.CreditSpecification)
 
+	retval.DisableApiStop = ltData.DisableApiStop
+
 	retval.DisableApiTermination = ltData.DisableApiTermination
 
 	retval.EbsOptimized = ltData.EbsOptimized
 
+	for _, egs := range ltData.ElasticGpuSpecifications {
+		retval.ElasticGpuSpecifications = append(retval.ElasticGpuSpecifications,
+			(*ec2.ElasticGpuSpecification)(egs))
+	}
+
+	for _, eia := range ltData.ElasticInferenceAccelerators {
+		retval.ElasticInferenceAccelerators = append(retval.ElasticInferenceAccelerators,
+			(*ec2.LaunchTemplateElasticInferenceAccelerator)(eia))
+	}
+
+	retval.EnclaveOptions = (*ec2.LaunchTemplateEnclaveOptionsRequest)(ltData.EnclaveOptions)
+
+	retval.HibernationOptions = (*ec2.LaunchTemplateHibernationOptionsRequest)(ltData.HibernationOptions)
+
 	retval.IamInstanceProfile = (*ec2.LaunchTemplateIamInstanceProfileSpecificationRequest)(ltData.IamInstanceProfile)
 
 	retval.ImageId = ltData.ImageId
 
 	retval.InstanceInitiatedShutdownBehavior = ltData.InstanceInitiatedShutdownBehavior
 
+	retval.KernelId = ltData.KernelId
+
 	retval.KeyName = ltData.KeyName
 
+	for _, ls := range ltData.LicenseSpecifications {
+		retval.LicenseSpecifications = append(retval.LicenseSpecifications,
+			(*ec2.LaunchTemplateLicenseConfigurationRequest)(ls))
+	}
+
+	retval.MaintenanceOptions = (*ec2.LaunchTemplateInstanceMaintenanceOptionsRequest)(ltData.MaintenanceOptions)
+
+	// dropping these would silently re-enable IMDSv1 on the spot instances
+	if mo := ltData.MetadataOptions; mo != nil {
+		retval.MetadataOptions = &ec2.LaunchTemplateInstanceMetadataOptionsRequest{
+			HttpEndpoint:            mo.HttpEndpoint,
+			HttpProtocolIpv6:        mo.HttpProtocolIpv6,
+			HttpPutResponseHopLimit: mo.HttpPutResponseHopLimit,
+			HttpTokens:              mo.HttpTokens,
+			InstanceMetadataTags:    mo.InstanceMetadataTags,
+		}
+	}
+
 	retval.Monitoring = (*ec2.LaunchTemplatesMonitoringRequest)(ltData.Monitoring)
 
 	if having, nis := i.launchTemplateHasNetworkInterfaces(ltData); having {
-		for _, ni := range nis {
-			retval.NetworkInterfaces = append(retval.NetworkInterfaces,
-				&ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
-					AssociatePublicIpAddress: ni.AssociatePublicIpAddress,
-					SubnetId:                 i.SubnetId,
-					DeviceIndex:              ni.DeviceIndex,
-					Groups:                   i.convertSecurityGroups(),
-				},
-			)
-		}
+		retval.NetworkInterfaces = i.convertLaunchTemplateNetworkInterfaces(nis)
 		retval.SecurityGroupIds = nil
 		retval.SecurityGroups = nil
 	} else {
@@ -326,15 +287,115 @@ func (i *instance) processLaunchTemplate(retval *ec2.RequestLaunchTemplateData)
 		retval.SecurityGroups = append(retval.SecurityGroups, ltData.SecurityGroups...)
 	}
 
-	if i.asg.config.PatchBeanstalkUserdata {
-		retval.UserData = getPatchedUserDataForBeanstalk(ltData.UserData)
-	} else {
-		retval.UserData = ltData.UserData
-	}
+	retval.PrivateDnsNameOptions = (*ec2.LaunchTemplatePrivateDnsNameOptionsRequest)(ltData.PrivateDnsNameOptions)
+
+	retval.RamDiskId = ltData.RamDiskId
+
+	retval.UserData = i.asg.transformUserData(ltData.UserData)
 
 	return nil
 }
 
+// launchTemplateDataAttributes documents how each attribute of the launch
+// template data of the group is carried over to the launch template of the
+// spot instances. The tests fail for the attributes missing from here, such as
+// the ones added by newer SDK versions, until they are handled.
+var launchTemplateDataAttributes = map[string]string{
+	"BlockDeviceMappings":               "converted, with the EBS volume types optionally upgraded",
+	"CapacityReservationSpecification":  "converted",
+	"CpuOptions":                        "copied",
+	"CreditSpecification":               "copied",
+	"DisableApiStop":                    "copied",
+	"DisableApiTermination":             "copied",
+	"EbsOptimized":                      "copied, then overridden by the attribute of the instance",
+	"ElasticGpuSpecifications":          "copied",
+	"ElasticInferenceAccelerators":      "copied",
+	"EnclaveOptions":                    "copied",
+	"HibernationOptions":                "copied",
+	"IamInstanceProfile":                "copied",
+	"ImageId":                           "copied, or replaced by the image configured for other architectures",
+	"InstanceInitiatedShutdownBehavior": "copied",
+	"InstanceMarketOptions":             "omitted, set to spot with the price of the instance as maximum price",
+	"InstanceRequirements":              "omitted, the instance types are set in the fleet overrides",
+	"InstanceType":                      "omitted, the instance types are set in the fleet overrides",
+	"KernelId":                          "copied",
+	"KeyName":                           "copied",
+	"LicenseSpecifications":             "copied",
+	"MaintenanceOptions":                "copied",
+	"MetadataOptions":                   "converted, without the read-only State",
+	"Monitoring":                        "copied",
+	"NetworkInterfaces":                 "converted, using the subnet and security groups of the instance for the primary interface",
+	"Placement":                         "omitted, set from the placement of the instance",
+	"PrivateDnsNameOptions":             "copied",
+	"RamDiskId":                         "copied",
+	"SecurityGroupIds":                  "copied, unless network interfaces are set",
+	"SecurityGroups":                    "copied, unless network interfaces are set",
+	"TagSpecifications":                 "omitted, generated from the tags of the instance and the group",
+	"UserData":                          "copied, transformed by the user data transformers of the group",
+}
+
+// convertLaunchTemplateNetworkInterfaces converts the network interfaces of the
+// launch template, keeping all their attributes except for the ones that
+// can't be shared with the instance being replaced: the specific private and
+// IPv6 addresses and prefixes are converted to the corresponding counts, and
+// the existing network interfaces aren't attached. The primary interface gets
+// the subnet and security groups of the instance, unless the fleet may launch
+// in other subnets, in which case its subnet is set by the fleet overrides.
+func (i *instance) convertLaunchTemplateNetworkInterfaces(nis []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification) []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest {
+	var retval []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest
+
+	for _, ni := range nis {
+		nir := &ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			AssociateCarrierIpAddress:      ni.AssociateCarrierIpAddress,
+			AssociatePublicIpAddress:       ni.AssociatePublicIpAddress,
+			DeleteOnTermination:            ni.DeleteOnTermination,
+			Description:                    ni.Description,
+			DeviceIndex:                    ni.DeviceIndex,
+			Groups:                         ni.Groups,
+			InterfaceType:                  ni.InterfaceType,
+			Ipv4PrefixCount:                ni.Ipv4PrefixCount,
+			Ipv6AddressCount:               ni.Ipv6AddressCount,
+			Ipv6PrefixCount:                ni.Ipv6PrefixCount,
+			NetworkCardIndex:               ni.NetworkCardIndex,
+			SecondaryPrivateIpAddressCount: ni.SecondaryPrivateIpAddressCount,
+			SubnetId:                       ni.SubnetId,
+		}
+
+		if len(ni.Ipv4Prefixes) > 0 {
+			nir.Ipv4PrefixCount = aws.Int64(int64(len(ni.Ipv4Prefixes)))
+		}
+
+		if len(ni.Ipv6Addresses) > 0 {
+			nir.Ipv6AddressCount = aws.Int64(int64(len(ni.Ipv6Addresses)))
+		}
+
+		if len(ni.Ipv6Prefixes) > 0 {
+			nir.Ipv6PrefixCount = aws.Int64(int64(len(ni.Ipv6Prefixes)))
+		}
+
+		var secondary int64
+		for _, ip := range ni.PrivateIpAddresses {
+			if !aws.BoolValue(ip.Primary) {
+				secondary++
+			}
+		}
+		if secondary > 0 {
+			nir.SecondaryPrivateIpAddressCount = aws.Int64(secondary)
+		}
+
+		if aws.Int64Value(ni.DeviceIndex) == 0 && aws.Int64Value(ni.NetworkCardIndex) == 0 {
+			nir.Groups = i.convertSecurityGroups()
+			nir.SubnetId = i.SubnetId
+			if i.spansMultipleAZs() {
+				nir.SubnetId = nil
+			}
+		}
+
+		retval = append(retval, nir)
+	}
+	return retval
+}
+
 func (i *instance) processLaunchConfiguration(retval *ec2.RequestLaunchTemplateData) {
 	lc := i.asg.launchConfiguration
 
@@ -355,11 +416,7 @@ func (i *instance) processLaunchConfiguration(retval *ec2.RequestLaunchTemplateD
 	}
 	retval.ImageId = lc.ImageId
 
-	if i.asg.config.PatchBeanstalkUserdata {
-		retval.UserData = getPatchedUserDataForBeanstalk(lc.UserData)
-	} else {
-		retval.UserData = lc.UserData
-	}
+	retval.UserData = i.asg.transformUserData(lc.UserData)
 
 	BDMs := i.convertLaunchConfigurationBlockDeviceMappings(lc.BlockDeviceMappings)
 
@@ -382,6 +439,10 @@ func (i *instance) processLaunchConfiguration(retval *ec2.RequestLaunchTemplateD
 				Groups:                   i.convertSecurityGroups(),
 			},
 		}
+		// the subnet is set by the fleet overrides
+		if i.spansMultipleAZs() {
+			retval.NetworkInterfaces[0].SubnetId = nil
+		}
 		retval.SecurityGroupIds = nil
 	}
 }
@@ -389,7 +450,8 @@ func (i *instance) processLaunchConfiguration(retval *ec2.RequestLaunchTemplateD
 func (i *instance) processImageBlockDevices(rii *ec2.RequestLaunchTemplateData) {
 	svc := i.region.services.ec2
 
-	resp, err := svc.DescribeImages(
+	resp, err := svc.DescribeImagesWithContext(
+		i.region.runContext(),
 		&ec2.DescribeImagesInput{
 			ImageIds: []*string{i.ImageId},
 		})
@@ -408,7 +470,7 @@ func (i *instance) processImageBlockDevices(rii *ec2.RequestLaunchTemplateData)
 
 func (i *instance) createLaunchTemplateData() (*ec2.RequestLaunchTemplateData, error) {
 
-	placement := ec2.LaunchTemplatePlacementRequest(*i.Placement)
+	placement := i.convertPlacement()
 
 	ltData := ec2.RequestLaunchTemplateData{}
 
@@ -441,7 +503,12 @@ func (i *instance) createLaunchTemplateData() (*ec2.RequestLaunchTemplateData, e
 		},
 	}
 
-	ltData.Placement = &placement
+	// the subnets of the fleet overrides determine the availability zone
+	if i.spansMultipleAZs() {
+		placement.AvailabilityZone = nil
+	}
+
+	ltData.Placement = placement
 
 	ltData.TagSpecifications = i.generateTagsList()
 
@@ -450,54 +517,58 @@ func (i *instance) createLaunchTemplateData() (*ec2.RequestLaunchTemplateData, e
 	return &ltData, nil
 }
 
-func (i *instance) createFleetLaunchTemplate(ltData *ec2.RequestLaunchTemplateData) (*string, error) {
-	ltName := "AutoSpotting-Temporary-LaunchTemplate-for-" + *i.Instance.InstanceId
-
-	_, err := i.region.services.ec2.CreateLaunchTemplate(&ec2.CreateLaunchTemplateInput{
-		LaunchTemplateName: aws.String(ltName),
-		LaunchTemplateData: ltData,
-	})
+// createFleetInput builds the fleet request, with a launch template config
+// for each of the launch template versions, if the instance types are spread
+// over multiple architectures.
+func (i *instance) createFleetInput(ltName *string, instanceTypes []*string, versions map[string]*string) *ec2.CreateFleetInput {
 
-	if err != nil {
-		log.Println("failed to create LaunchTemplate,", err.Error())
-		// if the LT already exists maybe from a previous failed run we take it and use it
-		if !strings.Contains(err.Error(), "AlreadyExistsException") {
-			return nil, err
-		}
-		log.Println("Reusing existing LaunchTemplate ", ltName)
-		err = nil
-	}
+	var configs []*ec2.FleetLaunchTemplateConfigRequest
+	configIndex := make(map[string]*ec2.FleetLaunchTemplateConfigRequest)
 
-	return &ltName, err
-}
+	debug.Printf("instance Details: %+#v\n", i)
 
-func (i *instance) createFleetInput(ltName *string, instanceTypes []*string) *ec2.CreateFleetInput {
+	// the subnets in the availability zone of the instance come first, and
+	// they get the highest priority
+	for s, subnet := range i.fleetSubnets() {
+		for p, inst := range instanceTypes {
+			if !i.isAvailableInAZ(*inst, subnet.availabilityZone) {
+				continue
+			}
 
-	var overrides []*ec2.FleetLaunchTemplateOverridesRequest
+			version := aws.String("$Latest")
+			if versions != nil {
+				info := i.region.instanceTypeInformation[*inst]
+				version = versions[info.cpuArchitecture()]
+			}
 
-	debug.Printf("instance Details: %+#v\n", i)
+			config, ok := configIndex[*version]
+			if !ok {
+				config = &ec2.FleetLaunchTemplateConfigRequest{
+					LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
+						LaunchTemplateName: ltName,
+						Version:            version,
+					},
+				}
+				configIndex[*version] = config
+				configs = append(configs, config)
+			}
 
-	for p, inst := range instanceTypes {
-		override := ec2.FleetLaunchTemplateOverridesRequest{
-			InstanceType: inst,
-			SubnetId:     i.SubnetId,
-		}
-		if i.asg.config.SpotAllocationStrategy == "capacity-optimized-prioritized" {
-			override.Priority = aws.Float64(float64(p))
+			override := ec2.FleetLaunchTemplateOverridesRequest{
+				InstanceType: inst,
+				SubnetId:     i.SubnetId,
+			}
+			if subnet.id != aws.StringValue(i.SubnetId) {
+				override.SubnetId = aws.String(subnet.id)
+			}
+			if i.asg.config.SpotAllocationStrategy == "capacity-optimized-prioritized" {
+				override.Priority = aws.Float64(float64(s*len(instanceTypes) + p))
+			}
+			config.Overrides = append(config.Overrides, &override)
 		}
-		overrides = append(overrides, &override)
 	}
 
 	retval := &ec2.CreateFleetInput{
-		LaunchTemplateConfigs: []*ec2.FleetLaunchTemplateConfigRequest{
-			{
-				LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
-					LaunchTemplateName: ltName,
-					Version:            aws.String("$Latest"),
-				},
-				Overrides: overrides,
-			},
-		},
+		LaunchTemplateConfigs: configs,
 		SpotOptions: &ec2.SpotOptionsRequest{
 			AllocationStrategy: aws.String(i.asg.config.SpotAllocationStrategy),
 		},
@@ -546,9 +617,22 @@ func (i *instance) generateTagsList() []*ec2.LaunchTemplateTagSpecificationReque
 		})
 	}
 
-	tags.Tags = append(tags.Tags, filterTags(i.Tags)...)
+	propagated := i.asg.propagatedTags(i.Tags)
+	tags.Tags = mergeTags(tags.Tags, propagated)
 
-	return []*ec2.LaunchTemplateTagSpecificationRequest{&tags}
+	specs := []*ec2.LaunchTemplateTagSpecificationRequest{&tags}
+
+	if len(propagated) == 0 {
+		return specs
+	}
+
+	for _, resourceType := range i.asg.tagPropagationResources() {
+		specs = append(specs, &ec2.LaunchTemplateTagSpecificationRequest{
+			ResourceType: aws.String(resourceType),
+			Tags:         mergeTags(nil, propagated),
+		})
+	}
+	return specs
 }
 
 func filterTags(tags []*ec2.Tag) []*ec2.Tag {
@@ -561,6 +645,10 @@ func filterTags(tags []*ec2.Tag) []*ec2.Tag {
 		"LaunchTemplateID",
 		"LaunchTemplateVersion",
 		"LaunchConfigurationName",
+		replacementStateTag,
+		replacementOriginalMaxSizeTag,
+		replacementUpdatedTag,
+		terminateAfterTag,
 	}
 
 	for _, tag := range tags {
@@ -5,10 +5,10 @@ package autospotting
 
 import (
 	"encoding/base64"
-	"errors"
 	"io/ioutil"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -214,6 +214,7 @@ func Test_instance_convertLaunchConfigurationBlockDeviceMappings(t *testing.T) {
 					Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
 						DeleteOnTermination: aws.Bool(false),
 						VolumeSize:          aws.Int64(10),
+						Throughput:          aws.Int64(128),
 						VolumeType:          aws.String("gp3"),
 					},
 					VirtualName: aws.String("bar"),
@@ -400,6 +401,130 @@ func Test_instance_convertSecurityGroups(t *testing.T) {
 	}
 }
 
+func Test_instance_convertLaunchTemplateNetworkInterfaces(t *testing.T) {
+
+	inst := instance{
+		Instance: &ec2.Instance{
+			SubnetId: aws.String("subnet-123"),
+			SecurityGroups: []*ec2.GroupIdentifier{
+				{GroupId: aws.String("sg-123")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		nis  []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification
+		want []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest
+	}{
+		{
+			name: "no network interfaces",
+			nis:  nil,
+			want: nil,
+		},
+		{
+			name: "primary interface gets the subnet and SGs of the instance",
+			nis: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{
+				{
+					AssociatePublicIpAddress: aws.Bool(true),
+					DeleteOnTermination:      aws.Bool(false),
+					DeviceIndex:              aws.Int64(0),
+					Groups:                   []*string{aws.String("sg-lt")},
+					Ipv6AddressCount:         aws.Int64(1),
+					SubnetId:                 aws.String("subnet-lt"),
+				},
+			},
+			want: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+				{
+					AssociatePublicIpAddress: aws.Bool(true),
+					DeleteOnTermination:      aws.Bool(false),
+					DeviceIndex:              aws.Int64(0),
+					Groups:                   []*string{aws.String("sg-123")},
+					Ipv6AddressCount:         aws.Int64(1),
+					SubnetId:                 aws.String("subnet-123"),
+				},
+			},
+		},
+		{
+			name: "multiple EFA interfaces on several network cards",
+			nis: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{
+				{
+					DeviceIndex:      aws.Int64(0),
+					InterfaceType:    aws.String("efa"),
+					NetworkCardIndex: aws.Int64(0),
+				},
+				{
+					DeviceIndex:      aws.Int64(1),
+					Groups:           []*string{aws.String("sg-lt")},
+					InterfaceType:    aws.String("efa"),
+					NetworkCardIndex: aws.Int64(1),
+					SubnetId:         aws.String("subnet-lt"),
+				},
+			},
+			want: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+				{
+					DeviceIndex:      aws.Int64(0),
+					Groups:           []*string{aws.String("sg-123")},
+					InterfaceType:    aws.String("efa"),
+					NetworkCardIndex: aws.Int64(0),
+					SubnetId:         aws.String("subnet-123"),
+				},
+				{
+					DeviceIndex:      aws.Int64(1),
+					Groups:           []*string{aws.String("sg-lt")},
+					InterfaceType:    aws.String("efa"),
+					NetworkCardIndex: aws.Int64(1),
+					SubnetId:         aws.String("subnet-lt"),
+				},
+			},
+		},
+		{
+			name: "specific addresses and prefixes are converted to counts",
+			nis: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{
+				{
+					DeviceIndex: aws.Int64(0),
+					Ipv4Prefixes: []*ec2.Ipv4PrefixSpecificationResponse{
+						{Ipv4Prefix: aws.String("10.0.0.16/28")},
+						{Ipv4Prefix: aws.String("10.0.0.32/28")},
+					},
+					Ipv6Addresses: []*ec2.InstanceIpv6Address{
+						{Ipv6Address: aws.String("2001:db8::1")},
+					},
+					Ipv6Prefixes: []*ec2.Ipv6PrefixSpecificationResponse{
+						{Ipv6Prefix: aws.String("2001:db8::/80")},
+					},
+					NetworkInterfaceId: aws.String("eni-123"),
+					PrivateIpAddress:   aws.String("10.0.0.1"),
+					PrivateIpAddresses: []*ec2.PrivateIpAddressSpecification{
+						{PrivateIpAddress: aws.String("10.0.0.1"), Primary: aws.Bool(true)},
+						{PrivateIpAddress: aws.String("10.0.0.2"), Primary: aws.Bool(false)},
+						{PrivateIpAddress: aws.String("10.0.0.3")},
+					},
+				},
+			},
+			want: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+				{
+					DeviceIndex:                    aws.Int64(0),
+					Groups:                         []*string{aws.String("sg-123")},
+					Ipv4PrefixCount:                aws.Int64(2),
+					Ipv6AddressCount:               aws.Int64(1),
+					Ipv6PrefixCount:                aws.Int64(1),
+					SecondaryPrivateIpAddressCount: aws.Int64(2),
+					SubnetId:                       aws.String("subnet-123"),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inst.convertLaunchTemplateNetworkInterfaces(tt.nis); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("instance.convertLaunchTemplateNetworkInterfaces() = %v, want %v",
+					spew.Sdump(got), spew.Sdump(tt.want))
+			}
+		})
+	}
+}
+
 func Test_instance_createLaunchTemplateData(t *testing.T) {
 	beanstalkUserDataExample, err := ioutil.ReadFile("../test_data/beanstalk_userdata_example.txt")
 	if err != nil {
@@ -459,7 +584,7 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 					InstanceType: aws.String("t2.medium"),
 
 					Placement: &ec2.Placement{
-						Affinity: aws.String("foo"),
+						Tenancy: aws.String("dedicated"),
 					},
 
 					SecurityGroups: []*ec2.GroupIdentifier{
@@ -486,7 +611,7 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 				},
 
 				Placement: &ec2.LaunchTemplatePlacementRequest{
-					Affinity: aws.String("foo"),
+					Tenancy: aws.String("dedicated"),
 				},
 
 				SecurityGroupIds: []*string{
@@ -574,7 +699,7 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 					KeyName:      aws.String("mykey"),
 
 					Placement: &ec2.Placement{
-						Affinity: aws.String("foo"),
+						Tenancy: aws.String("dedicated"),
 					},
 
 					SecurityGroups: []*ec2.GroupIdentifier{
@@ -603,13 +728,14 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 				KeyName: aws.String("mykey"),
 				NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
 					{
-						Groups:   []*string{aws.String("sg-123"), aws.String("sg-456")},
-						SubnetId: aws.String("subnet-123"),
+						Description: aws.String("dummy network interface definition"),
+						Groups:      []*string{aws.String("sg-123"), aws.String("sg-456")},
+						SubnetId:    aws.String("subnet-123"),
 					},
 				},
 
 				Placement: &ec2.LaunchTemplatePlacementRequest{
-					Affinity: aws.String("foo"),
+					Tenancy: aws.String("dedicated"),
 				},
 
 				TagSpecifications: []*ec2.LaunchTemplateTagSpecificationRequest{{
@@ -685,7 +811,7 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 					InstanceType: aws.String("t2.medium"),
 
 					Placement: &ec2.Placement{
-						Affinity: aws.String("foo"),
+						Tenancy: aws.String("dedicated"),
 					},
 
 					SecurityGroups: []*ec2.GroupIdentifier{
@@ -722,7 +848,7 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 				KeyName: aws.String("mykey"),
 
 				Placement: &ec2.LaunchTemplatePlacementRequest{
-					Affinity: aws.String("foo"),
+					Tenancy: aws.String("dedicated"),
 				},
 
 				SecurityGroupIds: []*string{
@@ -806,7 +932,7 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 					KeyName:      aws.String("older-key"),
 
 					Placement: &ec2.Placement{
-						Affinity: aws.String("foo"),
+						Tenancy: aws.String("dedicated"),
 					},
 
 					SecurityGroups: []*ec2.GroupIdentifier{
@@ -853,7 +979,7 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 				},
 
 				Placement: &ec2.LaunchTemplatePlacementRequest{
-					Affinity: aws.String("foo"),
+					Tenancy: aws.String("dedicated"),
 				},
 
 				NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
@@ -893,6 +1019,112 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 				UserData: aws.String("userdata"),
 			},
 		},
+		{
+			name: "createLaunchTemplateData() with LC spanning multiple availability zones",
+			inst: instance{
+				price: 1.5,
+				region: &region{
+					services: connections{
+						ec2: mockEC2{
+							damio: &ec2.DescribeImagesOutput{
+								Images: []*ec2.Image{},
+							},
+						},
+					},
+				},
+				asg: &autoScalingGroup{
+					name: "mygroup",
+					config: AutoScalingConfig{
+						OnDemandPriceMultiplier: 1,
+					},
+					Group: &autoscaling.Group{
+						LaunchConfigurationName: aws.String("myLC"),
+					},
+					launchConfiguration: &launchConfiguration{
+						LaunchConfiguration: &autoscaling.LaunchConfiguration{
+							ImageId:                  aws.String("ami-12345"),
+							AssociatePublicIpAddress: aws.Bool(true),
+						},
+					},
+					subnets: map[string]string{
+						"subnet-a": "us-east-1a",
+						"subnet-b": "us-east-1b",
+					},
+					instances: makeInstancesWithCatalog(instanceMap{
+						"i-a1": runningInstanceIn("i-a1", "us-east-1a"),
+						"i-a2": runningInstanceIn("i-a2", "us-east-1a"),
+						"i-b":  runningInstanceIn("i-b", "us-east-1b"),
+					}),
+				},
+				Instance: &ec2.Instance{
+					EbsOptimized: aws.Bool(false),
+					InstanceId:   aws.String("i-foo"),
+					InstanceType: aws.String("t2.medium"),
+
+					Placement: &ec2.Placement{
+						AvailabilityZone: aws.String("us-east-1a"),
+					},
+
+					SecurityGroups: []*ec2.GroupIdentifier{
+						{
+							GroupName: aws.String("foo"),
+							GroupId:   aws.String("sg-123"),
+						},
+					},
+
+					SubnetId: aws.String("subnet-a"),
+				},
+			},
+
+			want: &ec2.RequestLaunchTemplateData{
+				EbsOptimized: aws.Bool(false),
+
+				ImageId: aws.String("ami-12345"),
+
+				InstanceMarketOptions: &ec2.LaunchTemplateInstanceMarketOptionsRequest{
+					MarketType: aws.String(Spot),
+					SpotOptions: &ec2.LaunchTemplateSpotMarketOptionsRequest{
+						MaxPrice: aws.String("1.5"),
+					},
+				},
+
+				Placement: &ec2.LaunchTemplatePlacementRequest{},
+
+				// the subnets are set by the fleet overrides
+				NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+					{
+						AssociatePublicIpAddress: aws.Bool(true),
+						DeviceIndex:              aws.Int64(0),
+						Groups: []*string{
+							aws.String("sg-123"),
+						},
+					},
+				},
+
+				TagSpecifications: []*ec2.LaunchTemplateTagSpecificationRequest{{
+					ResourceType: aws.String("instance"),
+					Tags: []*ec2.Tag{
+						{
+							Key:   aws.String("LaunchConfigurationName"),
+							Value: aws.String("myLC"),
+						},
+						{
+							Key:   aws.String("launched-by-autospotting"),
+							Value: aws.String("true"),
+						},
+						{
+							Key:   aws.String("launched-for-asg"),
+							Value: aws.String("mygroup"),
+						},
+						{
+							Key:   aws.String("launched-for-replacing-instance"),
+							Value: aws.String("i-foo"),
+						},
+					},
+				},
+				},
+			},
+		},
 		{
 			name: "createLaunchTemplateData() with customized UserData for Beanstalk",
 			inst: instance{
@@ -944,7 +1176,7 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 					KeyName:      aws.String("older-key"),
 
 					Placement: &ec2.Placement{
-						Affinity: aws.String("foo"),
+						Tenancy: aws.String("dedicated"),
 					},
 
 					SecurityGroups: []*ec2.GroupIdentifier{
@@ -990,7 +1222,7 @@ func Test_instance_createLaunchTemplateData(t *testing.T) {
 				},
 
 				Placement: &ec2.LaunchTemplatePlacementRequest{
-					Affinity: aws.String("foo"),
+					Tenancy: aws.String("dedicated"),
 				},
 
 				NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
@@ -1128,6 +1360,7 @@ func Test_instance_convertLaunchTemplateBlockDeviceMappings(t *testing.T) {
 					Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
 						DeleteOnTermination: aws.Bool(false),
 						VolumeSize:          aws.Int64(10),
+						Throughput:          aws.Int64(128),
 						VolumeType:          aws.String("gp3"),
 					},
 					VirtualName: aws.String("bar"),
@@ -1336,6 +1569,7 @@ func Test_instance_convertImageBlockDeviceMappings(t *testing.T) {
 					Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
 						DeleteOnTermination: aws.Bool(false),
 						VolumeSize:          aws.Int64(10),
+						Throughput:          aws.Int64(128),
 						VolumeType:          aws.String("gp3"),
 					},
 					VirtualName: aws.String("bar"),
@@ -1464,69 +1698,90 @@ func Test_instance_convertImageBlockDeviceMappings(t *testing.T) {
 	}
 }
 
-func Test_convertLaunchConfigurationEBSVolumeType(t *testing.T) {
+func Test_instance_createFleetInput(t *testing.T) {
+
 	tests := []struct {
-		name string
-		ebs  *autoscaling.Ebs
-		a    *autoScalingGroup
-		want *string
+		name          string
+		i             *instance
+		ltName        *string
+		instanceTypes []*string
+		versions      map[string]*string
+		want          *ec2.CreateFleetInput
 	}{
 		{
-			name: "nil volume type",
-			a: &autoScalingGroup{
-				region: &region{
-					name: "us-east-1",
-				},
+			name:   "test generating launch template configs for multiple architectures",
+			ltName: aws.String("testLT"),
+			instanceTypes: []*string{
+				aws.String("m6g.large"),
+				aws.String("m5.large"),
+				aws.String("c6g.large"),
 			},
-			ebs: &autoscaling.Ebs{
-				VolumeType: nil,
+			versions: map[string]*string{
+				"x86_64": aws.String("1"),
+				"arm64":  aws.String("2"),
 			},
-			want: nil,
-		},
-
-		{
-			name: "IO1 in region supported by IO2",
-			a: &autoScalingGroup{
-				region: &region{
-					name: "us-east-1",
+			i: &instance{
+				Instance: &ec2.Instance{
+					SubnetId: aws.String("subnet-id"),
+				},
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{
+						SpotAllocationStrategy: "capacity-optimized-prioritized",
+					},
 				},
-			},
-			ebs: &autoscaling.Ebs{
-				VolumeType: aws.String("io1"),
-			},
-			want: aws.String("io2"),
-		},
-		{
-			name: "IO1 in region not supported by IO2",
-			a: &autoScalingGroup{
 				region: &region{
-					name: "cn-northwest-1",
+					instanceTypeInformation: map[string]instanceTypeInformation{
+						"m6g.large": {PhysicalProcessor: "AWS Graviton2 Processor"},
+						"m5.large":  {PhysicalProcessor: "Intel Xeon Platinum 8175"},
+						"c6g.large": {PhysicalProcessor: "AWS Graviton2 Processor"},
+					},
 				},
 			},
-			ebs: &autoscaling.Ebs{
-				VolumeType: aws.String("io1"),
+			want: &ec2.CreateFleetInput{
+				LaunchTemplateConfigs: []*ec2.FleetLaunchTemplateConfigRequest{
+					{
+						LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
+							LaunchTemplateName: aws.String("testLT"),
+							Version:            aws.String("2"),
+						},
+						Overrides: []*ec2.FleetLaunchTemplateOverridesRequest{
+							{
+								InstanceType: aws.String("m6g.large"),
+								Priority:     aws.Float64(0),
+								SubnetId:     aws.String("subnet-id"),
+							},
+							{
+								InstanceType: aws.String("c6g.large"),
+								Priority:     aws.Float64(2),
+								SubnetId:     aws.String("subnet-id"),
+							},
+						},
+					},
+					{
+						LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
+							LaunchTemplateName: aws.String("testLT"),
+							Version:            aws.String("1"),
+						},
+						Overrides: []*ec2.FleetLaunchTemplateOverridesRequest{
+							{
+								InstanceType: aws.String("m5.large"),
+								Priority:     aws.Float64(1),
+								SubnetId:     aws.String("subnet-id"),
+							},
+						},
+					},
+				},
+				SpotOptions: &ec2.SpotOptionsRequest{
+					AllocationStrategy: aws.String("capacity-optimized-prioritized"),
+				},
+				TargetCapacitySpecification: &ec2.TargetCapacitySpecificationRequest{
+					DefaultTargetCapacityType: aws.String("spot"),
+					SpotTargetCapacity:        aws.Int64(1),
+					TotalTargetCapacity:       aws.Int64(1),
+				},
+				Type: aws.String("instant"),
 			},
-			want: aws.String("io1"),
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := convertLaunchConfigurationEBSVolumeType(tt.ebs, tt.a); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("convertLaunchConfigurationEBSVolumeType() = %v, want %v", spew.Sdump(got), spew.Sdump(tt.want))
-			}
-		})
-	}
-}
-
-func Test_instance_createFleetInput(t *testing.T) {
-
-	tests := []struct {
-		name          string
-		i             *instance
-		ltName        *string
-		instanceTypes []*string
-		want          *ec2.CreateFleetInput
-	}{
 		{
 			name:   "test generating list of overrides with capacity-optimized-prioritized",
 			ltName: aws.String("testLT"),
@@ -1640,7 +1895,7 @@ func Test_instance_createFleetInput(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got := tt.i.createFleetInput(tt.ltName, tt.instanceTypes)
+			got := tt.i.createFleetInput(tt.ltName, tt.instanceTypes, tt.versions)
 
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("instance.createFleetInput() = %v, want %v", got, tt.want)
@@ -1649,62 +1904,110 @@ func Test_instance_createFleetInput(t *testing.T) {
 	}
 }
 
-func Test_instance_createFleetLaunchTemplate(t *testing.T) {
-	tests := []struct {
-		name       string
-		ltData     *ec2.RequestLaunchTemplateData
-		instanceID string
-		cltErr     error
-		want       *string
-		wantErr    bool
-	}{
-		{
-			name:       "dummy instance, no errors",
-			instanceID: "i-dummy",
-			want:       aws.String("AutoSpotting-Temporary-LaunchTemplate-for-i-dummy"),
+func Test_launchTemplateDataAttributes(t *testing.T) {
+	for _, data := range []interface{}{ec2.ResponseLaunchTemplateData{}, ec2.RequestLaunchTemplateData{}} {
+		typ := reflect.TypeOf(data)
+		for f := 0; f < typ.NumField(); f++ {
+			name := typ.Field(f).Name
+			if name == "_" {
+				continue
+			}
+			if _, ok := launchTemplateDataAttributes[name]; !ok {
+				t.Errorf("%s.%s isn't handled, add it to launchTemplateDataAttributes and processLaunchTemplate",
+					typ.Name(), name)
+			}
+		}
+	}
+
+	for name := range launchTemplateDataAttributes {
+		if _, ok := reflect.TypeOf(ec2.ResponseLaunchTemplateData{}).FieldByName(name); !ok {
+			t.Errorf("launchTemplateDataAttributes documents the unknown attribute %s", name)
+		}
+	}
+}
+
+// fillValue sets all the fields of a value to non-zero values.
+func fillValue(v reflect.Value, depth int) {
+	if depth > 6 {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillValue(v.Elem(), depth+1)
+	case reflect.Struct:
+		for f := 0; f < v.NumField(); f++ {
+			if v.Type().Field(f).PkgPath == "" {
+				fillValue(v.Field(f), depth+1)
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillValue(v.Index(0), depth+1)
+	case reflect.String:
+		v.SetString("value")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int64:
+		v.SetInt(1)
+	case reflect.Float64:
+		v.SetFloat(1)
+	}
+}
+
+func Test_instance_processLaunchTemplateCopiesAllAttributes(t *testing.T) {
+	ltData := &ec2.ResponseLaunchTemplateData{}
+	fillValue(reflect.ValueOf(ltData).Elem(), 0)
+	ltData.NetworkInterfaces = nil
+
+	r := &region{
+		name: "us-east-1",
+		services: connections{
+			ec2: mockEC2{
+				dltvo: &ec2.DescribeLaunchTemplateVersionsOutput{
+					LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{
+						{LaunchTemplateData: ltData},
+					},
+				},
+			},
 		},
-		{
-			name:       "dummy instance, AlreadyExistsException error",
-			instanceID: "i-dummy",
-			cltErr:     errors.New("AlreadyExistsException"),
-			want:       aws.String("AutoSpotting-Temporary-LaunchTemplate-for-i-dummy"),
+	}
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			SubnetId: aws.String("subnet-id"),
 		},
-		{
-			name:       "dummy instance, another error",
-			instanceID: "i-dummy",
-			cltErr:     errors.New("randomError"),
-			want:       nil,
-			wantErr:    true,
+		region: r,
+		asg: &autoScalingGroup{
+			name:   "test-asg",
+			region: r,
+			Group: &autoscaling.Group{
+				LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+					LaunchTemplateId: aws.String("lt-id"),
+					Version:          aws.String("1"),
+				},
+			},
 		},
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			i := &instance{
-				Instance: &ec2.Instance{
-					InstanceId: aws.String(tt.instanceID),
-				},
-				region: &region{
-					services: connections{
-						ec2: mockEC2{
-							clterr: tt.cltErr,
-						},
-					},
-				},
-			}
 
-			got, err := i.createFleetLaunchTemplate(tt.ltData)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("instance.createFleetLaunchTemplate() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
+	got := &ec2.RequestLaunchTemplateData{}
+	if err := i.processLaunchTemplate(got); err != nil {
+		t.Fatalf("processLaunchTemplate() error = %v", err)
+	}
 
-			if got == nil && tt.want != nil {
-				t.Errorf("instance.createFleetLaunchTemplate() = nil, want %v", tt.want)
-			}
+	v := reflect.ValueOf(got).Elem()
+	for name, handling := range launchTemplateDataAttributes {
+		if !strings.HasPrefix(handling, "copied") && !strings.HasPrefix(handling, "converted") ||
+			name == "NetworkInterfaces" {
+			continue
+		}
+		if v.FieldByName(name).IsZero() {
+			t.Errorf("processLaunchTemplate() didn't carry over %s", name)
+		}
+	}
 
-			if got != nil && tt.want != nil && *got != *tt.want {
-				t.Errorf("instance.createFleetLaunchTemplate() = %v, want %v", *got, tt.want)
-			}
-		})
+	if mo := got.MetadataOptions; mo == nil || aws.StringValue(mo.HttpTokens) != "value" {
+		t.Errorf("processLaunchTemplate() MetadataOptions = %v, want the HttpTokens of the group", mo)
 	}
 }
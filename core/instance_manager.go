@@ -32,6 +32,7 @@ type acceptableInstance struct {
 	instanceTI      instanceTypeInformation
 	price           float64
 	generationDelta int64
+	score           compatibilityScore
 }
 
 type instanceTypeInformation struct {
@@ -48,7 +49,31 @@ type instanceTypeInformation struct {
 	instanceStoreIsSSD       bool
 	hasEBSOptimization       bool
 	EBSThroughput            float32
-	generationDelta          int64
+	networkPerformance       float64
+	burstable                bool
+	bareMetal                bool
+	previousGeneration       bool
+
+	// the networking data is loaded from the EC2 API, and it's missing if
+	// that failed
+	maxNetworkInterfaces      int
+	ipv4AddressesPerInterface int
+	enaSupport                string
+	efaSupported              bool
+	accelerator               acceleratorInfo
+	hypervisor                string
+	nvmeSupport               string
+	bootModes                 []string
+
+	// the placement group strategies supported by the instance type, nil if
+	// they couldn't be loaded
+	placementGroupStrategies []string
+
+	// the availability zones in which the instance type is offered, nil if
+	// they couldn't be loaded
+	availabilityZones []string
+
+	generationDelta int64
 }
 
 func makeInstances() instances {
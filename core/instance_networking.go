@@ -0,0 +1,98 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_networking.go contains the network compatibility checks, preventing
+// the replacement of instances with spot instances that have lower bandwidth
+// or fewer network interfaces and IP addresses.
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func (info *instanceTypeInformation) setNetworkInfo(ni *ec2.NetworkInfo) {
+	if ni.NetworkPerformance != nil {
+		info.networkPerformance = parseNetworkPerformance(*ni.NetworkPerformance)
+	}
+	info.maxNetworkInterfaces = int(aws.Int64Value(ni.MaximumNetworkInterfaces))
+	info.ipv4AddressesPerInterface = int(aws.Int64Value(ni.Ipv4AddressesPerInterface))
+	info.enaSupport = aws.StringValue(ni.EnaSupport)
+	info.efaSupported = aws.BoolValue(ni.EfaSupported)
+}
+
+// ipv4Capacity is the total number of IPv4 addresses that can be assigned to
+// an instance, which for example limits the number of pods per node on EKS
+// clusters using the VPC CNI.
+func (info *instanceTypeInformation) ipv4Capacity() int {
+	return info.maxNetworkInterfaces * info.ipv4AddressesPerInterface
+}
+
+// isENACompatible checks if the candidate can boot the image of the instance,
+// the instance types requiring ENA can't run images without ENA support.
+func (i *instance) isENACompatible(spotCandidate *instanceTypeInformation) bool {
+	if spotCandidate.cpuArchitecture() != i.typeInfo.cpuArchitecture() {
+		return true
+	}
+
+	if ena := i.imageEnaSupport(); spotCandidate.enaSupport == ec2.EnaSupportRequired &&
+		ena != nil && !*ena {
+		debug.Println("\tNot ENA compatible, the instance image doesn't support ENA")
+		return false
+	}
+	return true
+}
+
+// isNetworkCompatible prevents networking downgrades, unless relaxed for the
+// group. The dimensions unknown for either of the instance types are ignored.
+func (i *instance) isNetworkCompatible(spotCandidate *instanceTypeInformation) bool {
+	current := i.typeInfo
+
+	debug.Println("Comparing networking spot/instance:")
+	debug.Println("\tSpot bandwidth/ENIs/IPv4 per ENI/ENA/EFA: ",
+		spotCandidate.networkPerformance, spotCandidate.maxNetworkInterfaces,
+		spotCandidate.ipv4AddressesPerInterface, spotCandidate.enaSupport,
+		spotCandidate.efaSupported)
+	debug.Println("\tInstance bandwidth/ENIs/IPv4 per ENI/ENA/EFA: ",
+		current.networkPerformance, current.maxNetworkInterfaces,
+		current.ipv4AddressesPerInterface, current.enaSupport,
+		current.efaSupported)
+
+	if !i.isENACompatible(spotCandidate) {
+		return false
+	}
+
+	if i.asg != nil && i.asg.config.RelaxNetworkCompatibility {
+		debug.Println("\tNetwork compatibility checks relaxed for the group")
+		return true
+	}
+
+	if current.networkPerformance > 0 && spotCandidate.networkPerformance > 0 &&
+		spotCandidate.networkPerformance < current.networkPerformance {
+		debug.Println("\tNetwork performance insufficient:",
+			spotCandidate.networkPerformance, "<", current.networkPerformance)
+		return false
+	}
+
+	if current.maxNetworkInterfaces > 0 && spotCandidate.maxNetworkInterfaces > 0 &&
+		(spotCandidate.maxNetworkInterfaces < current.maxNetworkInterfaces ||
+			spotCandidate.ipv4Capacity() < current.ipv4Capacity()) {
+		debug.Println("\tNot enough network interfaces or IPv4 addresses:",
+			spotCandidate.maxNetworkInterfaces, "x", spotCandidate.ipv4AddressesPerInterface, "<",
+			current.maxNetworkInterfaces, "x", current.ipv4AddressesPerInterface)
+		return false
+	}
+
+	if current.enaSupport != "" && current.enaSupport != ec2.EnaSupportUnsupported &&
+		spotCandidate.enaSupport == ec2.EnaSupportUnsupported {
+		debug.Println("\tENA support missing")
+		return false
+	}
+
+	if current.efaSupported && !spotCandidate.efaSupported {
+		debug.Println("\tEFA support missing")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestIsNetworkCompatible(t *testing.T) {
+	current := instanceTypeInformation{
+		networkPerformance:        25,
+		maxNetworkInterfaces:      8,
+		ipv4AddressesPerInterface: 30,
+		enaSupport:                ec2.EnaSupportRequired,
+		efaSupported:              false,
+	}
+
+	tests := []struct {
+		name       string
+		candidate  instanceTypeInformation
+		current    instanceTypeInformation
+		enaSupport *bool
+		relaxed    bool
+		want       bool
+	}{
+		{
+			name:      "same networking",
+			candidate: current,
+			current:   current,
+			want:      true,
+		},
+		{
+			name: "lower bandwidth",
+			candidate: instanceTypeInformation{
+				networkPerformance:        5,
+				maxNetworkInterfaces:      8,
+				ipv4AddressesPerInterface: 30,
+				enaSupport:                ec2.EnaSupportRequired,
+			},
+			current: current,
+			want:    false,
+		},
+		{
+			name: "lower bandwidth relaxed",
+			candidate: instanceTypeInformation{
+				networkPerformance:        5,
+				maxNetworkInterfaces:      8,
+				ipv4AddressesPerInterface: 30,
+				enaSupport:                ec2.EnaSupportRequired,
+			},
+			current: current,
+			relaxed: true,
+			want:    true,
+		},
+		{
+			name: "fewer IPv4 addresses",
+			candidate: instanceTypeInformation{
+				networkPerformance:        25,
+				maxNetworkInterfaces:      8,
+				ipv4AddressesPerInterface: 15,
+				enaSupport:                ec2.EnaSupportRequired,
+			},
+			current: current,
+			want:    false,
+		},
+		{
+			name: "fewer network interfaces",
+			candidate: instanceTypeInformation{
+				networkPerformance:        25,
+				maxNetworkInterfaces:      4,
+				ipv4AddressesPerInterface: 60,
+				enaSupport:                ec2.EnaSupportRequired,
+			},
+			current: current,
+			want:    false,
+		},
+		{
+			name: "ENA support missing",
+			candidate: instanceTypeInformation{
+				networkPerformance:        25,
+				maxNetworkInterfaces:      8,
+				ipv4AddressesPerInterface: 30,
+				enaSupport:                ec2.EnaSupportUnsupported,
+			},
+			current: current,
+			want:    false,
+		},
+		{
+			name: "EFA support missing",
+			candidate: instanceTypeInformation{
+				networkPerformance:        25,
+				maxNetworkInterfaces:      8,
+				ipv4AddressesPerInterface: 30,
+				enaSupport:                ec2.EnaSupportRequired,
+			},
+			current: instanceTypeInformation{
+				networkPerformance:        25,
+				maxNetworkInterfaces:      8,
+				ipv4AddressesPerInterface: 30,
+				enaSupport:                ec2.EnaSupportRequired,
+				efaSupported:              true,
+			},
+			want: false,
+		},
+		{
+			name:       "image without ENA support, even if relaxed",
+			candidate:  current,
+			current:    instanceTypeInformation{},
+			enaSupport: aws.Bool(false),
+			relaxed:    true,
+			want:       false,
+		},
+		{
+			name:      "unknown networking",
+			candidate: instanceTypeInformation{},
+			current:   current,
+			want:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{EnaSupport: tt.enaSupport},
+				typeInfo: tt.current,
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{RelaxNetworkCompatibility: tt.relaxed},
+				},
+			}
+			if got := i.isNetworkCompatible(&tt.candidate); got != tt.want {
+				t.Errorf("isNetworkCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_placement.go contains the handling of the placement of the
+// instances, such as their placement groups, tenancy and host affinity, which
+// need to be preserved by their spot replacements or prevent their
+// replacement if they can't be run on spot.
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func (info *instanceTypeInformation) setPlacementGroupInfo(it *ec2.InstanceTypeInfo) {
+	if it.PlacementGroupInfo != nil {
+		info.placementGroupStrategies = aws.StringValueSlice(it.PlacementGroupInfo.SupportedStrategies)
+	}
+}
+
+// placementGroupName returns the name of the placement group of the instance,
+// or an empty string if it's not running in a placement group.
+func (i *instance) placementGroupName() string {
+	if i.Instance == nil || i.Placement == nil {
+		return ""
+	}
+	return aws.StringValue(i.Placement.GroupName)
+}
+
+// placementGroupStrategy returns the strategy of the placement group of the
+// instance, such as cluster, partition or spread, or an empty string if it's
+// not running in a placement group or the strategy couldn't be determined.
+func (i *instance) placementGroupStrategy() string {
+	name := i.placementGroupName()
+	if name == "" {
+		return ""
+	}
+
+	if i.placementStrategy != nil {
+		return *i.placementStrategy
+	}
+
+	resp, err := i.region.services.ec2.DescribePlacementGroupsWithContext(
+		i.region.runContext(),
+		&ec2.DescribePlacementGroupsInput{GroupNames: []*string{aws.String(name)}})
+	if err != nil {
+		log.Println(i.region.name, "Couldn't describe the placement group", name,
+			"of instance", *i.InstanceId, err.Error())
+		// not retried for every candidate instance type
+		i.placementStrategy = aws.String("")
+		return ""
+	}
+
+	strategy := ""
+	if len(resp.PlacementGroups) > 0 {
+		strategy = aws.StringValue(resp.PlacementGroups[0].Strategy)
+	}
+	debug.Println(*i.InstanceId, "is running in the", strategy, "placement group", name)
+
+	i.placementStrategy = aws.String(strategy)
+	return strategy
+}
+
+// isInClusterPlacementGroup tells if the instance is running in a cluster
+// placement group, which can't span multiple availability zones.
+func (i *instance) isInClusterPlacementGroup() bool {
+	return i.placementGroupStrategy() == ec2.PlacementStrategyCluster
+}
+
+// spotUnsupportedPlacementReason explains why the instance can't be replaced
+// with a spot instance because of its placement, such as when it's running on
+// a dedicated host, or returns an empty string if it can be replaced.
+func (i *instance) spotUnsupportedPlacementReason() string {
+	if i.Instance == nil || i.Placement == nil {
+		return ""
+	}
+	p := i.Placement
+
+	switch {
+	case aws.StringValue(p.Tenancy) == ec2.TenancyHost:
+		return "it's running on a dedicated host, and spot instances can't be launched on dedicated hosts"
+	case p.HostResourceGroupArn != nil:
+		return fmt.Sprintf("it's launched in the host resource group %s, and spot instances can't be launched on dedicated hosts",
+			*p.HostResourceGroupArn)
+	case p.HostId != nil || aws.StringValue(p.Affinity) == ec2.AffinityHost:
+		return "it has affinity to a dedicated host, and spot instances can't be launched on dedicated hosts"
+	}
+	return ""
+}
+
+// hasSpotUnsupportedPlacement tells if the instance can't be replaced with a
+// spot instance because of its placement, logging the reason.
+func (i *instance) hasSpotUnsupportedPlacement() bool {
+	reason := i.spotUnsupportedPlacementReason()
+	if reason == "" {
+		return false
+	}
+	log.Printf("%s Not replacing instance %s with spot: %s",
+		i.region.name, *i.InstanceId, reason)
+	return true
+}
+
+// isPlacementCompatible checks if the candidate can be launched in the
+// placement group of the instance, skipped when the strategy of the placement
+// group or the strategies supported by the candidate are unknown.
+func (i *instance) isPlacementCompatible(spotCandidate *instanceTypeInformation) bool {
+	strategy := i.placementGroupStrategy()
+	if strategy == "" || spotCandidate.placementGroupStrategies == nil {
+		return true
+	}
+
+	if !itemInSlice(strategy, spotCandidate.placementGroupStrategies) {
+		debug.Println("\tNot supported in", strategy, "placement groups, only in",
+			spotCandidate.placementGroupStrategies)
+		return false
+	}
+	return true
+}
+
+// convertPlacement converts the placement of the instance into the placement
+// of its spot replacement. The placement group, partition and tenancy are
+// preserved, while the dedicated host settings are dropped since they're not
+// supported by spot instances.
+func (i *instance) convertPlacement() *ec2.LaunchTemplatePlacementRequest {
+	placement := &ec2.LaunchTemplatePlacementRequest{}
+	if i.Placement == nil {
+		return placement
+	}
+	p := i.Placement
+
+	placement.AvailabilityZone = p.AvailabilityZone
+	placement.SpreadDomain = p.SpreadDomain
+
+	if name := i.placementGroupName(); name != "" {
+		placement.GroupName = p.GroupName
+
+		// the partition number is only meaningful in partition placement
+		// groups, and keeps the replacement in the partition of the instance
+		if aws.Int64Value(p.PartitionNumber) > 0 &&
+			i.placementGroupStrategy() == ec2.PlacementStrategyPartition {
+			placement.PartitionNumber = p.PartitionNumber
+		}
+	}
+
+	switch aws.StringValue(p.Tenancy) {
+	case ec2.TenancyDedicated, ec2.TenancyDefault:
+		placement.Tenancy = p.Tenancy
+	case ec2.TenancyHost:
+		log.Println(i.region.name, "Dropping the dedicated host tenancy of", *i.InstanceId,
+			"from the placement of its spot replacement")
+	}
+
+	return placement
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func placementGroupsOutput(strategy string) *ec2.DescribePlacementGroupsOutput {
+	return &ec2.DescribePlacementGroupsOutput{
+		PlacementGroups: []*ec2.PlacementGroup{
+			{GroupName: aws.String("pg"), Strategy: aws.String(strategy)},
+		},
+	}
+}
+
+func Test_instance_placementGroupStrategy(t *testing.T) {
+	tests := []struct {
+		name      string
+		placement *ec2.Placement
+		ec2       mockEC2
+		want      string
+	}{
+		{
+			name:      "no placement group",
+			placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			ec2:       mockEC2{dpgerr: errors.New("unexpected call")},
+			want:      "",
+		},
+		{
+			name:      "cluster placement group",
+			placement: &ec2.Placement{GroupName: aws.String("pg")},
+			ec2:       mockEC2{dpgo: placementGroupsOutput(ec2.PlacementStrategyCluster)},
+			want:      ec2.PlacementStrategyCluster,
+		},
+		{
+			name:      "describe failure",
+			placement: &ec2.Placement{GroupName: aws.String("pg")},
+			ec2:       mockEC2{dpgerr: errors.New("error")},
+			want:      "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-foo"), Placement: tt.placement},
+				region:   &region{name: "us-east-1", services: connections{ec2: tt.ec2}},
+			}
+			if got := i.placementGroupStrategy(); got != tt.want {
+				t.Errorf("placementGroupStrategy() = %v, want %v", got, tt.want)
+			}
+
+			// the result is cached, including on failures
+			i.region.services.ec2 = mockEC2{dpgerr: errors.New("unexpected call")}
+			if got := i.placementGroupStrategy(); got != tt.want {
+				t.Errorf("cached placementGroupStrategy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_spotUnsupportedPlacementReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		placement *ec2.Placement
+		wantSkip  bool
+	}{
+		{name: "no placement", placement: nil},
+		{name: "default tenancy", placement: &ec2.Placement{Tenancy: aws.String(ec2.TenancyDefault)}},
+		{name: "dedicated tenancy", placement: &ec2.Placement{Tenancy: aws.String(ec2.TenancyDedicated)}},
+		{name: "dedicated host", placement: &ec2.Placement{Tenancy: aws.String(ec2.TenancyHost)}, wantSkip: true},
+		{
+			name:      "host resource group",
+			placement: &ec2.Placement{HostResourceGroupArn: aws.String("arn:aws:resource-groups:us-east-1:123456789012:group/hosts")},
+			wantSkip:  true,
+		},
+		{name: "host affinity", placement: &ec2.Placement{Affinity: aws.String(ec2.AffinityHost)}, wantSkip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-foo"), Placement: tt.placement},
+				region:   &region{name: "us-east-1"},
+			}
+			if got := i.spotUnsupportedPlacementReason(); (got != "") != tt.wantSkip {
+				t.Errorf("spotUnsupportedPlacementReason() = %q, want skip %v", got, tt.wantSkip)
+			}
+			if got := i.hasSpotUnsupportedPlacement(); got != tt.wantSkip {
+				t.Errorf("hasSpotUnsupportedPlacement() = %v, want %v", got, tt.wantSkip)
+			}
+		})
+	}
+}
+
+func Test_instance_isPlacementCompatible(t *testing.T) {
+	tests := []struct {
+		name       string
+		strategy   string
+		strategies []string
+		want       bool
+	}{
+		{name: "not in a placement group", strategy: "", strategies: []string{"spread"}, want: true},
+		{name: "unknown candidate strategies", strategy: "cluster", strategies: nil, want: true},
+		{name: "supported strategy", strategy: "cluster", strategies: []string{"cluster", "partition", "spread"}, want: true},
+		{name: "unsupported strategy", strategy: "cluster", strategies: []string{"partition", "spread"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-foo"),
+					Placement:  &ec2.Placement{GroupName: aws.String("pg")},
+				},
+				placementStrategy: aws.String(tt.strategy),
+			}
+			candidate := &instanceTypeInformation{placementGroupStrategies: tt.strategies}
+			if got := i.isPlacementCompatible(candidate); got != tt.want {
+				t.Errorf("isPlacementCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_convertPlacement(t *testing.T) {
+	tests := []struct {
+		name      string
+		placement *ec2.Placement
+		strategy  string
+		want      *ec2.LaunchTemplatePlacementRequest
+	}{
+		{
+			name:      "no placement",
+			placement: nil,
+			want:      &ec2.LaunchTemplatePlacementRequest{},
+		},
+		{
+			name: "partition placement group",
+			placement: &ec2.Placement{
+				AvailabilityZone: aws.String("us-east-1a"),
+				GroupName:        aws.String("pg"),
+				PartitionNumber:  aws.Int64(2),
+				Tenancy:          aws.String(ec2.TenancyDefault),
+			},
+			strategy: ec2.PlacementStrategyPartition,
+			want: &ec2.LaunchTemplatePlacementRequest{
+				AvailabilityZone: aws.String("us-east-1a"),
+				GroupName:        aws.String("pg"),
+				PartitionNumber:  aws.Int64(2),
+				Tenancy:          aws.String(ec2.TenancyDefault),
+			},
+		},
+		{
+			name: "partition number outside a partition placement group",
+			placement: &ec2.Placement{
+				AvailabilityZone: aws.String("us-east-1a"),
+				GroupName:        aws.String("pg"),
+				PartitionNumber:  aws.Int64(0),
+			},
+			strategy: ec2.PlacementStrategySpread,
+			want: &ec2.LaunchTemplatePlacementRequest{
+				AvailabilityZone: aws.String("us-east-1a"),
+				GroupName:        aws.String("pg"),
+			},
+		},
+		{
+			name: "dedicated tenancy",
+			placement: &ec2.Placement{
+				AvailabilityZone: aws.String("us-east-1a"),
+				Tenancy:          aws.String(ec2.TenancyDedicated),
+			},
+			want: &ec2.LaunchTemplatePlacementRequest{
+				AvailabilityZone: aws.String("us-east-1a"),
+				Tenancy:          aws.String(ec2.TenancyDedicated),
+			},
+		},
+		{
+			name: "dedicated host settings are dropped",
+			placement: &ec2.Placement{
+				AvailabilityZone:     aws.String("us-east-1a"),
+				Affinity:             aws.String(ec2.AffinityHost),
+				HostId:               aws.String("h-123"),
+				HostResourceGroupArn: aws.String("arn"),
+				Tenancy:              aws.String(ec2.TenancyHost),
+			},
+			want: &ec2.LaunchTemplatePlacementRequest{
+				AvailabilityZone: aws.String("us-east-1a"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance:          &ec2.Instance{InstanceId: aws.String("i-foo"), Placement: tt.placement},
+				region:            &region{name: "us-east-1"},
+				placementStrategy: aws.String(tt.strategy),
+			}
+			if got := i.convertPlacement(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertPlacement() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_fleetSubnetsClusterPlacementGroup(t *testing.T) {
+	asg := &autoScalingGroup{
+		name:      "asg",
+		Group:     &autoscaling.Group{},
+		instances: makeInstances(),
+		subnets: map[string]string{
+			"subnet-a1": "us-east-1a",
+			"subnet-a2": "us-east-1a",
+			"subnet-b":  "us-east-1b",
+		},
+	}
+	asg.instances.add(&instance{Instance: &ec2.Instance{
+		InstanceId: aws.String("i-foo"),
+		State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		Placement:  &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+	}})
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId: aws.String("i-foo"),
+			SubnetId:   aws.String("subnet-a1"),
+			Placement: &ec2.Placement{
+				AvailabilityZone: aws.String("us-east-1a"),
+				GroupName:        aws.String("pg"),
+			},
+		},
+		asg:               asg,
+		placementStrategy: aws.String(ec2.PlacementStrategyCluster),
+	}
+
+	want := []fleetSubnet{
+		{id: "subnet-a1", availabilityZone: "us-east-1a"},
+		{id: "subnet-a2", availabilityZone: "us-east-1a"},
+	}
+	if got := i.fleetSubnets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fleetSubnets() = %v, want %v", got, want)
+	}
+	if i.spansMultipleAZs() {
+		t.Errorf("spansMultipleAZs() = true, want false for cluster placement groups")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_platform.go contains the compatibility checks between the image of
+// the instances and the platform of the instance types, such as the Nitro
+// instance types requiring ENA and NVMe drivers, or the supported boot modes.
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func (info *instanceTypeInformation) setPlatformInfo(it *ec2.InstanceTypeInfo) {
+	info.hypervisor = aws.StringValue(it.Hypervisor)
+	if it.EbsInfo != nil {
+		info.nvmeSupport = aws.StringValue(it.EbsInfo.NvmeSupport)
+	}
+	if len(it.SupportedBootModes) > 0 {
+		info.bootModes = aws.StringValueSlice(it.SupportedBootModes)
+	}
+}
+
+// image returns the image of the launch template of the group, if it was
+// loaded.
+func (i *instance) image() *ec2.Image {
+	if i.asg == nil || i.asg.launchTemplate == nil {
+		return nil
+	}
+	return i.asg.launchTemplate.Image
+}
+
+// imageEnaSupport tells if the image supports ENA, falling back to the
+// attribute of the instance, which is inherited from its image.
+func (i *instance) imageEnaSupport() *bool {
+	if image := i.image(); image != nil && image.EnaSupport != nil {
+		return image.EnaSupport
+	}
+	if i.Instance == nil {
+		return nil
+	}
+	return i.EnaSupport
+}
+
+// imageBootMode returns the boot mode of the image, falling back to the one of
+// the instance.
+func (i *instance) imageBootMode() string {
+	if image := i.image(); image != nil && image.BootMode != nil {
+		return *image.BootMode
+	}
+	if i.Instance == nil {
+		return ""
+	}
+	return aws.StringValue(i.BootMode)
+}
+
+// isBootModeCompatible checks if the candidate supports the boot mode of the
+// image, skipped when either of them is unknown.
+func (i *instance) isBootModeCompatible(spotCandidate *instanceTypeInformation) bool {
+	bootMode := i.imageBootMode()
+	if bootMode == "" || len(spotCandidate.bootModes) == 0 {
+		return true
+	}
+
+	// the images preferring UEFI can also boot in legacy BIOS mode
+	if bootMode == "uefi-preferred" {
+		return true
+	}
+
+	if !itemInSlice(bootMode, spotCandidate.bootModes) {
+		debug.Println("\tNot boot mode compatible, the image requires", bootMode,
+			"while the instance type supports", spotCandidate.bootModes)
+		return false
+	}
+	return true
+}
+
+// isNVMeCompatible prevents moving from instance types without NVMe support,
+// such as the Xen ones, to instance types exposing the EBS volumes as NVMe
+// devices, unless the image supports ENA, which is a good indicator for the
+// presence of the NVMe drivers and device naming rules of Nitro-ready images.
+func (i *instance) isNVMeCompatible(spotCandidate *instanceTypeInformation) bool {
+	if spotCandidate.nvmeSupport != ec2.EbsNvmeSupportRequired ||
+		i.typeInfo.nvmeSupport != ec2.EbsNvmeSupportUnsupported {
+		return true
+	}
+
+	if ena := i.imageEnaSupport(); ena != nil && !*ena {
+		debug.Println("\tNot NVMe compatible, the image isn't ready for the", spotCandidate.hypervisor,
+			"instance types")
+		return false
+	}
+	return true
+}
+
+// isPlatformCompatible checks if the image of the instance can boot on the
+// candidate, in addition to the ENA support checked with the networking. The
+// candidates of another architecture are launched from another image,
+// configured for their architecture, so they are not checked.
+func (i *instance) isPlatformCompatible(spotCandidate *instanceTypeInformation) bool {
+	if spotCandidate.cpuArchitecture() != i.typeInfo.cpuArchitecture() {
+		return true
+	}
+
+	debug.Println("Comparing platform spot/instance:")
+	debug.Println("\tSpot hypervisor/NVMe/boot modes: ", spotCandidate.hypervisor,
+		spotCandidate.nvmeSupport, spotCandidate.bootModes)
+	debug.Println("\tInstance hypervisor/NVMe/image boot mode: ", i.typeInfo.hypervisor,
+		i.typeInfo.nvmeSupport, i.imageBootMode())
+
+	return i.isNVMeCompatible(spotCandidate) &&
+		i.isBootModeCompatible(spotCandidate)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instance_isPlatformCompatible(t *testing.T) {
+	xen := instanceTypeInformation{
+		instanceType:      "m4.large",
+		PhysicalProcessor: "Intel Xeon E5-2676 v3",
+		hypervisor:        ec2.InstanceTypeHypervisorXen,
+		nvmeSupport:       ec2.EbsNvmeSupportUnsupported,
+		bootModes:         []string{ec2.BootModeValuesLegacyBios},
+	}
+	nitro := instanceTypeInformation{
+		instanceType:      "m5.large",
+		PhysicalProcessor: "Intel Xeon Platinum 8175",
+		hypervisor:        ec2.InstanceTypeHypervisorNitro,
+		nvmeSupport:       ec2.EbsNvmeSupportRequired,
+		enaSupport:        ec2.EnaSupportRequired,
+		bootModes:         []string{ec2.BootModeValuesLegacyBios, ec2.BootModeValuesUefi},
+	}
+	graviton := instanceTypeInformation{
+		instanceType:      "m6g.large",
+		PhysicalProcessor: "AWS Graviton2 Processor",
+		hypervisor:        ec2.InstanceTypeHypervisorNitro,
+		nvmeSupport:       ec2.EbsNvmeSupportRequired,
+		enaSupport:        ec2.EnaSupportRequired,
+		bootModes:         []string{ec2.BootModeValuesUefi},
+	}
+
+	tests := []struct {
+		name       string
+		current    instanceTypeInformation
+		candidate  instanceTypeInformation
+		enaSupport *bool
+		bootMode   *string
+		image      *ec2.Image
+		want       bool
+	}{
+		{
+			name:       "Xen to Nitro with a Nitro-ready image",
+			current:    xen,
+			candidate:  nitro,
+			enaSupport: aws.Bool(true),
+			want:       true,
+		},
+		{
+			name:       "Xen to Nitro without NVMe drivers",
+			current:    xen,
+			candidate:  nitro,
+			enaSupport: aws.Bool(false),
+			want:       false,
+		},
+		{
+			name:       "launch template image without ENA support",
+			current:    xen,
+			candidate:  nitro,
+			enaSupport: aws.Bool(true),
+			image:      &ec2.Image{EnaSupport: aws.Bool(false)},
+			want:       false,
+		},
+		{
+			name:       "Nitro to Xen",
+			current:    nitro,
+			candidate:  xen,
+			enaSupport: aws.Bool(true),
+			want:       true,
+		},
+		{
+			name:       "UEFI image on legacy BIOS instance type",
+			current:    nitro,
+			candidate:  xen,
+			enaSupport: aws.Bool(true),
+			bootMode:   aws.String(ec2.BootModeValuesUefi),
+			want:       false,
+		},
+		{
+			name:       "UEFI preferred image on legacy BIOS instance type",
+			current:    nitro,
+			candidate:  xen,
+			enaSupport: aws.Bool(true),
+			image:      &ec2.Image{BootMode: aws.String("uefi-preferred")},
+			want:       true,
+		},
+		{
+			name:       "unknown platform",
+			current:    instanceTypeInformation{PhysicalProcessor: "Intel"},
+			candidate:  instanceTypeInformation{PhysicalProcessor: "Intel"},
+			enaSupport: aws.Bool(false),
+			bootMode:   aws.String(ec2.BootModeValuesUefi),
+			want:       true,
+		},
+		{
+			name:       "other architecture launched from another image",
+			current:    xen,
+			candidate:  graviton,
+			enaSupport: aws.Bool(false),
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					EnaSupport: tt.enaSupport,
+					BootMode:   tt.bootMode,
+				},
+				typeInfo: tt.current,
+				asg:      &autoScalingGroup{},
+			}
+			if tt.image != nil {
+				i.asg.launchTemplate = &launchTemplate{Image: tt.image}
+			}
+
+			if got := i.isPlatformCompatible(&tt.candidate) && i.isENACompatible(&tt.candidate); got != tt.want {
+				t.Errorf("isPlatformCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -28,6 +28,11 @@ func (i *instance) calculatePrice(spotCandidate instanceTypeInformation) float64
 		debug.Println("\tEBS Surcharge : ", spotCandidate.pricing.ebsSurcharge)
 	}
 
+	if surcharge := i.burstableSurcharge(&spotCandidate); surcharge > 0 {
+		spotPrice += surcharge
+		debug.Println("\tSurplus credits surcharge : ", surcharge)
+	}
+
 	debug.Println("\tSpot price: ", spotPrice)
 	debug.Println("\tInstance price: ", i.price)
 	return spotPrice
@@ -38,8 +43,11 @@ func (i *instance) isSpot() bool {
 		*i.InstanceLifecycle == Spot
 }
 
+// getSavings returns the hourly savings of the spot instance compared to the
+// on-demand price, or to the effective price of the unused Reserved Instances
+// that would have covered it.
 func (i *instance) getSavings() float64 {
-	odPrice := i.typeInfo.pricing.onDemand
+	odPrice := i.onDemandBaselinePrice()
 	spotPrice := i.typeInfo.pricing.spot[*i.Placement.AvailabilityZone]
 
 	log.Printf("Calculating savings for instance %s with OD price %f and Spot price %f\n", *i.InstanceId, odPrice, spotPrice)
@@ -50,7 +58,8 @@ func (i *instance) isProtectedFromTermination() (bool, error) {
 	debug.Println("\tChecking termination protection for instance: ", *i.InstanceId)
 
 	// determine and set the API termination protection field
-	diaRes, err := i.region.services.ec2.DescribeInstanceAttribute(
+	diaRes, err := i.region.services.ec2.DescribeInstanceAttributeWithContext(
+		i.region.runContext(),
 		&ec2.DescribeInstanceAttributeInput{
 			Attribute:  aws.String("disableApiTermination"),
 			InstanceId: i.InstanceId,
@@ -102,7 +111,10 @@ func (i *instance) shouldBeReplacedWithSpot() bool {
 		i.asgNeedsReplacement() &&
 		!i.isSpot() &&
 		!i.isProtectedFromScaleIn() &&
-		!protT
+		!protT &&
+		!i.isCreditConstrained() &&
+		!i.hasSpotUnsupportedPlacement() &&
+		!i.isCoveredByReservation()
 }
 
 func (i *instance) belongsToEnabledASG() bool {
@@ -177,7 +189,7 @@ func (i *instance) isClassCompatible(spotCandidate *instanceTypeInformation) boo
 	debug.Println("\tInstance CPU/memory/GPU: ", current.vCPU,
 		" / ", current.memory, " / ", current.GPU)
 
-	if i.isSameArch(spotCandidate) &&
+	if i.isArchitectureCompatible(spotCandidate) &&
 		spotCandidate.vCPU >= current.vCPU &&
 		spotCandidate.memory >= current.memory &&
 		spotCandidate.GPU >= current.GPU {
@@ -187,6 +199,85 @@ func (i *instance) isClassCompatible(spotCandidate *instanceTypeInformation) boo
 	return false
 }
 
+// upperBound is a limit given either as an absolute value or as a ratio to the
+// value of the original instance type.
+type upperBound struct {
+	value float64
+	ratio bool
+}
+
+// parseUpperBound parses values such as "16" or "2x", an empty value meaning
+// unlimited.
+func parseUpperBound(value string) (*upperBound, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	b := upperBound{}
+	if strings.HasSuffix(value, "x") {
+		b.ratio = true
+		value = strings.TrimSuffix(value, "x")
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v <= 0 {
+		return nil, fmt.Errorf("invalid upper bound %q", value)
+	}
+	b.value = v
+	return &b, nil
+}
+
+func (b *upperBound) allows(original, candidate float64) bool {
+	if b == nil {
+		return true
+	}
+	if b.ratio {
+		return candidate <= original*b.value
+	}
+	return candidate <= b.value
+}
+
+// isWithinUpperBounds prevents the replacement of instances with much bigger
+// or more expensive ones, which may happen to be cheaper than the on-demand
+// price of the original instance type.
+func (i *instance) isWithinUpperBounds(spotCandidate *instanceTypeInformation, candidatePrice float64) bool {
+	if i.asg == nil {
+		return true
+	}
+	current, conf := i.typeInfo, i.asg.config
+
+	// the values were validated when parsing the flags and loading the tags
+	maxVCPU, _ := parseUpperBound(conf.MaxVCPU)
+	if !maxVCPU.allows(float64(current.vCPU), float64(spotCandidate.vCPU)) {
+		debug.Println("\tAbove the vCPU upper bound", conf.MaxVCPU)
+		return false
+	}
+
+	maxMemory, _ := parseUpperBound(conf.MaxMemory)
+	if !maxMemory.allows(float64(current.memory), float64(spotCandidate.memory)) {
+		debug.Println("\tAbove the memory upper bound", conf.MaxMemory)
+		return false
+	}
+
+	if conf.MaxPriceRatio > 0 {
+		// the spot price of the original instance type includes the same
+		// surcharges as the price of the candidate
+		originalPrice := i.price
+		if i.Placement != nil && i.Placement.AvailabilityZone != nil &&
+			current.pricing.spot[*i.Placement.AvailabilityZone] > 0 {
+			originalPrice = i.calculatePrice(current)
+		}
+
+		if candidatePrice > originalPrice*conf.MaxPriceRatio {
+			debug.Println("\tAbove the price upper bound", conf.MaxPriceRatio,
+				"x", originalPrice)
+			return false
+		}
+	}
+	return true
+}
+
 func (i *instance) isSameArch(other *instanceTypeInformation) bool {
 	thisCPU := i.typeInfo.PhysicalProcessor
 	otherCPU := other.PhysicalProcessor
@@ -327,6 +418,10 @@ func (i *instance) getCompatibleSpotInstanceTypesList(PrioritizationBias string,
 
 	sort.Strings(keys)
 
+	bestFit := PrioritizationBias == BestFitPrioritizationBias
+	weights := i.asg.scoreWeights()
+	requirements := i.asg.instanceRequirements()
+
 	// Find all compatible and not blocked instance types
 	for _, k := range keys {
 		candidate := i.region.instanceTypeInformation[k]
@@ -335,8 +430,16 @@ func (i *instance) getCompatibleSpotInstanceTypesList(PrioritizationBias string,
 		debug.Println("Comparing current type", current.instanceType, "with price", i.price,
 			"with candidate", candidate.instanceType, "with price", candidatePrice)
 
-		if i.isAllowed(candidate.instanceType, allowedList, disallowedList) && i.isCompatible(&candidate, candidatePrice, attachedVolumesNumber) {
-			acceptableInstanceTypes = append(acceptableInstanceTypes, acceptableInstance{candidate, candidatePrice, candidate.generationDelta})
+		if i.isAllowed(candidate.instanceType, allowedList, disallowedList) &&
+			requirements.matches(&candidate) &&
+			i.isCompatible(&candidate, candidatePrice, attachedVolumesNumber) &&
+			i.isWithinUpperBounds(&candidate, candidatePrice) {
+			ai := acceptableInstance{instanceTI: candidate, price: candidatePrice, generationDelta: candidate.generationDelta}
+			if bestFit {
+				ai.score = i.scoreCandidate(&candidate, candidatePrice, weights)
+				debug.Println("\tCompatibility score of", candidate.instanceType, ":", ai.score)
+			}
+			acceptableInstanceTypes = append(acceptableInstanceTypes, ai)
 			log.Println("\tMATCH FOUND, added", candidate.instanceType, "to launch candidates list for instance", *i.InstanceId)
 		} else if candidate.instanceType != "" {
 			debug.Println("Non compatible option found:", candidate.instanceType, "at", candidatePrice, " - discarding")
@@ -344,7 +447,13 @@ func (i *instance) getCompatibleSpotInstanceTypesList(PrioritizationBias string,
 	}
 
 	if acceptableInstanceTypes != nil {
-		sort.Slice(acceptableInstanceTypes, func(i, j int) bool {
+		sort.SliceStable(acceptableInstanceTypes, func(i, j int) bool {
+			if bestFit {
+				if acceptableInstanceTypes[i].score.total != acceptableInstanceTypes[j].score.total {
+					return acceptableInstanceTypes[i].score.total > acceptableInstanceTypes[j].score.total
+				}
+				return acceptableInstanceTypes[i].price < acceptableInstanceTypes[j].price
+			}
 			if PrioritizationBias == "prefer_newer_generations" {
 				log.Printf("Sorting biased towards newer instance types, comparing %v"+
 					" of generation delta %v and price %v(adjusted to %v) with %v of generation delta %v and price %v (adjusted to %v)\n",
@@ -371,15 +480,25 @@ func (i *instance) getCompatibleSpotInstanceTypesList(PrioritizationBias string,
 		return result, nil
 	}
 
+	if current.pricing.savingsPlanRate > 0 {
+		log.Printf("%s Not replacing instance %s with spot: no spot instance type is cheaper than its "+
+			"effective on-demand price of %f discounted by Savings Plans", i.region.name, *i.InstanceId, i.price)
+	}
 	return nil, fmt.Errorf("no cheaper spot instance types could be found")
 }
 
 func (i *instance) isCompatible(candidate *instanceTypeInformation, candidatePrice float64, attachedVolumesNumber int) bool {
 	return i.isPriceCompatible(candidatePrice) &&
+		i.isOfferedInAZ(candidate) &&
+		i.isBurstableCompatible(candidate) &&
 		i.isEBSCompatible(candidate) &&
 		i.isClassCompatible(candidate) &&
 		i.isStorageCompatible(candidate, attachedVolumesNumber) &&
-		i.isVirtualizationCompatible(candidate.virtualizationTypes)
+		i.isNetworkCompatible(candidate) &&
+		i.isAcceleratorCompatible(candidate) &&
+		i.isVirtualizationCompatible(candidate.virtualizationTypes) &&
+		i.isPlatformCompatible(candidate) &&
+		i.isPlacementCompatible(candidate)
 }
 
 func (i *instance) getReplacementTargetInstanceID() *string {
@@ -1395,3 +1395,115 @@ func Test_instance_getReplacementTargetASGName(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseUpperBound(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    *upperBound
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "16", want: &upperBound{value: 16}},
+		{value: "1.5x", want: &upperBound{value: 1.5, ratio: true}},
+		{value: "x", wantErr: true},
+		{value: "-2", wantErr: true},
+		{value: "big", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseUpperBound(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseUpperBound() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUpperBound() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isWithinUpperBounds(t *testing.T) {
+	candidate := &instanceTypeInformation{
+		vCPU:   16,
+		memory: 64,
+	}
+
+	tests := []struct {
+		name         string
+		config       AutoScalingConfig
+		ebsOptimized bool
+		price        float64
+		want         bool
+	}{
+		{
+			name:  "unlimited",
+			price: 0.9,
+			want:  true,
+		},
+		{
+			name:   "vcpu ratio exceeded",
+			config: AutoScalingConfig{MaxVCPU: "2x"},
+			want:   false,
+		},
+		{
+			name:   "vcpu ratio respected",
+			config: AutoScalingConfig{MaxVCPU: "4x"},
+			want:   true,
+		},
+		{
+			name:   "absolute memory exceeded",
+			config: AutoScalingConfig{MaxMemory: "32"},
+			want:   false,
+		},
+		{
+			name:   "absolute memory respected",
+			config: AutoScalingConfig{MaxMemory: "64"},
+			want:   true,
+		},
+		{
+			name:   "price ratio exceeded",
+			config: AutoScalingConfig{MaxPriceRatio: 1.5},
+			price:  0.4,
+			want:   false,
+		},
+		{
+			name:   "price ratio respected",
+			config: AutoScalingConfig{MaxPriceRatio: 1.5},
+			price:  0.3,
+			want:   true,
+		},
+		{
+			name:         "price ratio including the EBS surcharge",
+			config:       AutoScalingConfig{MaxPriceRatio: 1.5},
+			ebsOptimized: true,
+			price:        0.4,
+			want:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{
+						AvailabilityZone: aws.String("us-east-1a"),
+					},
+					EbsOptimized: aws.Bool(tt.ebsOptimized),
+				},
+				typeInfo: instanceTypeInformation{
+					vCPU:   4,
+					memory: 16,
+					pricing: prices{
+						spot:         spotPriceMap{"us-east-1a": 0.25},
+						ebsSurcharge: 0.05,
+					},
+				},
+				price: 1,
+				asg:   &autoScalingGroup{config: tt.config},
+			}
+			if got := i.isWithinUpperBounds(candidate, tt.price); got != tt.want {
+				t.Errorf("isWithinUpperBounds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_requirements.go contains the attribute-based selection of the spot
+// instance types, modeled after the EC2 InstanceRequirements, as an
+// alternative to maintaining lists of allowed instance types.
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the values accepted by the burstable, bare_metal and local_storage
+	// requirements, matching the ones used by EC2
+	requirementIncluded = "included"
+	requirementExcluded = "excluded"
+	requirementRequired = "required"
+
+	currentGeneration  = "current"
+	previousGeneration = "previous"
+)
+
+// valueRange is an inclusive range, with zero meaning no limit on either side.
+type valueRange struct {
+	min float64
+	max float64
+}
+
+// parseValueRange parses ranges such as "2-8", "2-" or "-8", or single values.
+func parseValueRange(value string) (valueRange, error) {
+	var r valueRange
+	var err error
+
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+
+	if s := strings.TrimSpace(parts[0]); s != "" {
+		if r.min, err = strconv.ParseFloat(s, 64); err != nil || r.min < 0 {
+			return r, fmt.Errorf("invalid range %q", value)
+		}
+	}
+	if s := strings.TrimSpace(parts[1]); s != "" {
+		if r.max, err = strconv.ParseFloat(s, 64); err != nil || r.max < 0 {
+			return r, fmt.Errorf("invalid range %q", value)
+		}
+	}
+	if r.max > 0 && r.min > r.max {
+		return r, fmt.Errorf("invalid range %q", value)
+	}
+	return r, nil
+}
+
+func (r valueRange) contains(value float64) bool {
+	return value >= r.min && (r.max == 0 || value <= r.max)
+}
+
+// instanceRequirements are the attributes the spot instance types must have.
+type instanceRequirements struct {
+	vCPU            valueRange
+	memory          valueRange
+	memoryPerVCPU   valueRange
+	cpuManufacturer []string
+	burstable       string
+	bareMetal       string
+	localStorage    string
+	generations     []string
+}
+
+func parseInclusion(value string) (string, error) {
+	switch value {
+	case requirementIncluded, requirementExcluded, requirementRequired:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid value %q, expected one of %s, %s or %s",
+		value, requirementIncluded, requirementExcluded, requirementRequired)
+}
+
+// parseInstanceRequirements parses a semicolon-separated list of attributes,
+// such as "vcpu=2-8;memory=4-32;cpu_manufacturer=intel,amd;burstable=excluded".
+// The missing attributes don't restrict the instance types.
+func parseInstanceRequirements(value string) (*instanceRequirements, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	req := instanceRequirements{
+		burstable:    requirementIncluded,
+		bareMetal:    requirementIncluded,
+		localStorage: requirementIncluded,
+	}
+
+	for _, attr := range strings.Split(value, ";") {
+		if strings.TrimSpace(attr) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid instance requirement %q", attr)
+		}
+		key := strings.TrimSpace(kv[0])
+		val := strings.ToLower(strings.TrimSpace(kv[1]))
+
+		var err error
+		switch key {
+		case "vcpu":
+			req.vCPU, err = parseValueRange(val)
+		case "memory":
+			req.memory, err = parseValueRange(val)
+		case "memory_per_vcpu":
+			req.memoryPerVCPU, err = parseValueRange(val)
+		case "cpu_manufacturer":
+			for _, m := range strings.Split(val, ",") {
+				switch m = strings.TrimSpace(m); m {
+				case "intel", "amd", "aws":
+					req.cpuManufacturer = append(req.cpuManufacturer, m)
+				default:
+					err = fmt.Errorf("unknown CPU manufacturer %q", m)
+				}
+			}
+		case "burstable":
+			req.burstable, err = parseInclusion(val)
+		case "bare_metal":
+			req.bareMetal, err = parseInclusion(val)
+		case "local_storage":
+			req.localStorage, err = parseInclusion(val)
+		case "generations":
+			for _, g := range strings.Split(val, ",") {
+				switch g = strings.TrimSpace(g); g {
+				case currentGeneration, previousGeneration:
+					req.generations = append(req.generations, g)
+				default:
+					err = fmt.Errorf("unknown instance generation %q", g)
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown instance requirement %q", key)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &req, nil
+}
+
+func matchesInclusion(requirement string, value bool) bool {
+	switch requirement {
+	case requirementExcluded:
+		return !value
+	case requirementRequired:
+		return value
+	}
+	return true
+}
+
+// isBurstableInstanceType tells if the instance type belongs to one of the T
+// families, used until the data is loaded from the EC2 API.
+func isBurstableInstanceType(instanceType string) bool {
+	return len(instanceType) > 1 && instanceType[0] == 't' &&
+		instanceType[1] >= '0' && instanceType[1] <= '9'
+}
+
+// cpuManufacturer extracts the CPU manufacturer from the processor name, such
+// as "Intel Xeon Platinum 8175" or "AWS Graviton2 Processor".
+func (info *instanceTypeInformation) cpuManufacturer() string {
+	processor := strings.ToLower(info.PhysicalProcessor)
+	for _, m := range []string{"intel", "amd", "aws"} {
+		if strings.HasPrefix(processor, m) {
+			return m
+		}
+	}
+	return ""
+}
+
+func (info *instanceTypeInformation) generation() string {
+	if info.previousGeneration {
+		return previousGeneration
+	}
+	return currentGeneration
+}
+
+// matches tells if the instance type has all the required attributes, the
+// nil requirements are met by all instance types.
+func (req *instanceRequirements) matches(info *instanceTypeInformation) bool {
+	if req == nil {
+		return true
+	}
+
+	if !req.vCPU.contains(float64(info.vCPU)) {
+		debug.Println("\tvCPU count outside of the required range")
+		return false
+	}
+
+	if !req.memory.contains(float64(info.memory)) {
+		debug.Println("\tMemory size outside of the required range")
+		return false
+	}
+
+	if (req.memoryPerVCPU != valueRange{}) {
+		if info.vCPU == 0 ||
+			!req.memoryPerVCPU.contains(math.Round(float64(info.memory)/float64(info.vCPU)*100)/100) {
+			debug.Println("\tMemory per vCPU outside of the required range")
+			return false
+		}
+	}
+
+	if req.cpuManufacturer != nil && !itemInSlice(info.cpuManufacturer(), req.cpuManufacturer) {
+		debug.Println("\tCPU manufacturer not allowed")
+		return false
+	}
+
+	if !matchesInclusion(req.burstable, info.burstable) {
+		debug.Println("\tBurstable performance requirement not met")
+		return false
+	}
+
+	if !matchesInclusion(req.bareMetal, info.bareMetal) {
+		debug.Println("\tBare metal requirement not met")
+		return false
+	}
+
+	if !matchesInclusion(req.localStorage, info.hasInstanceStore) {
+		debug.Println("\tLocal storage requirement not met")
+		return false
+	}
+
+	if req.generations != nil && !itemInSlice(info.generation(), req.generations) {
+		debug.Println("\tInstance generation not allowed")
+		return false
+	}
+	return true
+}
+
+// instanceRequirements returns the instance requirements configured for the
+// group, ignoring them if they are invalid.
+func (a *autoScalingGroup) instanceRequirements() *instanceRequirements {
+	req, err := parseInstanceRequirements(a.config.InstanceRequirements)
+	if err != nil {
+		log.Printf("%s Ignoring the instance requirements %q: %s",
+			a.name, a.config.InstanceRequirements, err.Error())
+		return nil
+	}
+	return req
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseInstanceRequirements(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    *instanceRequirements
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "all attributes",
+			value: "vcpu=2-8; memory=4-; memory_per_vcpu=-4; cpu_manufacturer=Intel,AMD; burstable=excluded; bare_metal=excluded; local_storage=required; generations=current",
+			want: &instanceRequirements{
+				vCPU:            valueRange{min: 2, max: 8},
+				memory:          valueRange{min: 4},
+				memoryPerVCPU:   valueRange{max: 4},
+				cpuManufacturer: []string{"intel", "amd"},
+				burstable:       requirementExcluded,
+				bareMetal:       requirementExcluded,
+				localStorage:    requirementRequired,
+				generations:     []string{currentGeneration},
+			},
+		},
+		{
+			name:  "single value",
+			value: "vcpu=4",
+			want: &instanceRequirements{
+				vCPU:         valueRange{min: 4, max: 4},
+				burstable:    requirementIncluded,
+				bareMetal:    requirementIncluded,
+				localStorage: requirementIncluded,
+			},
+		},
+		{
+			name:    "inverted range",
+			value:   "vcpu=8-2",
+			wantErr: true,
+		},
+		{
+			name:    "unknown attribute",
+			value:   "gpus=1",
+			wantErr: true,
+		},
+		{
+			name:    "unknown manufacturer",
+			value:   "cpu_manufacturer=ibm",
+			wantErr: true,
+		},
+		{
+			name:    "invalid inclusion",
+			value:   "burstable=false",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInstanceRequirements(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseInstanceRequirements() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseInstanceRequirements() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instanceRequirements_matches(t *testing.T) {
+	m5 := instanceTypeInformation{
+		instanceType:      "m5.xlarge",
+		vCPU:              4,
+		memory:            16,
+		PhysicalProcessor: "Intel Xeon Platinum 8175",
+	}
+	t3 := instanceTypeInformation{
+		instanceType:      "t3.xlarge",
+		vCPU:              4,
+		memory:            16,
+		PhysicalProcessor: "Intel Skylake E5 2686 v5",
+		burstable:         true,
+	}
+	m6gMetal := instanceTypeInformation{
+		instanceType:      "m6g.metal",
+		vCPU:              64,
+		memory:            256,
+		PhysicalProcessor: "AWS Graviton2 Processor",
+		bareMetal:         true,
+	}
+	m3 := instanceTypeInformation{
+		instanceType:       "m3.xlarge",
+		vCPU:               4,
+		memory:             15,
+		PhysicalProcessor:  "Intel Xeon E5-2670 v2",
+		hasInstanceStore:   true,
+		previousGeneration: true,
+	}
+
+	tests := []struct {
+		name         string
+		requirements string
+		info         instanceTypeInformation
+		want         bool
+	}{
+		{name: "no requirements", info: m6gMetal, want: true},
+		{name: "vcpu in range", requirements: "vcpu=2-8", info: m5, want: true},
+		{name: "vcpu out of range", requirements: "vcpu=2-8", info: m6gMetal, want: false},
+		{name: "memory minimum", requirements: "memory=16-", info: m3, want: false},
+		{name: "memory per vcpu", requirements: "memory_per_vcpu=4", info: m5, want: true},
+		{name: "cpu manufacturer allowed", requirements: "cpu_manufacturer=aws", info: m6gMetal, want: true},
+		{name: "cpu manufacturer not allowed", requirements: "cpu_manufacturer=amd,aws", info: m5, want: false},
+		{name: "burstable excluded", requirements: "burstable=excluded", info: t3, want: false},
+		{name: "burstable included", requirements: "burstable=included", info: t3, want: true},
+		{name: "bare metal required", requirements: "bare_metal=required", info: m5, want: false},
+		{name: "local storage required", requirements: "local_storage=required", info: m3, want: true},
+		{name: "local storage excluded", requirements: "local_storage=excluded", info: m3, want: false},
+		{name: "current generation", requirements: "generations=current", info: m3, want: false},
+		{name: "any generation", requirements: "generations=current,previous", info: m3, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parseInstanceRequirements(tt.requirements)
+			if err != nil {
+				t.Fatalf("parseInstanceRequirements() error = %v", err)
+			}
+			if got := req.matches(&tt.info); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isBurstableInstanceType(t *testing.T) {
+	for instanceType, want := range map[string]bool{
+		"t3.micro":     true,
+		"t4g.nano":     true,
+		"trn1.2xlarge": false,
+		"m5.large":     false,
+	} {
+		if got := isBurstableInstanceType(instanceType); got != want {
+			t.Errorf("isBurstableInstanceType(%s) = %v, want %v", instanceType, got, want)
+		}
+	}
+}
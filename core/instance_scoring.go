@@ -0,0 +1,200 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_scoring.go contains the scoring of the compatible spot instance
+// types, used for ranking them by how closely they fit the replaced instance.
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// BestFitPrioritizationBias ranks the compatible instance types by their
+	// weighted compatibility score.
+	BestFitPrioritizationBias = "best_fit"
+
+	// DefaultCompatibilityScoreWeights are the default weights of the
+	// dimensions of the compatibility score.
+	DefaultCompatibilityScoreWeights = "vcpu=1,memory=1,network=1,ebs=1,generation=1,price=2"
+)
+
+// scoreWeights holds the weight of each dimension of the compatibility score.
+type scoreWeights struct {
+	vCPU       float64
+	memory     float64
+	network    float64
+	ebs        float64
+	generation float64
+	price      float64
+}
+
+// defaultScoreWeights matches the DefaultCompatibilityScoreWeights.
+var defaultScoreWeights = scoreWeights{
+	vCPU:       1,
+	memory:     1,
+	network:    1,
+	ebs:        1,
+	generation: 1,
+	price:      2,
+}
+
+// parseScoreWeights parses a comma-separated list of dimension=weight pairs,
+// the dimensions missing from the list get their default weight.
+func parseScoreWeights(value string) (scoreWeights, error) {
+	w := defaultScoreWeights
+
+	for _, pair := range strings.FieldsFunc(value, func(c rune) bool { return c == ',' }) {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return w, fmt.Errorf("invalid score weight %q", pair)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || weight < 0 {
+			return w, fmt.Errorf("invalid score weight %q", pair)
+		}
+
+		switch strings.TrimSpace(kv[0]) {
+		case "vcpu":
+			w.vCPU = weight
+		case "memory":
+			w.memory = weight
+		case "network":
+			w.network = weight
+		case "ebs":
+			w.ebs = weight
+		case "generation":
+			w.generation = weight
+		case "price":
+			w.price = weight
+		default:
+			return w, fmt.Errorf("unknown score dimension %q", kv[0])
+		}
+	}
+	return w, nil
+}
+
+func (w scoreWeights) total() float64 {
+	return w.vCPU + w.memory + w.network + w.ebs + w.generation + w.price
+}
+
+// compatibilityScore is the breakdown of the score of a compatible instance
+// type, each dimension ranging from 0 to 1, higher meaning a better fit.
+type compatibilityScore struct {
+	vCPU       float64
+	memory     float64
+	network    float64
+	ebs        float64
+	generation float64
+	price      float64
+
+	// the weighted average of the dimensions
+	total float64
+}
+
+func (s compatibilityScore) String() string {
+	return fmt.Sprintf("%.3f (vcpu: %.2f, memory: %.2f, network: %.2f, ebs: %.2f, generation: %.2f, price: %.2f)",
+		s.total, s.vCPU, s.memory, s.network, s.ebs, s.generation, s.price)
+}
+
+// headroomScore favours the candidates closest to the current capacity,
+// penalizing the oversized ones.
+func headroomScore(current, candidate float64) float64 {
+	if candidate <= 0 {
+		return 0
+	}
+	if current <= 0 || candidate <= current {
+		return 1
+	}
+	return current / candidate
+}
+
+// minimumScore penalizes the candidates falling short of the current capacity,
+// without favouring the ones exceeding it.
+func minimumScore(current, candidate float64) float64 {
+	if current <= 0 || candidate >= current {
+		return 1
+	}
+	if candidate <= 0 {
+		return 0
+	}
+	return candidate / current
+}
+
+// scoreCandidate computes the compatibility score of a candidate instance type
+// which passed the compatibility checks.
+func (i *instance) scoreCandidate(candidate *instanceTypeInformation, candidatePrice float64, w scoreWeights) compatibilityScore {
+	current := i.typeInfo
+
+	s := compatibilityScore{
+		vCPU:       headroomScore(float64(current.vCPU), float64(candidate.vCPU)),
+		memory:     headroomScore(float64(current.memory), float64(candidate.memory)),
+		network:    minimumScore(current.networkPerformance, candidate.networkPerformance),
+		ebs:        minimumScore(float64(current.EBSThroughput), float64(candidate.EBSThroughput)),
+		generation: 1 / (1 + float64(max64(candidate.generationDelta, 0))),
+	}
+
+	if i.price > 0 {
+		s.price = math.Max(0, math.Min(1, 1-candidatePrice/i.price))
+	}
+
+	if total := w.total(); total > 0 {
+		s.total = (w.vCPU*s.vCPU + w.memory*s.memory + w.network*s.network +
+			w.ebs*s.ebs + w.generation*s.generation + w.price*s.price) / total
+	}
+	return s
+}
+
+// scoreWeights returns the score weights configured for the group, falling
+// back to the default ones if they are invalid.
+func (a *autoScalingGroup) scoreWeights() scoreWeights {
+	w, err := parseScoreWeights(a.config.CompatibilityScoreWeights)
+	if err != nil {
+		log.Printf("%s Ignoring the compatibility score weights %q: %s",
+			a.name, a.config.CompatibilityScoreWeights, err.Error())
+		w = defaultScoreWeights
+	}
+	return w
+}
+
+var networkPerformanceRegexp = regexp.MustCompile(`^(?:Up to )?(?:(\d+)x )?([\d.]+) Gigabit$`)
+
+// parseNetworkPerformance converts the network performance of an instance type
+// to Gbps. The "Up to" values are burst values, counted as half of the
+// bandwidth since the baseline is usually much lower.
+func parseNetworkPerformance(value string) float64 {
+	switch value {
+	case "Very Low":
+		return 0.05
+	case "Low":
+		return 0.1
+	case "Low to Moderate":
+		return 0.3
+	case "Moderate":
+		return 0.5
+	case "High":
+		return 1
+	}
+
+	match := networkPerformanceRegexp.FindStringSubmatch(value)
+	if match == nil {
+		return 0
+	}
+
+	gbps, _ := strconv.ParseFloat(match[2], 64)
+	if match[1] != "" {
+		count, _ := strconv.ParseFloat(match[1], 64)
+		gbps *= count
+	}
+	if strings.HasPrefix(value, "Up to ") {
+		gbps /= 2
+	}
+	return gbps
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_parseScoreWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    scoreWeights
+		wantErr bool
+	}{
+		{
+			name:  "default",
+			value: DefaultCompatibilityScoreWeights,
+			want:  defaultScoreWeights,
+		},
+		{
+			name:  "empty",
+			value: "",
+			want:  defaultScoreWeights,
+		},
+		{
+			name:  "partial",
+			value: "vcpu=3, price=0.5",
+			want: scoreWeights{
+				vCPU:       3,
+				memory:     1,
+				network:    1,
+				ebs:        1,
+				generation: 1,
+				price:      0.5,
+			},
+		},
+		{
+			name:    "unknown dimension",
+			value:   "disk=1",
+			wantErr: true,
+		},
+		{
+			name:    "negative weight",
+			value:   "vcpu=-1",
+			wantErr: true,
+		},
+		{
+			name:    "missing weight",
+			value:   "vcpu",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseScoreWeights(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseScoreWeights() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseScoreWeights() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseNetworkPerformance(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{value: "Moderate", want: 0.5},
+		{value: "25 Gigabit", want: 25},
+		{value: "12.5 Gigabit", want: 12.5},
+		{value: "Up to 10 Gigabit", want: 5},
+		{value: "4x 100 Gigabit", want: 400},
+		{value: "NA", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseNetworkPerformance(tt.value); got != tt.want {
+				t.Errorf("parseNetworkPerformance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_scoreCandidate(t *testing.T) {
+	i := &instance{
+		typeInfo: instanceTypeInformation{
+			vCPU:               4,
+			memory:             16,
+			networkPerformance: 10,
+			EBSThroughput:      500,
+		},
+		price: 1,
+	}
+
+	tests := []struct {
+		name      string
+		candidate instanceTypeInformation
+		price     float64
+		weights   scoreWeights
+		want      compatibilityScore
+	}{
+		{
+			name: "exact fit",
+			candidate: instanceTypeInformation{
+				vCPU:               4,
+				memory:             16,
+				networkPerformance: 10,
+				EBSThroughput:      500,
+			},
+			price:   0.25,
+			weights: defaultScoreWeights,
+			want: compatibilityScore{
+				vCPU:       1,
+				memory:     1,
+				network:    1,
+				ebs:        1,
+				generation: 1,
+				price:      0.75,
+				total:      (1 + 1 + 1 + 1 + 1 + 2*0.75) / 7,
+			},
+		},
+		{
+			name: "oversized older generation",
+			candidate: instanceTypeInformation{
+				vCPU:               8,
+				memory:             64,
+				networkPerformance: 5,
+				EBSThroughput:      1000,
+				generationDelta:    1,
+			},
+			price:   0.5,
+			weights: defaultScoreWeights,
+			want: compatibilityScore{
+				vCPU:       0.5,
+				memory:     0.25,
+				network:    0.5,
+				ebs:        1,
+				generation: 0.5,
+				price:      0.5,
+				total:      (0.5 + 0.25 + 0.5 + 1 + 0.5 + 2*0.5) / 7,
+			},
+		},
+		{
+			name: "price only",
+			candidate: instanceTypeInformation{
+				vCPU:   8,
+				memory: 64,
+			},
+			price:   0.4,
+			weights: scoreWeights{price: 1},
+			want: compatibilityScore{
+				vCPU:       0.5,
+				memory:     0.25,
+				network:    0,
+				ebs:        0,
+				generation: 1,
+				price:      0.6,
+				total:      0.6,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := i.scoreCandidate(&tt.candidate, tt.price, tt.weights)
+			if math.Abs(got.total-tt.want.total) > 1e-9 {
+				t.Errorf("scoreCandidate() total = %v, want %v", got.total, tt.want.total)
+			}
+			got.total = tt.want.total
+			if math.Abs(got.price-tt.want.price) > 1e-9 {
+				t.Errorf("scoreCandidate() price = %v, want %v", got.price, tt.want.price)
+			}
+			got.price = tt.want.price
+			if got != tt.want {
+				t.Errorf("scoreCandidate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getCompatibleSpotInstanceTypesListBestFit(t *testing.T) {
+	candidate := func(instanceType string, vCPU int, memory float32, spotPrice float64) instanceTypeInformation {
+		return instanceTypeInformation{
+			instanceType:        instanceType,
+			vCPU:                vCPU,
+			memory:              memory,
+			PhysicalProcessor:   "Intel",
+			virtualizationTypes: []string{"HVM"},
+			pricing: prices{
+				spot: map[string]float64{"eu-central-1": spotPrice},
+			},
+		}
+	}
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:         aws.String("i-dummy"),
+			VirtualizationType: aws.String("hvm"),
+			Placement: &ec2.Placement{
+				AvailabilityZone: aws.String("eu-central-1"),
+			},
+		},
+		typeInfo: instanceTypeInformation{
+			instanceType:      "m5.xlarge",
+			PhysicalProcessor: "Intel",
+			vCPU:              4,
+			memory:            16,
+		},
+		price: 1,
+		region: &region{
+			instanceTypeInformation: map[string]instanceTypeInformation{
+				"m5.xlarge":  candidate("m5.xlarge", 4, 16, 0.4),
+				"m5.4xlarge": candidate("m5.4xlarge", 16, 64, 0.3),
+				"r5.xlarge":  candidate("r5.xlarge", 4, 32, 0.45),
+			},
+		},
+		asg: &autoScalingGroup{
+			name:  "test-asg",
+			Group: &autoscaling.Group{},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		bias    string
+		weights string
+		want    []string
+	}{
+		{
+			name: "lower cost",
+			bias: "lower_cost",
+			want: []string{"m5.4xlarge", "m5.xlarge", "r5.xlarge"},
+		},
+		{
+			name: "best fit",
+			bias: BestFitPrioritizationBias,
+			want: []string{"m5.xlarge", "r5.xlarge", "m5.4xlarge"},
+		},
+		{
+			name:    "best fit dominated by price",
+			bias:    BestFitPrioritizationBias,
+			weights: "vcpu=0,memory=0,network=0,ebs=0,generation=0,price=1",
+			want:    []string{"m5.4xlarge", "m5.xlarge", "r5.xlarge"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i.asg.config.CompatibilityScoreWeights = tt.weights
+
+			got, err := i.getCompatibleSpotInstanceTypesList(tt.bias, nil, nil)
+			if err != nil {
+				t.Fatalf("getCompatibleSpotInstanceTypesList() error = %v", err)
+			}
+			if !reflect.DeepEqual(aws.StringValueSlice(got), tt.want) {
+				t.Errorf("getCompatibleSpotInstanceTypesList() = %v, want %v",
+					aws.StringValueSlice(got), tt.want)
+			}
+		})
+	}
+}
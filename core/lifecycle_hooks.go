@@ -0,0 +1,466 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// lifecycle_hooks.go contains the emulation of the launch lifecycle hooks for
+// the spot instances attached to the groups, which unlike the instances
+// launched by the groups don't trigger them. The notifications of the hooks are
+// published to their targets, and the spot instances are only attached once
+// the hooks were completed or timed out, just like new instances only go
+// InService then. The spot instances whose hooks were abandoned are terminated.
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	lifecycleTransitionLaunching = "autoscaling:EC2_INSTANCE_LAUNCHING"
+
+	codeDeployLifecycleHookPrefix = "CodeDeploy-managed-automatic-launch-deployment-hook"
+
+	lifecycleActionResultContinue = "CONTINUE"
+	lifecycleActionResultAbandon  = "ABANDON"
+
+	// the CloudTrail event of the heartbeats extending the lifecycle hooks
+	recordLifecycleActionHeartbeatEvent = "RecordLifecycleActionHeartbeat"
+
+	// the default heartbeat timeout of the lifecycle hooks, in seconds
+	defaultLifecycleHookHeartbeatTimeout = 3600
+
+	// the EventBridge events of the emulated hooks can't use the aws.autoscaling
+	// source reserved for AWS, so the rules matching them need to also match
+	// this source
+	lifecycleHookEventSource     = "autospotting"
+	lifecycleHookEventDetailType = "EC2 Instance-launch Lifecycle Action"
+)
+
+// errLifecycleActionAbandoned is returned when the launch lifecycle hooks of
+// the spot instance were abandoned, which abandons the replacement.
+var errLifecycleActionAbandoned = errors.New("launch lifecycle action abandoned")
+
+// lifecycleHookNotification is the payload of the notifications sent for the
+// lifecycle hooks, matching the one sent by AutoScaling.
+type lifecycleHookNotification struct {
+	Origin               string `json:"Origin"`
+	Destination          string `json:"Destination"`
+	Service              string `json:"Service,omitempty"`
+	Time                 string `json:"Time,omitempty"`
+	AccountID            string `json:"AccountId,omitempty"`
+	RequestID            string `json:"RequestId,omitempty"`
+	LifecycleTransition  string `json:"LifecycleTransition"`
+	LifecycleActionToken string `json:"LifecycleActionToken"`
+	EC2InstanceID        string `json:"EC2InstanceId"`
+	LifecycleHookName    string `json:"LifecycleHookName"`
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	NotificationMetadata string `json:"NotificationMetadata,omitempty"`
+}
+
+// newUUID returns a random UUID, used for the lifecycle action tokens.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// launchLifecycleHooks returns the launch lifecycle hooks of the group which
+// need to be emulated. The hooks managed by CodeDeploy are left out since
+// they're handled by triggering a deployment instead.
+func (a *autoScalingGroup) launchLifecycleHooks() ([]*autoscaling.LifecycleHook, error) {
+	resp, err := a.region.services.autoScaling.DescribeLifecycleHooksWithContext(
+		a.region.runContext(),
+		&autoscaling.DescribeLifecycleHooksInput{
+			AutoScalingGroupName: aws.String(a.name),
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []*autoscaling.LifecycleHook
+	for _, hook := range resp.LifecycleHooks {
+		if aws.StringValue(hook.LifecycleTransition) != lifecycleTransitionLaunching ||
+			strings.HasPrefix(aws.StringValue(hook.LifecycleHookName), codeDeployLifecycleHookPrefix) {
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// accountID returns the ID of the AWS account owning the group.
+func (a *autoScalingGroup) accountID() string {
+	if a.Group == nil {
+		return ""
+	}
+	groupARN, err := arn.Parse(aws.StringValue(a.AutoScalingGroupARN))
+	if err != nil {
+		return ""
+	}
+	return groupARN.AccountID
+}
+
+func (a *autoScalingGroup) lifecycleHookNotification(hook *autoscaling.LifecycleHook,
+	instanceID, token string) lifecycleHookNotification {
+	return lifecycleHookNotification{
+		Origin:               "EC2",
+		Destination:          "AutoScalingGroup",
+		Service:              "AWS Auto Scaling",
+		Time:                 time.Now().UTC().Format(time.RFC3339Nano),
+		AccountID:            a.accountID(),
+		RequestID:            newUUID(),
+		LifecycleTransition:  lifecycleTransitionLaunching,
+		LifecycleActionToken: token,
+		EC2InstanceID:        instanceID,
+		LifecycleHookName:    aws.StringValue(hook.LifecycleHookName),
+		AutoScalingGroupName: a.name,
+		NotificationMetadata: aws.StringValue(hook.NotificationMetadata),
+	}
+}
+
+// publishLifecycleHookNotification sends the notification of the hook to its
+// SNS topic or SQS queue, or to EventBridge when it has no notification target.
+func (a *autoScalingGroup) publishLifecycleHookNotification(hook *autoscaling.LifecycleHook,
+	n lifecycleHookNotification) error {
+	svc := a.region.services
+	ctx := a.region.runContext()
+
+	target := aws.StringValue(hook.NotificationTargetARN)
+	if target == "" {
+		// the events sent by AutoScaling don't include these fields
+		n.Service, n.Time, n.AccountID, n.RequestID = "", "", "", ""
+
+		detail, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+
+		resp, err := svc.eventBridge.PutEventsWithContext(ctx, &eventbridge.PutEventsInput{
+			Entries: []*eventbridge.PutEventsRequestEntry{{
+				Source:     aws.String(lifecycleHookEventSource),
+				DetailType: aws.String(lifecycleHookEventDetailType),
+				Detail:     aws.String(string(detail)),
+				Resources:  []*string{a.AutoScalingGroupARN},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+		if aws.Int64Value(resp.FailedEntryCount) > 0 && len(resp.Entries) > 0 {
+			return fmt.Errorf("couldn't put the event: %s", aws.StringValue(resp.Entries[0].ErrorMessage))
+		}
+		return nil
+	}
+
+	message, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	targetARN, err := arn.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	switch targetARN.Service {
+	case "sns":
+		_, err = svc.sns.PublishWithContext(ctx, &sns.PublishInput{
+			TopicArn: aws.String(target),
+			Subject:  aws.String(fmt.Sprintf("Auto Scaling:  Lifecycle action 'LAUNCHING' for instance %s in progress.", n.EC2InstanceID)),
+			Message:  aws.String(string(message)),
+		})
+		return err
+	case "sqs":
+		queue, err := svc.regionalSQS.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+			QueueName:              aws.String(targetARN.Resource),
+			QueueOwnerAWSAccountId: aws.String(targetARN.AccountID),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = svc.regionalSQS.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+			QueueUrl:    queue.QueueUrl,
+			MessageBody: aws.String(string(message)),
+		})
+		return err
+	}
+	return fmt.Errorf("unsupported notification target %s", target)
+}
+
+// heartbeatTimeout returns the time the hook waits for being completed, unless
+// a heartbeat is recorded for it.
+func heartbeatTimeout(hook *autoscaling.LifecycleHook) time.Duration {
+	heartbeat := aws.Int64Value(hook.HeartbeatTimeout)
+	if heartbeat <= 0 {
+		heartbeat = defaultLifecycleHookHeartbeatTimeout
+	}
+	return time.Duration(heartbeat) * time.Second
+}
+
+// startLaunchLifecycleHooks emulates the launch lifecycle hooks of the group
+// for the spot instance before attaching it.
+func (m *replacementMachine) startLaunchLifecycleHooks() (replacementState, error) {
+	if m.emulateLaunchLifecycleHooks() {
+		return replacementLifecycleHooksPending, nil
+	}
+	return replacementReady, nil
+}
+
+// emulateLaunchLifecycleHooks publishes the notifications of the launch
+// lifecycle hooks of the group for the spot instance, and records them as
+// pending until they're completed or time out. It returns false if there are
+// no hooks to wait for.
+func (m *replacementMachine) emulateLaunchLifecycleHooks() bool {
+	if !m.asg.config.EmulateLaunchLifecycleHooks {
+		return false
+	}
+
+	hooks, err := m.asg.launchLifecycleHooks()
+	if err != nil {
+		log.Printf("%s Couldn't describe the lifecycle hooks: %s", m.asg.name, err.Error())
+		return false
+	}
+	if len(hooks) == 0 {
+		return false
+	}
+
+	m.lifecycleActionToken = newUUID()
+	m.pendingLifecycleHooks = nil
+	m.lifecycleActionResult = ""
+
+	var timeout time.Duration
+	for _, hook := range hooks {
+		name := aws.StringValue(hook.LifecycleHookName)
+
+		n := m.asg.lifecycleHookNotification(hook, m.spotInstanceID, m.lifecycleActionToken)
+		if err := m.asg.publishLifecycleHookNotification(hook, n); err != nil {
+			// just like for the instances launched by the group, the hook is
+			// left to time out if its notification can't be delivered
+			log.Printf("%s Couldn't publish the notification of lifecycle hook %s for spot instance %s: %s",
+				m.asg.name, name, m.spotInstanceID, err.Error())
+		} else {
+			log.Printf("%s Published the notification of lifecycle hook %s for spot instance %s",
+				m.asg.name, name, m.spotInstanceID)
+		}
+
+		m.pendingLifecycleHooks = append(m.pendingLifecycleHooks, name)
+
+		if heartbeat := heartbeatTimeout(hook); heartbeat > timeout {
+			timeout = heartbeat
+		}
+	}
+
+	m.lifecycleHooksDeadline = time.Now().Add(timeout)
+	return true
+}
+
+func (a *autoScalingGroup) describeLifecycleHooks(names []string) ([]*autoscaling.LifecycleHook, error) {
+	resp, err := a.region.services.autoScaling.DescribeLifecycleHooksWithContext(
+		a.region.runContext(),
+		&autoscaling.DescribeLifecycleHooksInput{
+			AutoScalingGroupName: aws.String(a.name),
+			LifecycleHookNames:   aws.StringSlice(names),
+		})
+	if err != nil {
+		return nil, err
+	}
+	return resp.LifecycleHooks, nil
+}
+
+// lifecycleHooksDefaultResult returns the result of the hooks which timed out,
+// which is ABANDON if any of them abandons the launch by default.
+func (a *autoScalingGroup) lifecycleHooksDefaultResult(names []string) (string, error) {
+	hooks, err := a.describeLifecycleHooks(names)
+	if err != nil {
+		return "", err
+	}
+
+	for _, hook := range hooks {
+		if aws.StringValue(hook.DefaultResult) == lifecycleActionResultAbandon {
+			return lifecycleActionResultAbandon, nil
+		}
+	}
+	return lifecycleActionResultContinue, nil
+}
+
+// waitForLaunchLifecycleHooks keeps the spot instance out of the group until
+// its emulated launch lifecycle hooks are completed or time out. When they're
+// abandoned, the replacement fails and the spot instance is terminated, while
+// the on-demand instance is kept.
+func (m *replacementMachine) waitForLaunchLifecycleHooks() (replacementState, error) {
+	result := m.lifecycleActionResult
+
+	if result == "" && len(m.pendingLifecycleHooks) == 0 {
+		result = lifecycleActionResultContinue
+	}
+
+	if result == "" {
+		if time.Now().Before(m.lifecycleHooksDeadline) {
+			return m.state, fmt.Errorf("%w: waiting until %s for the lifecycle hooks %s of spot instance %s",
+				errReplacementPending, m.lifecycleHooksDeadline.UTC().Format(time.RFC3339),
+				strings.Join(m.pendingLifecycleHooks, ","), m.spotInstanceID)
+		}
+
+		defaultResult, err := m.asg.lifecycleHooksDefaultResult(m.pendingLifecycleHooks)
+		if err != nil {
+			return m.state, fmt.Errorf("%w: couldn't describe the lifecycle hooks: %s",
+				errReplacementPending, err.Error())
+		}
+		log.Printf("%s Lifecycle hooks %s of spot instance %s timed out, using their default result %s",
+			m.asg.name, strings.Join(m.pendingLifecycleHooks, ","), m.spotInstanceID, defaultResult)
+		result = defaultResult
+	}
+
+	if result == lifecycleActionResultAbandon {
+		log.Printf("%s Lifecycle action of spot instance %s was abandoned, keeping on-demand instance %s",
+			m.asg.name, m.spotInstanceID, m.onDemandInstanceID)
+		return m.state, errLifecycleActionAbandoned
+	}
+	return replacementReady, nil
+}
+
+// completeLifecycleAction records the completion of a pending lifecycle hook
+// of the spot instance, and tells if there was any such hook pending.
+func (r *replacement) completeLifecycleAction(hookName, result string) bool {
+	for n, name := range r.pendingLifecycleHooks {
+		if name != hookName {
+			continue
+		}
+
+		r.pendingLifecycleHooks = append(r.pendingLifecycleHooks[:n:n], r.pendingLifecycleHooks[n+1:]...)
+		if result == lifecycleActionResultAbandon {
+			r.lifecycleActionResult = lifecycleActionResultAbandon
+		}
+		return true
+	}
+	return false
+}
+
+// findLifecycleActionInstance returns the ID of the spot instance whose
+// emulated lifecycle hooks were given the lifecycle action token.
+func (r *region) findLifecycleActionInstance(token string) (string, error) {
+	var instanceID string
+
+	err := r.services.ec2.DescribeInstancesPagesWithContext(
+		r.runContext(),
+		&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("tag:" + replacementLifecycleActionTokenTag),
+					Values: []*string{aws.String(token)},
+				},
+			},
+		},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			if page == nil {
+				return false
+			}
+			for _, res := range page.Reservations {
+				for _, inst := range res.Instances {
+					instanceID = aws.StringValue(inst.InstanceId)
+					return false
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return "", err
+	}
+
+	if instanceID == "" {
+		return "", fmt.Errorf("no instance found for the lifecycle action token %s", token)
+	}
+	return instanceID, nil
+}
+
+// handleEmulatedLifecycleAction handles the heartbeats and the completion of
+// the emulated lifecycle hooks of a spot instance, resuming its replacement
+// once they're completed. It returns false if the spot instance isn't waiting
+// for its hooks.
+func (i *instance) handleEmulatedLifecycleAction(ctEvent CloudTrailEvent) (bool, error) {
+	params := ctEvent.RequestParameters
+
+	store := i.region.replacementStore()
+	r, err := store.load(i)
+	if err != nil || r == nil || r.state != replacementLifecycleHooksPending {
+		return false, err
+	}
+
+	if params.LifecycleActionToken != "" && params.LifecycleActionToken != r.lifecycleActionToken {
+		return true, fmt.Errorf("lifecycle action token mismatch for spot instance %s", *i.InstanceId)
+	}
+
+	if ctEvent.EventName == recordLifecycleActionHeartbeatEvent {
+		return true, i.recordLifecycleActionHeartbeat(store, r, params.LifecycleHookName)
+	}
+
+	if !r.completeLifecycleAction(params.LifecycleHookName, params.LifecycleActionResult) {
+		log.Printf("%s Lifecycle hook %s of spot instance %s isn't pending, skipping...",
+			i.region.name, params.LifecycleHookName, *i.InstanceId)
+		return true, nil
+	}
+
+	log.Printf("%s Lifecycle hook %s of spot instance %s completed with result %s",
+		i.region.name, params.LifecycleHookName, *i.InstanceId, params.LifecycleActionResult)
+
+	r.updated = time.Now()
+	if err := store.save(r); err != nil {
+		return true, err
+	}
+
+	asg := i.region.findEnabledASGByName(r.asgName)
+	if asg == nil {
+		return true, fmt.Errorf("region %s is missing asg data", i.region.name)
+	}
+	return true, newReplacementMachine(asg, i, r).run()
+}
+
+// recordLifecycleActionHeartbeat extends the deadline of the emulated
+// lifecycle hooks of the spot instance by the heartbeat timeout of the hook,
+// just like the heartbeats of the hooks run by AutoScaling.
+func (i *instance) recordLifecycleActionHeartbeat(store replacementStore, r *replacement, hookName string) error {
+	if !itemInSlice(hookName, r.pendingLifecycleHooks) {
+		log.Printf("%s Lifecycle hook %s of spot instance %s isn't pending, skipping...",
+			i.region.name, hookName, *i.InstanceId)
+		return nil
+	}
+
+	asg := i.region.findEnabledASGByName(r.asgName)
+	if asg == nil {
+		return fmt.Errorf("region %s is missing asg data", i.region.name)
+	}
+
+	hooks, err := asg.describeLifecycleHooks([]string{hookName})
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return fmt.Errorf("lifecycle hook %s of the group %s is missing", hookName, r.asgName)
+	}
+
+	deadline := time.Now().Add(heartbeatTimeout(hooks[0]))
+	if !deadline.After(r.lifecycleHooksDeadline) {
+		return nil
+	}
+
+	log.Printf("%s Recorded heartbeat of lifecycle hook %s of spot instance %s, waiting until %s",
+		i.region.name, hookName, *i.InstanceId, deadline.UTC().Format(time.RFC3339))
+
+	r.lifecycleHooksDeadline, r.updated = deadline, time.Now()
+	return store.save(r)
+}
@@ -0,0 +1,424 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func lifecycleHooksOutput(hooks ...*autoscaling.LifecycleHook) *autoscaling.DescribeLifecycleHooksOutput {
+	return &autoscaling.DescribeLifecycleHooksOutput{LifecycleHooks: hooks}
+}
+
+func launchLifecycleHook(name string, heartbeat int64, defaultResult string) *autoscaling.LifecycleHook {
+	return &autoscaling.LifecycleHook{
+		LifecycleHookName:   aws.String(name),
+		LifecycleTransition: aws.String(lifecycleTransitionLaunching),
+		HeartbeatTimeout:    aws.Int64(heartbeat),
+		DefaultResult:       aws.String(defaultResult),
+	}
+}
+
+func lifecycleHooksGroup(services connections) *autoScalingGroup {
+	return &autoScalingGroup{
+		name: "asg",
+		Group: &autoscaling.Group{
+			AutoScalingGroupName: aws.String("asg"),
+			AutoScalingGroupARN:  aws.String("arn:aws:autoscaling:us-east-1:123456789012:autoScalingGroup:uuid:autoScalingGroupName/asg"),
+		},
+		region: &region{name: "us-east-1", conf: &Config{}, services: services},
+	}
+}
+
+func Test_autoScalingGroup_launchLifecycleHooks(t *testing.T) {
+	a := lifecycleHooksGroup(connections{autoScaling: mockASG{dlho: lifecycleHooksOutput(
+		launchLifecycleHook("bootstrap", 300, lifecycleActionResultContinue),
+		launchLifecycleHook(codeDeployLifecycleHookPrefix+"-foo", 300, lifecycleActionResultAbandon),
+		&autoscaling.LifecycleHook{
+			LifecycleHookName:   aws.String("drain"),
+			LifecycleTransition: aws.String("autoscaling:EC2_INSTANCE_TERMINATING"),
+		},
+	)}})
+
+	hooks, err := a.launchLifecycleHooks()
+	if err != nil {
+		t.Fatalf("launchLifecycleHooks() error = %v", err)
+	}
+	if len(hooks) != 1 || *hooks[0].LifecycleHookName != "bootstrap" {
+		t.Errorf("launchLifecycleHooks() = %v, want only the bootstrap hook", hooks)
+	}
+}
+
+func Test_autoScalingGroup_lifecycleHookNotification(t *testing.T) {
+	a := lifecycleHooksGroup(connections{})
+	hook := launchLifecycleHook("bootstrap", 300, lifecycleActionResultContinue)
+	hook.NotificationMetadata = aws.String("metadata")
+
+	got := a.lifecycleHookNotification(hook, "i-spot", "token")
+
+	if got.AccountID != "123456789012" || got.EC2InstanceID != "i-spot" ||
+		got.LifecycleActionToken != "token" || got.LifecycleHookName != "bootstrap" ||
+		got.AutoScalingGroupName != "asg" || got.NotificationMetadata != "metadata" ||
+		got.LifecycleTransition != lifecycleTransitionLaunching {
+		t.Errorf("lifecycleHookNotification() = %+v", got)
+	}
+}
+
+func Test_autoScalingGroup_publishLifecycleHookNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		services connections
+		wantErr  bool
+	}{
+		{
+			name:     "EventBridge",
+			services: connections{eventBridge: mockEventBridge{}},
+		},
+		{
+			name: "EventBridge failed entry",
+			services: connections{eventBridge: mockEventBridge{peo: &eventbridge.PutEventsOutput{
+				FailedEntryCount: aws.Int64(1),
+				Entries:          []*eventbridge.PutEventsResultEntry{{ErrorMessage: aws.String("error")}},
+			}}},
+			wantErr: true,
+		},
+		{
+			name:     "SNS topic",
+			target:   "arn:aws:sns:us-east-1:123456789012:topic",
+			services: connections{sns: mockSNS{}},
+		},
+		{
+			name:     "SNS failure",
+			target:   "arn:aws:sns:us-east-1:123456789012:topic",
+			services: connections{sns: mockSNS{perr: errors.New("error")}},
+			wantErr:  true,
+		},
+		{
+			name:   "SQS queue",
+			target: "arn:aws:sqs:us-east-1:123456789012:queue",
+			services: connections{regionalSQS: mockSQS{
+				gquo: &sqs.GetQueueUrlOutput{QueueUrl: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/queue")},
+			}},
+		},
+		{
+			name:     "missing SQS queue",
+			target:   "arn:aws:sqs:us-east-1:123456789012:queue",
+			services: connections{regionalSQS: mockSQS{gquerr: errors.New("error")}},
+			wantErr:  true,
+		},
+		{
+			name:    "unsupported target",
+			target:  "arn:aws:lambda:us-east-1:123456789012:function:foo",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := lifecycleHooksGroup(tt.services)
+			hook := launchLifecycleHook("bootstrap", 300, lifecycleActionResultContinue)
+			if tt.target != "" {
+				hook.NotificationTargetARN = aws.String(tt.target)
+			}
+
+			err := a.publishLifecycleHookNotification(hook, a.lifecycleHookNotification(hook, "i-spot", "token"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("publishLifecycleHookNotification() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_replacementMachine_emulateLaunchLifecycleHooks(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		asg         mockASG
+		want        bool
+		wantPending []string
+		wantTimeout time.Duration
+	}{
+		{
+			name:    "disabled",
+			enabled: false,
+			asg:     mockASG{dlho: lifecycleHooksOutput(launchLifecycleHook("bootstrap", 300, lifecycleActionResultContinue))},
+			want:    false,
+		},
+		{
+			name:    "no lifecycle hooks",
+			enabled: true,
+			asg:     mockASG{dlho: lifecycleHooksOutput()},
+			want:    false,
+		},
+		{
+			name:    "describe failure",
+			enabled: true,
+			asg:     mockASG{dlherr: errors.New("error")},
+			want:    false,
+		},
+		{
+			name:    "lifecycle hooks",
+			enabled: true,
+			asg: mockASG{dlho: lifecycleHooksOutput(
+				launchLifecycleHook("bootstrap", 300, lifecycleActionResultContinue),
+				launchLifecycleHook("register", 0, lifecycleActionResultAbandon),
+			)},
+			want:        true,
+			wantPending: []string{"bootstrap", "register"},
+			wantTimeout: defaultLifecycleHookHeartbeatTimeout * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := lifecycleHooksGroup(connections{autoScaling: tt.asg, eventBridge: mockEventBridge{}})
+			a.config.EmulateLaunchLifecycleHooks = tt.enabled
+
+			m := &replacementMachine{
+				replacement: &replacement{state: replacementRunning, spotInstanceID: "i-spot"},
+				asg:         a,
+			}
+
+			if got := m.emulateLaunchLifecycleHooks(); got != tt.want {
+				t.Errorf("emulateLaunchLifecycleHooks() = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+
+			if !reflect.DeepEqual(m.pendingLifecycleHooks, tt.wantPending) {
+				t.Errorf("pending lifecycle hooks = %v, want %v", m.pendingLifecycleHooks, tt.wantPending)
+			}
+			if m.lifecycleActionToken == "" {
+				t.Errorf("lifecycle action token wasn't set")
+			}
+			if timeout := time.Until(m.lifecycleHooksDeadline); timeout > tt.wantTimeout || timeout < tt.wantTimeout-time.Minute {
+				t.Errorf("lifecycle hooks deadline = %v, want %v from now", m.lifecycleHooksDeadline, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func Test_replacementMachine_waitForLaunchLifecycleHooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		pending  []string
+		deadline time.Time
+		result   string
+		asg      mockASG
+		want     replacementState
+		wantErr  error
+	}{
+		{
+			name:    "completed",
+			pending: nil,
+			want:    replacementReady,
+		},
+		{
+			name:     "pending",
+			pending:  []string{"bootstrap"},
+			deadline: time.Now().Add(time.Hour),
+			want:     replacementLifecycleHooksPending,
+			wantErr:  errReplacementPending,
+		},
+		{
+			name:     "timed out with CONTINUE",
+			pending:  []string{"bootstrap"},
+			deadline: time.Now().Add(-time.Minute),
+			asg:      mockASG{dlho: lifecycleHooksOutput(launchLifecycleHook("bootstrap", 300, lifecycleActionResultContinue))},
+			want:     replacementReady,
+		},
+		{
+			name:     "timed out with ABANDON",
+			pending:  []string{"bootstrap"},
+			deadline: time.Now().Add(-time.Minute),
+			asg:      mockASG{dlho: lifecycleHooksOutput(launchLifecycleHook("bootstrap", 300, lifecycleActionResultAbandon))},
+			want:     replacementLifecycleHooksPending,
+			wantErr:  errLifecycleActionAbandoned,
+		},
+		{
+			name:     "timed out and describe failure",
+			pending:  []string{"bootstrap"},
+			deadline: time.Now().Add(-time.Minute),
+			asg:      mockASG{dlherr: errors.New("error")},
+			want:     replacementLifecycleHooksPending,
+			wantErr:  errReplacementPending,
+		},
+		{
+			name:    "abandoned",
+			pending: []string{"register"},
+			result:  lifecycleActionResultAbandon,
+			asg:     mockASG{dlho: lifecycleHooksOutput()},
+			want:    replacementLifecycleHooksPending,
+			wantErr: errLifecycleActionAbandoned,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &replacementMachine{
+				replacement: &replacement{
+					state:                  replacementLifecycleHooksPending,
+					spotInstanceID:         "i-spot",
+					onDemandInstanceID:     "i-ondemand",
+					pendingLifecycleHooks:  tt.pending,
+					lifecycleHooksDeadline: tt.deadline,
+					lifecycleActionResult:  tt.result,
+				},
+				asg: lifecycleHooksGroup(connections{autoScaling: tt.asg}),
+			}
+
+			got, err := m.waitForLaunchLifecycleHooks()
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("waitForLaunchLifecycleHooks() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("waitForLaunchLifecycleHooks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_replacement_completeLifecycleAction(t *testing.T) {
+	r := &replacement{pendingLifecycleHooks: []string{"bootstrap", "register"}}
+	pending := r.pendingLifecycleHooks
+
+	if r.completeLifecycleAction("foo", lifecycleActionResultContinue) {
+		t.Errorf("completeLifecycleAction() = true, want false for a hook which isn't pending")
+	}
+
+	if !r.completeLifecycleAction("bootstrap", lifecycleActionResultContinue) {
+		t.Errorf("completeLifecycleAction() = false, want true")
+	}
+	if !reflect.DeepEqual(r.pendingLifecycleHooks, []string{"register"}) || r.lifecycleActionResult != "" {
+		t.Errorf("completeLifecycleAction() left %v pending with result %q",
+			r.pendingLifecycleHooks, r.lifecycleActionResult)
+	}
+
+	// the previously loaded state isn't changed
+	if !reflect.DeepEqual(pending, []string{"bootstrap", "register"}) {
+		t.Errorf("completeLifecycleAction() changed the previous pending hooks to %v", pending)
+	}
+
+	if !r.completeLifecycleAction("register", lifecycleActionResultAbandon) {
+		t.Errorf("completeLifecycleAction() = false, want true")
+	}
+	if len(r.pendingLifecycleHooks) != 0 || r.lifecycleActionResult != lifecycleActionResultAbandon {
+		t.Errorf("completeLifecycleAction() left %v pending with result %q",
+			r.pendingLifecycleHooks, r.lifecycleActionResult)
+	}
+}
+
+func Test_region_findLifecycleActionInstance(t *testing.T) {
+	tests := []struct {
+		name    string
+		ec2     mockEC2
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "found",
+			ec2: mockEC2{dio: &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+				Instances: []*ec2.Instance{{InstanceId: aws.String("i-spot")}},
+			}}}},
+			want: "i-spot",
+		},
+		{
+			name:    "not found",
+			ec2:     mockEC2{dio: &ec2.DescribeInstancesOutput{}},
+			wantErr: true,
+		},
+		{
+			name:    "describe failure",
+			ec2:     mockEC2{diperr: errors.New("error")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1", services: connections{ec2: tt.ec2}}
+			got, err := r.findLifecycleActionInstance("token")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findLifecycleActionInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("findLifecycleActionInstance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_recordLifecycleActionHeartbeat(t *testing.T) {
+	tests := []struct {
+		name         string
+		hookName     string
+		deadline     time.Duration
+		asg          mockASG
+		wantDeadline time.Duration
+		wantErr      bool
+	}{
+		{
+			name:         "deadline extended by the heartbeat timeout",
+			hookName:     "bootstrap",
+			deadline:     10 * time.Minute,
+			asg:          mockASG{dlho: lifecycleHooksOutput(launchLifecycleHook("bootstrap", 1800, lifecycleActionResultContinue))},
+			wantDeadline: 30 * time.Minute,
+		},
+		{
+			name:         "later deadline kept",
+			hookName:     "bootstrap",
+			deadline:     time.Hour,
+			asg:          mockASG{dlho: lifecycleHooksOutput(launchLifecycleHook("bootstrap", 1800, lifecycleActionResultContinue))},
+			wantDeadline: time.Hour,
+		},
+		{
+			name:         "hook not pending",
+			hookName:     "register",
+			deadline:     10 * time.Minute,
+			wantDeadline: 10 * time.Minute,
+		},
+		{
+			name:         "describe failure",
+			hookName:     "bootstrap",
+			deadline:     10 * time.Minute,
+			asg:          mockASG{dlherr: errors.New("error")},
+			wantDeadline: 10 * time.Minute,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asg := lifecycleHooksGroup(connections{autoScaling: tt.asg})
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-spot")},
+				region:   &region{name: "us-east-1", enabledASGs: []autoScalingGroup{*asg}},
+			}
+
+			start := time.Now()
+			r := &replacement{
+				state:                  replacementLifecycleHooksPending,
+				spotInstanceID:         "i-spot",
+				asgName:                "asg",
+				pendingLifecycleHooks:  []string{"bootstrap"},
+				lifecycleHooksDeadline: start.Add(tt.deadline),
+			}
+
+			err := i.recordLifecycleActionHeartbeat(newMemoryReplacementStore(), r, tt.hookName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("recordLifecycleActionHeartbeat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := r.lifecycleHooksDeadline.Sub(start); got < tt.wantDeadline || got > tt.wantDeadline+time.Minute {
+				t.Errorf("lifecycle hooks deadline = %v from now, want %v", got, tt.wantDeadline)
+			}
+		})
+	}
+}
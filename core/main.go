@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,7 +17,6 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	ec2instancesinfo "github.com/cristim/ec2-instances-info"
@@ -44,6 +44,7 @@ func (a *AutoSpotting) Init(cfg *Config) {
 	cfg.InstanceData = data
 	a.config = cfg
 	a.config.setupLogging()
+	apiCalls.configure(a.config)
 	// use this only to list all the other regions
 	a.mainEC2Conn = connectEC2(a.config.MainRegion)
 	as = a
@@ -61,21 +62,21 @@ func RunningFromLambda() bool {
 // ProcessCronEvent starts processing all AWS regions looking for AutoScaling groups
 // enabled and taking action by replacing more pricy on-demand instances with
 // compatible and cheaper spot instances.
-func (a *AutoSpotting) ProcessCronEvent() {
+func (a *AutoSpotting) ProcessCronEvent(ctx context.Context) {
 	// Clear FinalRecap map
 	a.config.FinalRecap = make(map[string][]string)
 
 	a.config.addDefaultFilteringMode()
 	a.config.addDefaultFilter()
 
-	allRegions, err := a.getRegions()
+	allRegions, err := a.getRegions(ctx)
 
 	if err != nil {
 		log.Println(err.Error())
 		return
 	}
 
-	a.processRegions(allRegions)
+	a.processRegions(ctx, allRegions)
 
 	// Print Final Recap
 	log.Println("####### BEGIN FINAL RECAP #######")
@@ -122,13 +123,13 @@ func (cfg *Config) setupLogging() {
 // processAllRegions iterates all regions in parallel, and replaces instances
 // for each of the ASGs tagged with tags as specified by slice represented by cfg.FilterByTags
 // by default this is all asg with the tag 'spot-enabled=true'.
-func (a *AutoSpotting) processRegions(regions []string) {
+func (a *AutoSpotting) processRegions(ctx context.Context, regions []string) {
 	var wg sync.WaitGroup
 	var savingsMutex sync.RWMutex
 
 	for _, r := range regions {
 		wg.Add(1)
-		r := region{name: r, conf: a.config}
+		r := region{name: r, conf: a.config, ctx: ctx}
 		go func() {
 			s := r.calculateSavings()
 			savingsMutex.Lock()
@@ -142,7 +143,7 @@ func (a *AutoSpotting) processRegions(regions []string) {
 	log.Println("Total hourly savings:", totalSavings)
 	if strings.Contains(as.config.Version, "stable") {
 		log.Println("Running a stable build, submitting AWS marketplace metering data")
-		if err := meterMarketplaceUsage(totalSavings); err != nil {
+		if err := meterMarketplaceUsage(ctx, totalSavings); err != nil {
 			log.Println("Failed marketplace metering, exiting... Encountered error:", err.Error())
 			return
 		}
@@ -157,10 +158,12 @@ func (a *AutoSpotting) processRegions(regions []string) {
 
 	for _, r := range regions {
 		wg.Add(1)
-		r := region{name: r, conf: a.config, autospotting: a}
+		r := region{name: r, conf: a.config, autospotting: a, ctx: ctx}
 
 		go func() {
-			if r.enabled() {
+			if ctx.Err() != nil {
+				log.Println("Run cancelled, skipping region", r.name)
+			} else if r.enabled() {
 				log.Printf("Enabled to run in %s, processing region.\n", r.name)
 				r.processRegion()
 			} else {
@@ -176,22 +179,21 @@ func (a *AutoSpotting) processRegions(regions []string) {
 
 func connectEC2(region string) *ec2.EC2 {
 
-	sess, err := session.NewSession()
+	sess, err := newAPISession(region)
 	if err != nil {
 		panic(err)
 	}
 
-	return ec2.New(sess,
-		aws.NewConfig().WithRegion(region))
+	return ec2.New(sess)
 }
 
 // getRegions generates a list of AWS regions.
-func (a *AutoSpotting) getRegions() ([]string, error) {
+func (a *AutoSpotting) getRegions(ctx context.Context) ([]string, error) {
 	var output []string
 
 	log.Println("Scanning for available AWS regions")
 
-	resp, err := a.mainEC2Conn.DescribeRegions(&ec2.DescribeRegionsInput{})
+	resp, err := a.mainEC2Conn.DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
 
 	if err != nil {
 		log.Println(err.Error())
@@ -245,7 +247,7 @@ func (a *AutoSpotting) convertRawEventToCloudwatchEvent(event *json.RawMessage)
 }
 
 // parse instance events and execute the relative methods
-func (a *AutoSpotting) processEventInstance(eventType string, region string, instanceID *string, instanceState *string) error {
+func (a *AutoSpotting) processEventInstance(ctx context.Context, eventType string, region string, instanceID *string, instanceState *string) error {
 	if eventType == InstanceStateChangeNotificationCode {
 		if a.config.DisableEventBasedInstanceReplacement {
 			log.Println("Event-based instance replacement is disabled, exiting...")
@@ -255,14 +257,14 @@ func (a *AutoSpotting) processEventInstance(eventType string, region string, ins
 		if len(a.config.sqsReceiptHandle) != 0 {
 			log.SetPrefix(fmt.Sprintf("SQS:%s ", *instanceID))
 		}
-		a.handleNewInstanceLaunch(region, *instanceID, *instanceState)
+		a.handleNewInstanceLaunch(ctx, region, *instanceID, *instanceState)
 	} else if eventType == SpotInstanceInterruptionWarningCode || eventType == InstanceRebalanceRecommendationCode {
 		if eventType == InstanceRebalanceRecommendationCode && a.config.DisableInstanceRebalanceRecommendation {
 			log.Println("Handling of instance rebalance recommendation events is disabled, exiting...")
 			return nil
 		}
 		// If the event is for an Instance Spot Interruption/Rebalance
-		spotTermination := newSpotTermination(region)
+		spotTermination := newSpotTermination(ctx, region, a.config)
 
 		if spotTermination.IsInAutoSpottingASG(instanceID, a.config.TagFilteringMode, a.config.FilterByTags) {
 			err := spotTermination.executeAction(instanceID, a.config.TerminationNotificationAction, eventType)
@@ -279,7 +281,7 @@ func (a *AutoSpotting) processEventInstance(eventType string, region string, ins
 }
 
 // parse event and execute the relative methods
-func (a *AutoSpotting) processEvent(event *json.RawMessage) error {
+func (a *AutoSpotting) processEvent(ctx context.Context, event *json.RawMessage) error {
 	cloudwatchEvent, err := a.convertRawEventToCloudwatchEvent(event)
 	if err != nil {
 		log.Println("Couldn't parse event", string(*event), err.Error())
@@ -303,30 +305,38 @@ func (a *AutoSpotting) processEvent(event *json.RawMessage) error {
 		instanceID != nil {
 		// Handle Instance Events
 		log.SetPrefix(fmt.Sprintf("%s:%s ", eventType, *instanceID))
-		a.processEventInstance(eventType, cloudwatchEvent.Region, instanceID, instanceState)
+		a.processEventInstance(ctx, eventType, cloudwatchEvent.Region, instanceID, instanceState)
 	} else if eventType == AWSAPICallCloudTrailCode {
 		// CloudTrail
-		a.handleLifecycleHookEvent(*cloudwatchEvent)
+		a.handleLifecycleHookEvent(ctx, *cloudwatchEvent)
 	} else if eventType == ScheduledEventCode {
 		// Cron Scheduling
-		a.ProcessCronEvent()
+		a.ProcessCronEvent(ctx)
 	}
 
 	return nil
 }
 
 // EventHandler implements the event handling logic and is the main entrypoint of
-// AutoSpotting
-func (a *AutoSpotting) EventHandler(event *json.RawMessage) {
+// AutoSpotting. The work is cancelled shortly before the deadline of the
+// received context, leaving enough time for restoring the state of the groups
+// being processed.
+func (a *AutoSpotting) EventHandler(ctx context.Context, event *json.RawMessage) {
+
+	ctx, cancel := newRunContext(ctx)
+	defer cancel()
+
+	// each run gets its own AWS API call retry budget
+	apiCalls.resetBudget()
 
 	if event == nil {
 		log.Println("Missing event data, running as if triggered from a cron event...")
 		// Event is Autospotting Cron Scheduling
-		a.ProcessCronEvent()
+		a.ProcessCronEvent(ctx)
 		return
 	}
 
-	a.processEvent(event)
+	a.processEvent(ctx, event)
 	log.SetPrefix("")
 }
 
@@ -337,7 +347,17 @@ func isValidLifecycleHookEvent(ctEvent CloudTrailEvent) bool {
 		strings.HasPrefix(ctEvent.ErrorMessage, "No active Lifecycle Action found with instance ID")
 }
 
-func (a *AutoSpotting) handleLifecycleHookEvent(event events.CloudWatchEvent) error {
+// isLifecycleActionNotFoundEvent tells if the event is a failed attempt to
+// complete a lifecycle hook or record its heartbeat, either for an unattached
+// spot instance or for the emulated launch lifecycle hooks of a spot instance.
+func isLifecycleActionNotFoundEvent(ctEvent CloudTrailEvent) bool {
+	return (ctEvent.EventName == "CompleteLifecycleAction" ||
+		ctEvent.EventName == recordLifecycleActionHeartbeatEvent) &&
+		ctEvent.ErrorCode == "ValidationException" &&
+		strings.HasPrefix(ctEvent.ErrorMessage, "No active Lifecycle Action found")
+}
+
+func (a *AutoSpotting) handleLifecycleHookEvent(ctx context.Context, event events.CloudWatchEvent) error {
 	var ctEvent CloudTrailEvent
 
 	// Try to parse the event.Detail as Cloudwatch Event Rule
@@ -351,11 +371,11 @@ func (a *AutoSpotting) handleLifecycleHookEvent(event events.CloudWatchEvent) er
 	instanceID := ctEvent.RequestParameters.InstanceID
 	eventASGName := ctEvent.RequestParameters.AutoScalingGroupName
 
-	if !isValidLifecycleHookEvent(ctEvent) {
+	if !isLifecycleActionNotFoundEvent(ctEvent) {
 		return fmt.Errorf("unexpected event: %#v", ctEvent)
 	}
 
-	r := region{name: regionName, conf: a.config, services: connections{}}
+	r := region{name: regionName, conf: a.config, services: connections{}, ctx: ctx}
 
 	if !r.enabled() {
 		return fmt.Errorf("region %s is not enabled", r.name)
@@ -364,6 +384,17 @@ func (a *AutoSpotting) handleLifecycleHookEvent(event events.CloudWatchEvent) er
 	r.setupAsgFilters()
 	r.scanForEnabledAutoScalingGroups()
 
+	// the emulated lifecycle hooks may be completed using only their token
+	if token := ctEvent.RequestParameters.LifecycleActionToken; instanceID == "" && token != "" {
+		id, err := r.findLifecycleActionInstance(token)
+		if err != nil {
+			log.Printf("%s Couldn't find the instance of the lifecycle action: %s",
+				regionName, err.Error())
+			return err
+		}
+		instanceID = id
+	}
+
 	if err := r.scanInstance(aws.String(instanceID)); err != nil {
 		log.Printf("%s Couldn't scan instance %s: %s", regionName,
 			instanceID, err.Error())
@@ -378,10 +409,20 @@ func (a *AutoSpotting) handleLifecycleHookEvent(event events.CloudWatchEvent) er
 		return errors.New("instance missing")
 	}
 
+	// the spot instances waiting for their emulated hooks are also unattached,
+	// so they need to be handled first
+	if handled, err := i.handleEmulatedLifecycleAction(ctEvent); handled {
+		return err
+	}
+
 	if skipRun, err := i.handleInstanceStates(); skipRun {
 		return err
 	}
 
+	if !isValidLifecycleHookEvent(ctEvent) {
+		return fmt.Errorf("unexpected event: %#v", ctEvent)
+	}
+
 	asgName := i.getReplacementTargetASGName()
 
 	if asgName == nil || *asgName != eventASGName {
@@ -407,8 +448,8 @@ func (a *AutoSpotting) handleLifecycleHookEvent(event events.CloudWatchEvent) er
 	return nil
 }
 
-func (a *AutoSpotting) handleNewInstanceLaunch(regionName string, instanceID string, state string) error {
-	r := &region{name: regionName, conf: a.config, services: connections{}}
+func (a *AutoSpotting) handleNewInstanceLaunch(ctx context.Context, regionName string, instanceID string, state string) error {
+	r := &region{name: regionName, conf: a.config, services: connections{}, ctx: ctx}
 
 	if !r.enabled() {
 		return fmt.Errorf("region %s is not enabled", regionName)
@@ -421,6 +462,9 @@ func (a *AutoSpotting) handleNewInstanceLaunch(regionName string, instanceID str
 	log.Println("Scanning full instance information in", r.name)
 	r.determineInstanceTypeInformation(r.conf)
 
+	log.Println("Scanning capacity reservations and Reserved Instances in", r.name)
+	r.loadReservations()
+
 	if err := r.scanInstance(aws.String(instanceID)); err != nil {
 		log.Printf("%s Couldn't scan instance %s: %s", regionName,
 			instanceID, err.Error())
@@ -458,7 +502,6 @@ func (a *AutoSpotting) handleNewInstanceLaunch(regionName string, instanceID str
 }
 
 func (a *AutoSpotting) handleNewOnDemandInstanceLaunch(r *region, i *instance) error {
-	var spotInstanceID *string
 	var err error
 
 	if !i.shouldBeReplacedWithSpot() {
@@ -489,37 +532,42 @@ func (a *AutoSpotting) handleNewOnDemandInstanceLaunch(r *region, i *instance) e
 	spotInstance := i.asg.findUnattachedInstanceLaunchedForThisASG()
 
 	if spotInstance != nil {
-		spotInstanceID = spotInstance.InstanceId
-		log.Println("Found unattached spot instance", *spotInstanceID)
+		log.Println("Found unattached spot instance", *spotInstance.InstanceId)
+		err = spotInstance.swapWithGroupMember(i.asg)
 	} else {
+		// the launch changes the managed launch template of the group, and
+		// may race with other runs changing it
+		if err = i.asg.waitForLock(); err != nil {
+			log.Printf("%s Couldn't lock the group %s for replacing %s: %s",
+				i.region.name, i.asg.name, *i.InstanceId, err.Error())
+			return err
+		}
+
 		log.Printf("Attempting to launch spot replacement")
+		var spotInstanceID *string
 		if spotInstanceID, err = i.launchSpotReplacement(); err != nil {
+			i.asg.unlock()
 			log.Printf("%s Couldn't launch spot replacement for %s",
 				i.region.name, *i.InstanceId)
 			return err
 		}
-	}
-	if spotInstanceID == nil {
-		return errors.New("no spot instance found")
-	}
+		if spotInstanceID == nil {
+			i.asg.unlock()
+			return errors.New("no spot instance found")
+		}
 
-	log.Printf("Waiting for spot instance %s to be in status running", *spotInstanceID)
-	err = r.services.ec2.WaitUntilInstanceRunning(
-		&ec2.DescribeInstancesInput{
-			InstanceIds: []*string{spotInstanceID},
+		// the replacement keeps the lock and releases it when done
+		m := newReplacementMachine(i.asg, nil, &replacement{
+			state:              replacementLaunched,
+			spotInstanceID:     *spotInstanceID,
+			onDemandInstanceID: *i.InstanceId,
+			asgName:            i.asg.name,
 		})
-	if err != nil {
-		log.Printf("Issue while waiting for spot instance %v to start: %v",
-			spotInstanceID, err.Error())
-		return err
+		m.locked = true
+		err = m.run()
 	}
-	if err := r.scanInstance(spotInstanceID); err != nil {
-		log.Printf("%s Couldn't scan instance %s: %s", i.region.name,
-			*spotInstanceID, err.Error())
-		return err
-	}
-	spotInstance = r.instances.get(*spotInstanceID)
-	if _, err := spotInstance.swapWithGroupMember(i.asg); err != nil {
+
+	if err != nil {
 		log.Printf("%s, couldn't perform spot replacement of %s ",
 			i.region.name, *i.InstanceId)
 		return err
@@ -552,7 +600,7 @@ func (a *AutoSpotting) handleNewSpotInstanceLaunch(r *region, i *instance) error
 		"attempting to swap it against a running on-demand instance",
 		i.region.name, *i.InstanceId)
 
-	if _, err := i.swapWithGroupMember(asg); err != nil {
+	if err := i.swapWithGroupMember(asg); err != nil {
 		log.Printf("%s, couldn't perform spot replacement of %s ",
 			i.region.name, *i.InstanceId)
 		return err
@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -78,7 +79,7 @@ func Test_getRegions(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			as.mainEC2Conn = tt.ec2conn
 
-			got, err := as.getRegions()
+			got, err := as.getRegions(context.Background())
 			CheckErrors(t, err, tt.wantErr)
 
 			if !reflect.DeepEqual(got, tt.want) {
@@ -0,0 +1,301 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	managedLaunchTemplatePrefix = "AutoSpotting-LaunchTemplate-for-"
+
+	// managedLaunchTemplateVersionsToKeep is the number of most recent versions
+	// kept when pruning the managed launch templates.
+	managedLaunchTemplateVersionsToKeep = 10
+
+	// the maximum length of launch template names
+	maxLaunchTemplateNameLength = 128
+
+	// the maximum number of versions deleted in a single API call
+	deleteLaunchTemplateVersionsBatchSize = 200
+
+	// the length of the group name hash appended to the launch template names
+	launchTemplateNameHashLength = 8
+
+	launchTemplateNotFoundErrorCode      = "InvalidLaunchTemplateName.NotFoundException"
+	launchTemplateAlreadyExistsErrorCode = "InvalidLaunchTemplateName.AlreadyExistsException"
+)
+
+var invalidLaunchTemplateNameChars = regexp.MustCompile(`[^a-zA-Z0-9().\-/_]`)
+
+// managedLaunchTemplateName returns the name of the launch template used for
+// launching the spot instances of the group. The group names are sanitized and
+// truncated, so a hash of the original name is appended to keep them unique.
+func (a *autoScalingGroup) managedLaunchTemplateName() string {
+	sum := sha256.Sum256([]byte(a.name))
+	suffix := "-" + hex.EncodeToString(sum[:])[:launchTemplateNameHashLength]
+
+	name := managedLaunchTemplatePrefix + invalidLaunchTemplateNameChars.ReplaceAllString(a.name, "_")
+	if len(name)+len(suffix) > maxLaunchTemplateNameLength {
+		name = name[:maxLaunchTemplateNameLength-len(suffix)]
+	}
+	return name + suffix
+}
+
+// launchTemplateDataHash identifies the launch template data, and is stored
+// as the description of the launch template versions created from it.
+func launchTemplateDataHash(ltData *ec2.RequestLaunchTemplateData) (string, error) {
+	data, err := json.Marshal(ltData)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// managedLaunchTemplateVersion returns the version of the managed launch
+// template of the group created from the given launch template data. The
+// launch template or a new version of it is created if needed, whenever the
+// data changes, and the versions no longer in use are pruned.
+func (a *autoScalingGroup) managedLaunchTemplateVersion(ltData *ec2.RequestLaunchTemplateData) (*string, error) {
+	ltName := a.managedLaunchTemplateName()
+
+	hash, err := launchTemplateDataHash(ltData)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := a.describeManagedLaunchTemplateVersions(ltName)
+	if isAWSErrorCode(err, launchTemplateNotFoundErrorCode) {
+		version, cerr := a.createManagedLaunchTemplate(ltName, hash, ltData)
+		if !isAWSErrorCode(cerr, launchTemplateAlreadyExistsErrorCode) {
+			return version, cerr
+		}
+		// created in the meantime by another launch for the same group
+		versions, err = a.describeManagedLaunchTemplateVersions(ltName)
+	}
+	if err != nil {
+		log.Println(a.region.name, a.name, "failed to describe the versions of LaunchTemplate", ltName, err.Error())
+		return nil, err
+	}
+
+	for _, v := range versions {
+		if aws.StringValue(v.VersionDescription) == hash {
+			debug.Println(a.name, "Reusing version", *v.VersionNumber, "of LaunchTemplate", ltName)
+			return aws.String(strconv.FormatInt(*v.VersionNumber, 10)), nil
+		}
+	}
+
+	resp, err := a.region.services.ec2.CreateLaunchTemplateVersionWithContext(a.region.runContext(),
+		&ec2.CreateLaunchTemplateVersionInput{
+			LaunchTemplateName: aws.String(ltName),
+			VersionDescription: aws.String(hash),
+			LaunchTemplateData: ltData,
+		})
+	if err != nil {
+		log.Println(a.region.name, a.name, "failed to create LaunchTemplate version,", err.Error())
+		return nil, err
+	}
+	if resp.LaunchTemplateVersion == nil || resp.LaunchTemplateVersion.VersionNumber == nil {
+		return nil, errors.New("missing launch template version number")
+	}
+
+	version := resp.LaunchTemplateVersion.VersionNumber
+	log.Println(a.region.name, a.name, "Created version", *version, "of LaunchTemplate", ltName)
+
+	a.pruneManagedLaunchTemplateVersions(ltName, append(versions, resp.LaunchTemplateVersion))
+
+	return aws.String(strconv.FormatInt(*version, 10)), nil
+}
+
+func isAWSErrorCode(err error, code string) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == code
+}
+
+func (a *autoScalingGroup) describeManagedLaunchTemplateVersions(ltName string) ([]*ec2.LaunchTemplateVersion, error) {
+	var versions []*ec2.LaunchTemplateVersion
+
+	err := a.region.services.ec2.DescribeLaunchTemplateVersionsPagesWithContext(a.region.runContext(),
+		&ec2.DescribeLaunchTemplateVersionsInput{
+			LaunchTemplateName: aws.String(ltName),
+		},
+		func(page *ec2.DescribeLaunchTemplateVersionsOutput, lastPage bool) bool {
+			versions = append(versions, page.LaunchTemplateVersions...)
+			return true
+		})
+
+	return versions, err
+}
+
+func (a *autoScalingGroup) createManagedLaunchTemplate(ltName, hash string, ltData *ec2.RequestLaunchTemplateData) (*string, error) {
+	resp, err := a.region.services.ec2.CreateLaunchTemplateWithContext(a.region.runContext(),
+		&ec2.CreateLaunchTemplateInput{
+			LaunchTemplateName: aws.String(ltName),
+			VersionDescription: aws.String(hash),
+			LaunchTemplateData: ltData,
+			TagSpecifications: []*ec2.TagSpecification{
+				{
+					ResourceType: aws.String(ec2.ResourceTypeLaunchTemplate),
+					Tags: []*ec2.Tag{
+						{
+							Key:   aws.String("launched-for-asg"),
+							Value: aws.String(a.name),
+						},
+					},
+				},
+			},
+		})
+	if err != nil {
+		log.Println(a.region.name, a.name, "failed to create LaunchTemplate,", err.Error())
+		return nil, err
+	}
+	if resp.LaunchTemplate == nil || resp.LaunchTemplate.LatestVersionNumber == nil {
+		return nil, errors.New("missing launch template version number")
+	}
+
+	log.Println(a.region.name, a.name, "Created LaunchTemplate", ltName)
+	return aws.String(strconv.FormatInt(*resp.LaunchTemplate.LatestVersionNumber, 10)), nil
+}
+
+// launchTemplateVersionsToPrune returns all but the most recent versions of
+// the managed launch template. The default version can't be deleted, so it's
+// always kept.
+func launchTemplateVersionsToPrune(versions []*ec2.LaunchTemplateVersion) []*string {
+	sort.Slice(versions, func(i, j int) bool {
+		return aws.Int64Value(versions[i].VersionNumber) > aws.Int64Value(versions[j].VersionNumber)
+	})
+
+	var prune []*string
+	for n, v := range versions {
+		if n < managedLaunchTemplateVersionsToKeep || aws.BoolValue(v.DefaultVersion) {
+			continue
+		}
+		prune = append(prune, aws.String(strconv.FormatInt(aws.Int64Value(v.VersionNumber), 10)))
+	}
+	return prune
+}
+
+func (a *autoScalingGroup) pruneManagedLaunchTemplateVersions(ltName string, versions []*ec2.LaunchTemplateVersion) {
+	prune := launchTemplateVersionsToPrune(versions)
+
+	for start := 0; start < len(prune); start += deleteLaunchTemplateVersionsBatchSize {
+		end := min(start+deleteLaunchTemplateVersionsBatchSize, len(prune))
+
+		_, err := a.region.services.ec2.DeleteLaunchTemplateVersionsWithContext(a.region.runContext(),
+			&ec2.DeleteLaunchTemplateVersionsInput{
+				LaunchTemplateName: aws.String(ltName),
+				Versions:           prune[start:end],
+			})
+		if err != nil {
+			log.Println(a.region.name, a.name, "failed to prune the versions of LaunchTemplate", ltName, err.Error())
+			return
+		}
+	}
+
+	if len(prune) > 0 {
+		debug.Println(a.name, "Pruned", len(prune), "versions of LaunchTemplate", ltName)
+	}
+}
+
+// fleetTagSpecifications moves the instance tags out of the launch template
+// data, since they're specific to each instance, so they can be set on the
+// fleet instead of on the launch template shared by all the instances of the
+// group. The tags of the other resources stay in the launch template, as the
+// fleets can only tag the instances.
+func fleetTagSpecifications(ltData *ec2.RequestLaunchTemplateData) []*ec2.TagSpecification {
+	var retval []*ec2.TagSpecification
+	var kept []*ec2.LaunchTemplateTagSpecificationRequest
+
+	for _, t := range ltData.TagSpecifications {
+		if aws.StringValue(t.ResourceType) != ec2.ResourceTypeInstance {
+			kept = append(kept, t)
+			continue
+		}
+		retval = append(retval, &ec2.TagSpecification{
+			ResourceType: t.ResourceType,
+			Tags:         t.Tags,
+		})
+	}
+
+	ltData.TagSpecifications = kept
+	return retval
+}
+
+// removeInstanceSpecificData removes the data specific to the replaced instance
+// from the launch template data, so the versions of the managed launch template
+// are reused for all the instances of the group. The subnet, which determines
+// the availability zone, and the maximum price are set by the fleet overrides,
+// while the EBS optimization is left to the configuration of the group. The
+// volumes and network interfaces get the tags of the group, and the ones of
+// the instance are added once the spot instance is running.
+func (i *instance) removeInstanceSpecificData(ltData *ec2.RequestLaunchTemplateData) {
+	if ltData.Placement != nil {
+		ltData.Placement.AvailabilityZone = nil
+	}
+
+	for _, ni := range ltData.NetworkInterfaces {
+		if aws.Int64Value(ni.DeviceIndex) == 0 && aws.Int64Value(ni.NetworkCardIndex) == 0 {
+			ni.SubnetId = nil
+		}
+	}
+
+	if m := ltData.InstanceMarketOptions; m != nil && m.SpotOptions != nil {
+		m.SpotOptions.MaxPrice = nil
+	}
+
+	ltData.EbsOptimized = i.asg.configuredEbsOptimized()
+
+	var kept []*ec2.LaunchTemplateTagSpecificationRequest
+	for _, t := range ltData.TagSpecifications {
+		if aws.StringValue(t.ResourceType) != ec2.ResourceTypeInstance {
+			t.Tags = mergeTags(nil, i.asg.propagatedTags(nil))
+		}
+		if len(t.Tags) > 0 {
+			kept = append(kept, t)
+		}
+	}
+	ltData.TagSpecifications = kept
+}
+
+// setFleetMaxPrice sets the maximum price of the spot instances on all the
+// overrides of the fleet.
+func setFleetMaxPrice(cfi *ec2.CreateFleetInput, price float64) {
+	maxPrice := aws.String(strconv.FormatFloat(price, 'g', 10, 64))
+	for _, config := range cfi.LaunchTemplateConfigs {
+		for _, override := range config.Overrides {
+			override.MaxPrice = maxPrice
+		}
+	}
+}
+
+// configuredEbsOptimized returns the EBS optimization set in the launch
+// configuration or launch template of the group, or nil if it's not set, in
+// which case the instance types use their default.
+func (a *autoScalingGroup) configuredEbsOptimized() *bool {
+	if a.launchConfiguration != nil && a.launchConfiguration.LaunchConfiguration != nil {
+		return a.launchConfiguration.EbsOptimized
+	}
+
+	if a.Group == nil || a.LaunchTemplate == nil {
+		return nil
+	}
+
+	lt, err := a.loadLaunchTemplate()
+	if err != nil || lt.LaunchTemplateData == nil {
+		return nil
+	}
+	return lt.LaunchTemplateData.EbsOptimized
+}
@@ -0,0 +1,384 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_managedLaunchTemplateName(t *testing.T) {
+	tests := []struct {
+		name    string
+		asgName string
+		want    string
+	}{
+		{
+			name:    "valid group name",
+			asgName: "my-asg_1",
+			want:    "AutoSpotting-LaunchTemplate-for-my-asg_1-262b5bb0",
+		},
+		{
+			name:    "invalid characters are replaced",
+			asgName: "my asg:1",
+			want:    "AutoSpotting-LaunchTemplate-for-my_asg_1-dee4eba7",
+		},
+		{
+			name:    "long group name is truncated",
+			asgName: strings.Repeat("a", 200),
+			want:    "AutoSpotting-LaunchTemplate-for-" + strings.Repeat("a", 87) + "-c2a908d9",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{name: tt.asgName}
+			if got := a.managedLaunchTemplateName(); got != tt.want {
+				t.Errorf("managedLaunchTemplateName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	a := &autoScalingGroup{name: "my asg:1"}
+	b := &autoScalingGroup{name: "my_asg_1"}
+	if a.managedLaunchTemplateName() == b.managedLaunchTemplateName() {
+		t.Errorf("managedLaunchTemplateName() = %v for both %q and %q, want different names",
+			a.managedLaunchTemplateName(), a.name, b.name)
+	}
+}
+
+func Test_launchTemplateDataHash(t *testing.T) {
+	hash := func(ltData *ec2.RequestLaunchTemplateData) string {
+		h, err := launchTemplateDataHash(ltData)
+		if err != nil {
+			t.Fatalf("launchTemplateDataHash() error = %v", err)
+		}
+		return h
+	}
+
+	a := hash(&ec2.RequestLaunchTemplateData{ImageId: aws.String("ami-1")})
+
+	if b := hash(&ec2.RequestLaunchTemplateData{ImageId: aws.String("ami-1")}); a != b {
+		t.Errorf("launchTemplateDataHash() = %v for the same data, want %v", b, a)
+	}
+
+	if b := hash(&ec2.RequestLaunchTemplateData{ImageId: aws.String("ami-2")}); a == b {
+		t.Errorf("launchTemplateDataHash() = %v for different data, want a different hash", b)
+	}
+
+	if len(a) != 64 {
+		t.Errorf("launchTemplateDataHash() = %v, want a SHA-256 hex digest", a)
+	}
+}
+
+func Test_autoScalingGroup_managedLaunchTemplateVersion(t *testing.T) {
+	ltData := &ec2.RequestLaunchTemplateData{ImageId: aws.String("ami-1")}
+	hash, _ := launchTemplateDataHash(ltData)
+
+	tests := []struct {
+		name    string
+		ec2     mockEC2
+		want    *string
+		wantErr bool
+	}{
+		{
+			name: "missing launch template is created",
+			ec2: mockEC2{
+				dltvperr: awserr.New(launchTemplateNotFoundErrorCode, "not found", nil),
+				clto: &ec2.CreateLaunchTemplateOutput{
+					LaunchTemplate: &ec2.LaunchTemplate{LatestVersionNumber: aws.Int64(1)},
+				},
+			},
+			want: aws.String("1"),
+		},
+		{
+			name: "launch template creation failure",
+			ec2: mockEC2{
+				dltvperr: awserr.New(launchTemplateNotFoundErrorCode, "not found", nil),
+				clterr:   errors.New("error"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "matching version is reused",
+			ec2: mockEC2{
+				dltvpo: []*ec2.DescribeLaunchTemplateVersionsOutput{{
+					LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{
+						{VersionNumber: aws.Int64(1), VersionDescription: aws.String("other")},
+					},
+				}, {
+					LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{
+						{VersionNumber: aws.Int64(4), VersionDescription: aws.String(hash)},
+					},
+				}},
+				cltverr: errors.New("error"),
+			},
+			want: aws.String("4"),
+		},
+		{
+			name: "new version is created when the data changed",
+			ec2: mockEC2{
+				dltvpo: []*ec2.DescribeLaunchTemplateVersionsOutput{{
+					LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{
+						{VersionNumber: aws.Int64(1), VersionDescription: aws.String("other")},
+					},
+				}},
+				cltvo: &ec2.CreateLaunchTemplateVersionOutput{
+					LaunchTemplateVersion: &ec2.LaunchTemplateVersion{VersionNumber: aws.Int64(2)},
+				},
+			},
+			want: aws.String("2"),
+		},
+		{
+			name: "version creation failure",
+			ec2: mockEC2{
+				cltverr: errors.New("error"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "describe failure",
+			ec2: mockEC2{
+				dltvperr: errors.New("error"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "test-asg",
+				region: &region{
+					name:     "us-east-1",
+					services: connections{ec2: tt.ec2},
+				},
+			}
+			got, err := a.managedLaunchTemplateVersion(ltData)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("managedLaunchTemplateVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("managedLaunchTemplateVersion() = %v, want %v",
+					aws.StringValue(got), aws.StringValue(tt.want))
+			}
+		})
+	}
+}
+
+// concurrentLaunchTemplateEC2 behaves as if the launch template was created by
+// another launch right after it was first described.
+type concurrentLaunchTemplateEC2 struct {
+	mockEC2
+	describes *int
+}
+
+func (m concurrentLaunchTemplateEC2) DescribeLaunchTemplateVersionsPagesWithContext(ctx aws.Context, in *ec2.DescribeLaunchTemplateVersionsInput, f func(*ec2.DescribeLaunchTemplateVersionsOutput, bool) bool, opts ...request.Option) error {
+	if *m.describes++; *m.describes == 1 {
+		return awserr.New(launchTemplateNotFoundErrorCode, "not found", nil)
+	}
+	return m.mockEC2.DescribeLaunchTemplateVersionsPagesWithContext(ctx, in, f, opts...)
+}
+
+func Test_autoScalingGroup_managedLaunchTemplateVersionConcurrentCreation(t *testing.T) {
+	ltData := &ec2.RequestLaunchTemplateData{ImageId: aws.String("ami-1")}
+	hash, _ := launchTemplateDataHash(ltData)
+
+	a := &autoScalingGroup{
+		name: "test-asg",
+		region: &region{
+			name: "us-east-1",
+			services: connections{ec2: concurrentLaunchTemplateEC2{
+				mockEC2: mockEC2{
+					clterr: awserr.New(launchTemplateAlreadyExistsErrorCode, "already exists", nil),
+					dltvpo: []*ec2.DescribeLaunchTemplateVersionsOutput{{
+						LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{
+							{VersionNumber: aws.Int64(1), VersionDescription: aws.String(hash)},
+						},
+					}},
+				},
+				describes: new(int),
+			}},
+		},
+	}
+
+	got, err := a.managedLaunchTemplateVersion(ltData)
+	if err != nil {
+		t.Fatalf("managedLaunchTemplateVersion() error = %v", err)
+	}
+	if aws.StringValue(got) != "1" {
+		t.Errorf("managedLaunchTemplateVersion() = %v, want 1", aws.StringValue(got))
+	}
+}
+
+func Test_launchTemplateVersionsToPrune(t *testing.T) {
+	var versions []*ec2.LaunchTemplateVersion
+	for v := int64(1); v <= 13; v++ {
+		versions = append(versions, &ec2.LaunchTemplateVersion{
+			VersionNumber:  aws.Int64(v),
+			DefaultVersion: aws.Bool(v == 1),
+		})
+	}
+
+	tests := []struct {
+		name     string
+		versions []*ec2.LaunchTemplateVersion
+		want     []*string
+	}{
+		{
+			name:     "fewer versions than kept",
+			versions: versions[:managedLaunchTemplateVersionsToKeep],
+			want:     nil,
+		},
+		{
+			name:     "oldest versions except the default one",
+			versions: versions,
+			want:     aws.StringSlice([]string{"3", "2"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := launchTemplateVersionsToPrune(tt.versions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("launchTemplateVersionsToPrune() = %v, want %v",
+					aws.StringValueSlice(got), aws.StringValueSlice(tt.want))
+			}
+		})
+	}
+}
+
+func Test_fleetTagSpecifications(t *testing.T) {
+	tags := []*ec2.Tag{
+		{Key: aws.String("launched-for-replacing-instance"), Value: aws.String("i-foo")},
+		{Key: aws.String("team"), Value: aws.String("data")},
+	}
+
+	volumeTags := []*ec2.LaunchTemplateTagSpecificationRequest{
+		{ResourceType: aws.String("volume"), Tags: tags[1:]},
+	}
+
+	ltData := &ec2.RequestLaunchTemplateData{
+		TagSpecifications: []*ec2.LaunchTemplateTagSpecificationRequest{
+			{ResourceType: aws.String("instance"), Tags: tags},
+			volumeTags[0],
+		},
+	}
+
+	got := fleetTagSpecifications(ltData)
+
+	want := []*ec2.TagSpecification{
+		{ResourceType: aws.String("instance"), Tags: tags},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fleetTagSpecifications() = %v, want %v", got, want)
+	}
+
+	if !reflect.DeepEqual(ltData.TagSpecifications, volumeTags) {
+		t.Errorf("fleetTagSpecifications() kept %v in the launch template, want %v",
+			ltData.TagSpecifications, volumeTags)
+	}
+}
+
+func Test_instance_removeInstanceSpecificData(t *testing.T) {
+	newData := func(instanceID, subnet, az, price string, ebsOptimized bool) *ec2.RequestLaunchTemplateData {
+		i := &instance{
+			Instance: &ec2.Instance{
+				InstanceId: aws.String(instanceID),
+				Tags:       []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(instanceID)}},
+			},
+			asg: &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{
+					Tags: []*autoscaling.TagDescription{
+						{Key: aws.String("team"), Value: aws.String("data"), PropagateAtLaunch: aws.Bool(true)},
+					},
+				},
+				launchConfiguration: &launchConfiguration{
+					LaunchConfiguration: &autoscaling.LaunchConfiguration{},
+				},
+				config: AutoScalingConfig{TagPropagationResources: "volume"},
+			},
+		}
+
+		ltData := &ec2.RequestLaunchTemplateData{
+			EbsOptimized: aws.Bool(ebsOptimized),
+			InstanceMarketOptions: &ec2.LaunchTemplateInstanceMarketOptionsRequest{
+				MarketType: aws.String(Spot),
+				SpotOptions: &ec2.LaunchTemplateSpotMarketOptionsRequest{
+					MaxPrice: aws.String(price),
+				},
+			},
+			NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+				{DeviceIndex: aws.Int64(0), SubnetId: aws.String(subnet)},
+				{DeviceIndex: aws.Int64(1), SubnetId: aws.String("subnet-secondary")},
+			},
+			Placement: &ec2.LaunchTemplatePlacementRequest{
+				AvailabilityZone: aws.String(az),
+				Tenancy:          aws.String("default"),
+			},
+			TagSpecifications: i.generateTagsList(),
+		}
+
+		fleetTagSpecifications(ltData)
+		i.removeInstanceSpecificData(ltData)
+		return ltData
+	}
+
+	a := newData("i-a", "subnet-a", "us-east-1a", "0.1", true)
+	b := newData("i-b", "subnet-b", "us-east-1b", "0.2", false)
+
+	hashA, _ := launchTemplateDataHash(a)
+	hashB, _ := launchTemplateDataHash(b)
+	if hashA != hashB {
+		t.Errorf("removeInstanceSpecificData() left instance specific data: %v and %v", a, b)
+	}
+
+	if a.Placement == nil || aws.StringValue(a.Placement.Tenancy) != "default" {
+		t.Errorf("removeInstanceSpecificData() removed the tenancy: %v", a.Placement)
+	}
+	if aws.StringValue(a.NetworkInterfaces[1].SubnetId) != "subnet-secondary" {
+		t.Errorf("removeInstanceSpecificData() removed the subnet of the secondary network interface")
+	}
+	if a.EbsOptimized != nil {
+		t.Errorf("removeInstanceSpecificData() EbsOptimized = %v, want nil", *a.EbsOptimized)
+	}
+
+	want := []*ec2.LaunchTemplateTagSpecificationRequest{{
+		ResourceType: aws.String("volume"),
+		Tags:         []*ec2.Tag{{Key: aws.String("team"), Value: aws.String("data")}},
+	}}
+	if !reflect.DeepEqual(a.TagSpecifications, want) {
+		t.Errorf("removeInstanceSpecificData() tags = %v, want %v", a.TagSpecifications, want)
+	}
+}
+
+func Test_setFleetMaxPrice(t *testing.T) {
+	cfi := &ec2.CreateFleetInput{
+		LaunchTemplateConfigs: []*ec2.FleetLaunchTemplateConfigRequest{
+			{Overrides: []*ec2.FleetLaunchTemplateOverridesRequest{
+				{InstanceType: aws.String("m5.large")},
+				{InstanceType: aws.String("c5.large")},
+			}},
+			{Overrides: []*ec2.FleetLaunchTemplateOverridesRequest{
+				{InstanceType: aws.String("m6g.large")},
+			}},
+		},
+	}
+
+	setFleetMaxPrice(cfi, 0.096)
+
+	for _, config := range cfi.LaunchTemplateConfigs {
+		for _, override := range config.Overrides {
+			if got := aws.StringValue(override.MaxPrice); got != "0.096" {
+				t.Errorf("setFleetMaxPrice() %s MaxPrice = %v, want 0.096",
+					*override.InstanceType, got)
+			}
+		}
+	}
+}
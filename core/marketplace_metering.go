@@ -1,6 +1,7 @@
 package autospotting
 
 import (
+	"context"
 	"errors"
 	"log"
 	"time"
@@ -14,12 +15,12 @@ import (
 // SSMParameterName stores the name of the SSM parameter that stores the success status of the latest metering call
 const SSMParameterName = "autospotting-metering"
 
-func meterMarketplaceUsage(savings float64) error {
+func meterMarketplaceUsage(ctx context.Context, savings float64) error {
 
 	// Metering is supposed to be done from Fargate, but we check it here and return an error in case it failed before
 	if RunningFromLambda() {
 		log.Println("Running from Lambda")
-		if failedFromFargate() {
+		if failedFromFargate(ctx) {
 			log.Println("Metering failed previously, exiting...")
 			return errors.New("metering previously failed")
 		}
@@ -38,7 +39,7 @@ func meterMarketplaceUsage(savings float64) error {
 	log.Printf("Billing %v units for $%v saved/hour (%v%% of the generated savings of $%v/hour)",
 		units, charge, as.config.SavingsCut, savings)
 
-	res, err := svc.MeterUsage(&marketplacemetering.MeterUsageInput{
+	res, err := svc.MeterUsageWithContext(ctx, &marketplacemetering.MeterUsageInput{
 		ProductCode:    aws.String("9e5m3z5f5hlwdqcrv16xdi040"),
 		Timestamp:      aws.Time(time.Now()),
 		UsageDimension: aws.String("SavingsCut"),
@@ -47,21 +48,21 @@ func meterMarketplaceUsage(savings float64) error {
 
 	if err != nil {
 		log.Printf("Error submitting AWS Marketplace metering data: %v, received response: %v\n", err.Error(), res.String())
-		markAsFailingFromFargate()
+		markAsFailingFromFargate(ctx)
 		return err
 	}
 
-	markAsSuccessfulFromFargate()
+	markAsSuccessfulFromFargate(ctx)
 	return nil
 }
 
-func putSSMParameter(status string) {
+func putSSMParameter(ctx context.Context, status string) {
 	mySession := session.Must(session.NewSession())
 
 	// Create a SSM client
 	svc := ssm.New(mySession, aws.NewConfig().WithRegion("us-east-1"))
 
-	_, err := svc.PutParameter(&ssm.PutParameterInput{
+	_, err := svc.PutParameterWithContext(ctx, &ssm.PutParameterInput{
 		Name:      aws.String(SSMParameterName),
 		Overwrite: aws.Bool(true),
 		Type:      aws.String("String"),
@@ -72,19 +73,19 @@ func putSSMParameter(status string) {
 	}
 }
 
-func markAsSuccessfulFromFargate() {
-	putSSMParameter("success")
+func markAsSuccessfulFromFargate(ctx context.Context) {
+	putSSMParameter(ctx, "success")
 }
 
-func markAsFailingFromFargate() {
-	putSSMParameter("failure")
+func markAsFailingFromFargate(ctx context.Context) {
+	putSSMParameter(ctx, "failure")
 }
 
-func failedFromFargate() bool {
+func failedFromFargate(ctx context.Context) bool {
 	mySession := session.Must(session.NewSession())
 	// Create a SSM client
 	svc := ssm.New(mySession, aws.NewConfig().WithRegion("us-east-1"))
-	res, err := svc.GetParameter(&ssm.GetParameterInput{
+	res, err := svc.GetParameterWithContext(ctx, &ssm.GetParameterInput{
 		Name: aws.String(SSMParameterName),
 	})
 
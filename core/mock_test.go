@@ -7,12 +7,24 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/aws/aws-sdk-go/service/savingsplans/savingsplansiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
@@ -77,60 +89,163 @@ type mockEC2 struct {
 
 	// WaitUntilInstanceRunning error
 	wuirerr error
+
+	// CreateTags
+	cto   *ec2.CreateTagsOutput
+	cterr error
+
+	// CreateLaunchTemplateVersion
+	cltvo   *ec2.CreateLaunchTemplateVersionOutput
+	cltverr error
+
+	// DescribeLaunchTemplateVersionsPages output
+	dltvpo   []*ec2.DescribeLaunchTemplateVersionsOutput
+	dltvperr error
+
+	// DeleteLaunchTemplateVersions
+	deltvo   *ec2.DeleteLaunchTemplateVersionsOutput
+	deltverr error
+
+	// DescribeLaunchTemplatesPages output
+	dltpo   []*ec2.DescribeLaunchTemplatesOutput
+	dltperr error
+
+	// DescribeSubnets
+	dso   *ec2.DescribeSubnetsOutput
+	dserr error
+
+	// DescribeInstanceTypesPages output
+	ditpo   []*ec2.DescribeInstanceTypesOutput
+	ditperr error
+
+	// DescribeInstanceTypeOfferingsPages output
+	ditopo   []*ec2.DescribeInstanceTypeOfferingsOutput
+	ditoperr error
+
+	// DescribePlacementGroups
+	dpgo   *ec2.DescribePlacementGroupsOutput
+	dpgerr error
+
+	// DescribeCapacityReservationsPages output
+	dcrpo   []*ec2.DescribeCapacityReservationsOutput
+	dcrperr error
+
+	// DescribeReservedInstances
+	drio   *ec2.DescribeReservedInstancesOutput
+	drierr error
 }
 
-func (m mockEC2) CreateFleet(in *ec2.CreateFleetInput) (*ec2.CreateFleetOutput, error) {
+func (m mockEC2) CreateFleetWithContext(ctx aws.Context, in *ec2.CreateFleetInput, opts ...request.Option) (*ec2.CreateFleetOutput, error) {
 	return m.cfo, m.cferr
 }
 
-func (m mockEC2) CreateLaunchTemplate(in *ec2.CreateLaunchTemplateInput) (*ec2.CreateLaunchTemplateOutput, error) {
+func (m mockEC2) CreateLaunchTemplateWithContext(ctx aws.Context, in *ec2.CreateLaunchTemplateInput, opts ...request.Option) (*ec2.CreateLaunchTemplateOutput, error) {
 	return m.clto, m.clterr
 }
 
-func (m mockEC2) DeleteLaunchTemplate(*ec2.DeleteLaunchTemplateInput) (*ec2.DeleteLaunchTemplateOutput, error) {
+func (m mockEC2) CreateLaunchTemplateVersionWithContext(ctx aws.Context, in *ec2.CreateLaunchTemplateVersionInput, opts ...request.Option) (*ec2.CreateLaunchTemplateVersionOutput, error) {
+	return m.cltvo, m.cltverr
+}
+
+func (m mockEC2) DeleteLaunchTemplateWithContext(aws.Context, *ec2.DeleteLaunchTemplateInput, ...request.Option) (*ec2.DeleteLaunchTemplateOutput, error) {
 	return m.dlto, m.dlterr
 }
 
-func (m mockEC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryInput, f func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool) error {
+func (m mockEC2) DescribeSpotPriceHistoryPagesWithContext(ctx aws.Context, in *ec2.DescribeSpotPriceHistoryInput, f func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool, opts ...request.Option) error {
 	for i, page := range m.dsphpo {
 		f(page, i == len(m.dsphpo)-1)
 	}
 	return m.dsphperr
 }
 
-func (m mockEC2) DescribeInstancesPages(in *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool) error {
+func (m mockEC2) DescribeSubnetsWithContext(ctx aws.Context, in *ec2.DescribeSubnetsInput, opts ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	return m.dso, m.dserr
+}
+
+func (m mockEC2) DescribePlacementGroupsWithContext(ctx aws.Context, in *ec2.DescribePlacementGroupsInput, opts ...request.Option) (*ec2.DescribePlacementGroupsOutput, error) {
+	return m.dpgo, m.dpgerr
+}
+
+func (m mockEC2) DescribeInstanceTypesPagesWithContext(ctx aws.Context, in *ec2.DescribeInstanceTypesInput, f func(*ec2.DescribeInstanceTypesOutput, bool) bool, opts ...request.Option) error {
+	for i, page := range m.ditpo {
+		f(page, i == len(m.ditpo)-1)
+	}
+	return m.ditperr
+}
+
+func (m mockEC2) DescribeInstanceTypeOfferingsPagesWithContext(ctx aws.Context, in *ec2.DescribeInstanceTypeOfferingsInput, f func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool, opts ...request.Option) error {
+	for i, page := range m.ditopo {
+		f(page, i == len(m.ditopo)-1)
+	}
+	return m.ditoperr
+}
+
+func (m mockEC2) DescribeInstancesPagesWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
 	f(m.dio, true)
 	return m.diperr
 }
 
-func (m mockEC2) DescribeInstanceAttribute(in *ec2.DescribeInstanceAttributeInput) (*ec2.DescribeInstanceAttributeOutput, error) {
+func (m mockEC2) DescribeCapacityReservationsPagesWithContext(ctx aws.Context, in *ec2.DescribeCapacityReservationsInput, f func(*ec2.DescribeCapacityReservationsOutput, bool) bool, opts ...request.Option) error {
+	for i, page := range m.dcrpo {
+		f(page, i == len(m.dcrpo)-1)
+	}
+	return m.dcrperr
+}
+
+func (m mockEC2) DescribeReservedInstancesWithContext(ctx aws.Context, in *ec2.DescribeReservedInstancesInput, opts ...request.Option) (*ec2.DescribeReservedInstancesOutput, error) {
+	return m.drio, m.drierr
+}
+
+func (m mockEC2) DescribeInstanceAttributeWithContext(ctx aws.Context, in *ec2.DescribeInstanceAttributeInput, opts ...request.Option) (*ec2.DescribeInstanceAttributeOutput, error) {
 	return m.diao, m.diaerr
 }
 
-func (m mockEC2) DescribeImages(in *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+func (m mockEC2) DescribeImagesWithContext(ctx aws.Context, in *ec2.DescribeImagesInput, opts ...request.Option) (*ec2.DescribeImagesOutput, error) {
 	return m.damio, m.damierr
 }
 
-func (m mockEC2) TerminateInstances(*ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+func (m mockEC2) TerminateInstancesWithContext(aws.Context, *ec2.TerminateInstancesInput, ...request.Option) (*ec2.TerminateInstancesOutput, error) {
 	return m.tio, m.tierr
 }
 
-func (m mockEC2) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+func (m mockEC2) DescribeRegionsWithContext(aws.Context, *ec2.DescribeRegionsInput, ...request.Option) (*ec2.DescribeRegionsOutput, error) {
 	return m.dro, m.drerr
 }
 
-func (m mockEC2) DeleteTags(*ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+func (m mockEC2) DeleteTagsWithContext(aws.Context, *ec2.DeleteTagsInput, ...request.Option) (*ec2.DeleteTagsOutput, error) {
 	return m.dto, m.dterr
 }
 
-func (m mockEC2) DescribeLaunchTemplateVersions(*ec2.DescribeLaunchTemplateVersionsInput) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+func (m mockEC2) DescribeLaunchTemplateVersionsWithContext(aws.Context, *ec2.DescribeLaunchTemplateVersionsInput, ...request.Option) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
 	return m.dltvo, m.dltverr
 }
 
-func (m mockEC2) WaitUntilInstanceRunning(*ec2.DescribeInstancesInput) error {
+func (m mockEC2) DescribeLaunchTemplateVersionsPagesWithContext(ctx aws.Context, in *ec2.DescribeLaunchTemplateVersionsInput, f func(*ec2.DescribeLaunchTemplateVersionsOutput, bool) bool, opts ...request.Option) error {
+	for i, page := range m.dltvpo {
+		f(page, i == len(m.dltvpo)-1)
+	}
+	return m.dltvperr
+}
+
+func (m mockEC2) DeleteLaunchTemplateVersionsWithContext(ctx aws.Context, in *ec2.DeleteLaunchTemplateVersionsInput, opts ...request.Option) (*ec2.DeleteLaunchTemplateVersionsOutput, error) {
+	return m.deltvo, m.deltverr
+}
+
+func (m mockEC2) WaitUntilInstanceRunningWithContext(aws.Context, *ec2.DescribeInstancesInput, ...request.WaiterOption) error {
 	return m.wuirerr
 }
 
+func (m mockEC2) CreateTagsWithContext(ctx aws.Context, in *ec2.CreateTagsInput, opts ...request.Option) (*ec2.CreateTagsOutput, error) {
+	return m.cto, m.cterr
+}
+
+func (m mockEC2) DescribeLaunchTemplatesPagesWithContext(ctx aws.Context, in *ec2.DescribeLaunchTemplatesInput, f func(*ec2.DescribeLaunchTemplatesOutput, bool) bool, opts ...request.Option) error {
+	for i, page := range m.dltpo {
+		f(page, i == len(m.dltpo)-1)
+	}
+	return m.dltperr
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockASG struct {
@@ -165,28 +280,44 @@ type mockASG struct {
 	dlho   *autoscaling.DescribeLifecycleHooksOutput
 	dlherr error
 
+	// CompleteLifecycleAction
+	clao   *autoscaling.CompleteLifecycleActionOutput
+	claerr error
+
 	// CreateOrUpdateTags
 	couto   *autoscaling.CreateOrUpdateTagsOutput
 	couterr error
+
+	// DeleteTags
+	dtgo   *autoscaling.DeleteTagsOutput
+	dtgerr error
+
+	// SuspendProcesses
+	spo   *autoscaling.SuspendProcessesOutput
+	sperr error
+
+	// ResumeProcesses
+	rpo   *autoscaling.ResumeProcessesOutput
+	rperr error
 }
 
-func (m mockASG) DetachInstances(*autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
+func (m mockASG) DetachInstancesWithContext(aws.Context, *autoscaling.DetachInstancesInput, ...request.Option) (*autoscaling.DetachInstancesOutput, error) {
 	return m.dio, m.dierr
 }
 
-func (m mockASG) TerminateInstanceInAutoScalingGroup(*autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+func (m mockASG) TerminateInstanceInAutoScalingGroupWithContext(aws.Context, *autoscaling.TerminateInstanceInAutoScalingGroupInput, ...request.Option) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
 	return m.tiiasgo, m.tiiasgerr
 }
 
-func (m mockASG) AttachInstances(*autoscaling.AttachInstancesInput) (*autoscaling.AttachInstancesOutput, error) {
+func (m mockASG) AttachInstancesWithContext(aws.Context, *autoscaling.AttachInstancesInput, ...request.Option) (*autoscaling.AttachInstancesOutput, error) {
 	return m.aio, m.aierr
 }
 
-func (m mockASG) DescribeLaunchConfigurations(*autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+func (m mockASG) DescribeLaunchConfigurationsWithContext(aws.Context, *autoscaling.DescribeLaunchConfigurationsInput, ...request.Option) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
 	return m.dlco, m.dlcerr
 }
 
-func (m mockASG) UpdateAutoScalingGroup(*autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+func (m mockASG) UpdateAutoScalingGroupWithContext(aws.Context, *autoscaling.UpdateAutoScalingGroupInput, ...request.Option) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
 	return m.uasgo, m.uasgerr
 }
 
@@ -195,27 +326,43 @@ func (m mockASG) DescribeTagsPages(input *autoscaling.DescribeTagsInput, functio
 	return nil
 }
 
-func (m mockASG) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+func (m mockASG) DescribeAutoScalingGroupsWithContext(ctx aws.Context, input *autoscaling.DescribeAutoScalingGroupsInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
 	return m.dasgo, m.dasgerr
 }
 
-func (m mockASG) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput, function func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+func (m mockASG) DescribeAutoScalingGroupsPagesWithContext(ctx aws.Context, input *autoscaling.DescribeAutoScalingGroupsInput, function func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool, opts ...request.Option) error {
 	function(m.dasgo, true)
 	return nil
 }
 
-func (m mockASG) DescribeAutoScalingInstances(input *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+func (m mockASG) DescribeAutoScalingInstancesWithContext(ctx aws.Context, input *autoscaling.DescribeAutoScalingInstancesInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
 	return m.dasio, m.dasierr
 }
 
-func (m mockASG) DescribeLifecycleHooks(*autoscaling.DescribeLifecycleHooksInput) (*autoscaling.DescribeLifecycleHooksOutput, error) {
+func (m mockASG) DescribeLifecycleHooksWithContext(aws.Context, *autoscaling.DescribeLifecycleHooksInput, ...request.Option) (*autoscaling.DescribeLifecycleHooksOutput, error) {
 	return m.dlho, m.dlherr
 }
 
-func (m mockASG) CreateOrUpdateTags(*autoscaling.CreateOrUpdateTagsInput) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+func (m mockASG) CompleteLifecycleActionWithContext(aws.Context, *autoscaling.CompleteLifecycleActionInput, ...request.Option) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	return m.clao, m.claerr
+}
+
+func (m mockASG) CreateOrUpdateTagsWithContext(aws.Context, *autoscaling.CreateOrUpdateTagsInput, ...request.Option) (*autoscaling.CreateOrUpdateTagsOutput, error) {
 	return m.couto, m.couterr
 }
 
+func (m mockASG) DeleteTagsWithContext(aws.Context, *autoscaling.DeleteTagsInput, ...request.Option) (*autoscaling.DeleteTagsOutput, error) {
+	return m.dtgo, m.dtgerr
+}
+
+func (m mockASG) SuspendProcessesWithContext(aws.Context, *autoscaling.ScalingProcessQuery, ...request.Option) (*autoscaling.SuspendProcessesOutput, error) {
+	return m.spo, m.sperr
+}
+
+func (m mockASG) ResumeProcessesWithContext(aws.Context, *autoscaling.ScalingProcessQuery, ...request.Option) (*autoscaling.ResumeProcessesOutput, error) {
+	return m.rpo, m.rperr
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockCloudFormation struct {
@@ -225,7 +372,7 @@ type mockCloudFormation struct {
 	dserr error
 }
 
-func (m mockCloudFormation) DescribeStacks(*cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+func (m mockCloudFormation) DescribeStacksWithContext(aws.Context, *cloudformation.DescribeStacksInput, ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
 	return m.dso, m.dserr
 }
 
@@ -240,16 +387,129 @@ type mockSQS struct {
 	//DeleteMessage
 	dmo   *sqs.DeleteMessageOutput
 	dmerr error
+
+	// GetQueueUrl
+	gquo   *sqs.GetQueueUrlOutput
+	gquerr error
+}
+
+func (m mockSQS) GetQueueUrlWithContext(aws.Context, *sqs.GetQueueUrlInput, ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+	return m.gquo, m.gquerr
 }
 
-func (m mockSQS) SendMessage(*sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+func (m mockSQS) SendMessageWithContext(aws.Context, *sqs.SendMessageInput, ...request.Option) (*sqs.SendMessageOutput, error) {
 	return m.smo, m.smerr
 }
 
-func (m mockSQS) DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+func (m mockSQS) DeleteMessageWithContext(aws.Context, *sqs.DeleteMessageInput, ...request.Option) (*sqs.DeleteMessageOutput, error) {
 	return m.dmo, m.dmerr
 }
 
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	// GetMetricStatistics
+	gmso   map[string]*cloudwatch.GetMetricStatisticsOutput
+	gmserr error
+}
+
+func (m mockCloudWatch) GetMetricStatisticsWithContext(ctx aws.Context, in *cloudwatch.GetMetricStatisticsInput, opts ...request.Option) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	if m.gmserr != nil {
+		return nil, m.gmserr
+	}
+	if out, ok := m.gmso[aws.StringValue(in.MetricName)]; ok {
+		return out, nil
+	}
+	return &cloudwatch.GetMetricStatisticsOutput{}, nil
+}
+
+type mockSavingsPlans struct {
+	savingsplansiface.SavingsPlansAPI
+	// DescribeSavingsPlans
+	dspo   *savingsplans.DescribeSavingsPlansOutput
+	dsperr error
+
+	// DescribeSavingsPlanRates, by Savings Plan ID
+	dsprpo  map[string]*savingsplans.DescribeSavingsPlanRatesOutput
+	dsprerr error
+}
+
+func (m mockSavingsPlans) DescribeSavingsPlansWithContext(aws.Context, *savingsplans.DescribeSavingsPlansInput, ...request.Option) (*savingsplans.DescribeSavingsPlansOutput, error) {
+	if m.dsperr != nil {
+		return nil, m.dsperr
+	}
+	if m.dspo == nil {
+		return &savingsplans.DescribeSavingsPlansOutput{}, nil
+	}
+	return m.dspo, nil
+}
+
+func (m mockSavingsPlans) DescribeSavingsPlanRatesWithContext(ctx aws.Context, in *savingsplans.DescribeSavingsPlanRatesInput, opts ...request.Option) (*savingsplans.DescribeSavingsPlanRatesOutput, error) {
+	if m.dsprerr != nil {
+		return nil, m.dsprerr
+	}
+	if out, ok := m.dsprpo[aws.StringValue(in.SavingsPlanId)]; ok {
+		return out, nil
+	}
+	return &savingsplans.DescribeSavingsPlanRatesOutput{}, nil
+}
+
+type mockSNS struct {
+	snsiface.SNSAPI
+	// Publish
+	po   *sns.PublishOutput
+	perr error
+}
+
+func (m mockSNS) PublishWithContext(aws.Context, *sns.PublishInput, ...request.Option) (*sns.PublishOutput, error) {
+	return m.po, m.perr
+}
+
+type mockEventBridge struct {
+	eventbridgeiface.EventBridgeAPI
+	// PutEvents
+	peo   *eventbridge.PutEventsOutput
+	peerr error
+}
+
+func (m mockEventBridge) PutEventsWithContext(aws.Context, *eventbridge.PutEventsInput, ...request.Option) (*eventbridge.PutEventsOutput, error) {
+	if m.peerr != nil {
+		return nil, m.peerr
+	}
+	if m.peo == nil {
+		return &eventbridge.PutEventsOutput{}, nil
+	}
+	return m.peo, nil
+}
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// PutItem
+	pio   *dynamodb.PutItemOutput
+	pierr error
+
+	// GetItem
+	gio   *dynamodb.GetItemOutput
+	gierr error
+
+	// DeleteItem
+	dio   *dynamodb.DeleteItemOutput
+	dierr error
+}
+
+func (m mockDynamoDB) PutItemWithContext(aws.Context, *dynamodb.PutItemInput, ...request.Option) (*dynamodb.PutItemOutput, error) {
+	return m.pio, m.pierr
+}
+
+func (m mockDynamoDB) GetItemWithContext(aws.Context, *dynamodb.GetItemInput, ...request.Option) (*dynamodb.GetItemOutput, error) {
+	return m.gio, m.gierr
+}
+
+func (m mockDynamoDB) DeleteItemWithContext(aws.Context, *dynamodb.DeleteItemInput, ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	return m.dio, m.dierr
+}
+
 // utility function for checking if error messages are matching
 func errorMatches(got error, wanted error) bool {
 	if got == nil {
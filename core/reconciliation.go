@@ -0,0 +1,489 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// reconciliation.go contains the reconciliation pass executed during the cron
+// runs, which finds and cleans up the resources left behind by failed runs.
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// DefaultReconciliationMode is the default handling of the resources left
+	// behind by failed runs.
+	DefaultReconciliationMode = RepairReconciliationMode
+
+	// RepairReconciliationMode reports and cleans up the leftover resources.
+	RepairReconciliationMode = "repair"
+
+	// ReportReconciliationMode only reports the leftover resources.
+	ReportReconciliationMode = "report"
+
+	// DisabledReconciliationMode disables the reconciliation pass.
+	DisabledReconciliationMode = "off"
+
+	// suspendedProcessesTag is set on the groups whose processes were
+	// suspended, until they are resumed.
+	suspendedProcessesTag = "autospotting-suspended-processes"
+
+	// originalMaxSizeTag is set on the groups whose MaxSize was temporarily
+	// increased, holding the value it needs to be restored to.
+	originalMaxSizeTag = "autospotting-original-max-size"
+
+	// temporaryLaunchTemplatePrefix is the prefix of the launch templates
+	// created for each launch by the previous versions, which used to leak
+	// when the runs failed.
+	temporaryLaunchTemplatePrefix = "AutoSpotting-Temporary-LaunchTemplate-for-"
+
+	// the maximum number of groups described in a single API call
+	describeAutoScalingGroupsBatchSize = 50
+)
+
+// autoSpottingSuspendedProcesses are the processes suspended while replacing
+// instances, so they don't interfere with the replacements.
+var autoSpottingSuspendedProcesses = []string{"Terminate", "AZRebalance"}
+
+// leftover is a resource left behind by a failed run.
+type leftover struct {
+	kind        string
+	resource    string
+	description string
+
+	// cleans up the resource, missing for the resources that are only
+	// reported because they may not have been left behind by AutoSpotting
+	repair func() error
+}
+
+// reconcile reports and, unless running in report-only mode, cleans up the
+// resources left behind by the failed runs in this region.
+func (r *region) reconcile() {
+	if r.conf.ReconciliationMode == DisabledReconciliationMode ||
+		r.runContext().Err() != nil {
+		return
+	}
+
+	log.Println("Reconciling the resources left behind by previous runs in", r.name)
+
+	spotInstances, err := r.scanAutoSpottingInstances()
+	if err != nil {
+		log.Println(r.name, "Failed to scan the instances launched by AutoSpotting:", err.Error())
+		return
+	}
+
+	var leftovers []leftover
+	leftovers = append(leftovers, r.findOrphanSpotInstances(spotInstances)...)
+	leftovers = append(leftovers, r.findTemporaryLaunchTemplates()...)
+	leftovers = append(leftovers, r.findOrphanManagedLaunchTemplates()...)
+	leftovers = append(leftovers, r.findTemporaryGroupChanges(spotInstances)...)
+
+	if len(leftovers) == 0 {
+		debug.Println(r.name, "Nothing to reconcile")
+		return
+	}
+
+	log.Printf("%s Found %d resources left behind by previous runs", r.name, len(leftovers))
+
+	for _, l := range leftovers {
+		result := "reported only"
+		if r.conf.ReconciliationMode != ReportReconciliationMode && l.repair != nil {
+			if err := l.repair(); err != nil {
+				result = "repair failed: " + err.Error()
+			} else {
+				result = "repaired"
+			}
+		}
+
+		text := fmt.Sprintf("Reconciliation: %s %s (%s), %s",
+			l.kind, l.resource, l.description, result)
+		log.Println(r.name, text)
+		r.addToFinalRecap(text)
+	}
+}
+
+// scanAutoSpottingInstances returns the instances launched by AutoSpotting,
+// without altering the instances scanned for the enabled groups.
+func (r *region) scanAutoSpottingInstances() ([]*ec2.Instance, error) {
+	var result []*ec2.Instance
+
+	err := r.services.ec2.DescribeInstancesPagesWithContext(
+		r.runContext(),
+		&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("tag-key"),
+					Values: []*string{aws.String("launched-by-autospotting")},
+				},
+				{
+					Name: aws.String("instance-state-name"),
+					Values: aws.StringSlice([]string{
+						ec2.InstanceStateNamePending,
+						ec2.InstanceStateNameRunning,
+						ec2.InstanceStateNameStopping,
+						ec2.InstanceStateNameStopped,
+					}),
+				},
+			},
+		},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, res := range page.Reservations {
+				result = append(result, res.Instances...)
+			}
+			return true
+		},
+	)
+
+	return result, err
+}
+
+// findOrphanSpotInstances returns the spot instances launched for groups which
+// no longer exist.
+func (r *region) findOrphanSpotInstances(spotInstances []*ec2.Instance) []leftover {
+	existing := make(map[string]bool)
+	for _, group := range r.groups {
+		existing[*group.AutoScalingGroupName] = true
+	}
+
+	candidates := make(map[string][]*instance)
+	var names []string
+
+	for _, inst := range spotInstances {
+		i := &instance{Instance: inst, region: r}
+		asgName := i.getReplacementTargetASGName()
+
+		if asgName == nil || existing[*asgName] ||
+			(inst.LaunchTime != nil && time.Since(*inst.LaunchTime) < replacementResumeDelay) {
+			continue
+		}
+
+		if _, ok := candidates[*asgName]; !ok {
+			names = append(names, *asgName)
+		}
+		candidates[*asgName] = append(candidates[*asgName], i)
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	// double-check, so that failing to list the groups doesn't make all the
+	// spot instances look orphan
+	missing, err := r.findMissingAutoScalingGroups(names)
+	if err != nil {
+		log.Println(r.name, "Failed to describe AutoScaling groups:", err.Error())
+		return nil
+	}
+
+	var leftovers []leftover
+	for _, name := range missing {
+		for _, i := range candidates[name] {
+			leftovers = append(leftovers, leftover{
+				kind:        "orphan spot instance",
+				resource:    *i.InstanceId,
+				description: "launched for the missing group " + name,
+				repair:      i.terminate,
+			})
+		}
+	}
+	return leftovers
+}
+
+// findMissingAutoScalingGroups returns the names of the given groups that
+// don't exist.
+func (r *region) findMissingAutoScalingGroups(names []string) ([]string, error) {
+	found := make(map[string]bool)
+
+	for start := 0; start < len(names); start += describeAutoScalingGroupsBatchSize {
+		end := min(start+describeAutoScalingGroupsBatchSize, len(names))
+
+		err := r.services.autoScaling.DescribeAutoScalingGroupsPagesWithContext(
+			r.runContext(),
+			&autoscaling.DescribeAutoScalingGroupsInput{
+				AutoScalingGroupNames: aws.StringSlice(names[start:end]),
+			},
+			func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+				for _, group := range page.AutoScalingGroups {
+					found[*group.AutoScalingGroupName] = true
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var missing []string
+	for _, name := range names {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// findTemporaryLaunchTemplates returns the temporary launch templates that
+// should have been deleted after launching the spot instances.
+func (r *region) findTemporaryLaunchTemplates() []leftover {
+	var leftovers []leftover
+
+	err := r.services.ec2.DescribeLaunchTemplatesPagesWithContext(
+		r.runContext(),
+		&ec2.DescribeLaunchTemplatesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("launch-template-name"),
+					Values: []*string{aws.String(temporaryLaunchTemplatePrefix + "*")},
+				},
+			},
+		},
+		func(page *ec2.DescribeLaunchTemplatesOutput, lastPage bool) bool {
+			for _, lt := range page.LaunchTemplates {
+				if lt.CreateTime == nil || time.Since(*lt.CreateTime) < replacementResumeDelay {
+					continue
+				}
+
+				ltName := lt.LaunchTemplateName
+				leftovers = append(leftovers, leftover{
+					kind:        "temporary launch template",
+					resource:    *ltName,
+					description: "created at " + lt.CreateTime.UTC().Format(time.RFC3339),
+					repair: func() error {
+						return r.deleteLaunchTemplate(ltName)
+					},
+				})
+			}
+			return true
+		},
+	)
+
+	if err != nil {
+		log.Println(r.name, "Failed to describe launch templates:", err.Error())
+		return nil
+	}
+	return leftovers
+}
+
+// findOrphanManagedLaunchTemplates returns the managed launch templates of the
+// groups which no longer exist.
+func (r *region) findOrphanManagedLaunchTemplates() []leftover {
+	existing := make(map[string]bool)
+	for _, group := range r.groups {
+		existing[*group.AutoScalingGroupName] = true
+	}
+
+	candidates := make(map[string][]*ec2.LaunchTemplate)
+	var names []string
+
+	err := r.services.ec2.DescribeLaunchTemplatesPagesWithContext(
+		r.runContext(),
+		&ec2.DescribeLaunchTemplatesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("launch-template-name"),
+					Values: []*string{aws.String(managedLaunchTemplatePrefix + "*")},
+				},
+			},
+		},
+		func(page *ec2.DescribeLaunchTemplatesOutput, lastPage bool) bool {
+			for _, lt := range page.LaunchTemplates {
+				var asgName string
+				for _, tag := range lt.Tags {
+					if aws.StringValue(tag.Key) == "launched-for-asg" {
+						asgName = aws.StringValue(tag.Value)
+					}
+				}
+
+				if asgName == "" || existing[asgName] ||
+					(lt.CreateTime != nil && time.Since(*lt.CreateTime) < replacementResumeDelay) {
+					continue
+				}
+
+				if _, ok := candidates[asgName]; !ok {
+					names = append(names, asgName)
+				}
+				candidates[asgName] = append(candidates[asgName], lt)
+			}
+			return true
+		},
+	)
+
+	if err != nil {
+		log.Println(r.name, "Failed to describe launch templates:", err.Error())
+		return nil
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	missing, err := r.findMissingAutoScalingGroups(names)
+	if err != nil {
+		log.Println(r.name, "Failed to describe AutoScaling groups:", err.Error())
+		return nil
+	}
+
+	var leftovers []leftover
+	for _, name := range missing {
+		for _, lt := range candidates[name] {
+			ltName := lt.LaunchTemplateName
+			leftovers = append(leftovers, leftover{
+				kind:        "orphan launch template",
+				resource:    *ltName,
+				description: "managed for the missing group " + name,
+				repair: func() error {
+					return r.deleteLaunchTemplate(ltName)
+				},
+			})
+		}
+	}
+	return leftovers
+}
+
+// findTemporaryGroupChanges returns the suspended processes and raised MaxSize
+// values of the groups without any replacement in progress, found from the
+// marker tags. The processes suspended on the enabled groups by the versions
+// predating the marker tags are only reported, since they may as well have
+// been suspended by their owners.
+func (r *region) findTemporaryGroupChanges(spotInstances []*ec2.Instance) []leftover {
+	var leftovers []leftover
+
+	for _, group := range r.groups {
+		a := &autoScalingGroup{Group: group, name: *group.AutoScalingGroupName, region: r}
+
+		suspended := a.getTagValue(suspendedProcessesTag)
+		maxSize := a.getTagValue(originalMaxSizeTag)
+
+		var untagged *string
+		if suspended == nil && r.findEnabledASGByName(a.name) != nil {
+			untagged = a.suspendedAutoSpottingProcesses()
+		}
+
+		if (suspended == nil && maxSize == nil && untagged == nil) ||
+			r.hasReplacementsInProgress(a.name, spotInstances) {
+			continue
+		}
+
+		if suspended != nil {
+			leftovers = append(leftovers, leftover{
+				kind:        "suspended processes",
+				resource:    a.name,
+				description: *suspended,
+				repair: func() error {
+					return a.withLock(a.resumeProcesses)
+				},
+			})
+		}
+
+		if untagged != nil {
+			leftovers = append(leftovers, leftover{
+				kind:        "suspended processes",
+				resource:    a.name,
+				description: *untagged + ", possibly suspended by a previous version",
+			})
+		}
+
+		if maxSize != nil {
+			leftovers = append(leftovers, a.raisedMaxSizeLeftover(*maxSize))
+		}
+	}
+	return leftovers
+}
+
+// suspendedAutoSpottingProcesses returns the processes suspended by AutoSpotting
+// while replacing instances, when the group has all of them suspended, or nil
+// otherwise.
+func (a *autoScalingGroup) suspendedAutoSpottingProcesses() *string {
+	var suspended []string
+	for _, p := range a.SuspendedProcesses {
+		if itemInSlice(aws.StringValue(p.ProcessName), autoSpottingSuspendedProcesses) {
+			suspended = append(suspended, aws.StringValue(p.ProcessName))
+		}
+	}
+
+	if len(suspended) < len(autoSpottingSuspendedProcesses) {
+		return nil
+	}
+	return aws.String(strings.Join(autoSpottingSuspendedProcesses, ","))
+}
+
+func (a *autoScalingGroup) raisedMaxSizeLeftover(tagValue string) leftover {
+	originalMaxSize, err := strconv.ParseInt(tagValue, 10, 64)
+	if err != nil {
+		return leftover{
+			kind:        "raised MaxSize",
+			resource:    a.name,
+			description: "invalid original MaxSize " + tagValue,
+			repair: func() error {
+				return a.withLock(func() error {
+					return a.deleteMarkerTag(originalMaxSizeTag)
+				})
+			},
+		}
+	}
+
+	return leftover{
+		kind:     "raised MaxSize",
+		resource: a.name,
+		description: fmt.Sprintf("MaxSize %d, originally %d",
+			*a.MaxSize, originalMaxSize),
+		repair: func() error {
+			return a.withLock(func() error {
+				// only revert our own change, in case the MaxSize was changed since
+				if *a.MaxSize == originalMaxSize+1 {
+					if err := a.setAutoScalingMaxSize(originalMaxSize); err != nil {
+						return err
+					}
+				} else {
+					log.Printf("%s MaxSize changed to %d since it was raised, keeping it",
+						a.name, *a.MaxSize)
+				}
+				return a.deleteMarkerTag(originalMaxSizeTag)
+			})
+		},
+	}
+}
+
+// hasReplacementsInProgress tells if any replacement is still running or may
+// be resumed in the given group, in which case its temporary changes are still
+// needed.
+func (r *region) hasReplacementsInProgress(asgName string, spotInstances []*ec2.Instance) bool {
+	enabled := r.findEnabledASGByName(asgName) != nil
+	store := r.replacementStore()
+
+	for _, inst := range spotInstances {
+		i := &instance{Instance: inst, region: r}
+		if name := i.getReplacementTargetASGName(); name == nil || *name != asgName {
+			continue
+		}
+
+		rep, err := store.load(i)
+		if err != nil {
+			return true
+		}
+
+		if rep == nil {
+			// no state was persisted yet
+			if inst.LaunchTime != nil && time.Since(*inst.LaunchTime) < replacementResumeDelay {
+				return true
+			}
+			continue
+		}
+
+		// the interrupted replacements from enabled groups are resumed later
+		if !rep.state.isFinal() &&
+			(enabled || time.Since(rep.updated) < replacementResumeDelay) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,353 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func spotInstanceLaunchedFor(id, asgName string, launchTime time.Time, tags ...*ec2.Tag) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId: aws.String(id),
+		LaunchTime: aws.Time(launchTime),
+		State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		Tags: append([]*ec2.Tag{
+			{Key: aws.String("launched-by-autospotting"), Value: aws.String("true")},
+			{Key: aws.String("launched-for-asg"), Value: aws.String(asgName)},
+		}, tags...),
+	}
+}
+
+func leftoverResources(leftovers []leftover) []string {
+	var resources []string
+	for _, l := range leftovers {
+		resources = append(resources, l.kind+":"+l.resource)
+	}
+	return resources
+}
+
+func Test_region_findOrphanSpotInstances(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		groups    []*autoscaling.Group
+		described []*autoscaling.Group
+		instances []*ec2.Instance
+		want      []string
+	}{
+		{
+			name: "group exists",
+			groups: []*autoscaling.Group{
+				{AutoScalingGroupName: aws.String("asg")},
+			},
+			instances: []*ec2.Instance{
+				spotInstanceLaunchedFor("i-spot", "asg", old),
+			},
+			want: nil,
+		},
+		{
+			name: "group missing",
+			instances: []*ec2.Instance{
+				spotInstanceLaunchedFor("i-spot", "deleted", old),
+			},
+			want: []string{"orphan spot instance:i-spot"},
+		},
+		{
+			name: "group missing from the initial scan",
+			described: []*autoscaling.Group{
+				{AutoScalingGroupName: aws.String("asg")},
+			},
+			instances: []*ec2.Instance{
+				spotInstanceLaunchedFor("i-spot", "asg", old),
+			},
+			want: nil,
+		},
+		{
+			name: "recently launched instance",
+			instances: []*ec2.Instance{
+				spotInstanceLaunchedFor("i-spot", "deleted", time.Now()),
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				groups: tt.groups,
+				services: connections{
+					autoScaling: mockASG{
+						dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+							AutoScalingGroups: tt.described,
+						},
+					},
+				},
+			}
+			got := leftoverResources(r.findOrphanSpotInstances(tt.instances))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findOrphanSpotInstances() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_findTemporaryLaunchTemplates(t *testing.T) {
+	r := &region{
+		services: connections{
+			ec2: mockEC2{
+				dltpo: []*ec2.DescribeLaunchTemplatesOutput{
+					{
+						LaunchTemplates: []*ec2.LaunchTemplate{
+							{
+								LaunchTemplateName: aws.String(temporaryLaunchTemplatePrefix + "i-old"),
+								CreateTime:         aws.Time(time.Now().Add(-time.Hour)),
+							},
+							{
+								LaunchTemplateName: aws.String(temporaryLaunchTemplatePrefix + "i-new"),
+								CreateTime:         aws.Time(time.Now()),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	want := []string{"temporary launch template:" + temporaryLaunchTemplatePrefix + "i-old"}
+	if got := leftoverResources(r.findTemporaryLaunchTemplates()); !reflect.DeepEqual(got, want) {
+		t.Errorf("findTemporaryLaunchTemplates() = %v, want %v", got, want)
+	}
+}
+
+func Test_region_findOrphanManagedLaunchTemplates(t *testing.T) {
+	old := aws.Time(time.Now().Add(-time.Hour))
+	managedFor := func(asgName string, created *time.Time) *ec2.LaunchTemplate {
+		return &ec2.LaunchTemplate{
+			LaunchTemplateName: aws.String(managedLaunchTemplatePrefix + asgName),
+			CreateTime:         created,
+			Tags: []*ec2.Tag{
+				{Key: aws.String("launched-for-asg"), Value: aws.String(asgName)},
+			},
+		}
+	}
+
+	r := &region{
+		groups: []*autoscaling.Group{
+			{AutoScalingGroupName: aws.String("asg")},
+		},
+		services: connections{
+			ec2: mockEC2{
+				dltpo: []*ec2.DescribeLaunchTemplatesOutput{
+					{
+						LaunchTemplates: []*ec2.LaunchTemplate{
+							managedFor("asg", old),
+							managedFor("deleted", old),
+							managedFor("disabled", old),
+							managedFor("recent", aws.Time(time.Now())),
+							{
+								LaunchTemplateName: aws.String(managedLaunchTemplatePrefix + "untagged"),
+								CreateTime:         old,
+							},
+						},
+					},
+				},
+			},
+			autoScaling: mockASG{
+				dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+					AutoScalingGroups: []*autoscaling.Group{
+						{AutoScalingGroupName: aws.String("disabled")},
+					},
+				},
+			},
+		},
+	}
+
+	want := []string{"orphan launch template:" + managedLaunchTemplatePrefix + "deleted"}
+	if got := leftoverResources(r.findOrphanManagedLaunchTemplates()); !reflect.DeepEqual(got, want) {
+		t.Errorf("findOrphanManagedLaunchTemplates() = %v, want %v", got, want)
+	}
+}
+
+func Test_region_findTemporaryGroupChanges(t *testing.T) {
+	markedGroup := func() []*autoscaling.Group {
+		return []*autoscaling.Group{
+			{
+				AutoScalingGroupName: aws.String("asg"),
+				MaxSize:              aws.Int64(4),
+				Tags: []*autoscaling.TagDescription{
+					{Key: aws.String(suspendedProcessesTag), Value: aws.String("Terminate,AZRebalance")},
+					{Key: aws.String(originalMaxSizeTag), Value: aws.String("3")},
+				},
+			},
+		}
+	}
+
+	suspendedGroup := func() []*autoscaling.Group {
+		return []*autoscaling.Group{
+			{
+				AutoScalingGroupName: aws.String("asg"),
+				SuspendedProcesses: []*autoscaling.SuspendedProcess{
+					{ProcessName: aws.String("Terminate")},
+					{ProcessName: aws.String("AZRebalance")},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		groups     []*autoscaling.Group
+		enabled    bool
+		instances  []*ec2.Instance
+		want       []string
+		reportOnly bool
+	}{
+		{
+			name: "unmarked group",
+			groups: []*autoscaling.Group{
+				{AutoScalingGroupName: aws.String("asg")},
+			},
+			want: nil,
+		},
+		{
+			name:   "unmarked group with suspended processes",
+			groups: suspendedGroup(),
+			want:   nil,
+		},
+		{
+			name:       "unmarked enabled group with suspended processes",
+			groups:     suspendedGroup(),
+			enabled:    true,
+			want:       []string{"suspended processes:asg"},
+			reportOnly: true,
+		},
+		{
+			name: "unmarked group with other suspended processes",
+			groups: []*autoscaling.Group{
+				{
+					AutoScalingGroupName: aws.String("asg"),
+					SuspendedProcesses: []*autoscaling.SuspendedProcess{
+						{ProcessName: aws.String("AZRebalance")},
+						{ProcessName: aws.String("ScheduledActions")},
+					},
+				},
+			},
+			want: nil,
+		},
+		{
+			name:   "marked group without replacements",
+			groups: markedGroup(),
+			want:   []string{"suspended processes:asg", "raised MaxSize:asg"},
+		},
+		{
+			name:   "marked group with a finished replacement",
+			groups: markedGroup(),
+			instances: []*ec2.Instance{
+				spotInstanceLaunchedFor("i-spot", "asg", time.Now().Add(-time.Hour),
+					&ec2.Tag{Key: aws.String(replacementStateTag), Value: aws.String(string(replacementCompleted))}),
+			},
+			want: []string{"suspended processes:asg", "raised MaxSize:asg"},
+		},
+		{
+			name:   "marked group with a replacement in progress",
+			groups: markedGroup(),
+			instances: []*ec2.Instance{
+				spotInstanceLaunchedFor("i-spot", "asg", time.Now().Add(-time.Hour),
+					&ec2.Tag{Key: aws.String(replacementStateTag), Value: aws.String(string(replacementAttaching))},
+					&ec2.Tag{Key: aws.String(replacementUpdatedTag), Value: aws.String(time.Now().UTC().Format(time.RFC3339))}),
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				conf:   &Config{},
+				groups: tt.groups,
+			}
+			if tt.enabled {
+				r.enabledASGs = []autoScalingGroup{{name: "asg"}}
+			}
+
+			leftovers := r.findTemporaryGroupChanges(tt.instances)
+			got := leftoverResources(leftovers)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findTemporaryGroupChanges() = %v, want %v", got, tt.want)
+			}
+			for _, l := range leftovers {
+				if (l.repair == nil) != tt.reportOnly {
+					t.Errorf("findTemporaryGroupChanges() %s repairable = %v, want %v",
+						l.resource, l.repair != nil, !tt.reportOnly)
+				}
+			}
+		})
+	}
+}
+
+func Test_region_reconcile(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		want string
+	}{
+		{
+			name: "repair",
+			mode: RepairReconciliationMode,
+			want: "repaired",
+		},
+		{
+			name: "report only",
+			mode: ReportReconciliationMode,
+			want: "reported only",
+		},
+		{
+			name: "disabled",
+			mode: DisabledReconciliationMode,
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{
+					ReconciliationMode: tt.mode,
+					FinalRecap:         make(map[string][]string),
+				},
+				services: connections{
+					ec2: mockEC2{
+						dio: &ec2.DescribeInstancesOutput{},
+						dltpo: []*ec2.DescribeLaunchTemplatesOutput{
+							{
+								LaunchTemplates: []*ec2.LaunchTemplate{
+									{
+										LaunchTemplateName: aws.String(temporaryLaunchTemplatePrefix + "i-old"),
+										CreateTime:         aws.Time(time.Now().Add(-time.Hour)),
+									},
+								},
+							},
+						},
+					},
+				},
+			}
+
+			r.reconcile()
+
+			recap := strings.Join(r.conf.FinalRecap[r.name], "\n")
+			if tt.want == "" && recap != "" {
+				t.Errorf("reconcile() recap = %q, want none", recap)
+			}
+			if !strings.Contains(recap, tt.want) {
+				t.Errorf("reconcile() recap = %q, want it to contain %q", recap, tt.want)
+			}
+		})
+	}
+}
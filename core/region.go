@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -30,6 +31,10 @@ type Tag struct {
 type region struct {
 	name string
 
+	// context of the current run, cancelled shortly before the Lambda function
+	// times out
+	ctx context.Context
+
 	autospotting *AutoSpotting
 	conf         *Config
 	// The key in this map is the instance type.
@@ -37,8 +42,16 @@ type region struct {
 
 	instances instances
 
+	// the capacity reservations and Reserved Instances of the region, nil if
+	// they weren't loaded
+	reservations *reservations
+
 	enabledASGs []autoScalingGroup
-	services    connections
+
+	// all the groups from the region, including the ones not enabled
+	groups []*autoscaling.Group
+
+	services connections
 
 	tagsToFilterASGsBy []Tag
 
@@ -50,6 +63,9 @@ type prices struct {
 	spot         spotPriceMap
 	ebsSurcharge float64
 	premium      float64
+
+	// the Savings Plans rate included in the on-demand price, if any
+	savingsPlanRate float64
 }
 
 // The key in this map is the availability zone
@@ -65,6 +81,10 @@ type instanceTypeFamilyInfoCache map[string]*instanceTypeFamilyInfo
 // Stores the maximum generation for each instance type
 type instanceTypeMaxGenerationCache map[string]int64
 
+func (r *region) runContext() context.Context {
+	return contextOrBackground(r.ctx)
+}
+
 func (r *region) enabled() bool {
 
 	var enabledRegions []string
@@ -100,6 +120,8 @@ func (r *region) processRegion() {
 	log.Println("Scanning for enabled AutoScaling groups in ", r.name)
 	r.scanForEnabledAutoScalingGroups()
 
+	r.reconcile()
+
 	// only process further the region if there are any enabled autoscaling groups
 	// within it
 	if r.hasEnabledAutoScalingGroups() {
@@ -112,6 +134,11 @@ func (r *region) processRegion() {
 			log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
 		}
 
+		log.Println("Scanning capacity reservations and Reserved Instances in", r.name)
+		r.loadReservations()
+
+		r.processDelayedTerminations()
+
 		log.Println("Processing enabled AutoScaling groups in", r.name)
 		r.processEnabledAutoScalingGroups()
 	} else {
@@ -183,7 +210,8 @@ func (r *region) scanInstances() error {
 
 	r.instances = makeInstances()
 
-	err := svc.DescribeInstancesPages(
+	err := svc.DescribeInstancesPagesWithContext(
+		r.runContext(),
 		input,
 		r.processDescribeInstancesPage)
 
@@ -209,7 +237,8 @@ func (r *region) scanInstance(instanceID *string) error {
 
 	r.instances = makeInstances()
 
-	err := svc.DescribeInstancesPages(
+	err := svc.DescribeInstancesPagesWithContext(
+		r.runContext(),
 		input,
 		r.processDescribeInstancesPage)
 
@@ -237,6 +266,8 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 	itfic := make(instanceTypeFamilyInfoCache)
 	itmgc := make(instanceTypeMaxGenerationCache)
 
+	savingsPlanRates := r.loadSavingsPlanRates()
+
 	for _, it := range *cfg.InstanceData {
 
 		var price prices
@@ -252,6 +283,13 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 		// region, so we don't even need to create an empty spot pricing
 		// data structure for it
 		if price.onDemand > 0 {
+			// the instance types covered by Savings Plans are cheaper than
+			// their list price
+			if rate, ok := savingsPlanRates[it.InstanceType]; ok {
+				price.savingsPlanRate = rate
+				price.onDemand = r.savingsPlansPrice(price.onDemand, rate)
+			}
+
 			// for each instance type populate the HW spec information
 			info = instanceTypeInformation{
 				instanceType:        it.InstanceType,
@@ -263,7 +301,11 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 				virtualizationTypes: it.LinuxVirtualizationTypes,
 				hasEBSOptimization:  it.EBSOptimized,
 				EBSThroughput:       it.EBSThroughput,
+				networkPerformance:  parseNetworkPerformance(it.NetworkPerformance),
 				generationDelta:     calculateGenerationDelta(cfg.InstanceData, it.InstanceType, &itfic, &itmgc),
+				burstable:           isBurstableInstanceType(it.InstanceType),
+				bareMetal:           strings.HasSuffix(it.InstanceType, ".metal"),
+				previousGeneration:  it.Generation == "previous",
 			}
 
 			if it.Storage != nil {
@@ -279,6 +321,11 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 	// return entries about the available instance types, so no invalid instance
 	// types would be returned
 
+	if err := r.loadInstanceTypeCatalog(); err != nil {
+		log.Println(r.name, "Couldn't load the instance type catalog, falling back to the embedded instance",
+			"type data and skipping the compatibility checks relying on the catalog:", err.Error())
+	}
+
 	if err := r.requestSpotPrices(); err != nil {
 		log.Println(err.Error())
 	}
@@ -287,7 +334,7 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 
 func (r *region) requestSpotPrices() error {
 
-	s := spotPrices{conn: r.services}
+	s := spotPrices{conn: r.services, ctx: r.ctx}
 
 	// Retrieve all current spot prices from the current region.
 	// TODO: add support for other OSes
@@ -380,7 +427,7 @@ func (r *region) isStackUpdating(stackName *string) (string, bool) {
 		StackName: stackName,
 	}
 
-	if output, err := svc.DescribeStacks(&input); err != nil {
+	if output, err := svc.DescribeStacksWithContext(r.runContext(), &input); err != nil {
 		log.Println("Failed to describe stack", *stackName, "with error:", err.Error())
 	} else {
 		stackStatus := output.Stacks[0].StackStatus
@@ -446,13 +493,15 @@ func (r *region) scanForEnabledAutoScalingGroups() {
 	svc := r.services.autoScaling
 
 	pageNum := 0
-	err := svc.DescribeAutoScalingGroupsPages(
+	err := svc.DescribeAutoScalingGroupsPagesWithContext(
+		r.runContext(),
 		&autoscaling.DescribeAutoScalingGroupsInput{},
 		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
 			pageNum++
 			debug.Println("Processing page", pageNum, "of DescribeAutoScalingGroupsPages for", r.name, "lastPage is", lastPage)
 			matchingAsgs := r.findMatchingASGsInPageOfResults(page.AutoScalingGroups, r.tagsToFilterASGsBy)
 			r.enabledASGs = append(r.enabledASGs, matchingAsgs...)
+			r.groups = append(r.groups, page.AutoScalingGroups...)
 			return true
 		},
 	)
@@ -472,6 +521,11 @@ func (r *region) hasEnabledAutoScalingGroups() bool {
 func (r *region) processEnabledAutoScalingGroups() {
 	for _, asg := range r.enabledASGs {
 
+		if err := r.runContext().Err(); err != nil {
+			log.Println(r.name, "Run cancelled, skipping the remaining AutoScaling groups:", err.Error())
+			break
+		}
+
 		// Pass default configs to the group
 		asg.config = r.conf.AutoScalingConfig
 		asg.autospotting = r.autospotting
@@ -480,7 +534,7 @@ func (r *region) processEnabledAutoScalingGroups() {
 		go func(a autoScalingGroup) {
 			action := a.cronEventAction()
 			action.run()
-			a.resumeProcesses()
+			a.withLock(a.resumeProcesses)
 			r.wg.Done()
 		}(asg)
 	}
@@ -514,7 +568,8 @@ func (r *region) sqsSendMessageOnInstanceLaunch(asgName, instanceID, instanceSta
 	// replace whitespaces with dashes, fixing #494
 	groupID = strings.ReplaceAll(groupID, " ", "-")
 
-	_, err := svc.SendMessage(
+	_, err := svc.SendMessageWithContext(
+		r.runContext(),
 		&sqs.SendMessageInput{
 			MessageBody:    &inputJSON,
 			MessageGroupId: aws.String(groupID),
@@ -536,7 +591,16 @@ func (r *region) sqsSendMessageOnInstanceLaunch(asgName, instanceID, instanceSta
 func (r *region) sqsDeleteMessage(instanceID *string, instanceLifecycle string) error {
 	svc := r.services.sqs
 
-	_, err := svc.DeleteMessage(
+	// Keeping the message in the queue makes SQS deliver it again once its
+	// visibility timeout expires, so that a later run resumes the replacement
+	if err := r.runContext().Err(); err != nil {
+		log.Printf("%s Run cancelled, keeping %s instance %s launch event message "+
+			"in the SQS Queue %s for a later run", r.name, instanceLifecycle, *instanceID, r.conf.SQSQueueURL)
+		return err
+	}
+
+	_, err := svc.DeleteMessageWithContext(
+		r.runContext(),
 		&sqs.DeleteMessageInput{
 			QueueUrl:      &r.conf.SQSQueueURL,
 			ReceiptHandle: &r.conf.sqsReceiptHandle,
@@ -567,6 +631,9 @@ func (r *region) calculateSavings() float64 {
 		log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
 	}
 
+	log.Println("Scanning capacity reservations and Reserved Instances in", r.name)
+	r.loadReservations()
+
 	log.Println("Calculating AutoSpotting savings in", r.name)
 
 	for inst := range r.instances.instances() {
@@ -581,3 +648,32 @@ func (r *region) calculateSavings() float64 {
 	log.Printf("Total savings in %s: %f\n", r.name, savings)
 	return savings
 }
+
+func (r *region) deleteLaunchTemplate(ltName *string) error {
+	// the launch template needs to be cleaned up even if the run was cancelled
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	_, err := r.services.ec2.DeleteLaunchTemplateWithContext(ctx, &ec2.DeleteLaunchTemplateInput{
+		LaunchTemplateName: ltName,
+	})
+
+	if err != nil {
+		log.Printf("Issue while deleting launch template %v, error: %v", *ltName, err.Error())
+	}
+	return err
+}
+
+// finalRecapMutex guards the FinalRecap map, populated concurrently by all the
+// regions and groups processed during a cron run.
+var finalRecapMutex sync.Mutex
+
+func (r *region) addToFinalRecap(text string) {
+	finalRecapMutex.Lock()
+	defer finalRecapMutex.Unlock()
+
+	if r.conf.FinalRecap == nil {
+		r.conf.FinalRecap = make(map[string][]string)
+	}
+	r.conf.FinalRecap[r.name] = append(r.conf.FinalRecap[r.name], text)
+}
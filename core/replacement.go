@@ -0,0 +1,467 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// replacement.go contains the state machine driving the replacement of an
+// on-demand instance with a spot instance. The replacement spans multiple API
+// calls and possibly multiple runs, so its state is persisted after each step
+// and any later run can resume it from where the previous one stopped.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+type replacementState string
+
+const (
+	// the spot instance was launched but may not be running yet
+	replacementLaunched replacementState = "launched"
+
+	// the spot instance is running, and its launch lifecycle hooks can be
+	// emulated before attaching it to the group
+	replacementRunning replacementState = "running"
+
+	// the emulated launch lifecycle hooks of the spot instance are waiting to
+	// be completed before attaching it to the group
+	replacementLifecycleHooksPending replacementState = "lifecycle-hooks-pending"
+
+	// the spot instance is running and can be attached to the group
+	replacementReady replacementState = "ready"
+
+	// the group processes were suspended and its MaxSize possibly increased
+	// in order to make room for the spot instance
+	replacementAttaching replacementState = "attaching"
+
+	// the spot instance was attached to the group and is InService
+	replacementAttached replacementState = "attached"
+
+	// the on-demand instance was terminated
+	replacementOnDemandTerminated replacementState = "on-demand-terminated"
+
+	// the MaxSize of the group was restored, nothing left to do
+	replacementCompleted replacementState = "completed"
+
+	// the replacement was abandoned and the spot instance terminated
+	replacementFailed replacementState = "failed"
+)
+
+// replacementResumeDelay is the time after which a replacement that didn't
+// make any progress is considered interrupted, and can be resumed by another
+// run. It matches the maximum duration of a Lambda function invocation.
+const replacementResumeDelay = 15 * time.Minute
+
+// errReplacementPending is returned by the steps waiting for something outside
+// of AutoSpotting, in which case the replacement is resumed by a later run.
+var errReplacementPending = errors.New("replacement pending")
+
+// replacementTransitions lists the states that can follow each of the states.
+// The states missing from here are final.
+var replacementTransitions = map[replacementState][]replacementState{
+	replacementLaunched:              {replacementRunning, replacementFailed},
+	replacementRunning:               {replacementLifecycleHooksPending, replacementReady, replacementFailed},
+	replacementLifecycleHooksPending: {replacementReady, replacementFailed},
+	replacementReady:                 {replacementAttaching, replacementFailed},
+	replacementAttaching:             {replacementAttached, replacementFailed},
+	replacementAttached:              {replacementOnDemandTerminated},
+	replacementOnDemandTerminated:    {replacementCompleted},
+}
+
+func (s replacementState) isFinal() bool {
+	_, ok := replacementTransitions[s]
+	return !ok
+}
+
+func (s replacementState) canTransitionTo(next replacementState) bool {
+	for _, state := range replacementTransitions[s] {
+		if state == next {
+			return true
+		}
+	}
+	return false
+}
+
+// failsOnError tells if an error encountered in this state abandons the
+// replacement. Once the spot instance is attached to the group the remaining
+// steps are retried by later runs instead.
+func (s replacementState) failsOnError() bool {
+	return s.canTransitionTo(replacementFailed)
+}
+
+// replacement is the persisted state of the replacement of an on-demand
+// instance with a spot instance.
+type replacement struct {
+	state              replacementState
+	spotInstanceID     string
+	onDemandInstanceID string
+	asgName            string
+
+	// set when the MaxSize of the group was temporarily increased
+	originalMaxSize *int64
+
+	// the state of the emulated launch lifecycle hooks of the spot instance
+	lifecycleActionToken   string
+	pendingLifecycleHooks  []string
+	lifecycleHooksDeadline time.Time
+	lifecycleActionResult  string
+
+	updated time.Time
+}
+
+// newReplacement creates the initial state of the replacement for a spot
+// instance launched by a previous run, based on its tags.
+func newReplacement(spot *instance) *replacement {
+	r := &replacement{
+		state:          replacementLaunched,
+		spotInstanceID: *spot.InstanceId,
+	}
+
+	if spot.State != nil && *spot.State.Name == ec2.InstanceStateNameRunning {
+		r.state = replacementRunning
+	}
+	if id := spot.getReplacementTargetInstanceID(); id != nil {
+		r.onDemandInstanceID = *id
+	}
+	if asgName := spot.getReplacementTargetASGName(); asgName != nil {
+		r.asgName = *asgName
+	}
+	return r
+}
+
+type replacementStep func(m *replacementMachine) (replacementState, error)
+
+// replacementSteps maps each non-final state to the step performing the work
+// needed for moving to the next state.
+var replacementSteps = map[replacementState]replacementStep{
+	replacementLaunched:              (*replacementMachine).waitForSpotInstance,
+	replacementRunning:               (*replacementMachine).startLaunchLifecycleHooks,
+	replacementLifecycleHooksPending: (*replacementMachine).waitForLaunchLifecycleHooks,
+	replacementReady:                 (*replacementMachine).prepareGroup,
+	replacementAttaching:             (*replacementMachine).attachSpotInstance,
+	replacementAttached:              (*replacementMachine).terminateOnDemandInstance,
+	replacementOnDemandTerminated:    (*replacementMachine).restoreGroup,
+}
+
+type replacementMachine struct {
+	*replacement
+	store replacementStore
+	steps map[replacementState]replacementStep
+	asg   *autoScalingGroup
+
+	// the spot instance, missing until it was scanned
+	spot *instance
+
+	// set while holding the lock of the group
+	locked bool
+
+	// stops the background renewals of the group lock
+	stopLockRenewal func()
+}
+
+func newReplacementMachine(asg *autoScalingGroup, spot *instance, r *replacement) *replacementMachine {
+	return &replacementMachine{
+		replacement: r,
+		store:       asg.region.replacementStore(),
+		steps:       replacementSteps,
+		asg:         asg,
+		spot:        spot,
+	}
+}
+
+// run executes the steps of the replacement until it reaches a final state,
+// persisting the state after each of them.
+func (m *replacementMachine) run() error {
+	ctx := m.asg.region.runContext()
+	defer m.unlockGroup()
+
+	for !m.state.isFinal() {
+		if err := ctx.Err(); err != nil {
+			log.Printf("%s Run cancelled, the replacement of %s by spot instance %s "+
+				"stopped in state %s and will be resumed by a later run",
+				m.asg.name, m.onDemandInstanceID, m.spotInstanceID, m.state)
+			return err
+		}
+
+		step, ok := m.steps[m.state]
+		if !ok {
+			return fmt.Errorf("no step defined for replacement state %s", m.state)
+		}
+
+		// the steps following the launch change the group, and may race with
+		// other runs changing it. The runs launching the spot instances
+		// already hold the lock while waiting for them.
+		if m.state != replacementLaunched || m.locked {
+			if err := m.lockGroup(); err != nil {
+				log.Printf("%s Replacement by spot instance %s stopped in state %s "+
+					"and will be resumed by a later run: %s",
+					m.asg.name, m.spotInstanceID, m.state, err.Error())
+				return err
+			}
+		}
+
+		next, err := step(m)
+		if errors.Is(err, errReplacementPending) {
+			log.Printf("%s Replacement of %s by spot instance %s is pending in state %s: %s",
+				m.asg.name, m.onDemandInstanceID, m.spotInstanceID, m.state, err.Error())
+			return nil
+		}
+		if err != nil {
+			log.Printf("%s Replacement of %s by spot instance %s failed in state %s: %s",
+				m.asg.name, m.onDemandInstanceID, m.spotInstanceID, m.state, err.Error())
+			if ctx.Err() == nil && m.state.failsOnError() {
+				m.fail()
+			}
+			return err
+		}
+
+		if err := m.transition(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockGroup takes the lock of the group, or checks it's still held before
+// each step. The lock is also renewed in the background, so that it doesn't
+// expire during the long steps.
+func (m *replacementMachine) lockGroup() error {
+	if m.locked {
+		if err := m.asg.tryLock(); err != nil {
+			return err
+		}
+	} else {
+		if err := m.asg.waitForLock(); err != nil {
+			return err
+		}
+		m.locked = true
+	}
+
+	if m.stopLockRenewal == nil {
+		m.stopLockRenewal = m.asg.keepLock()
+	}
+	return nil
+}
+
+func (m *replacementMachine) unlockGroup() {
+	if m.stopLockRenewal != nil {
+		m.stopLockRenewal()
+		m.stopLockRenewal = nil
+	}
+	if m.locked {
+		m.asg.unlock()
+		m.locked = false
+	}
+}
+
+func (m *replacementMachine) transition(next replacementState) error {
+	if !m.state.canTransitionTo(next) {
+		return fmt.Errorf("invalid replacement state transition from %s to %s",
+			m.state, next)
+	}
+
+	previous := m.state
+	m.state, m.updated = next, time.Now()
+
+	if err := m.store.save(m.replacement); err != nil {
+		log.Printf("Couldn't persist the state %s of the replacement by spot instance %s: %s",
+			next, m.spotInstanceID, err.Error())
+		m.state = previous
+		return err
+	}
+
+	debug.Printf("%s Replacement by spot instance %s moved from state %s to %s",
+		m.asg.name, m.spotInstanceID, previous, next)
+	return nil
+}
+
+// fail abandons the replacement, terminating the spot instance and restoring
+// the MaxSize of the group if it was changed.
+func (m *replacementMachine) fail() {
+	if m.originalMaxSize != nil {
+		log.Println(m.asg.name, "Restoring MaxSize to", *m.originalMaxSize)
+		if m.asg.setAutoScalingMaxSize(*m.originalMaxSize) == nil {
+			m.asg.deleteMarkerTag(originalMaxSizeTag)
+		}
+	}
+
+	// a spot instance which wasn't scanned yet is left to be resumed later
+	if m.spot == nil {
+		return
+	}
+
+	log.Printf("Terminating spot instance %s", m.spotInstanceID)
+	m.spot.terminate()
+	m.transition(replacementFailed)
+}
+
+func (m *replacementMachine) waitForSpotInstance() (replacementState, error) {
+	r := m.asg.region
+
+	log.Printf("Waiting for spot instance %s to be in status running", m.spotInstanceID)
+	err := r.services.ec2.WaitUntilInstanceRunningWithContext(
+		r.runContext(),
+		&ec2.DescribeInstancesInput{
+			InstanceIds: []*string{aws.String(m.spotInstanceID)},
+		})
+	if err != nil {
+		return m.state, fmt.Errorf("spot instance %s didn't start: %s",
+			m.spotInstanceID, err.Error())
+	}
+
+	if err := r.scanInstance(aws.String(m.spotInstanceID)); err != nil {
+		return m.state, fmt.Errorf("couldn't scan spot instance %s: %s",
+			m.spotInstanceID, err.Error())
+	}
+
+	if m.spot = r.instances.get(m.spotInstanceID); m.spot == nil {
+		return m.state, fmt.Errorf("spot instance %s is missing", m.spotInstanceID)
+	}
+
+	// the tags are only missing on the resources of the spot instance, so
+	// failing to set them doesn't fail the replacement
+	m.asg.tagSpotInstanceResources(m.spot)
+
+	return replacementRunning, nil
+}
+
+func (m *replacementMachine) prepareGroup() (replacementState, error) {
+	if m.spot == nil {
+		return m.state, fmt.Errorf("spot instance %s is missing", m.spotInstanceID)
+	}
+
+	odInstance, err := m.spot.getSwapCandidate()
+	if err != nil {
+		log.Printf("Couldn't find suitable OnDemand swap candidate: %s", err.Error())
+		return m.state, err
+	}
+	m.onDemandInstanceID = *odInstance.InstanceId
+
+	m.asg.suspendProcesses()
+
+	desiredCapacity, maxSize := *m.asg.DesiredCapacity, *m.asg.MaxSize
+
+	// temporarily increase AutoScaling group in case the desired capacity reaches the max size,
+	// otherwise attachSpotInstance might fail
+	if desiredCapacity == maxSize {
+		log.Println(m.asg.name, "Temporarily increasing MaxSize")
+		if err := m.asg.setAutoScalingMaxSize(maxSize + 1); err != nil {
+			return m.state, err
+		}
+		m.originalMaxSize = aws.Int64(maxSize)
+		m.asg.setMarkerTag(originalMaxSizeTag, strconv.FormatInt(maxSize, 10))
+	}
+	return replacementAttaching, nil
+}
+
+func (m *replacementMachine) attachSpotInstance() (replacementState, error) {
+	log.Printf("Attaching spot instance %s to the group %s",
+		m.spotInstanceID, m.asg.name)
+
+	if err := m.asg.attachSpotInstance(m.spotInstanceID, true); err != nil {
+		log.Printf("Spot instance %s couldn't be attached to the group %s, terminating it...",
+			m.spotInstanceID, m.asg.name)
+		return m.state, fmt.Errorf("couldn't attach spot instance %s ", m.spotInstanceID)
+	}
+	return replacementAttached, nil
+}
+
+func (m *replacementMachine) terminateOnDemandInstance() (replacementState, error) {
+	odInstanceID := aws.String(m.onDemandInstanceID)
+
+	if !m.asg.hasMemberInstance(&instance{Instance: &ec2.Instance{InstanceId: odInstanceID}}) {
+		log.Printf("On-demand instance %s is no longer part of the group %s",
+			m.onDemandInstanceID, m.asg.name)
+		return replacementOnDemandTerminated, nil
+	}
+
+	log.Printf("Terminating on-demand instance %s from the group %s",
+		m.onDemandInstanceID, m.asg.name)
+	if err := m.asg.terminateInstanceInAutoScalingGroup(odInstanceID, true, true); err != nil {
+		log.Printf("On-demand instance %s couldn't be terminated, re-trying...",
+			m.onDemandInstanceID)
+		return m.state, fmt.Errorf("couldn't terminate on-demand instance %s",
+			m.onDemandInstanceID)
+	}
+	return replacementOnDemandTerminated, nil
+}
+
+// restoreGroup restores the MaxSize of the group. The suspended processes are
+// resumed at the end of the next cron run, since other replacements may still
+// be in progress in the same group.
+func (m *replacementMachine) restoreGroup() (replacementState, error) {
+	if m.originalMaxSize != nil {
+		log.Println(m.asg.name, "Restoring MaxSize to", *m.originalMaxSize)
+		if err := m.asg.setAutoScalingMaxSize(*m.originalMaxSize); err != nil {
+			return m.state, err
+		}
+		m.asg.deleteMarkerTag(originalMaxSizeTag)
+	}
+	return replacementCompleted, nil
+}
+
+// swapWithGroupMember replaces the on-demand instance this spot instance was
+// launched for, resuming any replacement previously started for it.
+func (i *instance) swapWithGroupMember(asg *autoScalingGroup) error {
+	r, err := asg.region.replacementStore().load(i)
+	if err != nil {
+		log.Printf("Couldn't load the replacement state of spot instance %s: %s",
+			*i.InstanceId, err.Error())
+		return err
+	}
+
+	if r == nil {
+		r = newReplacement(i)
+	}
+
+	if r.state.isFinal() {
+		log.Printf("Replacement by spot instance %s already finished in state %s",
+			*i.InstanceId, r.state)
+		return nil
+	}
+
+	return newReplacementMachine(asg, i, r).run()
+}
+
+// resumeReplacements continues the replacements in this group which were
+// interrupted by the end of previous runs.
+func (a *autoScalingGroup) resumeReplacements() {
+	var spotInstances []*instance
+
+	for i := range a.region.instances.instances() {
+		if asgName := i.getReplacementTargetASGName(); i.isSpot() &&
+			asgName != nil && *asgName == a.name {
+			spotInstances = append(spotInstances, i)
+		}
+	}
+
+	store := a.region.replacementStore()
+
+	for _, i := range spotInstances {
+		r, err := store.load(i)
+		if err != nil || r == nil || r.state.isFinal() {
+			continue
+		}
+
+		// the replacements waiting for lifecycle hooks aren't run by any other
+		// run in the meantime, so they're checked on each run
+		if r.state != replacementLifecycleHooksPending &&
+			time.Since(r.updated) < replacementResumeDelay {
+			continue
+		}
+
+		log.Printf("%s %s Resuming the replacement of %s by spot instance %s from state %s",
+			a.region.name, a.name, r.onDemandInstanceID, r.spotInstanceID, r.state)
+
+		if err := newReplacementMachine(a, i, r).run(); err != nil {
+			log.Printf("%s %s Couldn't resume the replacement by spot instance %s: %s",
+				a.region.name, a.name, r.spotInstanceID, err.Error())
+		}
+	}
+}
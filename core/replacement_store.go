@@ -0,0 +1,235 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// replacement_store.go contains the implementations of the storage used for
+// persisting the state of the replacements between runs.
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// DefaultReplacementStateStore is the default storage of the replacement
+	// state, persisted as tags on the spot instances.
+	DefaultReplacementStateStore = TagsReplacementStateStore
+
+	// TagsReplacementStateStore persists the replacement state as tags set
+	// on the spot instances.
+	TagsReplacementStateStore = "tags"
+
+	// MemoryReplacementStateStore keeps the replacement state in memory, only
+	// useful when running as a long-lived process.
+	MemoryReplacementStateStore = "memory"
+
+	replacementStateTag           = "autospotting-replacement-state"
+	replacementOriginalMaxSizeTag = "autospotting-replacement-original-max-size"
+	replacementUpdatedTag         = "autospotting-replacement-updated"
+
+	replacementLifecycleActionTokenTag   = "autospotting-replacement-lifecycle-action-token"
+	replacementPendingLifecycleHooksTag  = "autospotting-replacement-pending-lifecycle-hooks"
+	replacementLifecycleHooksDeadlineTag = "autospotting-replacement-lifecycle-hooks-deadline"
+	replacementLifecycleActionResultTag  = "autospotting-replacement-lifecycle-action-result"
+)
+
+// replacementStore persists the state of the replacements, indexed by the ID
+// of their spot instance.
+type replacementStore interface {
+	// load returns the persisted state of the replacement done by the given
+	// spot instance, or nil if there is none.
+	load(spot *instance) (*replacement, error)
+	save(r *replacement) error
+}
+
+func (r *region) replacementStore() replacementStore {
+	if r.conf != nil && r.conf.ReplacementStateStore == MemoryReplacementStateStore {
+		return memoryReplacements
+	}
+	return tagReplacementStore{region: r}
+}
+
+// tagReplacementStore persists the replacement state in tags set on the spot
+// instance, next to the tags set at launch time.
+type tagReplacementStore struct {
+	region *region
+}
+
+func (s tagReplacementStore) load(spot *instance) (*replacement, error) {
+	var state, maxSize, updated string
+	var token, pendingHooks, hooksDeadline, actionResult string
+
+	for _, tag := range spot.Tags {
+		switch *tag.Key {
+		case replacementStateTag:
+			state = *tag.Value
+		case replacementOriginalMaxSizeTag:
+			maxSize = *tag.Value
+		case replacementUpdatedTag:
+			updated = *tag.Value
+		case replacementLifecycleActionTokenTag:
+			token = *tag.Value
+		case replacementPendingLifecycleHooksTag:
+			pendingHooks = *tag.Value
+		case replacementLifecycleHooksDeadlineTag:
+			hooksDeadline = *tag.Value
+		case replacementLifecycleActionResultTag:
+			actionResult = *tag.Value
+		}
+	}
+
+	if state == "" {
+		return nil, nil
+	}
+
+	r := newReplacement(spot)
+	r.state = replacementState(state)
+
+	if maxSize != "" {
+		size, err := strconv.ParseInt(maxSize, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		r.originalMaxSize = aws.Int64(size)
+	}
+
+	if updated != "" {
+		t, err := time.Parse(time.RFC3339, updated)
+		if err != nil {
+			return nil, err
+		}
+		r.updated = t
+	}
+
+	if token != "" {
+		r.lifecycleActionToken = token
+		r.lifecycleActionResult = actionResult
+		if pendingHooks != "" {
+			r.pendingLifecycleHooks = strings.Split(pendingHooks, ",")
+		}
+
+		if hooksDeadline != "" {
+			t, err := time.Parse(time.RFC3339, hooksDeadline)
+			if err != nil {
+				return nil, err
+			}
+			r.lifecycleHooksDeadline = t
+		}
+	}
+	return r, nil
+}
+
+// save writes the state tags even if the run was cancelled, since this is
+// exactly when they are needed by the next run.
+func (s tagReplacementStore) save(r *replacement) error {
+	tags := []*ec2.Tag{
+		{
+			Key:   aws.String(replacementStateTag),
+			Value: aws.String(string(r.state)),
+		},
+		{
+			Key:   aws.String(replacementUpdatedTag),
+			Value: aws.String(r.updated.UTC().Format(time.RFC3339)),
+		},
+	}
+
+	if r.originalMaxSize != nil {
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String(replacementOriginalMaxSizeTag),
+			Value: aws.String(strconv.FormatInt(*r.originalMaxSize, 10)),
+		})
+	}
+
+	// the pending hooks and the result are also written when empty, since they
+	// change when the hooks are completed
+	if r.lifecycleActionToken != "" {
+		tags = append(tags,
+			&ec2.Tag{
+				Key:   aws.String(replacementLifecycleActionTokenTag),
+				Value: aws.String(r.lifecycleActionToken),
+			},
+			&ec2.Tag{
+				Key:   aws.String(replacementPendingLifecycleHooksTag),
+				Value: aws.String(strings.Join(r.pendingLifecycleHooks, ",")),
+			},
+			&ec2.Tag{
+				Key:   aws.String(replacementLifecycleHooksDeadlineTag),
+				Value: aws.String(r.lifecycleHooksDeadline.UTC().Format(time.RFC3339)),
+			},
+			&ec2.Tag{
+				Key:   aws.String(replacementLifecycleActionResultTag),
+				Value: aws.String(r.lifecycleActionResult),
+			})
+	}
+
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	_, err := s.region.services.ec2.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: []*string{aws.String(r.spotInstanceID)},
+		Tags:      tags,
+	})
+
+	if err != nil {
+		log.Printf("Failed to tag spot instance %s with its replacement state: %s",
+			r.spotInstanceID, err.Error())
+		return err
+	}
+
+	// keep the scanned instance data in sync with the tags
+	if i := s.region.instances.get(r.spotInstanceID); i != nil {
+		for _, tag := range tags {
+			i.setTag(*tag.Key, *tag.Value)
+		}
+	}
+	return nil
+}
+
+// memoryReplacementStore keeps the replacement state for the lifetime of the
+// process.
+type memoryReplacementStore struct {
+	sync.Mutex
+	replacements map[string]replacement
+}
+
+var memoryReplacements = newMemoryReplacementStore()
+
+func newMemoryReplacementStore() *memoryReplacementStore {
+	return &memoryReplacementStore{replacements: make(map[string]replacement)}
+}
+
+func (s *memoryReplacementStore) load(spot *instance) (*replacement, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	r, ok := s.replacements[*spot.InstanceId]
+	if !ok {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func (s *memoryReplacementStore) save(r *replacement) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.replacements[r.spotInstanceID] = *r
+	return nil
+}
+
+func (i *instance) setTag(key, value string) {
+	for _, tag := range i.Tags {
+		if *tag.Key == key {
+			tag.Value = aws.String(value)
+			return
+		}
+	}
+	i.Tags = append(i.Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+}
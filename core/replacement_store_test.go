@@ -0,0 +1,183 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_region_replacementStore(t *testing.T) {
+	tests := []struct {
+		name string
+		conf *Config
+		want replacementStore
+	}{
+		{
+			name: "default",
+			conf: &Config{},
+			want: tagReplacementStore{},
+		},
+		{
+			name: "tags",
+			conf: &Config{ReplacementStateStore: TagsReplacementStateStore},
+			want: tagReplacementStore{},
+		},
+		{
+			name: "memory",
+			conf: &Config{ReplacementStateStore: MemoryReplacementStateStore},
+			want: memoryReplacements,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{conf: tt.conf}
+			got := r.replacementStore()
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Errorf("replacementStore() = %T, want %T", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tagReplacementStore(t *testing.T) {
+	updated := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		r        *replacement
+		cterr    error
+		wantErr  bool
+		wantLoad *replacement
+	}{
+		{
+			name: "without original MaxSize",
+			r: &replacement{
+				state:              replacementAttached,
+				spotInstanceID:     "i-spot",
+				onDemandInstanceID: "i-ondemand",
+				asgName:            "asg",
+				updated:            updated,
+			},
+			wantLoad: &replacement{
+				state:              replacementAttached,
+				spotInstanceID:     "i-spot",
+				onDemandInstanceID: "i-ondemand",
+				asgName:            "asg",
+				updated:            updated,
+			},
+		},
+		{
+			name: "with original MaxSize",
+			r: &replacement{
+				state:              replacementAttaching,
+				spotInstanceID:     "i-spot",
+				onDemandInstanceID: "i-ondemand",
+				asgName:            "asg",
+				originalMaxSize:    aws.Int64(3),
+				updated:            updated,
+			},
+			wantLoad: &replacement{
+				state:              replacementAttaching,
+				spotInstanceID:     "i-spot",
+				onDemandInstanceID: "i-ondemand",
+				asgName:            "asg",
+				originalMaxSize:    aws.Int64(3),
+				updated:            updated,
+			},
+		},
+		{
+			name: "with lifecycle hooks",
+			r: &replacement{
+				state:                  replacementLifecycleHooksPending,
+				spotInstanceID:         "i-spot",
+				onDemandInstanceID:     "i-ondemand",
+				asgName:                "asg",
+				lifecycleActionToken:   "token",
+				pendingLifecycleHooks:  []string{"hook1", "hook2"},
+				lifecycleHooksDeadline: updated.Add(time.Hour),
+				updated:                updated,
+			},
+			wantLoad: &replacement{
+				state:                  replacementLifecycleHooksPending,
+				spotInstanceID:         "i-spot",
+				onDemandInstanceID:     "i-ondemand",
+				asgName:                "asg",
+				lifecycleActionToken:   "token",
+				pendingLifecycleHooks:  []string{"hook1", "hook2"},
+				lifecycleHooksDeadline: updated.Add(time.Hour),
+				updated:                updated,
+			},
+		},
+		{
+			name: "tagging error",
+			r: &replacement{
+				state:          replacementAttached,
+				spotInstanceID: "i-spot",
+			},
+			cterr:    errors.New("error"),
+			wantErr:  true,
+			wantLoad: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spot := &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-spot"),
+					State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNamePending)},
+					Tags: []*ec2.Tag{
+						{Key: aws.String("launched-for-asg"), Value: aws.String("asg")},
+						{Key: aws.String("launched-for-replacing-instance"), Value: aws.String("i-ondemand")},
+					},
+				},
+			}
+
+			s := tagReplacementStore{
+				region: &region{
+					instances: makeInstancesWithCatalog(instanceMap{"i-spot": spot}),
+					services: connections{
+						ec2: mockEC2{cterr: tt.cterr},
+					},
+				},
+			}
+
+			if err := s.save(tt.r); (err != nil) != tt.wantErr {
+				t.Errorf("save() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got, err := s.load(spot)
+			if err != nil {
+				t.Errorf("load() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.wantLoad) {
+				t.Errorf("load() = %+v, want %+v", got, tt.wantLoad)
+			}
+		})
+	}
+}
+
+func Test_memoryReplacementStore(t *testing.T) {
+	s := newMemoryReplacementStore()
+	spot := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-spot")}}
+
+	if got, _ := s.load(spot); got != nil {
+		t.Errorf("load() = %+v, want nil", got)
+	}
+
+	want := &replacement{
+		state:          replacementRunning,
+		spotInstanceID: "i-spot",
+	}
+	s.save(want)
+
+	if got, _ := s.load(spot); !reflect.DeepEqual(got, want) {
+		t.Errorf("load() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_replacementState_transitions(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     replacementState
+		next      replacementState
+		want      bool
+		wantFinal bool
+	}{
+		{
+			name:  "launched to running",
+			state: replacementLaunched,
+			next:  replacementRunning,
+			want:  true,
+		},
+		{
+			name:  "launched straight to attached",
+			state: replacementLaunched,
+			next:  replacementAttached,
+			want:  false,
+		},
+		{
+			name:  "attaching can fail",
+			state: replacementAttaching,
+			next:  replacementFailed,
+			want:  true,
+		},
+		{
+			name:  "running to waiting for the lifecycle hooks",
+			state: replacementRunning,
+			next:  replacementLifecycleHooksPending,
+			want:  true,
+		},
+		{
+			name:  "completed lifecycle hooks to ready",
+			state: replacementLifecycleHooksPending,
+			next:  replacementReady,
+			want:  true,
+		},
+		{
+			name:  "lifecycle hooks pending straight to attaching",
+			state: replacementLifecycleHooksPending,
+			next:  replacementAttaching,
+			want:  false,
+		},
+		{
+			name:  "abandoned lifecycle hooks fail",
+			state: replacementLifecycleHooksPending,
+			next:  replacementFailed,
+			want:  true,
+		},
+		{
+			name:  "attaching no longer waits for the lifecycle hooks",
+			state: replacementAttaching,
+			next:  replacementLifecycleHooksPending,
+			want:  false,
+		},
+		{
+			name:  "attached can't fail",
+			state: replacementAttached,
+			next:  replacementFailed,
+			want:  false,
+		},
+		{
+			name:      "completed is final",
+			state:     replacementCompleted,
+			next:      replacementLaunched,
+			want:      false,
+			wantFinal: true,
+		},
+		{
+			name:      "unknown state is final",
+			state:     replacementState("foo"),
+			next:      replacementRunning,
+			want:      false,
+			wantFinal: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.canTransitionTo(tt.next); got != tt.want {
+				t.Errorf("canTransitionTo() = %v, want %v", got, tt.want)
+			}
+			if got := tt.state.isFinal(); got != tt.wantFinal {
+				t.Errorf("isFinal() = %v, want %v", got, tt.wantFinal)
+			}
+		})
+	}
+}
+
+func Test_newReplacement(t *testing.T) {
+	tests := []struct {
+		name string
+		spot *instance
+		want *replacement
+	}{
+		{
+			name: "pending spot instance",
+			spot: &instance{Instance: &ec2.Instance{
+				InstanceId: aws.String("i-spot"),
+				State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNamePending)},
+				Tags: []*ec2.Tag{
+					{Key: aws.String("launched-for-asg"), Value: aws.String("asg")},
+					{Key: aws.String("launched-for-replacing-instance"), Value: aws.String("i-ondemand")},
+				},
+			}},
+			want: &replacement{
+				state:              replacementLaunched,
+				spotInstanceID:     "i-spot",
+				onDemandInstanceID: "i-ondemand",
+				asgName:            "asg",
+			},
+		},
+		{
+			name: "running spot instance",
+			spot: &instance{Instance: &ec2.Instance{
+				InstanceId: aws.String("i-spot"),
+				State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			}},
+			want: &replacement{
+				state:          replacementRunning,
+				spotInstanceID: "i-spot",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newReplacement(tt.spot); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newReplacement() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_replacementMachine_run(t *testing.T) {
+	succeed := func(next replacementState) replacementStep {
+		return func(m *replacementMachine) (replacementState, error) {
+			return next, nil
+		}
+	}
+	failing := func(m *replacementMachine) (replacementState, error) {
+		return m.state, errors.New("step failed")
+	}
+
+	allSteps := map[replacementState]replacementStep{
+		replacementLaunched:           succeed(replacementRunning),
+		replacementRunning:            succeed(replacementReady),
+		replacementReady:              succeed(replacementAttaching),
+		replacementAttaching:          succeed(replacementAttached),
+		replacementAttached:           succeed(replacementOnDemandTerminated),
+		replacementOnDemandTerminated: succeed(replacementCompleted),
+	}
+
+	withStep := func(state replacementState, step replacementStep) map[replacementState]replacementStep {
+		steps := make(map[replacementState]replacementStep)
+		for k, v := range allSteps {
+			steps[k] = v
+		}
+		steps[state] = step
+		return steps
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		state     replacementState
+		steps     map[replacementState]replacementStep
+		wantState replacementState
+		wantErr   bool
+	}{
+		{
+			name:      "complete replacement",
+			state:     replacementLaunched,
+			steps:     allSteps,
+			wantState: replacementCompleted,
+		},
+		{
+			name:      "resumed replacement",
+			state:     replacementAttached,
+			steps:     allSteps,
+			wantState: replacementCompleted,
+		},
+		{
+			name:      "failure before attaching",
+			state:     replacementLaunched,
+			steps:     withStep(replacementRunning, failing),
+			wantState: replacementFailed,
+			wantErr:   true,
+		},
+		{
+			name:      "failure after attaching is retried later",
+			state:     replacementLaunched,
+			steps:     withStep(replacementAttached, failing),
+			wantState: replacementAttached,
+			wantErr:   true,
+		},
+		{
+			name:  "pending step is resumed later",
+			state: replacementLaunched,
+			steps: withStep(replacementAttached, func(m *replacementMachine) (replacementState, error) {
+				return m.state, fmt.Errorf("%w: waiting", errReplacementPending)
+			}),
+			wantState: replacementAttached,
+		},
+		{
+			name:  "abandoned lifecycle hooks fail before attaching",
+			state: replacementLifecycleHooksPending,
+			steps: withStep(replacementLifecycleHooksPending, func(m *replacementMachine) (replacementState, error) {
+				return m.state, errLifecycleActionAbandoned
+			}),
+			wantState: replacementFailed,
+			wantErr:   true,
+		},
+		{
+			name:      "invalid transition",
+			state:     replacementLaunched,
+			steps:     withStep(replacementRunning, succeed(replacementCompleted)),
+			wantState: replacementRunning,
+			wantErr:   true,
+		},
+		{
+			name:      "cancelled run",
+			ctx:       cancelled,
+			state:     replacementRunning,
+			steps:     allSteps,
+			wantState: replacementRunning,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				ctx: tt.ctx,
+				services: connections{
+					ec2: mockEC2{},
+				},
+			}
+			store := newMemoryReplacementStore()
+
+			m := &replacementMachine{
+				replacement: &replacement{
+					state:          tt.state,
+					spotInstanceID: "i-spot",
+				},
+				store: store,
+				steps: tt.steps,
+				asg: &autoScalingGroup{
+					name:   "asg",
+					Group:  &autoscaling.Group{},
+					region: r,
+				},
+				spot: &instance{
+					Instance: &ec2.Instance{
+						InstanceId: aws.String("i-spot"),
+						State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+					},
+					region: r,
+				},
+			}
+
+			if err := m.run(); (err != nil) != tt.wantErr {
+				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if m.state != tt.wantState {
+				t.Errorf("run() state = %v, want %v", m.state, tt.wantState)
+			}
+
+			if tt.wantState != tt.state {
+				saved, _ := store.load(m.spot)
+				if saved == nil || saved.state != tt.wantState {
+					t.Errorf("persisted state = %+v, want %v", saved, tt.wantState)
+				}
+			}
+		})
+	}
+}
+
+func Test_replacementMachine_runRenewsGroupLock(t *testing.T) {
+	lease := 30 * time.Millisecond
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{GroupLockLease: lease},
+		services: connections{
+			ec2: mockEC2{},
+		},
+	}
+	asg := &autoScalingGroup{
+		name:   "renewed-lock-asg",
+		Group:  &autoscaling.Group{},
+		region: r,
+	}
+
+	var lockedByOther []string
+	slow := func(next replacementState) replacementStep {
+		return func(m *replacementMachine) (replacementState, error) {
+			// the step takes longer than the lease
+			time.Sleep(4 * lease)
+			if owner, _ := memoryGroupLocks.lock(asg.lockKey(), "other-run",
+				time.Now().Add(lease)); owner == "other-run" {
+				lockedByOther = append(lockedByOther, string(m.state))
+				memoryGroupLocks.unlock(asg.lockKey(), "other-run")
+			}
+			return next, nil
+		}
+	}
+
+	m := &replacementMachine{
+		replacement: &replacement{
+			state:          replacementAttaching,
+			spotInstanceID: "i-spot",
+		},
+		store: newMemoryReplacementStore(),
+		steps: map[replacementState]replacementStep{
+			replacementAttaching: slow(replacementAttached),
+			replacementAttached:  slow(replacementOnDemandTerminated),
+			replacementOnDemandTerminated: func(m *replacementMachine) (replacementState, error) {
+				return replacementCompleted, nil
+			},
+		},
+		asg: asg,
+		spot: &instance{
+			Instance: &ec2.Instance{InstanceId: aws.String("i-spot")},
+			region:   r,
+		},
+	}
+
+	if err := m.run(); err != nil {
+		t.Errorf("run() error = %v", err)
+	}
+	if lockedByOther != nil {
+		t.Errorf("group lock expired during the steps in states %v", lockedByOther)
+	}
+	if m.stopLockRenewal != nil || m.locked {
+		t.Errorf("run() didn't release the group lock")
+	}
+}
+
+func Test_replacementMachine_terminateOnDemandInstance(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []*autoscaling.Instance
+		tiiasg  error
+		want    replacementState
+		wantErr bool
+	}{
+		{
+			name:    "on-demand instance already gone",
+			members: []*autoscaling.Instance{},
+			want:    replacementOnDemandTerminated,
+		},
+		{
+			name: "on-demand instance terminated",
+			members: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-ondemand")},
+			},
+			want: replacementOnDemandTerminated,
+		},
+		{
+			name: "on-demand instance termination failure",
+			members: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-ondemand")},
+			},
+			tiiasg:  errors.New("error"),
+			want:    replacementAttached,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &replacementMachine{
+				replacement: &replacement{
+					state:              replacementAttached,
+					spotInstanceID:     "i-spot",
+					onDemandInstanceID: "i-ondemand",
+				},
+				asg: &autoScalingGroup{
+					name: "asg",
+					Group: &autoscaling.Group{
+						AutoScalingGroupName: aws.String("asg"),
+						Instances:            tt.members,
+					},
+					region: &region{
+						conf: &Config{},
+						services: connections{
+							ec2: mockEC2{},
+							autoScaling: mockASG{
+								dlho:      &autoscaling.DescribeLifecycleHooksOutput{},
+								dasio:     &autoscaling.DescribeAutoScalingInstancesOutput{},
+								tiiasgerr: tt.tiiasg,
+							},
+						},
+					},
+				},
+			}
+
+			got, err := m.terminateOnDemandInstance()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("terminateOnDemandInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("terminateOnDemandInstance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// reservations.go contains the awareness of the On-Demand Capacity
+// Reservations and Reserved Instances of the region. The on-demand instances
+// covered by them are already paid for or discounted, so replacing them with
+// spot instances would increase the bill instead of reducing it.
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	reservedInstancesScopeAZ = "Availability Zone"
+
+	// the platform and tenancy of the size flexible Reserved Instances
+	sizeFlexiblePlatform = "Linux/UNIX"
+)
+
+// reservationKey identifies the instances matched by Reserved Instances. The
+// availability zone is empty for the regional Reserved Instances, and the
+// instance type is replaced by the instance family for the size flexible ones.
+type reservationKey struct {
+	instanceType     string
+	availabilityZone string
+	platform         string
+	tenancy          string
+}
+
+// reservedCapacity is the capacity of the Reserved Instances matching a key,
+// measured in instances or in normalized units for the size flexible ones,
+// together with its effective hourly cost.
+type reservedCapacity struct {
+	units float64
+	cost  float64
+}
+
+// unitPrice is the effective hourly price of a unit of reserved capacity.
+func (c *reservedCapacity) unitPrice() float64 {
+	if c.units == 0 {
+		return 0
+	}
+	return c.cost / c.units
+}
+
+// reservations contains the active capacity reservations and Reserved
+// Instances of the region, and the on-demand instances they can cover.
+type reservations struct {
+	// the active capacity reservations by ID, nil if they couldn't be loaded
+	capacityReservations map[string]*ec2.CapacityReservation
+
+	zonal    map[reservationKey]*reservedCapacity
+	regional map[reservationKey]*reservedCapacity
+
+	// the running on-demand instances matching the zonal Reserved Instances,
+	// and the units left uncovered by them, matching the regional ones
+	zonalDemand    map[reservationKey]float64
+	regionalDemand map[reservationKey]float64
+}
+
+// normalizationFactor returns the normalization factor of the size of the
+// instance type, used for applying the size flexible Reserved Instances to
+// other sizes of the same instance family, or 0 if it's unknown.
+func normalizationFactor(instanceType string) float64 {
+	parts := strings.SplitN(instanceType, ".", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+
+	switch size := parts[1]; size {
+	case "nano":
+		return 0.25
+	case "micro":
+		return 0.5
+	case "small":
+		return 1
+	case "medium":
+		return 2
+	case "large":
+		return 4
+	case "xlarge":
+		return 8
+	default:
+		n, err := strconv.Atoi(strings.TrimSuffix(size, "xlarge"))
+		if err != nil || !strings.HasSuffix(size, "xlarge") {
+			return 0
+		}
+		return 8 * float64(n)
+	}
+}
+
+func instanceFamily(instanceType string) string {
+	return strings.SplitN(instanceType, ".", 2)[0]
+}
+
+// reservedInstancesPlatform converts the product description of the Reserved
+// Instances into the platform details of the instances they cover.
+func reservedInstancesPlatform(productDescription string) string {
+	return strings.TrimSuffix(productDescription, " (Amazon VPC)")
+}
+
+func instancePlatform(inst *ec2.Instance) string {
+	if inst.PlatformDetails != nil {
+		return *inst.PlatformDetails
+	}
+	if aws.StringValue(inst.Platform) == ec2.PlatformValuesWindows {
+		return "Windows"
+	}
+	return sizeFlexiblePlatform
+}
+
+func instanceTenancy(inst *ec2.Instance) string {
+	if inst.Placement == nil || inst.Placement.Tenancy == nil {
+		return ec2.TenancyDefault
+	}
+	return *inst.Placement.Tenancy
+}
+
+// regionalKey returns the key of the regional Reserved Instances matching the
+// instance type, and the units it consumes from them.
+func regionalKey(instanceType, platform, tenancy string) (reservationKey, float64) {
+	factor := normalizationFactor(instanceType)
+	if platform == sizeFlexiblePlatform && tenancy == ec2.TenancyDefault && factor > 0 {
+		return reservationKey{instanceType: instanceFamily(instanceType), platform: platform, tenancy: tenancy}, factor
+	}
+	return reservationKey{instanceType: instanceType, platform: platform, tenancy: tenancy}, 1
+}
+
+func zonalKey(inst *ec2.Instance) reservationKey {
+	return reservationKey{
+		instanceType:     aws.StringValue(inst.InstanceType),
+		availabilityZone: aws.StringValue(inst.Placement.AvailabilityZone),
+		platform:         instancePlatform(inst),
+		tenancy:          instanceTenancy(inst),
+	}
+}
+
+// reservedInstancesHourlyPrice returns the effective hourly price of a
+// Reserved Instance, amortizing its upfront price over its duration.
+func reservedInstancesHourlyPrice(ri *ec2.ReservedInstances) float64 {
+	price := aws.Float64Value(ri.UsagePrice)
+
+	if hours := float64(aws.Int64Value(ri.Duration)) / 3600; hours > 0 {
+		price += aws.Float64Value(ri.FixedPrice) / hours
+	}
+
+	for _, rc := range ri.RecurringCharges {
+		if aws.StringValue(rc.Frequency) == ec2.RecurringChargeFrequencyHourly {
+			price += aws.Float64Value(rc.Amount)
+		}
+	}
+	return price
+}
+
+func addReservedCapacity(m map[reservationKey]*reservedCapacity, key reservationKey, units, cost float64) {
+	c, ok := m[key]
+	if !ok {
+		c = &reservedCapacity{}
+		m[key] = c
+	}
+	c.units += units
+	c.cost += cost
+}
+
+// addReservedInstances adds the capacity of the Reserved Instances to the zonal
+// or regional reserved capacity.
+func (r *reservations) addReservedInstances(ri *ec2.ReservedInstances) {
+	instanceType := aws.StringValue(ri.InstanceType)
+	platform := reservedInstancesPlatform(aws.StringValue(ri.ProductDescription))
+	tenancy := aws.StringValue(ri.InstanceTenancy)
+	count := float64(aws.Int64Value(ri.InstanceCount))
+	cost := count * reservedInstancesHourlyPrice(ri)
+
+	if aws.StringValue(ri.Scope) == reservedInstancesScopeAZ {
+		key := reservationKey{
+			instanceType:     instanceType,
+			availabilityZone: aws.StringValue(ri.AvailabilityZone),
+			platform:         platform,
+			tenancy:          tenancy,
+		}
+		addReservedCapacity(r.zonal, key, count, cost)
+		return
+	}
+
+	key, factor := regionalKey(instanceType, platform, tenancy)
+	addReservedCapacity(r.regional, key, count*factor, cost)
+}
+
+// addOnDemandInstances computes the demand of the running on-demand instances
+// for the Reserved Instances. The zonal Reserved Instances apply first, and
+// the instances left uncovered by them can be covered by the regional ones.
+func (r *reservations) addOnDemandInstances(instances []*ec2.Instance) {
+	for _, inst := range instances {
+		r.zonalDemand[zonalKey(inst)]++
+	}
+
+	for key, count := range r.zonalDemand {
+		uncovered := count
+		if c, ok := r.zonal[key]; ok {
+			uncovered -= c.units
+		}
+		if uncovered <= 0 {
+			continue
+		}
+		rk, factor := regionalKey(key.instanceType, key.platform, key.tenancy)
+		r.regionalDemand[rk] += uncovered * factor
+	}
+}
+
+// loadReservations loads the active capacity reservations and Reserved
+// Instances of the region, together with the running on-demand instances
+// which may be covered by them. When they can't be loaded, the instances are
+// handled as if they weren't covered by any reservation.
+func (r *region) loadReservations() {
+	res := &reservations{
+		zonal:          make(map[reservationKey]*reservedCapacity),
+		regional:       make(map[reservationKey]*reservedCapacity),
+		zonalDemand:    make(map[reservationKey]float64),
+		regionalDemand: make(map[reservationKey]float64),
+	}
+
+	capacityReservations := make(map[string]*ec2.CapacityReservation)
+	err := r.services.ec2.DescribeCapacityReservationsPagesWithContext(
+		r.runContext(),
+		&ec2.DescribeCapacityReservationsInput{
+			Filters: []*ec2.Filter{{
+				Name:   aws.String("state"),
+				Values: []*string{aws.String(ec2.CapacityReservationStateActive)},
+			}},
+		},
+		func(page *ec2.DescribeCapacityReservationsOutput, lastPage bool) bool {
+			for _, cr := range page.CapacityReservations {
+				capacityReservations[aws.StringValue(cr.CapacityReservationId)] = cr
+			}
+			return true
+		})
+	if err != nil {
+		log.Println(r.name, "Couldn't describe the capacity reservations:", err.Error())
+	} else {
+		res.capacityReservations = capacityReservations
+	}
+
+	resp, err := r.services.ec2.DescribeReservedInstancesWithContext(
+		r.runContext(),
+		&ec2.DescribeReservedInstancesInput{
+			Filters: []*ec2.Filter{{
+				Name:   aws.String("state"),
+				Values: []*string{aws.String(ec2.ReservedInstanceStateActive)},
+			}},
+		})
+	if err != nil {
+		log.Println(r.name, "Couldn't describe the Reserved Instances:", err.Error())
+		r.reservations = res
+		return
+	}
+
+	for _, ri := range resp.ReservedInstances {
+		res.addReservedInstances(ri)
+	}
+
+	// there's no need to look at the instances without Reserved Instances
+	if len(res.zonal) == 0 && len(res.regional) == 0 {
+		r.reservations = res
+		return
+	}
+
+	var onDemand []*ec2.Instance
+	err = r.services.ec2.DescribeInstancesPagesWithContext(
+		r.runContext(),
+		&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{{
+				Name:   aws.String("instance-state-name"),
+				Values: []*string{aws.String(ec2.InstanceStateNameRunning)},
+			}},
+		},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, inst := range reservation.Instances {
+					if inst.InstanceLifecycle == nil && inst.Placement != nil {
+						onDemand = append(onDemand, inst)
+					}
+				}
+			}
+			return true
+		})
+	if err != nil {
+		log.Println(r.name, "Couldn't describe the on-demand instances covered by Reserved Instances:", err.Error())
+		r.reservations = &reservations{capacityReservations: res.capacityReservations}
+		return
+	}
+
+	res.addOnDemandInstances(onDemand)
+	debug.Println(r.name, "Loaded", len(resp.ReservedInstances), "Reserved Instances covering",
+		len(onDemand), "running on-demand instances")
+
+	r.reservations = res
+}
+
+// coverageReason explains why the on-demand instance is covered by a capacity
+// reservation or by Reserved Instances, in which case replacing it with a spot
+// instance would leave the reservation unused, or returns an empty string if
+// it's not covered.
+func (r *reservations) coverageReason(inst *ec2.Instance) string {
+	if r == nil || inst == nil || inst.Placement == nil {
+		return ""
+	}
+
+	if id := aws.StringValue(inst.CapacityReservationId); id != "" {
+		// the reservation is assumed to be active if they couldn't be loaded
+		if _, active := r.capacityReservations[id]; active || r.capacityReservations == nil {
+			return fmt.Sprintf("it's consuming the On-Demand Capacity Reservation %s", id)
+		}
+	}
+
+	key := zonalKey(inst)
+	if c, ok := r.zonal[key]; ok && r.zonalDemand[key]-1 < c.units {
+		return fmt.Sprintf("it's covered by the zonal Reserved Instances of type %s in %s",
+			key.instanceType, key.availabilityZone)
+	}
+
+	rk, units := regionalKey(key.instanceType, key.platform, key.tenancy)
+	if c, ok := r.regional[rk]; ok && r.regionalDemand[rk]-units < c.units {
+		return fmt.Sprintf("it's covered by the regional Reserved Instances of %s", rk.instanceType)
+	}
+	return ""
+}
+
+// unusedReservedPrice returns the effective hourly price of the unused
+// Reserved Instances which would cover the instance if it was running
+// on-demand, if there are any.
+func (r *reservations) unusedReservedPrice(inst *ec2.Instance) (float64, bool) {
+	if r == nil || inst == nil || inst.Placement == nil {
+		return 0, false
+	}
+
+	key := zonalKey(inst)
+	if c, ok := r.zonal[key]; ok && c.units-r.zonalDemand[key] >= 1 {
+		return c.unitPrice(), true
+	}
+
+	rk, units := regionalKey(key.instanceType, key.platform, key.tenancy)
+	if c, ok := r.regional[rk]; ok && c.units-r.regionalDemand[rk] >= units {
+		return c.unitPrice() * units, true
+	}
+	return 0, false
+}
+
+// isCoveredByReservation tells if the on-demand instance is covered by a
+// capacity reservation or by Reserved Instances, logging the reason, unless
+// the group is configured to replace such instances.
+func (i *instance) isCoveredByReservation() bool {
+	if i.asg != nil && i.asg.config.ReplaceReservedInstances {
+		return false
+	}
+
+	reason := i.region.reservations.coverageReason(i.Instance)
+	if reason == "" {
+		return false
+	}
+	log.Printf("%s Not replacing instance %s with spot: %s",
+		i.region.name, *i.InstanceId, reason)
+	return true
+}
+
+// onDemandBaselinePrice returns the price the instance would have if it was
+// running on-demand, which is the effective price of the Reserved Instances
+// that would cover it, if they're otherwise unused.
+func (i *instance) onDemandBaselinePrice() float64 {
+	price := i.typeInfo.pricing.onDemand
+	if reserved, ok := i.region.reservations.unusedReservedPrice(i.Instance); ok && reserved < price {
+		debug.Println(*i.InstanceId, "would be covered by unused Reserved Instances at", reserved)
+		return reserved
+	}
+	return price
+}
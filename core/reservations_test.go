@@ -0,0 +1,303 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func onDemandInstance(id, instanceType, az string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:   aws.String(id),
+		InstanceType: aws.String(instanceType),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String(az)},
+	}
+}
+
+func Test_normalizationFactor(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		want         float64
+	}{
+		{instanceType: "t3.nano", want: 0.25},
+		{instanceType: "m5.large", want: 4},
+		{instanceType: "m5.xlarge", want: 8},
+		{instanceType: "m5.12xlarge", want: 96},
+		{instanceType: "m5.metal", want: 0},
+		{instanceType: "foo", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instanceType, func(t *testing.T) {
+			if got := normalizationFactor(tt.instanceType); got != tt.want {
+				t.Errorf("normalizationFactor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_reservedInstancesHourlyPrice(t *testing.T) {
+	ri := &ec2.ReservedInstances{
+		Duration:   aws.Int64(31536000),
+		FixedPrice: aws.Float64(876),
+		UsagePrice: aws.Float64(0.01),
+		RecurringCharges: []*ec2.RecurringCharge{
+			{Amount: aws.Float64(0.02), Frequency: aws.String(ec2.RecurringChargeFrequencyHourly)},
+		},
+	}
+
+	// 876 upfront over 8760 hours, plus the usage and recurring charges
+	if got, want := reservedInstancesHourlyPrice(ri), 0.13; math.Abs(got-want) > 0.000001 {
+		t.Errorf("reservedInstancesHourlyPrice() = %v, want %v", got, want)
+	}
+}
+
+func Test_region_loadReservations(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		services: connections{ec2: mockEC2{
+			dcrpo: []*ec2.DescribeCapacityReservationsOutput{{
+				CapacityReservations: []*ec2.CapacityReservation{
+					{CapacityReservationId: aws.String("cr-active")},
+				},
+			}},
+			drio: &ec2.DescribeReservedInstancesOutput{
+				ReservedInstances: []*ec2.ReservedInstances{
+					{
+						InstanceType:       aws.String("m5.large"),
+						InstanceCount:      aws.Int64(1),
+						Scope:              aws.String(reservedInstancesScopeAZ),
+						AvailabilityZone:   aws.String("us-east-1a"),
+						ProductDescription: aws.String("Linux/UNIX"),
+						InstanceTenancy:    aws.String(ec2.TenancyDefault),
+						UsagePrice:         aws.Float64(0.06),
+					},
+					{
+						InstanceType:       aws.String("c5.xlarge"),
+						InstanceCount:      aws.Int64(1),
+						Scope:              aws.String("Region"),
+						ProductDescription: aws.String("Linux/UNIX (Amazon VPC)"),
+						InstanceTenancy:    aws.String(ec2.TenancyDefault),
+						UsagePrice:         aws.Float64(0.1),
+					},
+				},
+			},
+			dio: &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{
+					Instances: []*ec2.Instance{
+						onDemandInstance("i-m5", "m5.large", "us-east-1a"),
+						onDemandInstance("i-c5-1", "c5.large", "us-east-1a"),
+						onDemandInstance("i-c5-2", "c5.large", "us-east-1b"),
+						{
+							InstanceId:        aws.String("i-spot"),
+							InstanceType:      aws.String("c5.large"),
+							InstanceLifecycle: aws.String(Spot),
+							Placement:         &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+						},
+					},
+				}},
+			},
+		}},
+	}
+
+	r.loadReservations()
+	res := r.reservations
+
+	if _, ok := res.capacityReservations["cr-active"]; !ok {
+		t.Errorf("loadReservations() capacity reservations = %v, want cr-active", res.capacityReservations)
+	}
+
+	c5 := reservationKey{instanceType: "c5", platform: "Linux/UNIX", tenancy: ec2.TenancyDefault}
+	if got := res.regional[c5]; got == nil || got.units != 8 {
+		t.Errorf("loadReservations() regional capacity = %+v, want 8 units", got)
+	}
+	if got := res.regionalDemand[c5]; got != 8 {
+		t.Errorf("loadReservations() regional demand = %v, want 8 units", got)
+	}
+
+	m5 := reservationKey{instanceType: "m5.large", availabilityZone: "us-east-1a", platform: "Linux/UNIX", tenancy: ec2.TenancyDefault}
+	if got := res.zonalDemand[m5]; got != 1 {
+		t.Errorf("loadReservations() zonal demand = %v, want 1", got)
+	}
+}
+
+func Test_region_loadReservationsFailure(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		services: connections{ec2: mockEC2{
+			dcrperr: errors.New("error"),
+			drierr:  errors.New("error"),
+		}},
+	}
+
+	r.loadReservations()
+
+	inst := onDemandInstance("i-foo", "m5.large", "us-east-1a")
+	if got := r.reservations.coverageReason(inst); got != "" {
+		t.Errorf("coverageReason() = %q, want no coverage", got)
+	}
+
+	inst.CapacityReservationId = aws.String("cr-foo")
+	if got := r.reservations.coverageReason(inst); got == "" {
+		t.Errorf("coverageReason() = %q, want the capacity reservation to be assumed active", got)
+	}
+}
+
+func Test_reservations_coverageReason(t *testing.T) {
+	res := &reservations{
+		capacityReservations: map[string]*ec2.CapacityReservation{"cr-active": {}},
+		zonal:                make(map[reservationKey]*reservedCapacity),
+		regional:             make(map[reservationKey]*reservedCapacity),
+		zonalDemand:          make(map[reservationKey]float64),
+		regionalDemand:       make(map[reservationKey]float64),
+	}
+
+	// one zonal m5.large covering one of the two running in us-east-1a, and
+	// a regional m5.xlarge covering two more m5.large instances
+	res.addReservedInstances(&ec2.ReservedInstances{
+		InstanceType: aws.String("m5.large"), InstanceCount: aws.Int64(1),
+		Scope: aws.String(reservedInstancesScopeAZ), AvailabilityZone: aws.String("us-east-1a"),
+		ProductDescription: aws.String("Linux/UNIX"), InstanceTenancy: aws.String(ec2.TenancyDefault),
+	})
+	res.addReservedInstances(&ec2.ReservedInstances{
+		InstanceType: aws.String("m5.xlarge"), InstanceCount: aws.Int64(1), Scope: aws.String("Region"),
+		ProductDescription: aws.String("Linux/UNIX"), InstanceTenancy: aws.String(ec2.TenancyDefault),
+	})
+
+	tests := []struct {
+		name     string
+		demand   []*ec2.Instance
+		inst     *ec2.Instance
+		wantSkip bool
+	}{
+		{
+			name:     "not reserved",
+			demand:   []*ec2.Instance{onDemandInstance("i-1", "c5.large", "us-east-1a")},
+			inst:     onDemandInstance("i-1", "c5.large", "us-east-1a"),
+			wantSkip: false,
+		},
+		{
+			name:   "active capacity reservation",
+			demand: nil,
+			inst: &ec2.Instance{
+				InstanceType:          aws.String("c5.large"),
+				CapacityReservationId: aws.String("cr-active"),
+				Placement:             &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			},
+			wantSkip: true,
+		},
+		{
+			name:   "expired capacity reservation",
+			demand: nil,
+			inst: &ec2.Instance{
+				InstanceType:          aws.String("c5.large"),
+				CapacityReservationId: aws.String("cr-expired"),
+				Placement:             &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			},
+			wantSkip: false,
+		},
+		{
+			name: "covered by the reservations",
+			demand: []*ec2.Instance{
+				onDemandInstance("i-1", "m5.large", "us-east-1a"),
+				onDemandInstance("i-2", "m5.large", "us-east-1a"),
+				onDemandInstance("i-3", "m5.large", "us-east-1b"),
+			},
+			inst:     onDemandInstance("i-3", "m5.large", "us-east-1b"),
+			wantSkip: true,
+		},
+		{
+			name: "more instances than reservations",
+			demand: []*ec2.Instance{
+				onDemandInstance("i-1", "m5.large", "us-east-1a"),
+				onDemandInstance("i-2", "m5.large", "us-east-1a"),
+				onDemandInstance("i-3", "m5.large", "us-east-1b"),
+				onDemandInstance("i-4", "m5.large", "us-east-1b"),
+			},
+			inst:     onDemandInstance("i-4", "m5.large", "us-east-1b"),
+			wantSkip: false,
+		},
+		{
+			name:     "other platform",
+			demand:   []*ec2.Instance{onDemandInstance("i-1", "m5.large", "us-east-1b")},
+			inst:     &ec2.Instance{InstanceType: aws.String("m5.large"), PlatformDetails: aws.String("Windows"), Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1b")}},
+			wantSkip: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res.zonalDemand = make(map[reservationKey]float64)
+			res.regionalDemand = make(map[reservationKey]float64)
+			res.addOnDemandInstances(tt.demand)
+
+			if got := res.coverageReason(tt.inst); (got != "") != tt.wantSkip {
+				t.Errorf("coverageReason() = %q, want skip %v", got, tt.wantSkip)
+			}
+		})
+	}
+}
+
+func Test_instance_isCoveredByReservation(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		reservations: &reservations{
+			capacityReservations: map[string]*ec2.CapacityReservation{"cr-active": {}},
+		},
+	}
+
+	inst := onDemandInstance("i-foo", "m5.large", "us-east-1a")
+	inst.CapacityReservationId = aws.String("cr-active")
+
+	i := &instance{Instance: inst, region: r, asg: &autoScalingGroup{}}
+	if !i.isCoveredByReservation() {
+		t.Errorf("isCoveredByReservation() = false, want true")
+	}
+
+	i.asg.config.ReplaceReservedInstances = true
+	if i.isCoveredByReservation() {
+		t.Errorf("isCoveredByReservation() = true, want false when configured to replace reserved instances")
+	}
+}
+
+func Test_instance_getSavingsWithReservations(t *testing.T) {
+	res := &reservations{
+		zonal:          make(map[reservationKey]*reservedCapacity),
+		regional:       make(map[reservationKey]*reservedCapacity),
+		zonalDemand:    make(map[reservationKey]float64),
+		regionalDemand: make(map[reservationKey]float64),
+	}
+	res.addReservedInstances(&ec2.ReservedInstances{
+		InstanceType: aws.String("m5.xlarge"), InstanceCount: aws.Int64(1), Scope: aws.String("Region"),
+		ProductDescription: aws.String("Linux/UNIX"), InstanceTenancy: aws.String(ec2.TenancyDefault),
+		UsagePrice: aws.Float64(0.12),
+	})
+
+	spot := onDemandInstance("i-spot", "m5.large", "us-east-1a")
+	spot.InstanceLifecycle = aws.String(Spot)
+
+	i := &instance{
+		Instance: spot,
+		typeInfo: instanceTypeInformation{
+			pricing: prices{
+				onDemand: 0.096,
+				spot:     spotPriceMap{"us-east-1a": 0.036},
+			},
+		},
+		region: &region{name: "us-east-1"},
+	}
+
+	if got, want := i.getSavings(), 0.06; math.Abs(got-want) > 0.000001 {
+		t.Errorf("getSavings() = %v, want %v without reservations", got, want)
+	}
+
+	// half of the unused m5.xlarge reservation would cover the instance
+	i.region.reservations = res
+	if got, want := i.getSavings(), 0.024; math.Abs(got-want) > 0.000001 {
+		t.Errorf("getSavings() = %v, want %v with unused reservations", got, want)
+	}
+}
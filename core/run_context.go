@@ -0,0 +1,143 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// run_context.go contains the logic that stops a run cleanly before the Lambda
+// function times out, and resumes the work interrupted by previous runs.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+const (
+	// deadlineSafetyMargin is the time reserved at the end of a Lambda
+	// invocation for restoring the configuration of the groups we were
+	// working on when the run was cancelled.
+	deadlineSafetyMargin = 60 * time.Second
+
+	// cleanupTimeout limits the duration of each of the cleanup calls made
+	// after the run was cancelled.
+	cleanupTimeout = 30 * time.Second
+
+	// terminateAfterTag is set on the detached spot instances whose delayed
+	// termination was interrupted, holding the time after which they should be
+	// terminated.
+	terminateAfterTag = "autospotting-terminate-after"
+)
+
+type runIDKey struct{}
+
+// processID identifies this process, used for the runs started outside Lambda.
+var processID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+var runCount uint64
+
+// runID identifies the current run in the logs and as holder of the group
+// locks, using the Lambda request ID when available.
+func runID(ctx context.Context) string {
+	if id, ok := contextOrBackground(ctx).Value(runIDKey{}).(string); ok {
+		return id
+	}
+	return processID
+}
+
+// newRunContext derives the context of a run from the one received from the
+// Lambda runtime, cancelling it a safety margin before the function times out.
+func newRunContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	id := fmt.Sprintf("%s-%d", processID, atomic.AddUint64(&runCount, 1))
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		id = lc.AwsRequestID
+	}
+	ctx = context.WithValue(ctx, runIDKey{}, id)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		log.Printf("Run deadline is %v, stopping any work %v earlier",
+			deadline.Format(time.RFC3339), deadlineSafetyMargin)
+		return context.WithDeadline(ctx, deadline.Add(-deadlineSafetyMargin))
+	}
+	return context.WithCancel(ctx)
+}
+
+// contextOrBackground is used for the data structures created by the tests
+// without setting a context.
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// cleanupContext returns a context that isn't cancelled together with the
+// run, used for restoring the group configuration after interrupted actions.
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupTimeout)
+}
+
+// tagForDelayedTermination marks an instance to be terminated by a later run
+// after the given time.
+func tagForDelayedTermination(svc ec2iface.EC2API, instanceID *string, after time.Time) error {
+	ctx, cancel := cleanupContext()
+	defer cancel()
+
+	_, err := svc.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: []*string{instanceID},
+		Tags: []*ec2.Tag{
+			{
+				Key:   aws.String(terminateAfterTag),
+				Value: aws.String(after.UTC().Format(time.RFC3339)),
+			},
+		},
+	})
+
+	if err != nil {
+		log.Printf("Failed to tag instance %s for delayed termination: %s",
+			*instanceID, err.Error())
+	}
+	return err
+}
+
+// processDelayedTerminations terminates the instances whose delayed
+// termination was interrupted by the end of a previous run.
+func (r *region) processDelayedTerminations() {
+	now := time.Now()
+
+	for i := range r.instances.instances() {
+		for _, tag := range i.Tags {
+			if *tag.Key != terminateAfterTag {
+				continue
+			}
+
+			after, err := time.Parse(time.RFC3339, *tag.Value)
+			if err != nil {
+				log.Printf("%s Invalid %s tag value on instance %s: %s",
+					r.name, terminateAfterTag, *i.InstanceId, *tag.Value)
+				break
+			}
+
+			if now.After(after) && i.canTerminate() {
+				log.Printf("%s Terminating instance %s, scheduled for termination after %s",
+					r.name, *i.InstanceId, *tag.Value)
+				i.terminate()
+			}
+			break
+		}
+	}
+}
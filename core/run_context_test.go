@@ -0,0 +1,54 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_newRunContext(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Minute)
+	lambdaCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		wantDeadline bool
+		want         time.Time
+	}{
+		{
+			name:         "without deadline",
+			ctx:          context.Background(),
+			wantDeadline: false,
+		},
+		{
+			name:         "nil context",
+			ctx:          nil,
+			wantDeadline: false,
+		},
+		{
+			name:         "with deadline",
+			ctx:          lambdaCtx,
+			wantDeadline: true,
+			want:         deadline.Add(-deadlineSafetyMargin),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := newRunContext(tt.ctx)
+			defer cancel()
+
+			got, ok := ctx.Deadline()
+			if ok != tt.wantDeadline {
+				t.Errorf("newRunContext() has deadline = %v, want %v", ok, tt.wantDeadline)
+			}
+			if ok && !got.Equal(tt.want) {
+				t.Errorf("newRunContext() deadline = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
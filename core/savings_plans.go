@@ -0,0 +1,251 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// savings_plans.go contains the awareness of the Savings Plans, which cover
+// the on-demand usage at discounted rates. The effective on-demand price of
+// the instance types covered by them is lower than the list price, so the
+// instances are only replaced by spot instances cheaper than the discounted
+// rates.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+)
+
+const (
+	// DefaultSavingsPlansCoverage is the default percentage of the on-demand
+	// usage covered by Savings Plans, when their rates are configured.
+	DefaultSavingsPlansCoverage = 100.0
+
+	savingsPlansPlatform = "Linux/UNIX"
+	savingsPlansTenancy  = "shared"
+)
+
+// savingsPlanRates are the Savings Plans rates of the instance types in a
+// region, by instance type.
+type savingsPlanRates struct {
+	rates    map[string]float64
+	loadedAt time.Time
+}
+
+// savingsPlanRatesCache keeps the Savings Plans rates of the regions across
+// the runs executed by the same process, for as long as the instance type
+// catalog is cached.
+type savingsPlanRatesCache struct {
+	sync.Mutex
+	regions map[string]*savingsPlanRates
+}
+
+var savingsPlanRatesCaches = &savingsPlanRatesCache{regions: make(map[string]*savingsPlanRates)}
+
+func (c *savingsPlanRatesCache) get(region string, ttl time.Duration) *savingsPlanRates {
+	c.Lock()
+	defer c.Unlock()
+
+	rates, ok := c.regions[region]
+	if !ok || time.Since(rates.loadedAt) > ttl {
+		return nil
+	}
+	return rates
+}
+
+func (c *savingsPlanRatesCache) set(region string, rates *savingsPlanRates) {
+	c.Lock()
+	defer c.Unlock()
+	c.regions[region] = rates
+}
+
+// savingsPlanRateProperty returns the value of a property of the rate, such as
+// its region or instance type.
+func savingsPlanRateProperty(rate *savingsplans.SavingsPlanRate, name string) string {
+	for _, p := range rate.Properties {
+		if aws.StringValue(p.Name) == name {
+			return aws.StringValue(p.Value)
+		}
+	}
+	return ""
+}
+
+// parseSavingsPlanRates returns the lowest Savings Plans rate of each instance
+// type in the region, for the Linux instances with shared tenancy.
+func parseSavingsPlanRates(outputs []*savingsplans.DescribeSavingsPlanRatesOutput, region string) map[string]float64 {
+	rates := make(map[string]float64)
+
+	for _, out := range outputs {
+		if out == nil {
+			continue
+		}
+		for _, r := range out.SearchResults {
+			if aws.StringValue(r.ProductType) != savingsplans.SavingsPlanProductTypeEc2 ||
+				!strings.Contains(aws.StringValue(r.UsageType), "BoxUsage") ||
+				savingsPlanRateProperty(r, savingsplans.SavingsPlanRatePropertyKeyRegion) != region ||
+				savingsPlanRateProperty(r, savingsplans.SavingsPlanRatePropertyKeyProductDescription) != savingsPlansPlatform ||
+				savingsPlanRateProperty(r, savingsplans.SavingsPlanRatePropertyKeyTenancy) != savingsPlansTenancy {
+				continue
+			}
+
+			instanceType := savingsPlanRateProperty(r, savingsplans.SavingsPlanRatePropertyKeyInstanceType)
+			rate, err := strconv.ParseFloat(aws.StringValue(r.Rate), 64)
+			if instanceType == "" || err != nil || rate <= 0 {
+				continue
+			}
+
+			if current, ok := rates[instanceType]; !ok || rate < current {
+				rates[instanceType] = rate
+			}
+		}
+	}
+	return rates
+}
+
+// readSavingsPlanRatesFile reads the Savings Plans rates exported using the
+// AWS CLI, such as with "aws savingsplans describe-savings-plan-rates", given
+// either as a single output or as a list of outputs for multiple plans.
+func readSavingsPlanRatesFile(path string) ([]*savingsplans.DescribeSavingsPlanRatesOutput, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []*savingsplans.DescribeSavingsPlanRatesOutput
+	if err := json.Unmarshal(data, &outputs); err == nil {
+		return outputs, nil
+	}
+
+	var output savingsplans.DescribeSavingsPlanRatesOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, fmt.Errorf("invalid Savings Plans rates file %s: %s", path, err.Error())
+	}
+	return []*savingsplans.DescribeSavingsPlanRatesOutput{&output}, nil
+}
+
+// describeSavingsPlanRates loads the rates of the active Savings Plans
+// applicable to the region from the Savings Plans API.
+func (r *region) describeSavingsPlanRates() ([]*savingsplans.DescribeSavingsPlanRatesOutput, error) {
+	var plans []*savingsplans.SavingsPlan
+
+	input := &savingsplans.DescribeSavingsPlansInput{
+		States: []*string{aws.String(savingsplans.SavingsPlanStateActive)},
+	}
+	for {
+		resp, err := r.services.savingsPlans.DescribeSavingsPlansWithContext(r.runContext(), input)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, resp.SavingsPlans...)
+		if aws.StringValue(resp.NextToken) == "" {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	var outputs []*savingsplans.DescribeSavingsPlanRatesOutput
+	for _, plan := range plans {
+		switch aws.StringValue(plan.SavingsPlanType) {
+		case savingsplans.SavingsPlanTypeCompute:
+		case savingsplans.SavingsPlanTypeEc2instance:
+			if aws.StringValue(plan.Region) != r.name {
+				continue
+			}
+		default:
+			continue
+		}
+
+		ratesInput := &savingsplans.DescribeSavingsPlanRatesInput{
+			SavingsPlanId: plan.SavingsPlanId,
+			Filters: []*savingsplans.SavingsPlanRateFilter{
+				{
+					Name:   aws.String(savingsplans.SavingsPlanRateFilterNameRegion),
+					Values: []*string{aws.String(r.name)},
+				},
+				{
+					Name:   aws.String(savingsplans.SavingsPlanRateFilterNameProductDescription),
+					Values: []*string{aws.String(savingsPlansPlatform)},
+				},
+				{
+					Name:   aws.String(savingsplans.SavingsPlanRateFilterNameTenancy),
+					Values: []*string{aws.String(savingsPlansTenancy)},
+				},
+			},
+		}
+		for {
+			resp, err := r.services.savingsPlans.DescribeSavingsPlanRatesWithContext(r.runContext(), ratesInput)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, resp)
+			if aws.StringValue(resp.NextToken) == "" {
+				break
+			}
+			ratesInput.NextToken = resp.NextToken
+		}
+	}
+	return outputs, nil
+}
+
+// loadSavingsPlanRates loads the Savings Plans rates of the instance types in
+// the region, from the configured export file or from the Savings Plans API.
+// The rates are cached as long as the instance type catalog. It returns nil if
+// there are no Savings Plans rates configured.
+func (r *region) loadSavingsPlanRates() map[string]float64 {
+	if r.conf == nil || (r.conf.SavingsPlansRatesFile == "" && !r.conf.SavingsPlansFromAPI) {
+		return nil
+	}
+
+	ttl := r.instanceTypeCatalogTTL()
+	if cached := savingsPlanRatesCaches.get(r.name, ttl); cached != nil {
+		debug.Println(r.name, "Using the Savings Plans rates loaded at", cached.loadedAt)
+		return cached.rates
+	}
+
+	var outputs []*savingsplans.DescribeSavingsPlanRatesOutput
+	var err error
+
+	if r.conf.SavingsPlansRatesFile != "" {
+		outputs, err = readSavingsPlanRatesFile(r.conf.SavingsPlansRatesFile)
+	} else {
+		outputs, err = r.describeSavingsPlanRates()
+	}
+
+	if err != nil {
+		log.Println(r.name, "Couldn't load the Savings Plans rates, using the on-demand list prices:", err.Error())
+		return nil
+	}
+
+	rates := parseSavingsPlanRates(outputs, r.name)
+	log.Println(r.name, "Loaded the Savings Plans rates of", len(rates), "instance types")
+
+	if ttl > 0 {
+		savingsPlanRatesCaches.set(r.name, &savingsPlanRates{rates: rates, loadedAt: time.Now()})
+	}
+	return rates
+}
+
+// savingsPlansPrice returns the effective on-demand price of an instance type
+// covered by Savings Plans, blending the discounted rate with the on-demand
+// price according to the configured coverage of the on-demand usage.
+func (r *region) savingsPlansPrice(onDemand, rate float64) float64 {
+	coverage := DefaultSavingsPlansCoverage
+	if r.conf != nil {
+		coverage = r.conf.SavingsPlansCoverage
+	}
+	coverage = math.Min(math.Max(coverage, 0), 100) / 100
+
+	price := coverage*rate + (1-coverage)*onDemand
+	if price > onDemand {
+		return onDemand
+	}
+	return price
+}
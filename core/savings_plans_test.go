@@ -0,0 +1,260 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+)
+
+func savingsPlanRate(region, instanceType, rate string) *savingsplans.SavingsPlanRate {
+	return &savingsplans.SavingsPlanRate{
+		ProductType: aws.String(savingsplans.SavingsPlanProductTypeEc2),
+		UsageType:   aws.String("USE1-BoxUsage:" + instanceType),
+		Rate:        aws.String(rate),
+		Properties: []*savingsplans.SavingsPlanRateProperty{
+			{Name: aws.String(savingsplans.SavingsPlanRatePropertyKeyRegion), Value: aws.String(region)},
+			{Name: aws.String(savingsplans.SavingsPlanRatePropertyKeyInstanceType), Value: aws.String(instanceType)},
+			{Name: aws.String(savingsplans.SavingsPlanRatePropertyKeyProductDescription), Value: aws.String("Linux/UNIX")},
+			{Name: aws.String(savingsplans.SavingsPlanRatePropertyKeyTenancy), Value: aws.String("shared")},
+		},
+	}
+}
+
+const savingsPlanRatesJSON = `{
+  "SavingsPlanId": "sp-1",
+  "SearchResults": [
+    {
+      "Rate": "0.06",
+      "Currency": "USD",
+      "Unit": "Hrs",
+      "ProductType": "EC2",
+      "ServiceCode": "AmazonEC2",
+      "UsageType": "BoxUsage:m5.large",
+      "Operation": "RunInstances",
+      "Properties": [
+        {"Name": "region", "Value": "us-east-1"},
+        {"Name": "instanceType", "Value": "m5.large"},
+        {"Name": "productDescription", "Value": "Linux/UNIX"},
+        {"Name": "tenancy", "Value": "shared"}
+      ]
+    }
+  ]
+}`
+
+func Test_parseSavingsPlanRates(t *testing.T) {
+	windows := savingsPlanRate("us-east-1", "c5.large", "0.1")
+	windows.Properties[2].Value = aws.String("Windows")
+
+	dedicatedHost := savingsPlanRate("us-east-1", "c5.large", "0.01")
+	dedicatedHost.UsageType = aws.String("USE1-HostUsage:c5")
+
+	outputs := []*savingsplans.DescribeSavingsPlanRatesOutput{
+		{SearchResults: []*savingsplans.SavingsPlanRate{
+			savingsPlanRate("us-east-1", "m5.large", "0.07"),
+			savingsPlanRate("us-east-1", "c5.large", "0.05"),
+			savingsPlanRate("eu-west-1", "c5.large", "0.01"),
+			windows,
+			dedicatedHost,
+			savingsPlanRate("us-east-1", "t3.micro", "invalid"),
+		}},
+		nil,
+		{SearchResults: []*savingsplans.SavingsPlanRate{
+			savingsPlanRate("us-east-1", "m5.large", "0.06"),
+		}},
+	}
+
+	want := map[string]float64{"m5.large": 0.06, "c5.large": 0.05}
+	if got := parseSavingsPlanRates(outputs, "us-east-1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSavingsPlanRates() = %v, want %v", got, want)
+	}
+}
+
+func Test_readSavingsPlanRatesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "savings-plans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		content  string
+		want     map[string]float64
+		wantFail bool
+	}{
+		{
+			name:    "single output",
+			content: savingsPlanRatesJSON,
+			want:    map[string]float64{"m5.large": 0.06},
+		},
+		{
+			name:    "list of outputs",
+			content: "[" + savingsPlanRatesJSON + "]",
+			want:    map[string]float64{"m5.large": 0.06},
+		},
+		{
+			name:     "invalid file",
+			content:  "foo",
+			wantFail: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "rates.json")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			outputs, err := readSavingsPlanRatesFile(path)
+			if (err != nil) != tt.wantFail {
+				t.Fatalf("readSavingsPlanRatesFile() error = %v, want failure %v", err, tt.wantFail)
+			}
+			if tt.wantFail {
+				return
+			}
+			if got := parseSavingsPlanRates(outputs, "us-east-1"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSavingsPlanRates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_loadSavingsPlanRates(t *testing.T) {
+	services := connections{savingsPlans: mockSavingsPlans{
+		dspo: &savingsplans.DescribeSavingsPlansOutput{
+			SavingsPlans: []*savingsplans.SavingsPlan{
+				{SavingsPlanId: aws.String("sp-compute"), SavingsPlanType: aws.String(savingsplans.SavingsPlanTypeCompute)},
+				{
+					SavingsPlanId:   aws.String("sp-ec2-other-region"),
+					SavingsPlanType: aws.String(savingsplans.SavingsPlanTypeEc2instance),
+					Region:          aws.String("eu-west-1"),
+				},
+				{SavingsPlanId: aws.String("sp-sagemaker"), SavingsPlanType: aws.String(savingsplans.SavingsPlanTypeSageMaker)},
+			},
+		},
+		dsprpo: map[string]*savingsplans.DescribeSavingsPlanRatesOutput{
+			"sp-compute": {SearchResults: []*savingsplans.SavingsPlanRate{
+				savingsPlanRate("us-east-1", "m5.large", "0.07"),
+			}},
+			"sp-ec2-other-region": {SearchResults: []*savingsplans.SavingsPlanRate{
+				savingsPlanRate("us-east-1", "m5.large", "0.01"),
+			}},
+			"sp-sagemaker": {SearchResults: []*savingsplans.SavingsPlanRate{
+				savingsPlanRate("us-east-1", "m5.large", "0.02"),
+			}},
+		},
+	}}
+
+	tests := []struct {
+		name     string
+		conf     *Config
+		services connections
+		want     map[string]float64
+	}{
+		{
+			name:     "not configured",
+			conf:     &Config{},
+			services: connections{savingsPlans: mockSavingsPlans{dsperr: errors.New("unexpected call")}},
+			want:     nil,
+		},
+		{
+			name:     "from the API",
+			conf:     &Config{SavingsPlansFromAPI: true},
+			services: services,
+			want:     map[string]float64{"m5.large": 0.07},
+		},
+		{
+			name:     "API failure",
+			conf:     &Config{SavingsPlansFromAPI: true},
+			services: connections{savingsPlans: mockSavingsPlans{dsperr: errors.New("error")}},
+			want:     nil,
+		},
+		{
+			name:     "missing file",
+			conf:     &Config{SavingsPlansRatesFile: filepath.Join(os.TempDir(), "autospotting-missing-savings-plans-rates.json")},
+			services: services,
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1", conf: tt.conf, services: tt.services}
+			if got := r.loadSavingsPlanRates(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadSavingsPlanRates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_loadSavingsPlanRatesCached(t *testing.T) {
+	defer func() {
+		savingsPlanRatesCaches = &savingsPlanRatesCache{regions: make(map[string]*savingsPlanRates)}
+	}()
+
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{SavingsPlansFromAPI: true, InstanceTypeCatalogTTL: time.Hour},
+		services: connections{savingsPlans: mockSavingsPlans{
+			dspo: &savingsplans.DescribeSavingsPlansOutput{
+				SavingsPlans: []*savingsplans.SavingsPlan{
+					{SavingsPlanId: aws.String("sp-compute"), SavingsPlanType: aws.String(savingsplans.SavingsPlanTypeCompute)},
+				},
+			},
+			dsprpo: map[string]*savingsplans.DescribeSavingsPlanRatesOutput{
+				"sp-compute": {SearchResults: []*savingsplans.SavingsPlanRate{
+					savingsPlanRate("us-east-1", "m5.large", "0.07"),
+				}},
+			},
+		}},
+	}
+
+	want := map[string]float64{"m5.large": 0.07}
+	if got := r.loadSavingsPlanRates(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("loadSavingsPlanRates() = %v, want %v", got, want)
+	}
+
+	r.services.savingsPlans = mockSavingsPlans{dsperr: errors.New("unexpected call")}
+	if got := r.loadSavingsPlanRates(); !reflect.DeepEqual(got, want) {
+		t.Errorf("cached loadSavingsPlanRates() = %v, want %v", got, want)
+	}
+
+	r.conf.InstanceTypeCatalogTTL = 0
+	if got := r.loadSavingsPlanRates(); got != nil {
+		t.Errorf("loadSavingsPlanRates() with the cache disabled = %v, want nil", got)
+	}
+}
+
+func Test_region_savingsPlansPrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		coverage float64
+		onDemand float64
+		rate     float64
+		want     float64
+	}{
+		{name: "full coverage", coverage: 100, onDemand: 0.1, rate: 0.06, want: 0.06},
+		{name: "partial coverage", coverage: 50, onDemand: 0.1, rate: 0.06, want: 0.08},
+		{name: "no coverage", coverage: 0, onDemand: 0.1, rate: 0.06, want: 0.1},
+		{name: "coverage out of range", coverage: 150, onDemand: 0.1, rate: 0.06, want: 0.06},
+		{name: "rate above the on-demand price", coverage: 100, onDemand: 0.1, rate: 0.2, want: 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1", conf: &Config{SavingsPlansCoverage: tt.coverage}}
+			if got := r.savingsPlansPrice(tt.onDemand, tt.rate); math.Abs(got-tt.want) > 0.000001 {
+				t.Errorf("savingsPlansPrice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
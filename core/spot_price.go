@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 type spotPrices struct {
 	data []*ec2.SpotPrice
 	conn connections
+	ctx  context.Context
 }
 
 // fetch queries all spot prices in the current region
@@ -36,7 +38,7 @@ func (s *spotPrices) fetch(product string,
 	}
 
 	data := []*ec2.SpotPrice{}
-	err := ec2Conn.DescribeSpotPriceHistoryPages(params, func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
+	err := ec2Conn.DescribeSpotPriceHistoryPagesWithContext(contextOrBackground(s.ctx), params, func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
 		data = append(data, page.SpotPriceHistory...)
 		debug.Printf("DescribeSpotPriceHistory lastPage: %v", lastPage)
 		return true
@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"context"
 	"errors"
 	"log"
 	"strings"
@@ -13,6 +14,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
@@ -25,23 +28,45 @@ const (
 
 // SpotTermination is used to detach an instance, used when a spot instance is due for termination
 type SpotTermination struct {
+	ctx             context.Context
 	asSvc           autoscalingiface.AutoScalingAPI
 	ec2Svc          ec2iface.EC2API
 	SleepMultiplier time.Duration
+
+	// used for taking the group locks, like the other runs changing the groups
+	region      string
+	conf        *Config
+	dynamoDBSvc dynamodbiface.DynamoDBAPI
 }
 
-func newSpotTermination(region string) SpotTermination {
+func newSpotTermination(ctx context.Context, region string, conf *Config) SpotTermination {
 
 	log.Println("Connection to region ", region)
 
-	session := session.Must(
-		session.NewSession(&aws.Config{Region: aws.String(region)}))
+	session := session.Must(newAPISession(region))
 
 	return SpotTermination{
-
+		ctx:             ctx,
 		asSvc:           autoscaling.New(session),
 		ec2Svc:          ec2.New(session),
 		SleepMultiplier: 1,
+		region:          region,
+		conf:            conf,
+		dynamoDBSvc:     dynamodb.New(session, aws.NewConfig().WithRegion(conf.MainRegion)),
+	}
+}
+
+// group returns the AutoScaling group, only used for serializing the changes
+// made to it with the other runs by taking its lock.
+func (s *SpotTermination) group(asgName string) *autoScalingGroup {
+	return &autoScalingGroup{
+		name: asgName,
+		region: &region{
+			name:     s.region,
+			conf:     s.conf,
+			ctx:      s.ctx,
+			services: connections{dynamoDB: s.dynamoDBSvc},
+		},
 	}
 }
 
@@ -60,7 +85,11 @@ func (s *SpotTermination) detachInstance(instanceID *string, asgName string, eve
 		},
 		ShouldDecrementDesiredCapacity: aws.Bool(false),
 	}
-	if _, detachErr := s.asSvc.DetachInstances(&detachParams); detachErr != nil {
+	detachErr := s.group(asgName).withInterruptionLock(func() error {
+		_, err := s.asSvc.DetachInstancesWithContext(s.runContext(), &detachParams)
+		return err
+	})
+	if detachErr != nil {
 		log.Println(detachErr.Error())
 		return detachErr
 	}
@@ -75,13 +104,26 @@ func (s *SpotTermination) detachInstance(instanceID *string, asgName string, eve
 	return nil
 }
 
+func (s *SpotTermination) runContext() context.Context {
+	return contextOrBackground(s.ctx)
+}
+
 // delayedTermination is used to terminate instances that were marked as being in danger of being terminated.
+// When the run is cancelled before the delay elapses, the instance is tagged
+// instead, and terminated later by the next cron run.
 func (s *SpotTermination) delayedTermination(instanceID *string, minutes time.Duration) error {
 
 	log.Printf("Terminating instance %s with %d minutes delay, sleeping...\n",
 		*instanceID, minutes)
 
-	time.Sleep(minutes * time.Minute * s.SleepMultiplier)
+	delay := minutes * time.Minute * s.SleepMultiplier
+	terminateAfter := time.Now().Add(delay)
+
+	if err := aws.SleepWithContext(s.runContext(), delay); err != nil {
+		log.Printf("Run cancelled while waiting to terminate instance %s, "+
+			"deferring its termination to a later run", *instanceID)
+		return tagForDelayedTermination(s.ec2Svc, instanceID, terminateAfter)
+	}
 
 	log.Println("Terminating instance", *instanceID)
 	// terminate the spot instance
@@ -89,7 +131,7 @@ func (s *SpotTermination) delayedTermination(instanceID *string, minutes time.Du
 		InstanceIds: []*string{instanceID},
 	}
 
-	if _, err := s.ec2Svc.TerminateInstances(&terminateParams); err != nil {
+	if _, err := s.ec2Svc.TerminateInstancesWithContext(s.runContext(), &terminateParams); err != nil {
 		log.Println(err.Error())
 		return err
 	}
@@ -110,7 +152,11 @@ func (s *SpotTermination) terminateInstance(instanceID *string, asgName string)
 		ShouldDecrementDesiredCapacity: aws.Bool(false),
 	}
 
-	if _, err := s.asSvc.TerminateInstanceInAutoScalingGroup(&terminateParams); err != nil {
+	err := s.group(asgName).withInterruptionLock(func() error {
+		_, err := s.asSvc.TerminateInstanceInAutoScalingGroupWithContext(s.runContext(), &terminateParams)
+		return err
+	})
+	if err != nil {
 		log.Println(err.Error())
 		return err
 	}
@@ -122,7 +168,7 @@ func (s *SpotTermination) getAsgName(instanceID *string) (string, error) {
 		InstanceIds: []*string{instanceID},
 	}
 
-	result, err := s.asSvc.DescribeAutoScalingInstances(&asParams)
+	result, err := s.asSvc.DescribeAutoScalingInstancesWithContext(s.runContext(), &asParams)
 	if err != nil {
 		return "", err
 	} else if len(result.AutoScalingInstances) == 0 {
@@ -151,18 +197,15 @@ func (s *SpotTermination) executeAction(instanceID *string, terminationNotificat
 
 	switch terminationNotificationAction {
 	case "detach":
-		s.detachInstance(instanceID, asgName, eventType)
+		return s.detachInstance(instanceID, asgName, eventType)
 	case "terminate":
-		s.terminateInstance(instanceID, asgName)
+		return s.terminateInstance(instanceID, asgName)
 	default:
 		if s.asgHasTerminationLifecycleHook(&asgName) {
-			s.terminateInstance(instanceID, asgName)
-		} else {
-			s.detachInstance(instanceID, asgName, eventType)
+			return s.terminateInstance(instanceID, asgName)
 		}
+		return s.detachInstance(instanceID, asgName, eventType)
 	}
-
-	return nil
 }
 
 func (s *SpotTermination) deleteTagInstanceLaunchedForAsg(instanceID *string) error {
@@ -176,7 +219,7 @@ func (s *SpotTermination) deleteTagInstanceLaunchedForAsg(instanceID *string) er
 			},
 		},
 	}
-	_, err := s.ec2Svc.DeleteTags(&ec2Params)
+	_, err := s.ec2Svc.DeleteTagsWithContext(s.runContext(), &ec2Params)
 
 	if err != nil {
 		log.Printf("Failed to delete Tag 'launched-for-asg' from spot instance %s with err: %s\n", *instanceID, err.Error())
@@ -193,7 +236,7 @@ func (s *SpotTermination) asgHasTerminationLifecycleHook(autoScalingGroupName *s
 		AutoScalingGroupName: autoScalingGroupName,
 	}
 
-	result, err := s.asSvc.DescribeLifecycleHooks(&asParams)
+	result, err := s.asSvc.DescribeLifecycleHooksWithContext(s.runContext(), &asParams)
 
 	if err != nil {
 		log.Println(err.Error())
@@ -227,7 +270,7 @@ func (s *SpotTermination) IsInAutoSpottingASG(instanceID *string, tagFilteringMo
 		return false
 	}
 
-	asgGroupsOutput, err := s.asSvc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+	asgGroupsOutput, err := s.asSvc.DescribeAutoScalingGroupsWithContext(s.runContext(), &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{
 			&asgName,
 		},
@@ -4,9 +4,11 @@
 package autospotting
 
 import (
+	"context"
 	//	"encoding/json"
 	"errors"
 	"testing"
+	"time"
 
 	//	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
@@ -17,7 +19,7 @@ import (
 func TestNewSpotTermination(t *testing.T) {
 
 	region := "foo"
-	spotTermination := newSpotTermination(region)
+	spotTermination := newSpotTermination(context.Background(), region, &Config{MainRegion: region})
 
 	if spotTermination.asSvc == nil || spotTermination.ec2Svc == nil {
 		t.Errorf("Unable to connect to region %s", region)
@@ -228,6 +230,38 @@ func TestTerminateInstance(t *testing.T) {
 	}
 }
 
+func TestSpotTerminationGroupLock(t *testing.T) {
+	asgName := "locked-asg"
+	instanceID := "dummyInstanceID"
+
+	s := &SpotTermination{
+		region: "us-east-1",
+		conf:   &Config{},
+		asSvc:  mockASG{},
+	}
+
+	key := "us-east-1/" + asgName
+	memoryGroupLocks.lock(key, "other-run", time.Now().Add(time.Minute))
+	defer memoryGroupLocks.unlock(key, "other-run")
+
+	if err := s.terminateInstance(&instanceID, asgName); err != nil {
+		t.Errorf("terminateInstance() on locked group error = %v", err)
+	}
+	if err := s.detachInstance(&instanceID, asgName, InstanceRebalanceRecommendationCode); err != nil {
+		t.Errorf("detachInstance() on locked group error = %v", err)
+	}
+
+	s.asSvc = mockASG{tiiasgerr: errors.New("terminate failed")}
+	if err := s.terminateInstance(&instanceID, asgName); err == nil {
+		t.Errorf("terminateInstance() on locked group didn't make the API call")
+	}
+
+	owner, _ := memoryGroupLocks.lock(key, "", time.Now())
+	if owner != "other-run" {
+		t.Errorf("the group lock held by other-run was taken over by %q", owner)
+	}
+}
+
 func TestGetAsgName(t *testing.T) {
 	asgName := "dummyASGName"
 	instanceID := "dummyInstanceID"
@@ -0,0 +1,244 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// DefaultTagPropagationResources is the default list of resources, other
+	// than the instances, tagged with the propagated tags.
+	DefaultTagPropagationResources = "volume,network-interface"
+
+	// the maximum number of tags of an EC2 resource
+	maxResourceTags = 50
+)
+
+// parseTagPatterns parses comma-separated lists of shell patterns matching tag
+// keys, such as "team,cost-*".
+func parseTagPatterns(spec string) ([]string, error) {
+	var patterns []string
+
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %s", p, err.Error())
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// parseExtraTags parses comma-separated lists of static tags, such as
+// "lifecycle=spot,team=data".
+func parseExtraTags(spec string) ([]*ec2.Tag, error) {
+	var tags []*ec2.Tag
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", item)
+		}
+		if strings.HasPrefix(key, "aws:") {
+			return nil, fmt.Errorf("reserved tag key %q", key)
+		}
+		tags = append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(strings.TrimSpace(kv[1]))})
+	}
+	return tags, nil
+}
+
+// parseTagPropagationResources parses comma-separated lists of resource types
+// tagged with the propagated tags in addition to the instances.
+func parseTagPropagationResources(spec string) ([]string, error) {
+	var resources []string
+
+	for _, r := range strings.Split(spec, ",") {
+		r = strings.TrimSpace(r)
+		switch r {
+		case "", ec2.ResourceTypeInstance:
+			continue
+		case ec2.ResourceTypeVolume, ec2.ResourceTypeNetworkInterface, ec2.ResourceTypeSpotInstancesRequest:
+			if !itemInSlice(r, resources) {
+				resources = append(resources, r)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported resource type %q", r)
+		}
+	}
+	return resources, nil
+}
+
+func matchesAnyTagPattern(key string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tagPropagationPatterns returns the include and exclude patterns of the
+// group, ignoring the invalid ones.
+func (a *autoScalingGroup) tagPropagationPatterns() ([]string, []string) {
+	include, err := parseTagPatterns(a.config.TagPropagationInclude)
+	if err != nil {
+		log.Printf("%s Ignoring the tag propagation include patterns %q: %s",
+			a.name, a.config.TagPropagationInclude, err.Error())
+		include = nil
+	}
+
+	exclude, err := parseTagPatterns(a.config.TagPropagationExclude)
+	if err != nil {
+		log.Printf("%s Ignoring the tag propagation exclude patterns %q: %s",
+			a.name, a.config.TagPropagationExclude, err.Error())
+		exclude = nil
+	}
+	return include, exclude
+}
+
+// tagPropagationResources returns the resource types tagged in addition to the
+// instances.
+func (a *autoScalingGroup) tagPropagationResources() []string {
+	resources, err := parseTagPropagationResources(a.config.TagPropagationResources)
+	if err != nil {
+		log.Printf("%s Ignoring the tag propagation resources %q: %s",
+			a.name, a.config.TagPropagationResources, err.Error())
+		return nil
+	}
+	return resources
+}
+
+// propagatedTags returns the tags propagated from the group and the instance
+// to the resources of its spot replacement. The tags of the group marked for
+// propagation at launch come first, overridden by the tags of the instance and
+// by the extra tags of the group. The tags are filtered using the include and
+// exclude patterns, except for the extra tags.
+func (a *autoScalingGroup) propagatedTags(instanceTags []*ec2.Tag) []*ec2.Tag {
+	include, exclude := a.tagPropagationPatterns()
+
+	var candidates []*ec2.Tag
+	for _, tag := range a.Tags {
+		if aws.BoolValue(tag.PropagateAtLaunch) {
+			candidates = append(candidates, &ec2.Tag{Key: tag.Key, Value: tag.Value})
+		}
+	}
+	candidates = append(candidates, filterTags(instanceTags)...)
+
+	var tags []*ec2.Tag
+	index := make(map[string]int)
+
+	add := func(tag *ec2.Tag) {
+		if n, ok := index[*tag.Key]; ok {
+			tags[n] = tag
+			return
+		}
+		index[*tag.Key] = len(tags)
+		tags = append(tags, tag)
+	}
+
+	for _, tag := range candidates {
+		key := aws.StringValue(tag.Key)
+		if strings.HasPrefix(key, "aws:") ||
+			(len(include) > 0 && !matchesAnyTagPattern(key, include)) ||
+			matchesAnyTagPattern(key, exclude) {
+			continue
+		}
+		add(tag)
+	}
+
+	extra, err := parseExtraTags(a.config.ExtraTags)
+	if err != nil {
+		log.Printf("%s Ignoring the extra tags %q: %s", a.name, a.config.ExtraTags, err.Error())
+	}
+	for _, tag := range extra {
+		add(tag)
+	}
+
+	return tags
+}
+
+// mergeTags appends the propagated tags to the given ones, skipping the keys
+// already set and the tags exceeding the limit of tags per resource.
+func mergeTags(tags []*ec2.Tag, propagated []*ec2.Tag) []*ec2.Tag {
+	existing := make(map[string]bool)
+	for _, tag := range tags {
+		existing[*tag.Key] = true
+	}
+
+	for _, tag := range propagated {
+		if existing[*tag.Key] {
+			continue
+		}
+		if len(tags) >= maxResourceTags {
+			log.Println("Not propagating tag", *tag.Key, "exceeding the limit of",
+				maxResourceTags, "tags per resource")
+			continue
+		}
+		existing[*tag.Key] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// tagSpotInstanceResources tags the resources of a running spot instance with
+// the propagated tags, since the shared launch template of the group only tags
+// them with the tags of the group. The spot instance carries the tags of the
+// instance it replaces, set when launching it.
+func (a *autoScalingGroup) tagSpotInstanceResources(spot *instance) error {
+	tags := mergeTags(nil, a.propagatedTags(spot.Tags))
+	if len(tags) == 0 {
+		return nil
+	}
+
+	var resources []*string
+	for _, resourceType := range a.tagPropagationResources() {
+		switch resourceType {
+		case ec2.ResourceTypeVolume:
+			for _, bdm := range spot.BlockDeviceMappings {
+				if bdm.Ebs != nil && bdm.Ebs.VolumeId != nil {
+					resources = append(resources, bdm.Ebs.VolumeId)
+				}
+			}
+		case ec2.ResourceTypeNetworkInterface:
+			for _, ni := range spot.NetworkInterfaces {
+				if ni.NetworkInterfaceId != nil {
+					resources = append(resources, ni.NetworkInterfaceId)
+				}
+			}
+		case ec2.ResourceTypeSpotInstancesRequest:
+			if spot.SpotInstanceRequestId != nil {
+				resources = append(resources, spot.SpotInstanceRequestId)
+			}
+		}
+	}
+	if len(resources) == 0 {
+		return nil
+	}
+
+	_, err := a.region.services.ec2.CreateTagsWithContext(a.region.runContext(), &ec2.CreateTagsInput{
+		Resources: resources,
+		Tags:      tags,
+	})
+	if err != nil {
+		log.Printf("%s Failed to tag the resources of spot instance %s: %s",
+			a.name, *spot.InstanceId, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func tagsToMap(tags []*ec2.Tag) map[string]string {
+	m := make(map[string]string)
+	for _, t := range tags {
+		m[*t.Key] = *t.Value
+	}
+	return m
+}
+
+func Test_parseTagPatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", spec: "", want: nil},
+		{name: "patterns", spec: "Name, cost-*", want: []string{"Name", "cost-*"}},
+		{name: "invalid pattern", spec: "team,[a-", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTagPatterns(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTagPatterns() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTagPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseExtraTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", spec: "", want: map[string]string{}},
+		{
+			name: "tags",
+			spec: "lifecycle=spot, team = data,empty=",
+			want: map[string]string{"lifecycle": "spot", "team": "data", "empty": ""},
+		},
+		{name: "missing value", spec: "lifecycle", wantErr: true},
+		{name: "missing key", spec: "=spot", wantErr: true},
+		{name: "reserved key", spec: "aws:foo=bar", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExtraTags(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExtraTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tagsToMap(got), tt.want) {
+				t.Errorf("parseExtraTags() = %v, want %v", tagsToMap(got), tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseTagPropagationResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", spec: "", want: nil},
+		{
+			name: "instances are always tagged",
+			spec: "instance,volume,network-interface,volume",
+			want: []string{"volume", "network-interface"},
+		},
+		{name: "unsupported resource", spec: "volume,snapshot", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTagPropagationResources(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTagPropagationResources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTagPropagationResources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_propagatedTags(t *testing.T) {
+	groupTags := []*autoscaling.TagDescription{
+		{Key: aws.String("team"), Value: aws.String("group-team"), PropagateAtLaunch: aws.Bool(true)},
+		{Key: aws.String("cost-center"), Value: aws.String("42"), PropagateAtLaunch: aws.Bool(true)},
+		{Key: aws.String("autospotting_enabled"), Value: aws.String("true"), PropagateAtLaunch: aws.Bool(false)},
+	}
+
+	instanceTags := []*ec2.Tag{
+		{Key: aws.String("team"), Value: aws.String("instance-team")},
+		{Key: aws.String("Name"), Value: aws.String("web")},
+		{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("asg")},
+		{Key: aws.String("launched-for-asg"), Value: aws.String("asg")},
+	}
+
+	tests := []struct {
+		name   string
+		config AutoScalingConfig
+		want   map[string]string
+	}{
+		{
+			name:   "all tags",
+			config: AutoScalingConfig{},
+			want:   map[string]string{"team": "instance-team", "cost-center": "42", "Name": "web"},
+		},
+		{
+			name:   "include patterns",
+			config: AutoScalingConfig{TagPropagationInclude: "cost-*,Name"},
+			want:   map[string]string{"cost-center": "42", "Name": "web"},
+		},
+		{
+			name:   "exclude patterns",
+			config: AutoScalingConfig{TagPropagationExclude: "Na*"},
+			want:   map[string]string{"team": "instance-team", "cost-center": "42"},
+		},
+		{
+			name: "extra tags override the propagated ones",
+			config: AutoScalingConfig{
+				TagPropagationInclude: "team",
+				ExtraTags:             "team=extra,lifecycle=spot",
+			},
+			want: map[string]string{"team": "extra", "lifecycle": "spot"},
+		},
+		{
+			name:   "invalid patterns are ignored",
+			config: AutoScalingConfig{TagPropagationInclude: "[a-"},
+			want:   map[string]string{"team": "instance-team", "cost-center": "42", "Name": "web"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:   "asg",
+				Group:  &autoscaling.Group{Tags: groupTags},
+				config: tt.config,
+			}
+			got := a.propagatedTags(instanceTags)
+			if !reflect.DeepEqual(tagsToMap(got), tt.want) {
+				t.Errorf("propagatedTags() = %v, want %v", tagsToMap(got), tt.want)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("propagatedTags() returned %d tags, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func Test_mergeTags(t *testing.T) {
+	tags := []*ec2.Tag{{Key: aws.String("launched-by-autospotting"), Value: aws.String("true")}}
+
+	var propagated []*ec2.Tag
+	for n := 0; n < maxResourceTags; n++ {
+		propagated = append(propagated, &ec2.Tag{
+			Key:   aws.String(fmt.Sprintf("tag-%d", n)),
+			Value: aws.String("value"),
+		})
+	}
+	propagated = append(propagated, &ec2.Tag{Key: aws.String("launched-by-autospotting"), Value: aws.String("false")})
+
+	got := mergeTags(tags, propagated)
+
+	if len(got) != maxResourceTags {
+		t.Errorf("mergeTags() returned %d tags, want %d", len(got), maxResourceTags)
+	}
+	if m := tagsToMap(got); m["launched-by-autospotting"] != "true" {
+		t.Errorf("mergeTags() overrode the existing tag with %v", m["launched-by-autospotting"])
+	}
+}
+
+func Test_instance_generateTagsListResources(t *testing.T) {
+	i := instance{
+		Instance: &ec2.Instance{
+			InstanceId: aws.String("i-foo"),
+			Tags:       []*ec2.Tag{{Key: aws.String("team"), Value: aws.String("data")}},
+		},
+		asg: &autoScalingGroup{
+			name:  "asg",
+			Group: &autoscaling.Group{LaunchConfigurationName: aws.String("lc")},
+			config: AutoScalingConfig{
+				TagPropagationResources: "volume,network-interface",
+				ExtraTags:               "lifecycle=spot",
+			},
+		},
+	}
+
+	specs := i.generateTagsList()
+
+	var resources []string
+	for _, s := range specs {
+		resources = append(resources, *s.ResourceType)
+	}
+	if want := []string{"instance", "volume", "network-interface"}; !reflect.DeepEqual(resources, want) {
+		t.Fatalf("generateTagsList() resources = %v, want %v", resources, want)
+	}
+
+	if m := tagsToMap(specs[0].Tags); m["launched-for-replacing-instance"] != "i-foo" ||
+		m["team"] != "data" || m["lifecycle"] != "spot" {
+		t.Errorf("generateTagsList() instance tags = %v", m)
+	}
+
+	want := map[string]string{"team": "data", "lifecycle": "spot"}
+	for _, s := range specs[1:] {
+		if got := tagsToMap(s.Tags); !reflect.DeepEqual(got, want) {
+			t.Errorf("generateTagsList() %s tags = %v, want %v", *s.ResourceType, got, want)
+		}
+	}
+}
+
+// createTagsRecorder records the CreateTags calls.
+type createTagsRecorder struct {
+	mockEC2
+	calls *[]*ec2.CreateTagsInput
+}
+
+func (m createTagsRecorder) CreateTagsWithContext(ctx aws.Context, in *ec2.CreateTagsInput, opts ...request.Option) (*ec2.CreateTagsOutput, error) {
+	*m.calls = append(*m.calls, in)
+	return m.mockEC2.CreateTagsWithContext(ctx, in, opts...)
+}
+
+func Test_autoScalingGroup_tagSpotInstanceResources(t *testing.T) {
+	spot := &instance{
+		Instance: &ec2.Instance{
+			InstanceId: aws.String("i-spot"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("launched-for-replacing-instance"), Value: aws.String("i-foo")},
+				{Key: aws.String("team"), Value: aws.String("data")},
+			},
+			BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+				{Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-1")}},
+			},
+			NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+				{NetworkInterfaceId: aws.String("eni-1")},
+			},
+			SpotInstanceRequestId: aws.String("sir-1"),
+		},
+	}
+
+	tests := []struct {
+		name          string
+		resources     string
+		wantResources []string
+	}{
+		{
+			name:          "default resources",
+			resources:     DefaultTagPropagationResources,
+			wantResources: []string{"vol-1", "eni-1"},
+		},
+		{
+			name:          "spot instance requests",
+			resources:     "spot-instances-request",
+			wantResources: []string{"sir-1"},
+		},
+		{
+			name:      "no resources",
+			resources: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []*ec2.CreateTagsInput
+			a := &autoScalingGroup{
+				name:   "asg",
+				Group:  &autoscaling.Group{},
+				config: AutoScalingConfig{TagPropagationResources: tt.resources},
+				region: &region{services: connections{ec2: createTagsRecorder{calls: &calls}}},
+			}
+
+			if err := a.tagSpotInstanceResources(spot); err != nil {
+				t.Fatalf("tagSpotInstanceResources() error = %v", err)
+			}
+
+			if tt.wantResources == nil {
+				if len(calls) != 0 {
+					t.Errorf("tagSpotInstanceResources() tagged %v", calls)
+				}
+				return
+			}
+			if len(calls) != 1 {
+				t.Fatalf("tagSpotInstanceResources() made %d CreateTags calls, want 1", len(calls))
+			}
+			if got := aws.StringValueSlice(calls[0].Resources); !reflect.DeepEqual(got, tt.wantResources) {
+				t.Errorf("tagSpotInstanceResources() resources = %v, want %v", got, tt.wantResources)
+			}
+			if got, want := tagsToMap(calls[0].Tags), map[string]string{"team": "data"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("tagSpotInstanceResources() tags = %v, want %v", got, want)
+			}
+		})
+	}
+}
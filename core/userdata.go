@@ -0,0 +1,462 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// beanstalkUserDataTransformer patches the Beanstalk user data to use the
+	// instance role when calling the CloudFormation helpers.
+	beanstalkUserDataTransformer = "beanstalk"
+
+	// environmentUserDataTransformer exports the configured environment
+	// variables to the shell scripts of the user data.
+	environmentUserDataTransformer = "environment"
+
+	// appendScriptUserDataTransformer appends the configured script snippet
+	// to the user data.
+	appendScriptUserDataTransformer = "append_script"
+
+	// DefaultUserDataEnvironment is the default list of environment variables
+	// injected by the environment transformer.
+	DefaultUserDataEnvironment = "AUTOSPOTTING_LIFECYCLE=spot"
+
+	shellScriptContentType = "text/x-shellscript"
+	cloudConfigContentType = "text/cloud-config"
+	userDataBoundary       = "AUTOSPOTTING-USERDATA-BOUNDARY"
+)
+
+var environmentVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// userDataTransformer rewrites the decoded user data of the instances being
+// replaced before it's used for launching their spot replacements.
+type userDataTransformer interface {
+	transform(userData string) (string, error)
+}
+
+type beanstalkTransformer struct{}
+
+func (t beanstalkTransformer) transform(userData string) (string, error) {
+	return patchBeanstalkUserData(userData), nil
+}
+
+type environmentVariable struct {
+	name  string
+	value string
+}
+
+type environmentTransformer struct {
+	variables []environmentVariable
+}
+
+// transform exports the variables at the beginning of each shell script. The
+// user data without any shell scripts gets a new script that persists the
+// variables in /etc/environment instead.
+func (t environmentTransformer) transform(userData string) (string, error) {
+	var exports strings.Builder
+	for _, v := range t.variables {
+		fmt.Fprintf(&exports, "export %s=%s\n", v.name, shellQuote(v.value))
+	}
+
+	var persisted strings.Builder
+	for _, v := range t.variables {
+		fmt.Fprintf(&persisted, "echo %s >> /etc/environment\n", shellQuote(v.name+"="+v.value))
+	}
+
+	return transformShellScripts(userData,
+		func(scripts []string) []string {
+			for n, s := range scripts {
+				scripts[n] = insertAfterShebang(s, exports.String())
+			}
+			return scripts
+		},
+		persisted.String())
+}
+
+type appendScriptTransformer struct {
+	script string
+}
+
+// transform appends the snippet to the last shell script, or adds a new shell
+// script running it if the user data doesn't contain any.
+func (t appendScriptTransformer) transform(userData string) (string, error) {
+	snippet := t.script
+	if !strings.HasSuffix(snippet, "\n") {
+		snippet += "\n"
+	}
+
+	return transformShellScripts(userData,
+		func(scripts []string) []string {
+			last := len(scripts) - 1
+			if !strings.HasSuffix(scripts[last], "\n") {
+				scripts[last] += "\n"
+			}
+			scripts[last] += snippet
+			return scripts
+		},
+		snippet)
+}
+
+// parseUserDataEnvironment parses comma-separated lists of environment
+// variables such as "AUTOSPOTTING_LIFECYCLE=spot,STAGE=prod".
+func parseUserDataEnvironment(spec string) ([]environmentVariable, error) {
+	var variables []environmentVariable
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("missing value of the environment variable %q", item)
+		}
+
+		name := strings.TrimSpace(kv[0])
+		if !environmentVariableName.MatchString(name) {
+			return nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+		variables = append(variables, environmentVariable{name: name, value: kv[1]})
+	}
+	return variables, nil
+}
+
+// parseUserDataTransformers validates comma-separated lists of transformer
+// names, such as "beanstalk,environment", returning them in order.
+func parseUserDataTransformers(spec string) ([]string, error) {
+	var names []string
+
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			continue
+		case beanstalkUserDataTransformer, environmentUserDataTransformer, appendScriptUserDataTransformer:
+			if itemInSlice(name, names) {
+				return nil, fmt.Errorf("duplicate user data transformer %q", name)
+			}
+			names = append(names, name)
+		default:
+			return nil, fmt.Errorf("unknown user data transformer %q", name)
+		}
+	}
+	return names, nil
+}
+
+// userDataTransformers returns the transformers configured for the group, in
+// the order in which they are applied. Beanstalk patching can also be enabled
+// using the PatchBeanstalkUserdata setting, in which case it's applied first.
+func (a *autoScalingGroup) userDataTransformers() []userDataTransformer {
+	names, err := parseUserDataTransformers(a.config.UserDataTransformers)
+	if err != nil {
+		log.Printf("%s Ignoring the user data transformers %q: %s",
+			a.name, a.config.UserDataTransformers, err.Error())
+		names = nil
+	}
+
+	if a.config.PatchBeanstalkUserdata && !itemInSlice(beanstalkUserDataTransformer, names) {
+		names = append([]string{beanstalkUserDataTransformer}, names...)
+	}
+
+	var transformers []userDataTransformer
+	for _, name := range names {
+		switch name {
+		case beanstalkUserDataTransformer:
+			transformers = append(transformers, beanstalkTransformer{})
+		case environmentUserDataTransformer:
+			variables, err := parseUserDataEnvironment(a.config.UserDataEnvironment)
+			if err != nil {
+				log.Printf("%s Ignoring the user data environment %q: %s",
+					a.name, a.config.UserDataEnvironment, err.Error())
+				continue
+			}
+			if len(variables) > 0 {
+				transformers = append(transformers, environmentTransformer{variables: variables})
+			}
+		case appendScriptUserDataTransformer:
+			if a.config.UserDataAppendScript != "" {
+				transformers = append(transformers, appendScriptTransformer{script: a.config.UserDataAppendScript})
+			}
+		}
+	}
+	return transformers
+}
+
+// transformUserData applies the transformers of the group to the user data,
+// which is returned unchanged if none of them changed it. Transformers failing
+// on the user data are skipped.
+func (a *autoScalingGroup) transformUserData(userData *string) *string {
+	transformers := a.userDataTransformers()
+	if len(transformers) == 0 {
+		return userData
+	}
+
+	decoded := ""
+	if userData != nil {
+		decoded = *decodeUserData(userData)
+	}
+
+	if strings.HasPrefix(decoded, "\x1f\x8b") {
+		log.Println(a.name, "Not transforming the gzip-compressed user data")
+		return userData
+	}
+
+	transformed := decoded
+	for _, t := range transformers {
+		result, err := t.transform(transformed)
+		if err != nil {
+			log.Printf("%s Skipping the user data transformer %T: %s", a.name, t, err.Error())
+			continue
+		}
+		transformed = result
+	}
+
+	if transformed == decoded {
+		return userData
+	}
+	return encodeUserData(&transformed)
+}
+
+// transformShellScripts applies fn to the shell scripts found in the user
+// data, which can be a single script or a MIME multipart document. If there
+// are no shell scripts, a new one with the given body is added instead.
+func transformShellScripts(userData string, fn func([]string) []string, newScript string) (string, error) {
+	newScript = "#!/bin/bash\n" + newScript
+
+	switch {
+	case userData == "":
+		return newScript, nil
+
+	case strings.HasPrefix(userData, "#!"):
+		if !isShellScript(userData) {
+			return "", fmt.Errorf("unsupported script interpreter %q", shebang(userData))
+		}
+		return fn([]string{userData})[0], nil
+
+	case strings.HasPrefix(userData, "Content-Type:"), strings.HasPrefix(userData, "MIME-Version:"):
+		return transformMultipartShellScripts(userData, fn, newScript)
+
+	case strings.HasPrefix(userData, "#cloud-config"):
+		return buildMultipartUserData([]userDataPart{
+			{contentType: cloudConfigContentType, body: userData},
+			{contentType: shellScriptContentType, body: newScript},
+		}), nil
+	}
+
+	return "", errors.New("unsupported user data format")
+}
+
+type userDataPart struct {
+	header      textproto.MIMEHeader
+	contentType string
+	body        string
+}
+
+// buildMultipartUserData creates a MIME multipart document in the format
+// expected by cloud-init.
+func buildMultipartUserData(parts []userDataPart) string {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.SetBoundary(userDataBoundary)
+
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", p.contentType+`; charset="us-ascii"`)
+		pw, _ := w.CreatePart(header)
+		io.WriteString(pw, p.body)
+	}
+	w.Close()
+
+	return "Content-Type: multipart/mixed; boundary=\"" + userDataBoundary + "\"\n" +
+		"MIME-Version: 1.0\n\n" + body.String()
+}
+
+// transformMultipartShellScripts rewrites the shell script parts of a MIME
+// multipart document, keeping its headers and its other parts as they are.
+func transformMultipartShellScripts(userData string, fn func([]string) []string, newScript string) (string, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(userData))
+	if err != nil {
+		return "", err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return "", fmt.Errorf("unsupported user data content type %q", mediaType)
+	}
+
+	var parts []userDataPart
+	var scripts []int
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		raw, err := ioutil.ReadAll(p)
+		if err != nil {
+			return "", err
+		}
+
+		part := userDataPart{header: p.Header, body: string(raw)}
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+
+		if partType == shellScriptContentType {
+			body, err := decodePartBody(part)
+			if err != nil {
+				return "", err
+			}
+
+			// the scripts run by other interpreters, such as Python, are
+			// kept as they are
+			if isShellScript(body) {
+				part.body = body
+				scripts = append(scripts, len(parts))
+			}
+		}
+		parts = append(parts, part)
+	}
+
+	if len(scripts) > 0 {
+		bodies := make([]string, len(scripts))
+		for n, s := range scripts {
+			bodies[n] = parts[s].body
+		}
+		for n, body := range fn(bodies) {
+			parts[scripts[n]].body = encodePartBody(parts[scripts[n]], body)
+		}
+	} else {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", shellScriptContentType+`; charset="us-ascii"`)
+		parts = append(parts, userDataPart{header: header, body: newScript})
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.SetBoundary(params["boundary"])
+
+	for _, p := range parts {
+		pw, err := w.CreatePart(p.header)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(pw, p.body)
+	}
+	w.Close()
+
+	return userDataHeaders(userData) + body.String(), nil
+}
+
+// userDataHeaders returns the headers of the MIME document as they are,
+// including the empty line separating them from the body.
+func userDataHeaders(userData string) string {
+	for _, sep := range []string{"\r\n\r\n", "\n\n"} {
+		if n := strings.Index(userData, sep); n >= 0 {
+			return userData[:n+len(sep)]
+		}
+	}
+	return userData
+}
+
+func decodePartBody(p userDataPart) (string, error) {
+	switch strings.ToLower(p.header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		decoded, err := base64.StdEncoding.DecodeString(
+			strings.Join(strings.Fields(p.body), ""))
+		return string(decoded), err
+	case "quoted-printable":
+		decoded, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(p.body)))
+		return string(decoded), err
+	}
+	return p.body, nil
+}
+
+func encodePartBody(p userDataPart, body string) string {
+	switch strings.ToLower(p.header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(body))
+	case "quoted-printable":
+		var encoded bytes.Buffer
+		w := quotedprintable.NewWriter(&encoded)
+		io.WriteString(w, body)
+		w.Close()
+		return encoded.String()
+	}
+	return body
+}
+
+// shebang returns the interpreter line of the script, without the leading #!.
+func shebang(script string) string {
+	line := strings.TrimPrefix(script, "#!")
+	if n := strings.IndexAny(line, "\r\n"); n >= 0 {
+		line = line[:n]
+	}
+	return strings.TrimSpace(line)
+}
+
+// isShellScript tells if the script is interpreted by a POSIX compatible
+// shell, such as "#!/bin/bash" or "#!/usr/bin/env sh", so the variables and
+// snippets can be injected into it.
+func isShellScript(script string) bool {
+	if !strings.HasPrefix(script, "#!") {
+		return false
+	}
+
+	args := strings.Fields(shebang(script))
+	if len(args) > 1 && path.Base(args[0]) == "env" {
+		args = args[1:]
+		// skip the options of env, such as -S
+		for len(args) > 1 && strings.HasPrefix(args[0], "-") {
+			args = args[1:]
+		}
+	}
+	if len(args) == 0 {
+		return false
+	}
+
+	switch path.Base(args[0]) {
+	case "sh", "bash", "zsh":
+		return true
+	}
+	return false
+}
+
+// insertAfterShebang inserts the snippet after the interpreter line of the
+// script.
+func insertAfterShebang(script, snippet string) string {
+	n := strings.Index(script, "\n")
+	if n < 0 {
+		return script + "\n" + snippet
+	}
+	return script[:n+1] + snippet + script[n+1:]
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func Test_parseUserDataTransformers(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			spec: "",
+			want: nil,
+		},
+		{
+			name: "multiple transformers in order",
+			spec: "environment, beanstalk,append_script",
+			want: []string{"environment", "beanstalk", "append_script"},
+		},
+		{
+			name:    "unknown transformer",
+			spec:    "beanstalk,foo",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate transformer",
+			spec:    "environment,environment",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUserDataTransformers(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseUserDataTransformers() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUserDataTransformers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseUserDataEnvironment(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []environmentVariable
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			spec: "",
+			want: nil,
+		},
+		{
+			name: "multiple variables",
+			spec: "AUTOSPOTTING_LIFECYCLE=spot, STAGE=prod=1",
+			want: []environmentVariable{
+				{name: "AUTOSPOTTING_LIFECYCLE", value: "spot"},
+				{name: "STAGE", value: "prod=1"},
+			},
+		},
+		{
+			name:    "missing value",
+			spec:    "STAGE",
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			spec:    "1STAGE=prod",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUserDataEnvironment(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseUserDataEnvironment() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUserDataEnvironment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+const multipartUserDataExample = "Content-Type: multipart/mixed; boundary=\"XYZ\"\n" +
+	"MIME-Version: 1.0\n" +
+	"\n" +
+	"--XYZ\n" +
+	"Content-Type: text/cloud-config; charset=\"us-ascii\"\n" +
+	"\n" +
+	"#cloud-config\n" +
+	"packages: [jq]\n" +
+	"--XYZ\n" +
+	"Content-Type: text/x-shellscript; charset=\"us-ascii\"\n" +
+	"\n" +
+	"#!/bin/bash\n" +
+	"echo first\n" +
+	"--XYZ\n" +
+	"Content-Type: text/x-shellscript; charset=\"us-ascii\"\n" +
+	"Content-Transfer-Encoding: base64\n" +
+	"\n" +
+	"IyEvYmluL2Jhc2gKZWNobyBzZWNvbmQK\n" +
+	"--XYZ--\n"
+
+func Test_environmentTransformer_transform(t *testing.T) {
+	tr := environmentTransformer{variables: []environmentVariable{
+		{name: "AUTOSPOTTING_LIFECYCLE", value: "spot"},
+		{name: "GREETING", value: "it's"},
+	}}
+
+	tests := []struct {
+		name     string
+		userData string
+		contains []string
+		wantErr  bool
+	}{
+		{
+			name:     "shell script",
+			userData: "#!/bin/bash\necho hello\n",
+			contains: []string{
+				"#!/bin/bash\nexport AUTOSPOTTING_LIFECYCLE='spot'\nexport GREETING='it'\\''s'\necho hello\n",
+			},
+		},
+		{
+			name:     "empty user data",
+			userData: "",
+			contains: []string{
+				"#!/bin/bash\necho 'AUTOSPOTTING_LIFECYCLE=spot' >> /etc/environment\n",
+			},
+		},
+		{
+			name:     "cloud-config is converted to multipart",
+			userData: "#cloud-config\npackages: [jq]\n",
+			contains: []string{
+				"Content-Type: multipart/mixed",
+				"Content-Type: text/cloud-config",
+				"#cloud-config\npackages: [jq]\n",
+				"Content-Type: text/x-shellscript",
+				"echo 'AUTOSPOTTING_LIFECYCLE=spot' >> /etc/environment",
+			},
+		},
+		{
+			name:     "multipart with shell scripts",
+			userData: multipartUserDataExample,
+			contains: []string{
+				"Content-Type: multipart/mixed; boundary=\"XYZ\"\nMIME-Version: 1.0\n\n",
+				"#cloud-config\npackages: [jq]\r\n--XYZ",
+				"#!/bin/bash\nexport AUTOSPOTTING_LIFECYCLE='spot'\nexport GREETING='it'\\''s'\necho first\r\n--XYZ",
+				base64.StdEncoding.EncodeToString([]byte(
+					"#!/bin/bash\nexport AUTOSPOTTING_LIFECYCLE='spot'\nexport GREETING='it'\\''s'\necho second\n")),
+			},
+		},
+		{
+			name:     "shell script run through env",
+			userData: "#!/usr/bin/env sh\necho hello\n",
+			contains: []string{
+				"#!/usr/bin/env sh\nexport AUTOSPOTTING_LIFECYCLE='spot'\n",
+			},
+		},
+		{
+			name:     "python script",
+			userData: "#!/usr/bin/env python3\nprint('hello')\n",
+			wantErr:  true,
+		},
+		{
+			name:     "unsupported format",
+			userData: "<powershell>Write-Host hello</powershell>",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tr.transform(tt.userData)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("environmentTransformer.transform() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(got, c) {
+					t.Errorf("environmentTransformer.transform() = %q, doesn't contain %q", got, c)
+				}
+			}
+		})
+	}
+}
+
+func Test_isShellScript(t *testing.T) {
+	tests := []struct {
+		script string
+		want   bool
+	}{
+		{script: "#!/bin/bash\necho hello\n", want: true},
+		{script: "#! /bin/sh -e\necho hello\n", want: true},
+		{script: "#!/usr/bin/zsh\r\necho hello\r\n", want: true},
+		{script: "#!/usr/bin/env bash\necho hello\n", want: true},
+		{script: "#!/usr/bin/env -S bash -e\necho hello\n", want: true},
+		{script: "#!/usr/bin/python3\nprint('hello')\n", want: false},
+		{script: "#!/usr/bin/env ruby\nputs 'hello'\n", want: false},
+		{script: "#!\necho hello\n", want: false},
+		{script: "echo hello\n", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.script, func(t *testing.T) {
+			if got := isShellScript(tt.script); got != tt.want {
+				t.Errorf("isShellScript() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_appendScriptTransformer_transform(t *testing.T) {
+	tr := appendScriptTransformer{script: "echo appended"}
+
+	tests := []struct {
+		name     string
+		userData string
+		want     string
+		contains []string
+	}{
+		{
+			name:     "shell script without trailing newline",
+			userData: "#!/bin/bash\necho hello",
+			want:     "#!/bin/bash\necho hello\necho appended\n",
+		},
+		{
+			name:     "empty user data",
+			userData: "",
+			want:     "#!/bin/bash\necho appended\n",
+		},
+		{
+			name:     "multipart appends to the last shell script",
+			userData: multipartUserDataExample,
+			contains: []string{
+				"#!/bin/bash\necho first\r\n--XYZ",
+				base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\necho second\necho appended\n")),
+			},
+		},
+		{
+			name: "multipart skips the scripts of other interpreters",
+			userData: strings.Replace(multipartUserDataExample, "--XYZ--\n",
+				"--XYZ\nContent-Type: text/x-shellscript\n\n#!/usr/bin/python3\nprint('third')\n--XYZ--\n", 1),
+			contains: []string{
+				base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\necho second\necho appended\n")),
+				"#!/usr/bin/python3\nprint('third')\r\n--XYZ--",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tr.transform(tt.userData)
+			if err != nil {
+				t.Errorf("appendScriptTransformer.transform() error = %v", err)
+				return
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("appendScriptTransformer.transform() = %q, want %q", got, tt.want)
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(got, c) {
+					t.Errorf("appendScriptTransformer.transform() = %q, doesn't contain %q", got, c)
+				}
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_transformUserData(t *testing.T) {
+	encoded := func(s string) *string {
+		return aws.String(base64.StdEncoding.EncodeToString([]byte(s)))
+	}
+
+	tests := []struct {
+		name     string
+		config   AutoScalingConfig
+		userData *string
+		want     *string
+	}{
+		{
+			name:     "no transformers",
+			config:   AutoScalingConfig{},
+			userData: encoded("#!/bin/bash\necho hello\n"),
+			want:     encoded("#!/bin/bash\necho hello\n"),
+		},
+		{
+			name: "environment and append script in order",
+			config: AutoScalingConfig{
+				UserDataTransformers: "append_script,environment",
+				UserDataEnvironment:  DefaultUserDataEnvironment,
+				UserDataAppendScript: "echo appended",
+			},
+			userData: encoded("#!/bin/bash\necho hello\n"),
+			want:     encoded("#!/bin/bash\nexport AUTOSPOTTING_LIFECYCLE='spot'\necho hello\necho appended\n"),
+		},
+		{
+			name: "missing user data",
+			config: AutoScalingConfig{
+				UserDataTransformers: "environment",
+				UserDataEnvironment:  "STAGE=prod",
+			},
+			userData: nil,
+			want:     encoded("#!/bin/bash\necho 'STAGE=prod' >> /etc/environment\n"),
+		},
+		{
+			name: "unsupported user data is left unchanged",
+			config: AutoScalingConfig{
+				UserDataTransformers: "environment",
+				UserDataEnvironment:  DefaultUserDataEnvironment,
+			},
+			userData: encoded("<powershell>Write-Host hello</powershell>"),
+			want:     encoded("<powershell>Write-Host hello</powershell>"),
+		},
+		{
+			name: "invalid transformers are ignored",
+			config: AutoScalingConfig{
+				UserDataTransformers: "foo",
+			},
+			userData: encoded("#!/bin/bash\necho hello\n"),
+			want:     encoded("#!/bin/bash\necho hello\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{name: "asg", config: tt.config}
+			if got := a.transformUserData(tt.userData); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("autoScalingGroup.transformUserData() = %v, want %v",
+					aws.StringValue(got), aws.StringValue(tt.want))
+			}
+		})
+	}
+}
@@ -22,3 +22,17 @@ func itemInSlice(search string, items []string) bool {
 	}
 	return false
 }
+
+func max64(x, y int64) int64 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+func min64(x, y int64) int64 {
+	if x < y {
+		return x
+	}
+	return y
+}